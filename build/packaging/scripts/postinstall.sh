@@ -1,2 +1,7 @@
 #!/bin/bash
 systemctl daemon-reload
+
+# On upgrade, hand the socket over to the new binary without dropping requests
+if [ "${1:-0}" -gt 1 ]; then
+  systemctl try-reload-or-restart podman-volume-stratis 2>/dev/null || true
+fi
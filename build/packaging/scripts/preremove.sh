@@ -1,3 +1,8 @@
 #!/bin/bash
-systemctl stop podman-volume-stratis 2>/dev/null || true
-systemctl disable podman-volume-stratis 2>/dev/null || true
+# Keep the plugin running during upgrades; postinstall reloads it
+if [ "${1:-0}" -gt 0 ]; then
+  exit 0
+fi
+
+systemctl stop podman-volume-stratis.socket podman-volume-stratis 2>/dev/null || true
+systemctl disable podman-volume-stratis.socket podman-volume-stratis 2>/dev/null || true
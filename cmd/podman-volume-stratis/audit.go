@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/kriansa/podman-volume-stratis/internal/audit"
+)
+
+// auditCommand returns the admin command for inspecting the audit log
+func auditCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "audit",
+		Usage: "Inspect the operation audit log",
+		Commands: []*cli.Command{
+			{
+				Name:  "tail",
+				Usage: "Print the most recent audit entries",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:    "lines",
+						Aliases: []string{"n"},
+						Usage:   "Number of entries to print",
+						Value:   20,
+					},
+				},
+				Action: auditTail,
+			},
+		},
+	}
+}
+
+func auditTail(ctx context.Context, cmd *cli.Command) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	entries, err := audit.Tail(cfg.AuditLog, cmd.Int("lines"))
+	if err != nil {
+		return fmt.Errorf("read audit log: %w", err)
+	}
+
+	for _, e := range entries {
+		fmt.Println(formatAuditEntry(e))
+	}
+
+	return nil
+}
+
+// formatAuditEntry renders an audit entry as a single human-readable line
+func formatAuditEntry(e audit.Entry) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s %-12s", e.Time.Local().Format("2006-01-02 15:04:05"), e.Operation)
+	if e.Volume != "" {
+		fmt.Fprintf(&b, " volume=%s", e.Volume)
+	}
+	if e.Actor != "" {
+		fmt.Fprintf(&b, " actor=%s", e.Actor)
+	}
+	if e.Peer != nil {
+		fmt.Fprintf(&b, " pid=%d uid=%d gid=%d", e.Peer.PID, e.Peer.UID, e.Peer.GID)
+	}
+	for k, v := range e.Options {
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+	fmt.Fprintf(&b, " result=%s duration=%.3fms", e.Result, e.DurationMS)
+	if e.Error != "" {
+		fmt.Fprintf(&b, " error=%q", e.Error)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/kriansa/podman-volume-stratis/internal/control"
+	"github.com/kriansa/podman-volume-stratis/internal/events"
+)
+
+// eventsCommand returns the admin command that streams volume events
+func eventsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "events",
+		Usage: "Stream volume lifecycle events as JSON lines",
+		Flags: []cli.Flag{
+			&cli.Uint64Flag{
+				Name:  "since",
+				Usage: "Replay buffered events after this sequence number; 0 replays them all",
+			},
+			&cli.StringFlag{
+				Name:  "epoch",
+				Usage: "Epoch of the --since sequence number, so a cursor from before a restart is detected",
+			},
+		},
+		Action: streamEvents,
+	}
+}
+
+func streamEvents(ctx context.Context, cmd *cli.Command) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	var since *events.Cursor
+	if cmd.IsSet("since") {
+		since = &events.Cursor{Seq: cmd.Uint64("since"), Epoch: cmd.String("epoch")}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	client := control.NewClient(cfg.ControlSocket)
+	return client.Events(ctx, since, func(e events.Event) error {
+		if e.Type == events.Missed {
+			if e.Attributes["restarted"] == true {
+				fmt.Fprintf(os.Stderr, "warning: the plugin restarted since the cursor; events in between were missed\n")
+			} else {
+				fmt.Fprintf(os.Stderr, "warning: %v events are no longer buffered and were missed\n", e.Attributes["missed"])
+			}
+		}
+		return enc.Encode(e)
+	})
+}
@@ -0,0 +1,19 @@
+//go:build !debug
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/kriansa/podman-volume-stratis/internal/config"
+	"github.com/kriansa/podman-volume-stratis/internal/mount"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+// injectFaults refuses configured faults; only debug builds inject them
+func injectFaults(cfg *config.Config, m stratis.Manager, mounter mount.Mounter) (stratis.Manager, mount.Mounter, error) {
+	if len(cfg.Faults) > 0 {
+		return nil, nil, fmt.Errorf("fault injection is configured, but this build does not support it (build with -tags debug)")
+	}
+	return m, mounter, nil
+}
@@ -0,0 +1,26 @@
+//go:build debug
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/kriansa/podman-volume-stratis/internal/config"
+	"github.com/kriansa/podman-volume-stratis/internal/fault"
+	"github.com/kriansa/podman-volume-stratis/internal/mount"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+// injectFaults wraps the backend and the mounter to inject the configured
+// faults, if any
+func injectFaults(cfg *config.Config, m stratis.Manager, mounter mount.Mounter) (stratis.Manager, mount.Mounter, error) {
+	if len(cfg.Faults) == 0 {
+		return m, mounter, nil
+	}
+
+	injector, err := fault.NewInjector(cfg.Faults, cfg.FaultSeed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create fault injector: %w", err)
+	}
+	return fault.WrapManager(m, injector), fault.WrapMounter(mounter, injector), nil
+}
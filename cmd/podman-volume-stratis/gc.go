@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/kriansa/podman-volume-stratis/internal/control"
+	"github.com/kriansa/podman-volume-stratis/internal/driver"
+)
+
+// gcCommand returns the admin command that finds volumes no container
+// references
+func gcCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "gc",
+		Usage: "Report or remove volumes no Podman container has referenced for longer than orphan_threshold",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only report what would be removed",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print orphaned volumes as JSON",
+			},
+		},
+		Action: gc,
+	}
+}
+
+func gc(ctx context.Context, cmd *cli.Command) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	orphans, err := control.NewClient(cfg.ControlSocket).CollectOrphans(ctx, cmd.Bool("dry-run"))
+	if err != nil {
+		return err
+	}
+
+	if cmd.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(orphans)
+	}
+
+	if len(orphans) == 0 {
+		fmt.Println("no orphaned volumes found")
+		return nil
+	}
+	for _, o := range orphans {
+		fmt.Println(formatOrphan(o))
+	}
+
+	return nil
+}
+
+// formatOrphan renders an orphaned volume as a single human-readable line
+func formatOrphan(o driver.Orphan) string {
+	var b strings.Builder
+
+	status := string(o.Action)
+	switch {
+	case o.Removed:
+		status = "removed"
+	case o.Error != "":
+		status = "failed"
+	}
+
+	fmt.Fprintf(&b, "%-7s volume=%s unreferenced=%s (%s ago)", status, o.Volume,
+		o.Unreferenced.Local().Format("2006-01-02 15:04:05"), time.Since(o.Unreferenced).Round(time.Minute))
+	if o.Error != "" {
+		fmt.Fprintf(&b, " error=%q", o.Error)
+	}
+
+	return b.String()
+}
@@ -4,19 +4,34 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/docker/go-plugins-helpers/volume"
 	"github.com/urfave/cli/v3"
 
+	"github.com/kriansa/podman-volume-stratis/internal/audit"
 	"github.com/kriansa/podman-volume-stratis/internal/config"
 	"github.com/kriansa/podman-volume-stratis/internal/driver"
+	"github.com/kriansa/podman-volume-stratis/internal/events"
+	"github.com/kriansa/podman-volume-stratis/internal/handover"
+	"github.com/kriansa/podman-volume-stratis/internal/journal"
 	"github.com/kriansa/podman-volume-stratis/internal/mount"
+	"github.com/kriansa/podman-volume-stratis/internal/podman"
+	"github.com/kriansa/podman-volume-stratis/internal/state"
 	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+	"github.com/kriansa/podman-volume-stratis/internal/systemd"
 	"github.com/kriansa/podman-volume-stratis/internal/version"
 	"github.com/kriansa/podman-volume-stratis/internal/log"
 )
 
+const (
+	// eventHistorySize is how many events are kept for replay by the events API
+	eventHistorySize = 1024
+	// versionProbeTimeout bounds asking stratisd for its API revision on --version
+	versionProbeTimeout = 5 * time.Second
+)
+
 func main() {
 	cmd := &cli.Command{
 		Name:  "podman-volume-stratis",
@@ -53,7 +68,7 @@ func main() {
 			&cli.StringFlag{
 				Name:    "backend",
 				Aliases: []string{"b"},
-				Usage:   "Stratis backend: dbus or cli",
+				Usage:   "Stratis backend: dbus, cli, loopfile or memory",
 				Value:   config.DefaultBackend,
 			},
 			&cli.BoolFlag{
@@ -63,6 +78,15 @@ func main() {
 			},
 		},
 		Action: run,
+		Commands: []*cli.Command{
+			auditCommand(),
+			eventsCommand(),
+			gcCommand(),
+			protectCommand(),
+			reconcileCommand(),
+			trashCommand(),
+			unprotectCommand(),
+		},
 	}
 
 	if err := cmd.Run(context.Background(), os.Args); err != nil {
@@ -74,30 +98,22 @@ func main() {
 func run(ctx context.Context, cmd *cli.Command) error {
 	// Handle version flag
 	if cmd.Bool("version") {
-		fmt.Println(version.String())
+		printVersion(ctx, cmd)
 		return nil
 	}
 
 	// Setup logging
 	log.Setup(cmd.Bool("verbose"))
 
-	// Load config file
-	cfg, err := config.Load(cmd.String("config"))
+	// Stop gracefully on SIGTERM/SIGINT
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	cfg, err := loadConfig(cmd)
 	if err != nil {
-		return fmt.Errorf("load config: %w", err)
+		return err
 	}
 
-	// Merge CLI flags (CLI takes precedence)
-	cfg.Merge(
-		cmd.String("pool"),
-		cmd.String("mount-path"),
-		cmd.String("socket"),
-		cmd.String("backend"),
-	)
-
-	// Apply defaults
-	cfg.ApplyDefaults()
-
 	// Validate config
 	if err := cfg.Validate(); err != nil {
 		return fmt.Errorf("invalid config: %w", err)
@@ -108,6 +124,7 @@ func run(ctx context.Context, cmd *cli.Command) error {
 		"mount_path", cfg.MountPath,
 		"socket", cfg.SocketPath,
 		"backend", cfg.Backend,
+		"mounter", cfg.Mounter,
 	)
 
 	// Ensure mount path exists
@@ -116,14 +133,33 @@ func run(ctx context.Context, cmd *cli.Command) error {
 	}
 
 	// Create components
-	stratisMgr, err := stratis.NewManager(cfg.Pool, cfg.Backend)
+	stratisMgr, err := stratis.NewManager(cfg.Pool, cfg.Backend,
+		stratis.WithLoopfileDir(cfg.LoopfileDir),
+		stratis.WithMemoryDir(cfg.MemoryDir),
+	)
 	if err != nil {
 		return fmt.Errorf("create stratis manager: %w", err)
 	}
-	mounter := mount.NewSyscallMounter(cfg.MountPath)
+	mounter, err := mount.NewMounter(cfg.Mounter, cfg.MountPath)
+	if err != nil {
+		return fmt.Errorf("create mounter: %w", err)
+	}
+
+	backend := cfg.Backend
+	if dbusMgr, ok := stratisMgr.(*stratis.DBusManager); ok {
+		log.Info("using stratisd DBus API", "revision", dbusMgr.Revision())
+		backend += " API " + dbusMgr.Revision()
+	}
+
+	stratisMgr, mounter, err = injectFaults(cfg, stratisMgr, mounter)
+	if err != nil {
+		return err
+	}
 
 	// Check pool exists
-	poolExists, err := stratisMgr.PoolExists()
+	poolCtx, cancel := context.WithTimeout(ctx, cfg.QueryTimeout)
+	poolExists, err := stratisMgr.PoolExists(poolCtx)
+	cancel()
 	if err != nil {
 		return fmt.Errorf("check stratis pool: %w", err)
 	}
@@ -133,34 +169,153 @@ func run(ctx context.Context, cmd *cli.Command) error {
 
 	log.Debug("stratis pool verified", "pool", cfg.Pool)
 
+	// During an upgrade, wait for the previous process to stop and save the
+	// state before loading it
+	if err := handover.Prepared(); err != nil {
+		return err
+	}
+
+	// Load persisted volume state
+	store, err := state.Open(cfg.StatePath)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+
+	// Load the journal of operations a previous process left unfinished
+	opJournal, err := journal.Open(cfg.JournalPath)
+	if err != nil {
+		return fmt.Errorf("load journal: %w", err)
+	}
+
+	// Open audit log
+	auditLog, err := audit.Open(cfg.AuditLog, int64(cfg.AuditMaxSizeMB)*1024*1024, cfg.AuditMaxFiles)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer auditLog.Close()
+
 	// Create driver
-	d := driver.NewDriver(
-		cfg.MountPath,
-		stratisMgr,
-		mounter,
-	)
+	bus := events.NewBus(eventHistorySize)
+	driverOpts := []driver.DriverOption{
+		driver.WithEvents(bus),
+		driver.WithState(store),
+		driver.WithJournal(opJournal),
+		driver.WithAudit(auditLog),
+		driver.WithReconcilePolicy(reconcilePolicy(cfg)),
+		driver.WithTimeouts(driver.Timeouts{
+			Create:  cfg.CreateTimeout,
+			Remove:  cfg.RemoveTimeout,
+			Mount:   cfg.MountTimeout,
+			Unmount: cfg.UnmountTimeout,
+			Query:   cfg.QueryTimeout,
+			Erase:   cfg.EraseTimeout,
+		}),
+		driver.WithTrash(cfg.TrashRetention),
+		driver.WithSecureErase(cfg.SecureErase),
+	}
+	if cfg.PodmanSocket != "" {
+		driverOpts = append(driverOpts, driver.WithContainers(podman.NewClient(cfg.PodmanSocket), driver.OrphanPolicy{
+			Threshold: cfg.OrphanThreshold,
+			Action:    driver.OrphanAction(cfg.OrphanAction),
+		}))
+	}
+	d := driver.NewDriver(cfg.MountPath, stratisMgr, mounter, driverOpts...)
 
-	// Create handler
-	h := volume.NewHandler(d)
+	// Roll back or finish operations cut short by a crash, before
+	// reconciliation looks at what they left behind
+	if err := d.Recover(ctx); err != nil {
+		log.Warn("failed to recover unfinished operations", "error", err)
+	}
 
-	// Ensure socket directory exists
-	socketDir := filepath.Dir(cfg.SocketPath)
-	if err := os.MkdirAll(socketDir, 0755); err != nil {
-		return fmt.Errorf("create socket directory: %w", err)
+	// Bring mounts, directories and state in line with stratisd before
+	// serving, e.g. after a crash or reboot. Failures are not fatal.
+	if _, err := d.Reconcile(ctx, false); err != nil {
+		log.Warn("startup reconciliation failed", "error", err)
 	}
 
-	// Remove existing socket if present (stale from previous run)
-	if err := os.Remove(cfg.SocketPath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("remove existing socket: %w", err)
+	// Background monitors, run by the plugin while it serves
+	var workers []func(context.Context)
+	if cfg.UsageThreshold > 0 {
+		workers = append(workers, func(ctx context.Context) {
+			d.MonitorUsage(ctx, cfg.UsageCheckInterval, cfg.UsageThreshold)
+		})
+	}
+	if cfg.TrashRetention > 0 {
+		workers = append(workers, func(ctx context.Context) { d.ReapTrash(ctx, cfg.TrashReapInterval) })
 	}
+	workers = append(workers, func(ctx context.Context) { d.ReapExpired(ctx, cfg.ExpiryCheckInterval) })
+	if cfg.PodmanSocket != "" {
+		workers = append(workers, func(ctx context.Context) { d.MonitorOrphans(ctx, cfg.OrphanCheckInterval) })
+	}
+
+	// Ping the watchdog only while stratisd answers for our pool. It only
+	// looks at the pool, and keeps running during an upgrade, as systemd
+	// expects pings from this process until the new one has taken over.
+	go systemd.Watchdog(ctx, func() error {
+		checkCtx, cancel := context.WithTimeout(ctx, cfg.QueryTimeout)
+		defer cancel()
 
-	// Clean up socket on exit
-	defer func() {
-		if err := os.Remove(cfg.SocketPath); err != nil && !os.IsNotExist(err) {
-			log.Warn("failed to remove socket on shutdown", "path", cfg.SocketPath, "error", err)
+		exists, err := stratisMgr.PoolExists(checkCtx)
+		if err != nil {
+			return err
 		}
-	}()
+		if !exists {
+			return fmt.Errorf("stratis pool %q does not exist", cfg.Pool)
+		}
+		return nil
+	})
+
+	p := &plugin{
+		cfg:      cfg,
+		backend:  backend,
+		driver:   d,
+		store:    store,
+		events:   bus,
+		auditLog: auditLog,
+		workers:  workers,
+	}
+	return p.run(ctx)
+}
+
+// printVersion prints the plugin version and, for the DBus backend, the
+// revision of stratisd's API it would use
+func printVersion(ctx context.Context, cmd *cli.Command) {
+	fmt.Println(version.String())
+
+	cfg, err := loadConfig(cmd)
+	if err != nil || cfg.Backend != "dbus" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, versionProbeTimeout)
+	defer cancel()
+
+	rev, err := stratis.DetectRevision(ctx)
+	if err != nil {
+		fmt.Printf("stratisd DBus API: unavailable (%v)\n", err)
+		return
+	}
+	fmt.Printf("stratisd DBus API: %s\n", rev)
+}
+
+// loadConfig loads the config file and merges the global CLI flags into it
+func loadConfig(cmd *cli.Command) (*config.Config, error) {
+	// Load config file
+	cfg, err := config.Load(cmd.String("config"))
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+
+	// Merge CLI flags (CLI takes precedence)
+	cfg.Merge(
+		cmd.String("pool"),
+		cmd.String("mount-path"),
+		cmd.String("socket"),
+		cmd.String("backend"),
+	)
+
+	// Apply defaults
+	cfg.ApplyDefaults()
 
-	log.Info("listening on socket", "path", cfg.SocketPath)
-	return h.ServeUnix(cfg.SocketPath, 0)
+	return cfg, nil
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/kriansa/podman-volume-stratis/internal/control"
+)
+
+// protectCommand returns the admin command that protects a volume against removal
+func protectCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "protect",
+		Usage:     "Make the plugin refuse to remove a volume",
+		ArgsUsage: "VOLUME",
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			return setProtected(ctx, cmd, true)
+		},
+	}
+}
+
+// unprotectCommand returns the admin command that lifts the protection of a volume
+func unprotectCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "unprotect",
+		Usage:     "Allow a protected volume to be removed again",
+		ArgsUsage: "VOLUME",
+		Action: func(ctx context.Context, cmd *cli.Command) error {
+			return setProtected(ctx, cmd, false)
+		},
+	}
+}
+
+func setProtected(ctx context.Context, cmd *cli.Command, protected bool) error {
+	if cmd.Args().Len() != 1 {
+		return fmt.Errorf("expected one volume name, got %d arguments", cmd.Args().Len())
+	}
+	name := cmd.Args().First()
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	if err := control.NewClient(cfg.ControlSocket).SetProtected(ctx, name, protected); err != nil {
+		return err
+	}
+
+	if protected {
+		fmt.Printf("volume %s is protected against removal\n", name)
+	} else {
+		fmt.Printf("volume %s can be removed\n", name)
+	}
+	return nil
+}
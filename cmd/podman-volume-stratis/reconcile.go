@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/kriansa/podman-volume-stratis/internal/config"
+	"github.com/kriansa/podman-volume-stratis/internal/control"
+	"github.com/kriansa/podman-volume-stratis/internal/driver"
+)
+
+// reconcileCommand returns the admin command that runs a reconciliation pass
+func reconcileCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "reconcile",
+		Usage: "Find and fix inconsistencies between stratisd, mounts, directories and state",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only report what would be fixed",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print findings as JSON",
+			},
+		},
+		Action: reconcile,
+	}
+}
+
+func reconcile(ctx context.Context, cmd *cli.Command) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	client := control.NewClient(cfg.ControlSocket)
+	findings, err := client.Reconcile(ctx, cmd.Bool("dry-run"))
+	if err != nil {
+		return err
+	}
+
+	if cmd.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(findings)
+	}
+
+	if len(findings) == 0 {
+		fmt.Println("no inconsistencies found")
+		return nil
+	}
+	for _, f := range findings {
+		fmt.Println(formatFinding(f))
+	}
+
+	return nil
+}
+
+// formatFinding renders a reconciliation finding as a single human-readable line
+func formatFinding(f driver.Finding) string {
+	var b strings.Builder
+
+	status := string(f.Action)
+	switch {
+	case f.Fixed:
+		status = "fixed"
+	case f.Error != "":
+		status = "failed"
+	}
+
+	fmt.Fprintf(&b, "%-7s %-16s", status, f.Kind)
+	if f.Volume != "" {
+		fmt.Fprintf(&b, " volume=%s", f.Volume)
+	}
+	if f.Path != "" {
+		fmt.Fprintf(&b, " path=%s", f.Path)
+	}
+	if f.Device != "" {
+		fmt.Fprintf(&b, " device=%s", f.Device)
+	}
+	fmt.Fprintf(&b, " %s", f.Detail)
+	if f.Error != "" {
+		fmt.Fprintf(&b, " error=%q", f.Error)
+	}
+
+	return b.String()
+}
+
+// reconcilePolicy builds the driver's reconcile policy from the config
+func reconcilePolicy(cfg *config.Config) driver.ReconcilePolicy {
+	return driver.ReconcilePolicy{
+		driver.StrayMount:     driver.ReconcileAction(cfg.ReconcileStrayMounts),
+		driver.MisplacedMount: driver.ReconcileAction(cfg.ReconcileMisplacedMounts),
+		driver.OrphanDir:      driver.ReconcileAction(cfg.ReconcileOrphanDirs),
+		driver.StaleState:     driver.ReconcileAction(cfg.ReconcileStaleState),
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/go-connections/sockets"
+
+	"github.com/kriansa/podman-volume-stratis/internal/audit"
+	"github.com/kriansa/podman-volume-stratis/internal/config"
+	"github.com/kriansa/podman-volume-stratis/internal/control"
+	"github.com/kriansa/podman-volume-stratis/internal/driver"
+	"github.com/kriansa/podman-volume-stratis/internal/events"
+	"github.com/kriansa/podman-volume-stratis/internal/handover"
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+	"github.com/kriansa/podman-volume-stratis/internal/server"
+	"github.com/kriansa/podman-volume-stratis/internal/state"
+	"github.com/kriansa/podman-volume-stratis/internal/systemd"
+)
+
+// handoverReadyTimeout is how long a new process may take to start, and then
+// to start serving, during an upgrade before the old one resumes
+const handoverReadyTimeout = time.Minute
+
+// plugin ties the driver to its sockets and handles the process lifecycle:
+// serving, graceful shutdown and upgrades by socket handover
+type plugin struct {
+	cfg      *config.Config
+	backend  string
+	driver   *driver.Driver
+	store    *state.Store
+	events   *events.Bus
+	auditLog *audit.Logger
+
+	// workers act on volumes by themselves, e.g. reapers, and run while
+	// the plugin serves
+	workers     []func(ctx context.Context)
+	stopWorkers func()
+
+	listener  net.Listener
+	activated bool
+	srv       *server.Server
+	ctrl      *control.Server
+}
+
+// run serves requests until ctx is cancelled or the plugin hands its socket
+// over to a new process on SIGUSR2
+func (p *plugin) run(ctx context.Context) error {
+	// Use the socket from a previous process or systemd, otherwise create it
+	listener, activated, err := listenPlugin(p.cfg.SocketPath)
+	if err != nil {
+		return err
+	}
+	p.listener = listener
+	p.activated = activated
+
+	// Clean up sockets on exit, unless they now belong to a new process.
+	// The plugin socket is also left alone when it belongs to systemd.
+	handedOver := false
+	defer func() {
+		if !handedOver {
+			p.removeSockets()
+		}
+	}()
+
+	upgrade := make(chan os.Signal, 1)
+	signal.Notify(upgrade, syscall.SIGUSR2)
+	defer signal.Stop(upgrade)
+
+	p.startWorkers(ctx)
+	serveErr, err := p.start()
+	if err != nil {
+		p.stopWorkers()
+		return err
+	}
+
+	for {
+		select {
+		case err := <-serveErr:
+			return fmt.Errorf("serve: %w", err)
+		case <-ctx.Done():
+			return p.shutdown()
+		case <-upgrade:
+		}
+
+		stopped, err := p.upgrade()
+		if err == nil {
+			handedOver = true
+			return nil
+		}
+		if !stopped {
+			log.Error("upgrade failed, still serving", "error", err)
+			systemd.Ready(p.status())
+			continue
+		}
+		if p.listener == nil {
+			return err
+		}
+
+		log.Error("upgrade failed, resuming service", "error", err)
+		p.startWorkers(ctx)
+		if serveErr, err = p.start(); err != nil {
+			p.stopWorkers()
+			return err
+		}
+	}
+}
+
+// start begins serving the plugin protocol and the admin API, then reports
+// readiness to systemd and to a previous process waiting on a handover
+func (p *plugin) start() (<-chan error, error) {
+	p.srv = server.New(p.driver, p.auditLog)
+	p.ctrl = control.NewServer(p.events, p.driver)
+
+	if err := serveControl(p.cfg.ControlSocket, p.ctrl); err != nil {
+		return nil, err
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if err := p.srv.Serve(p.listener); !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+		}
+	}()
+
+	log.Info("listening on socket", "path", p.cfg.SocketPath, "activated", p.activated)
+	systemd.Ready(p.status())
+	if err := handover.Ready(); err != nil {
+		log.Warn("failed to notify previous process", "error", err)
+	}
+
+	return serveErr, nil
+}
+
+// startWorkers runs the background workers until stopWorkers is called or
+// ctx is cancelled
+func (p *plugin) startWorkers(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, worker := range p.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker(ctx)
+		}()
+	}
+
+	p.stopWorkers = func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// status describes the plugin to systemd
+func (p *plugin) status() string {
+	return fmt.Sprintf("serving pool %s via %s", p.cfg.Pool, p.backend)
+}
+
+// stop stops accepting requests and waits for in-flight ones to finish.
+// Removals busy erasing a volume are cut short rather than waited for; the
+// erase is finished later.
+func (p *plugin) stop() {
+	p.ctrl.Close()
+	p.driver.AbortErases()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := p.srv.Shutdown(ctx); err != nil {
+		log.Warn("in-flight requests did not finish before the shutdown timeout", "error", err)
+	}
+}
+
+// shutdown stops serving and persists the volume state. The sockets are
+// removed by the caller only after this returns.
+func (p *plugin) shutdown() error {
+	log.Info("shutting down, waiting for in-flight requests", "timeout", p.cfg.ShutdownTimeout)
+	systemd.Notify("STOPPING=1")
+
+	p.stop()
+	p.stopWorkers()
+
+	if p.cfg.UnmountIdleOnShutdown {
+		if err := p.driver.UnmountIdle(context.Background()); err != nil {
+			log.Warn("failed to unmount idle volumes", "error", err)
+		}
+	}
+
+	if err := p.store.Save(); err != nil {
+		return fmt.Errorf("persist state: %w", err)
+	}
+
+	log.Info("shutdown complete")
+	return nil
+}
+
+// upgrade hands the plugin socket over to a freshly executed binary. The old
+// process keeps serving while the new one starts, and only stops, draining
+// in-flight requests and saving the state, once the new one reports that it
+// has started; the new one loads the state after that, so the two never
+// operate on the same volumes. Meanwhile new connections queue in the socket
+// backlog. Stopping includes the background workers, which the caller starts
+// again if needed. stopped reports whether the old process stopped serving;
+// if it did and the upgrade failed, it takes the socket back, leaving
+// p.listener nil if that is impossible.
+func (p *plugin) upgrade() (stopped bool, err error) {
+	log.Info("upgrading, handing socket over to a new process")
+	systemd.Notify("RELOADING=1")
+
+	unixListener, ok := p.listener.(*net.UnixListener)
+	if !ok {
+		return false, fmt.Errorf("cannot hand over a %T", p.listener)
+	}
+
+	// Keep a duplicate of the socket, as stopping the server closes the listener
+	file, err := unixListener.File()
+	if err != nil {
+		return false, fmt.Errorf("duplicate socket: %w", err)
+	}
+	defer file.Close()
+
+	pid, err := handover.Start(file, p.activated, handoverReadyTimeout, func() {
+		stopped = true
+		p.stop()
+		p.stopWorkers()
+		if err := p.store.Save(); err != nil {
+			log.Warn("failed to persist state before upgrade", "error", err)
+		}
+	})
+	if err != nil {
+		if !stopped {
+			return false, err
+		}
+		listener, reclaimErr := net.FileListener(file)
+		if reclaimErr != nil {
+			p.listener = nil
+			return true, fmt.Errorf("%w (and failed to reclaim socket: %v)", err, reclaimErr)
+		}
+		p.listener = listener
+		return true, err
+	}
+
+	log.Info("upgrade complete, new process is serving", "pid", pid)
+	return true, nil
+}
+
+// removeSockets removes the admin socket, and the plugin socket unless it
+// belongs to systemd
+func (p *plugin) removeSockets() {
+	if !p.activated {
+		if err := os.Remove(p.cfg.SocketPath); err != nil && !os.IsNotExist(err) {
+			log.Warn("failed to remove socket on shutdown", "path", p.cfg.SocketPath, "error", err)
+		}
+	}
+
+	if err := os.Remove(p.cfg.ControlSocket); err != nil && !os.IsNotExist(err) {
+		log.Warn("failed to remove control socket on shutdown", "path", p.cfg.ControlSocket, "error", err)
+	}
+}
+
+// listenPlugin returns the listener for the plugin socket. The socket is
+// inherited from a previous process during an upgrade, or from a systemd
+// .socket unit, in which case activated is true; otherwise a fresh socket is
+// created at path, replacing any stale one.
+func listenPlugin(path string) (listener net.Listener, activated bool, err error) {
+	listener, activated, err = handover.Inherited()
+	if err != nil {
+		return nil, false, err
+	}
+	if listener != nil {
+		log.Info("took over socket from previous process", "path", path)
+		return listener, activated, nil
+	}
+
+	listener, err = systemd.ActivatedListener()
+	if err != nil {
+		return nil, false, err
+	}
+	if listener != nil {
+		if addr := listener.Addr().String(); addr != path {
+			log.Warn("activated socket differs from configured socket path", "activated", addr, "configured", path)
+		}
+		return listener, true, nil
+	}
+
+	// Ensure socket directory exists
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, false, fmt.Errorf("create socket directory: %w", err)
+	}
+
+	// Remove existing socket if present (stale from previous run)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, false, fmt.Errorf("remove existing socket: %w", err)
+	}
+
+	listener, err = sockets.NewUnixSocket(path, 0)
+	if err != nil {
+		return nil, false, fmt.Errorf("listen on socket: %w", err)
+	}
+
+	// Closing the listener must not unlink the socket; it is removed
+	// explicitly once shutdown has completed
+	if unixListener, ok := listener.(*net.UnixListener); ok {
+		unixListener.SetUnlinkOnClose(false)
+	}
+
+	return listener, false, nil
+}
+
+// serveControl starts the admin API on a root-only unix socket
+func serveControl(path string, srv *control.Server) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create control socket directory: %w", err)
+	}
+
+	listener, err := sockets.NewUnixSocketWithOpts(path, sockets.WithChmod(0600))
+	if err != nil {
+		return fmt.Errorf("listen on control socket: %w", err)
+	}
+
+	log.Info("admin API listening", "path", path)
+	go func() {
+		if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Error("admin API stopped", "error", err)
+		}
+	}()
+
+	return nil
+}
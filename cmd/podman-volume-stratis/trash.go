@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+
+	"github.com/kriansa/podman-volume-stratis/internal/control"
+	"github.com/kriansa/podman-volume-stratis/internal/driver"
+)
+
+// trashCommand returns the admin command for removed volumes kept in the trash
+func trashCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "trash",
+		Usage: "List, restore and empty removed volumes kept in the trash",
+		Commands: []*cli.Command{
+			{
+				Name:  "list",
+				Usage: "List the removed volumes in the trash, oldest first",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print entries as JSON",
+					},
+				},
+				Action: trashList,
+			},
+			{
+				Name:      "restore",
+				Usage:     "Restore a removed volume, by its trash entry or its volume name",
+				ArgsUsage: "ENTRY",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "as",
+						Usage: "Restore the volume under another name",
+					},
+				},
+				Action: trashRestore,
+			},
+			{
+				Name:   "empty",
+				Usage:  "Destroy every removed volume in the trash",
+				Action: trashEmpty,
+			},
+		},
+	}
+}
+
+func trashList(ctx context.Context, cmd *cli.Command) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	entries, err := control.NewClient(cfg.ControlSocket).Trash(ctx)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("trash is empty")
+		return nil
+	}
+	for _, e := range entries {
+		fmt.Println(formatTrashEntry(e))
+	}
+
+	return nil
+}
+
+func trashRestore(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() != 1 {
+		return fmt.Errorf("expected one trash entry or volume name, got %d arguments", cmd.Args().Len())
+	}
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	name, err := control.NewClient(cfg.ControlSocket).RestoreTrash(ctx, cmd.Args().First(), cmd.String("as"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("restored volume %s\n", name)
+	return nil
+}
+
+func trashEmpty(ctx context.Context, cmd *cli.Command) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+
+	purged, err := control.NewClient(cfg.ControlSocket).EmptyTrash(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range purged {
+		fmt.Printf("destroyed %s\n", formatTrashEntry(e))
+	}
+	fmt.Printf("%d removed volumes destroyed\n", len(purged))
+	return nil
+}
+
+// formatTrashEntry renders a trash entry as a single human-readable line
+func formatTrashEntry(e driver.TrashEntry) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s volume=%s removed=%s used=%d",
+		e.Name, e.Volume, e.Removed.Local().Format("2006-01-02 15:04:05"), e.Used)
+	if !e.Expires.IsZero() {
+		fmt.Fprintf(&b, " expires=%s", e.Expires.Local().Format("2006-01-02 15:04:05"))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,252 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+)
+
+const (
+	// ResultOK marks an operation that completed successfully
+	ResultOK = "ok"
+	// ResultError marks an operation that returned an error
+	ResultError = "error"
+)
+
+// Peer identifies the process on the other end of the plugin or control socket
+type Peer struct {
+	PID int32  `json:"pid"`
+	UID uint32 `json:"uid"`
+	GID uint32 `json:"gid"`
+}
+
+// Entry is a single audit record, written as one JSON line
+type Entry struct {
+	Time      time.Time         `json:"time"`
+	Operation string            `json:"operation"`
+	Volume    string            `json:"volume,omitempty"`
+	Options   map[string]string `json:"options,omitempty"`
+	Peer      *Peer             `json:"peer,omitempty"`
+	// Actor is who ran an operation that did not come from the plugin
+	// socket, e.g. ActorAdmin
+	Actor      string  `json:"actor,omitempty"`
+	Result     string  `json:"result"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// Logger appends audit entries to a JSON lines file, rotating it once it
+// grows past maxSize. Rotated files are named <path>.1 (newest) up to
+// <path>.<maxFiles> (oldest).
+type Logger struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// Open opens (or creates) the audit log at path for appending
+func Open(path string, maxSize int64, maxFiles int) (*Logger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("create audit log directory: %w", err)
+	}
+
+	l := &Logger{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// open opens the current log file in append-only mode
+func (l *Logger) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat audit log: %w", err)
+	}
+
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Record appends an entry to the log, rotating first if needed
+func (l *Logger) Record(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encode audit entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return fmt.Errorf("audit log is closed")
+	}
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("rotate audit log: %w", err)
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write audit entry: %w", err)
+	}
+
+	// Audit records must survive a crash right after the operation
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("sync audit log: %w", err)
+	}
+
+	return nil
+}
+
+// RecordOperation records the outcome of an operation that started at
+// start, with the peer and actor stored in ctx. A failure to write the entry
+// is logged. A nil Logger records nothing.
+func (l *Logger) RecordOperation(ctx context.Context, op, volume string, options map[string]string, start time.Time, err error) {
+	if l == nil {
+		return
+	}
+
+	entry := Entry{
+		Time:       start.UTC(),
+		Operation:  op,
+		Volume:     volume,
+		Options:    options,
+		Peer:       PeerFromContext(ctx),
+		Actor:      ActorFromContext(ctx),
+		Result:     ResultOK,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		entry.Result = ResultError
+		entry.Error = err.Error()
+	}
+
+	if recordErr := l.Record(entry); recordErr != nil {
+		log.Error("failed to write audit entry", "operation", op, "name", volume, "error", recordErr)
+	}
+}
+
+// rotate shifts <path>.N to <path>.N+1, moves the current file to <path>.1
+// and reopens a fresh file. The oldest file beyond maxFiles is dropped.
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	if l.maxFiles > 0 {
+		for i := l.maxFiles - 1; i >= 1; i-- {
+			err := os.Rename(rotatedPath(l.path, i), rotatedPath(l.path, i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(l.path, rotatedPath(l.path, 1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return err
+	}
+
+	return l.open()
+}
+
+// Close closes the underlying file
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Tail returns the last n entries of the audit log at path, oldest first.
+// Rotated files are read as needed to fill up n entries.
+func Tail(path string, n int) ([]Entry, error) {
+	var entries []Entry
+
+	for i := 0; len(entries) < n; i++ {
+		file := path
+		if i > 0 {
+			file = rotatedPath(path, i)
+		}
+
+		fileEntries, err := readEntries(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			return nil, err
+		}
+
+		entries = append(fileEntries, entries...)
+	}
+
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+
+	return entries, nil
+}
+
+// readEntries reads all entries from a single audit log file
+func readEntries(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		entries = append(entries, e)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+
+	return entries, nil
+}
+
+// rotatedPath returns the path of the n-th rotated log file
+func rotatedPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
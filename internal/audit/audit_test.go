@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLogger_RecordAndTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	l, err := Open(path, 0, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer l.Close()
+
+	entries := []Entry{
+		{Time: time.Now(), Operation: "create", Volume: "vol1", Options: map[string]string{"size": "1G"}, Result: ResultOK},
+		{Time: time.Now(), Operation: "mount", Volume: "vol1", Peer: &Peer{PID: 42, UID: 0, GID: 0}, Result: ResultOK},
+		{Time: time.Now(), Operation: "remove", Volume: "vol1", Result: ResultError, Error: "volume is busy"},
+	}
+	for _, e := range entries {
+		if err := l.Record(e); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	got, err := Tail(path, 2)
+	if err != nil {
+		t.Fatalf("Tail() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Tail() returned %d entries, want 2", len(got))
+	}
+	if got[0].Operation != "mount" || got[1].Operation != "remove" {
+		t.Errorf("Tail() = [%s %s], want [mount remove]", got[0].Operation, got[1].Operation)
+	}
+	if got[0].Peer == nil || got[0].Peer.PID != 42 {
+		t.Errorf("Tail() lost peer credentials: %+v", got[0].Peer)
+	}
+	if got[1].Error != "volume is busy" {
+		t.Errorf("Error = %q, want %q", got[1].Error, "volume is busy")
+	}
+}
+
+func TestLogger_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	// Small enough that every entry triggers a rotation
+	l, err := Open(path, 10, 2)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer l.Close()
+
+	for _, op := range []string{"create", "mount", "unmount", "remove"} {
+		if err := l.Record(Entry{Operation: op, Volume: "vol1", Result: ResultOK}); err != nil {
+			t.Fatalf("Record(%s) error = %v", op, err)
+		}
+	}
+
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("expected %s to exist: %v", p, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected %s.3 to be dropped, got err = %v", path, err)
+	}
+
+	got, err := Tail(path, 10)
+	if err != nil {
+		t.Fatalf("Tail() error = %v", err)
+	}
+
+	var ops []string
+	for _, e := range got {
+		ops = append(ops, e.Operation)
+	}
+	want := []string{"mount", "unmount", "remove"}
+	if len(ops) != len(want) {
+		t.Fatalf("Tail() = %v, want %v", ops, want)
+	}
+	for i := range want {
+		if ops[i] != want[i] {
+			t.Errorf("Tail() = %v, want %v", ops, want)
+			break
+		}
+	}
+}
+
+func TestTail_MissingFile(t *testing.T) {
+	got, err := Tail(filepath.Join(t.TempDir(), "missing.log"), 5)
+	if err != nil {
+		t.Fatalf("Tail() error = %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Tail() returned %d entries, want 0", len(got))
+	}
+}
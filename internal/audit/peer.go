@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// Who runs an operation that does not come from the plugin socket
+const (
+	// ActorAdmin is an admin request on the control socket
+	ActorAdmin = "admin"
+	// ActorReaper is a background worker of the plugin, e.g. the trash reaper
+	ActorReaper = "reaper"
+	// ActorPlugin is the plugin itself, e.g. recovering at startup
+	ActorPlugin = "plugin"
+)
+
+type peerKey struct{}
+
+type actorKey struct{}
+
+// WithPeerCredentials stores the SO_PEERCRED credentials of a unix socket
+// connection in its context. Other connection types are left untouched.
+// Meant as the ConnContext of an http.Server.
+func WithPeerCredentials(ctx context.Context, c net.Conn) context.Context {
+	unixConn, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return ctx
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, peerKey{}, &Peer{
+		PID: cred.Pid,
+		UID: cred.Uid,
+		GID: cred.Gid,
+	})
+}
+
+// PeerFromContext returns the peer credentials stored by WithPeerCredentials
+func PeerFromContext(ctx context.Context) *Peer {
+	peer, _ := ctx.Value(peerKey{}).(*Peer)
+	return peer
+}
+
+// WithActor stores who runs the operations done with ctx
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor stored by WithActor, or ""
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
@@ -3,8 +3,11 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/kriansa/podman-volume-stratis/internal/fault"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
 )
 
 const (
@@ -16,6 +19,61 @@ const (
 	DefaultMountPath = "/mnt"
 	// DefaultBackend is the default stratis backend
 	DefaultBackend = "dbus"
+	// DefaultMounter is the default way of mounting volumes
+	DefaultMounter = "syscall"
+	// DefaultAuditLogPath is the default location of the audit log
+	DefaultAuditLogPath = "/var/log/podman-volume-stratis/audit.log"
+	// DefaultAuditMaxSizeMB is the size at which the audit log is rotated
+	DefaultAuditMaxSizeMB = 10
+	// DefaultAuditMaxFiles is the number of rotated audit logs to keep
+	DefaultAuditMaxFiles = 5
+	// DefaultControlSocketPath is the default Unix socket path for the admin API
+	DefaultControlSocketPath = "/run/podman-volume-stratis/control.sock"
+	// DefaultUsageThreshold is the default volume usage percentage that triggers an event
+	DefaultUsageThreshold = 90
+	// DefaultUsageCheckInterval is how often volume usage is checked by default
+	DefaultUsageCheckInterval = time.Minute
+	// DefaultStatePath is the default location of the persisted volume state
+	DefaultStatePath = "/var/lib/podman-volume-stratis/state.json"
+	// DefaultJournalPath is the default location of the journal of unfinished
+	// operations
+	DefaultJournalPath = "/var/lib/podman-volume-stratis/journal.json"
+	// DefaultShutdownTimeout is how long in-flight requests may run on shutdown
+	DefaultShutdownTimeout = 30 * time.Second
+	// DefaultReconcileAction is what startup reconciliation does about a
+	// finding unless configured otherwise
+	DefaultReconcileAction = "report"
+	// DefaultCreateTimeout bounds creating a volume by default
+	DefaultCreateTimeout = 2 * time.Minute
+	// DefaultRemoveTimeout bounds removing a volume by default
+	DefaultRemoveTimeout = 2 * time.Minute
+	// DefaultMountTimeout bounds mounting a volume by default; mounting XFS
+	// may replay its log
+	DefaultMountTimeout = time.Minute
+	// DefaultUnmountTimeout bounds unmounting a volume by default
+	DefaultUnmountTimeout = time.Minute
+	// DefaultQueryTimeout bounds inspecting volumes by default
+	DefaultQueryTimeout = 30 * time.Second
+	// DefaultEraseTimeout bounds securely erasing a volume by default;
+	// zero-filling a large device takes a while
+	DefaultEraseTimeout = time.Hour
+	// DefaultTrashReapInterval is how often the trash is checked for expired
+	// volumes by default
+	DefaultTrashReapInterval = 10 * time.Minute
+	// DefaultExpiryCheckInterval is how often ephemeral and ttl volumes are
+	// checked for expiry by default
+	DefaultExpiryCheckInterval = time.Minute
+	// DefaultOrphanThreshold is how long a volume must have gone
+	// unreferenced by any container to be an orphan by default
+	DefaultOrphanThreshold = 7 * 24 * time.Hour
+	// DefaultOrphanAction is what is done about orphaned volumes by default
+	DefaultOrphanAction = "report"
+	// DefaultOrphanCheckInterval is how often Podman is asked for volume
+	// references by default
+	DefaultOrphanCheckInterval = 10 * time.Minute
+	// DefaultReconcileStaleState is what reconciliation does about stale
+	// state by default; mount references never survive a reboot
+	DefaultReconcileStaleState = "fix"
 )
 
 // Config holds the plugin configuration
@@ -26,8 +84,91 @@ type Config struct {
 	MountPath string `toml:"mount_path"`
 	// SocketPath is the Unix socket path for the plugin
 	SocketPath string `toml:"socket"`
-	// Backend is the stratis backend to use: "dbus" or "cli"
+	// Backend is the stratis backend to use: "dbus", "cli", "loopfile" or "memory"
 	Backend string `toml:"backend"`
+	// LoopfileDir is where the loopfile backend keeps the image files of its pools
+	LoopfileDir string `toml:"loopfile_dir"`
+	// MemoryDir is where the memory backend creates the directories of its
+	// pools; empty for the system's temporary directory
+	MemoryDir string `toml:"memory_dir"`
+	// Mounter is how volumes are mounted: "syscall" mounts their devices,
+	// "bind" bind mounts the directories of the memory backend
+	Mounter string `toml:"mounter"`
+	// AuditLog is the path of the JSON lines audit log
+	AuditLog string `toml:"audit_log"`
+	// AuditMaxSizeMB is the size in MiB at which the audit log is rotated
+	AuditMaxSizeMB int `toml:"audit_max_size_mb"`
+	// AuditMaxFiles is the number of rotated audit logs to keep
+	AuditMaxFiles int `toml:"audit_max_files"`
+	// ControlSocket is the Unix socket path for the admin API (events, etc.)
+	ControlSocket string `toml:"control_socket"`
+	// UsageThreshold is the volume usage percentage that triggers a usage event.
+	// A negative value disables the usage monitor.
+	UsageThreshold float64 `toml:"usage_threshold"`
+	// UsageCheckInterval is how often volume usage is checked
+	UsageCheckInterval time.Duration `toml:"usage_check_interval"`
+	// StatePath is the file where per-volume state is persisted
+	StatePath string `toml:"state_path"`
+	// JournalPath is the file where operations are recorded while they run,
+	// to roll back or finish those cut short by a crash on the next start
+	JournalPath string `toml:"journal_path"`
+	// ShutdownTimeout is how long in-flight requests may run after a stop signal
+	ShutdownTimeout time.Duration `toml:"shutdown_timeout"`
+	// UnmountIdleOnShutdown unmounts volumes with no mount references on shutdown
+	UnmountIdleOnShutdown bool `toml:"unmount_idle_on_shutdown"`
+	// ReconcileStrayMounts is what reconciliation does about Stratis devices
+	// mounted under MountPath that are not in the pool: "report", "fix" or "ignore"
+	ReconcileStrayMounts string `toml:"reconcile_stray_mounts"`
+	// ReconcileMisplacedMounts is what reconciliation does about volumes
+	// mounted somewhere other than their mount point
+	ReconcileMisplacedMounts string `toml:"reconcile_misplaced_mounts"`
+	// ReconcileOrphanDirs is what reconciliation does about empty directories
+	// under MountPath with no matching volume
+	ReconcileOrphanDirs string `toml:"reconcile_orphan_dirs"`
+	// ReconcileStaleState is what reconciliation does about persisted state
+	// of missing volumes or of mounts that no longer exist
+	ReconcileStaleState string `toml:"reconcile_stale_state"`
+	// CreateTimeout is how long creating a volume may take
+	CreateTimeout time.Duration `toml:"create_timeout"`
+	// RemoveTimeout is how long removing a volume may take
+	RemoveTimeout time.Duration `toml:"remove_timeout"`
+	// MountTimeout is how long mounting a volume may take
+	MountTimeout time.Duration `toml:"mount_timeout"`
+	// UnmountTimeout is how long unmounting a volume may take
+	UnmountTimeout time.Duration `toml:"unmount_timeout"`
+	// QueryTimeout is how long inspecting volumes (path, get, list) may take
+	QueryTimeout time.Duration `toml:"query_timeout"`
+	// EraseTimeout is how long securely erasing a volume may take
+	EraseTimeout time.Duration `toml:"erase_timeout"`
+	// TrashRetention is how long removed volumes are kept in the trash, to be
+	// restored, before they are destroyed. Zero destroys them right away.
+	TrashRetention time.Duration `toml:"trash_retention"`
+	// TrashReapInterval is how often the trash is checked for volumes whose
+	// retention has passed
+	TrashReapInterval time.Duration `toml:"trash_reap_interval"`
+	// ExpiryCheckInterval is how often ephemeral and ttl volumes are checked
+	// for expiry
+	ExpiryCheckInterval time.Duration `toml:"expiry_check_interval"`
+	// SecureErase erases volumes created without the secure_erase option
+	// before their filesystem is destroyed
+	SecureErase bool `toml:"secure_erase"`
+	// PodmanSocket is the Podman API socket, asked which containers reference
+	// each volume. Empty disables the Podman integration.
+	PodmanSocket string `toml:"podman_socket"`
+	// OrphanThreshold is how long a volume must have gone unreferenced by
+	// any container to be an orphan
+	OrphanThreshold time.Duration `toml:"orphan_threshold"`
+	// OrphanAction is what is done about orphaned volumes: "report", "remove"
+	// or "ignore"
+	OrphanAction string `toml:"orphan_action"`
+	// OrphanCheckInterval is how often Podman is asked for volume references
+	OrphanCheckInterval time.Duration `toml:"orphan_check_interval"`
+	// Faults are injected into the backend and the mounter, for chaos
+	// testing. Only debug builds accept them.
+	Faults []fault.Rule `toml:"fault"`
+	// FaultSeed seeds the probabilities of Faults, to repeat a run; 0 for a
+	// random seed
+	FaultSeed uint64 `toml:"fault_seed"`
 }
 
 // Load loads configuration from a TOML file
@@ -78,6 +219,88 @@ func (c *Config) ApplyDefaults() {
 	if c.Backend == "" {
 		c.Backend = DefaultBackend
 	}
+	if c.LoopfileDir == "" {
+		c.LoopfileDir = stratis.DefaultLoopfileDir
+	}
+	if c.Mounter == "" {
+		// The memory backend has directories to mount rather than devices
+		c.Mounter = DefaultMounter
+		if c.Backend == "memory" {
+			c.Mounter = "bind"
+		}
+	}
+	if c.AuditLog == "" {
+		c.AuditLog = DefaultAuditLogPath
+	}
+	if c.AuditMaxSizeMB == 0 {
+		c.AuditMaxSizeMB = DefaultAuditMaxSizeMB
+	}
+	if c.AuditMaxFiles == 0 {
+		c.AuditMaxFiles = DefaultAuditMaxFiles
+	}
+	if c.ControlSocket == "" {
+		c.ControlSocket = DefaultControlSocketPath
+	}
+	if c.UsageThreshold == 0 {
+		c.UsageThreshold = DefaultUsageThreshold
+	}
+	if c.UsageCheckInterval == 0 {
+		c.UsageCheckInterval = DefaultUsageCheckInterval
+	}
+	if c.StatePath == "" {
+		c.StatePath = DefaultStatePath
+	}
+	if c.JournalPath == "" {
+		c.JournalPath = DefaultJournalPath
+	}
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	if c.ReconcileStrayMounts == "" {
+		c.ReconcileStrayMounts = DefaultReconcileAction
+	}
+	if c.ReconcileMisplacedMounts == "" {
+		c.ReconcileMisplacedMounts = DefaultReconcileAction
+	}
+	if c.ReconcileOrphanDirs == "" {
+		c.ReconcileOrphanDirs = DefaultReconcileAction
+	}
+	if c.ReconcileStaleState == "" {
+		c.ReconcileStaleState = DefaultReconcileStaleState
+	}
+	if c.CreateTimeout == 0 {
+		c.CreateTimeout = DefaultCreateTimeout
+	}
+	if c.RemoveTimeout == 0 {
+		c.RemoveTimeout = DefaultRemoveTimeout
+	}
+	if c.MountTimeout == 0 {
+		c.MountTimeout = DefaultMountTimeout
+	}
+	if c.UnmountTimeout == 0 {
+		c.UnmountTimeout = DefaultUnmountTimeout
+	}
+	if c.QueryTimeout == 0 {
+		c.QueryTimeout = DefaultQueryTimeout
+	}
+	if c.EraseTimeout == 0 {
+		c.EraseTimeout = DefaultEraseTimeout
+	}
+	if c.TrashReapInterval == 0 {
+		c.TrashReapInterval = DefaultTrashReapInterval
+	}
+	if c.ExpiryCheckInterval == 0 {
+		c.ExpiryCheckInterval = DefaultExpiryCheckInterval
+	}
+	if c.OrphanThreshold == 0 {
+		c.OrphanThreshold = DefaultOrphanThreshold
+	}
+	if c.OrphanAction == "" {
+		c.OrphanAction = DefaultOrphanAction
+	}
+	if c.OrphanCheckInterval == 0 {
+		c.OrphanCheckInterval = DefaultOrphanCheckInterval
+	}
 }
 
 // Validate validates the configuration
@@ -87,8 +310,92 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("pool name is required (use --pool or set 'pool' in config file)")
 	}
 
-	if c.Backend != "dbus" && c.Backend != "cli" {
-		return fmt.Errorf("backend must be 'dbus' or 'cli', got %q", c.Backend)
+	switch c.Backend {
+	case "dbus", "cli", "loopfile", "memory":
+	default:
+		return fmt.Errorf("backend must be 'dbus', 'cli', 'loopfile' or 'memory', got %q", c.Backend)
+	}
+
+	switch c.Mounter {
+	case "syscall", "bind":
+	default:
+		return fmt.Errorf("mounter must be 'syscall' or 'bind', got %q", c.Mounter)
+	}
+
+	// Only the memory backend has directories rather than devices
+	if (c.Backend == "memory") != (c.Mounter == "bind") {
+		return fmt.Errorf("the memory backend and the bind mounter only work together, got backend %q and mounter %q", c.Backend, c.Mounter)
+	}
+
+	// The memory backend has directories rather than devices to erase
+	if c.SecureErase && c.Backend == "memory" {
+		return fmt.Errorf("secure_erase needs a backend with devices, not the memory backend")
+	}
+
+	if c.AuditMaxSizeMB < 0 {
+		return fmt.Errorf("audit_max_size_mb cannot be negative")
+	}
+
+	if c.AuditMaxFiles < 0 {
+		return fmt.Errorf("audit_max_files cannot be negative")
+	}
+
+	if c.UsageThreshold > 100 {
+		return fmt.Errorf("usage_threshold must be a percentage, got %v", c.UsageThreshold)
+	}
+
+	if c.UsageCheckInterval < 0 {
+		return fmt.Errorf("usage_check_interval cannot be negative")
+	}
+
+	if c.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdown_timeout cannot be negative")
+	}
+
+	for _, timeout := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"create_timeout", c.CreateTimeout},
+		{"remove_timeout", c.RemoveTimeout},
+		{"mount_timeout", c.MountTimeout},
+		{"unmount_timeout", c.UnmountTimeout},
+		{"query_timeout", c.QueryTimeout},
+		{"erase_timeout", c.EraseTimeout},
+		{"trash_retention", c.TrashRetention},
+		{"trash_reap_interval", c.TrashReapInterval},
+		{"expiry_check_interval", c.ExpiryCheckInterval},
+		{"orphan_threshold", c.OrphanThreshold},
+		{"orphan_check_interval", c.OrphanCheckInterval},
+	} {
+		if timeout.value < 0 {
+			return fmt.Errorf("%s cannot be negative", timeout.key)
+		}
+	}
+
+	switch c.OrphanAction {
+	case "report", "remove", "ignore":
+	default:
+		return fmt.Errorf("orphan_action must be 'report', 'remove' or 'ignore', got %q", c.OrphanAction)
+	}
+
+	for _, policy := range []struct{ key, action string }{
+		{"reconcile_stray_mounts", c.ReconcileStrayMounts},
+		{"reconcile_misplaced_mounts", c.ReconcileMisplacedMounts},
+		{"reconcile_orphan_dirs", c.ReconcileOrphanDirs},
+		{"reconcile_stale_state", c.ReconcileStaleState},
+	} {
+		switch policy.action {
+		case "report", "fix", "ignore":
+		default:
+			return fmt.Errorf("%s must be 'report', 'fix' or 'ignore', got %q", policy.key, policy.action)
+		}
+	}
+
+	for _, rule := range c.Faults {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
 	}
 
 	return nil
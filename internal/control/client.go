@@ -0,0 +1,199 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+
+	"github.com/kriansa/podman-volume-stratis/internal/driver"
+	"github.com/kriansa/podman-volume-stratis/internal/events"
+)
+
+// Client talks to a running plugin over its control socket
+type Client struct {
+	http *http.Client
+}
+
+// NewClient creates a Client for the control socket at socketPath
+func NewClient(socketPath string) *Client {
+	return &Client{
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// url builds a request URL; the host is ignored by the unix dialer
+func url(path string) string {
+	return "http://plugin" + path
+}
+
+// Events streams events after the since cursor, calling fn for each one
+// until ctx is cancelled or the plugin closes the stream. A nil since
+// streams only new events.
+func (c *Client) Events(ctx context.Context, since *events.Cursor, fn func(events.Event) error) error {
+	path := "/events"
+	if since != nil {
+		query := neturl.Values{"since": {strconv.FormatUint(since.Seq, 10)}}
+		if since.Epoch != "" {
+			query.Set("epoch", since.Epoch)
+		}
+		path += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url(path), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("connect to plugin: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var e events.Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("decode event: %w", err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("read events: %w", err)
+	}
+
+	return nil
+}
+
+// Reconcile runs a reconciliation pass in the plugin and returns its
+// findings. With dryRun, the plugin only reports what it would fix.
+func (c *Client) Reconcile(ctx context.Context, dryRun bool) ([]driver.Finding, error) {
+	path := "/reconcile"
+	if dryRun {
+		path += "?dry_run=true"
+	}
+
+	var findings []driver.Finding
+	if err := c.do(ctx, http.MethodPost, path, &findings); err != nil {
+		return nil, err
+	}
+	return findings, nil
+}
+
+// CollectOrphans runs an orphan collection pass in the plugin and returns
+// the orphaned volumes. With dryRun, the plugin removes none of them.
+func (c *Client) CollectOrphans(ctx context.Context, dryRun bool) ([]driver.Orphan, error) {
+	path := "/gc"
+	if dryRun {
+		path += "?dry_run=true"
+	}
+
+	var orphans []driver.Orphan
+	if err := c.do(ctx, http.MethodPost, path, &orphans); err != nil {
+		return nil, err
+	}
+	return orphans, nil
+}
+
+// Trash returns the removed volumes in the trash, oldest first
+func (c *Client) Trash(ctx context.Context) ([]driver.TrashEntry, error) {
+	var entries []driver.TrashEntry
+	if err := c.do(ctx, http.MethodGet, "/trash", &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// RestoreTrash restores a removed volume, named by its entry in the trash or
+// by its volume name, under its own name or as, if set. It returns the name
+// of the restored volume.
+func (c *Client) RestoreTrash(ctx context.Context, entry, as string) (string, error) {
+	path := "/trash/" + neturl.PathEscape(entry) + "/restore"
+	if as != "" {
+		path += "?as=" + neturl.QueryEscape(as)
+	}
+
+	var restored struct {
+		Volume string `json:"volume"`
+	}
+	if err := c.do(ctx, http.MethodPost, path, &restored); err != nil {
+		return "", err
+	}
+	return restored.Volume, nil
+}
+
+// EmptyTrash destroys every removed volume in the trash and returns those
+// destroyed
+func (c *Client) EmptyTrash(ctx context.Context) ([]driver.TrashEntry, error) {
+	var purged []driver.TrashEntry
+	if err := c.do(ctx, http.MethodPost, "/trash/empty", &purged); err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+// SetProtected protects a volume against removal, or lifts its protection
+func (c *Client) SetProtected(ctx context.Context, name string, protected bool) error {
+	action := "unprotect"
+	if protected {
+		action = "protect"
+	}
+	return c.do(ctx, http.MethodPost, "/volumes/"+neturl.PathEscape(name)+"/"+action, nil)
+}
+
+// do sends a request without a body and decodes the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, url(path), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("connect to plugin: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// checkResponse turns a non-2xx response into an error with the server's message
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("plugin returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,212 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/kriansa/podman-volume-stratis/internal/audit"
+	"github.com/kriansa/podman-volume-stratis/internal/driver"
+	"github.com/kriansa/podman-volume-stratis/internal/events"
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+// Server exposes the plugin's admin API over a unix socket
+type Server struct {
+	events *events.Bus
+	driver *driver.Driver
+	http   *http.Server
+}
+
+// NewServer creates a control Server
+func NewServer(bus *events.Bus, d *driver.Driver) *Server {
+	s := &Server{
+		events: bus,
+		driver: d,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", s.streamEvents)
+	mux.HandleFunc("POST /reconcile", s.reconcile)
+	mux.HandleFunc("POST /gc", s.collectOrphans)
+	mux.HandleFunc("GET /trash", s.listTrash)
+	mux.HandleFunc("POST /trash/empty", s.emptyTrash)
+	mux.HandleFunc("POST /trash/{entry}/restore", s.restoreTrash)
+	mux.HandleFunc("POST /volumes/{name}/protect", s.protect(true))
+	mux.HandleFunc("POST /volumes/{name}/unprotect", s.protect(false))
+
+	s.http = &http.Server{Handler: mux, ConnContext: adminContext}
+	return s
+}
+
+// adminContext marks the requests of a connection as admin requests from
+// its peer, so the driver audits what they change
+func adminContext(ctx context.Context, c net.Conn) context.Context {
+	return audit.WithActor(audit.WithPeerCredentials(ctx, c), audit.ActorAdmin)
+}
+
+// Serve accepts connections on the listener until the server is closed
+func (s *Server) Serve(l net.Listener) error {
+	return s.http.Serve(l)
+}
+
+// Close stops the server, dropping open event streams
+func (s *Server) Close() error {
+	return s.http.Close()
+}
+
+// streamEvents streams events as newline-delimited JSON until the client
+// disconnects. The optional "since" query parameter is a replay cursor: all
+// buffered events with a greater sequence number are sent first, so 0
+// replays the whole history. Without it, nothing is replayed. The optional
+// "epoch" query parameter is the epoch of the cursor's event, so a cursor
+// from before a restart is not taken for one of this process.
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	var since *events.Cursor
+	if query := r.URL.Query(); query.Has("since") {
+		seq, err := strconv.ParseUint(query.Get("since"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid since cursor", http.StatusBadRequest)
+			return
+		}
+		since = &events.Cursor{Seq: seq, Epoch: query.Get("epoch")}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	replay, ch, cancel := s.events.Subscribe(since)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	for _, e := range replay {
+		if err := enc.Encode(e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				log.Debug("dropping slow event subscriber")
+				return
+			}
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// reconcile runs a reconciliation pass and returns its findings. With the
+// "dry_run" query parameter set to true, nothing is fixed.
+func (s *Server) reconcile(w http.ResponseWriter, r *http.Request) {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	findings, err := s.driver.Reconcile(r.Context(), dryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, findings)
+}
+
+// collectOrphans runs an orphan collection pass and returns the orphaned
+// volumes. With the "dry_run" query parameter set to true, nothing is
+// removed.
+func (s *Server) collectOrphans(w http.ResponseWriter, r *http.Request) {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	orphans, err := s.driver.CollectOrphans(r.Context(), dryRun)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, orphans)
+}
+
+// listTrash returns the removed volumes in the trash
+func (s *Server) listTrash(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.driver.Trash(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, entries)
+}
+
+// restoreTrash restores a removed volume, named by its entry in the trash or
+// by its volume name. The optional "as" query parameter restores it under
+// another name.
+func (s *Server) restoreTrash(w http.ResponseWriter, r *http.Request) {
+	name, err := s.driver.Restore(r.Context(), r.PathValue("entry"), r.URL.Query().Get("as"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, stratis.ErrNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, stratis.ErrAlreadyExists):
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	writeJSON(w, map[string]string{"volume": name})
+}
+
+// emptyTrash destroys every removed volume in the trash and returns those
+// it destroyed
+func (s *Server) emptyTrash(w http.ResponseWriter, r *http.Request) {
+	purged, err := s.driver.EmptyTrash(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, purged)
+}
+
+// protect returns the handler that protects a volume against removal, or
+// lifts its protection
+func (s *Server) protect(protected bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := s.driver.SetProtected(r.Context(), r.PathValue("name"), protected)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, stratis.ErrNotFound) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debug("failed to write admin API response", "error", err)
+	}
+}
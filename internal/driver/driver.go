@@ -1,16 +1,24 @@
 package driver
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/kriansa/podman-volume-stratis/internal/audit"
+	"github.com/kriansa/podman-volume-stratis/internal/erase"
+	"github.com/kriansa/podman-volume-stratis/internal/events"
+	"github.com/kriansa/podman-volume-stratis/internal/journal"
 	"github.com/kriansa/podman-volume-stratis/internal/mount"
+	"github.com/kriansa/podman-volume-stratis/internal/state"
 	"github.com/kriansa/podman-volume-stratis/internal/stratis"
 	"github.com/kriansa/podman-volume-stratis/internal/validation"
 	"github.com/kriansa/podman-volume-stratis/internal/log"
@@ -18,10 +26,111 @@ import (
 
 // Driver implements the Docker volume plugin interface
 type Driver struct {
-	mu        sync.Mutex
+	// pool is held shared by operations on a single volume and exclusively
+	// by those that act on every volume at once
+	pool sync.RWMutex
+	// volumes serializes operations on the same volume
+	volumes volumeLocks
+
 	mountPath string
 	stratis   stratis.Manager
 	mounter   mount.Mounter
+	events    *events.Bus
+	state     *state.Store
+	journal   *journal.Journal
+	// audit records the operations the plugin runs on volumes outside
+	// plugin requests; nil records nothing
+	audit     *audit.Logger
+	reconcile ReconcilePolicy
+	timeouts  Timeouts
+	// trashRetention is how long removed volumes stay in the trash; zero
+	// destroys them right away
+	trashRetention time.Duration
+	// containers tells which containers reference each volume, and removes
+	// volumes; nil without a container engine to ask
+	containers ContainerEngine
+	orphans    OrphanPolicy
+	// secureErase is whether volumes that do not say otherwise are erased
+	// before their filesystem is destroyed
+	secureErase bool
+	// erase erases a device; replaced in tests
+	erase func(ctx context.Context, path string, progress func(erase.Progress)) (erase.Method, error)
+	// erasing cancels each erase in progress, by the entry being erased
+	erasingMu sync.Mutex
+	erasing   map[string]context.CancelFunc
+}
+
+// Timeouts bounds how long each kind of operation may take. A zero duration
+// means no limit.
+type Timeouts struct {
+	// Create bounds creating a volume
+	Create time.Duration
+	// Remove bounds removing a volume, including unmounting it
+	Remove time.Duration
+	// Mount bounds mounting a volume
+	Mount time.Duration
+	// Unmount bounds unmounting a volume
+	Unmount time.Duration
+	// Query bounds operations that only inspect volumes: Path, Get and List
+	Query time.Duration
+	// Erase bounds securely erasing a volume, on top of the operation that
+	// destroys it
+	Erase time.Duration
+}
+
+// DriverOption is a functional option for Driver
+type DriverOption func(*Driver)
+
+// WithEvents publishes volume lifecycle events to the given bus
+func WithEvents(bus *events.Bus) DriverOption {
+	return func(d *Driver) {
+		d.events = bus
+	}
+}
+
+// WithState persists per-volume state, such as mount references, in the store.
+// Without it, state is kept in memory only.
+func WithState(store *state.Store) DriverOption {
+	return func(d *Driver) {
+		d.state = store
+	}
+}
+
+// WithJournal records operations of several steps in the journal while they
+// run, so Recover can deal with those a crash left unfinished. Without it,
+// the journal is kept in memory only, and failed operations are still
+// rolled back.
+func WithJournal(j *journal.Journal) DriverOption {
+	return func(d *Driver) {
+		d.journal = j
+	}
+}
+
+// WithAudit records in the audit log the operations on volumes that do not
+// come from the plugin socket: admin requests, background workers, and
+// recovery and cleanup at startup and shutdown. Plugin requests are audited
+// by the server.
+func WithAudit(auditLog *audit.Logger) DriverOption {
+	return func(d *Driver) {
+		d.audit = auditLog
+	}
+}
+
+// WithReconcilePolicy sets what Reconcile does about each kind of finding.
+// Without it, every finding is only reported.
+func WithReconcilePolicy(policy ReconcilePolicy) DriverOption {
+	return func(d *Driver) {
+		d.reconcile = policy
+	}
+}
+
+// WithTimeouts bounds how long each kind of operation may take, so a hung
+// stratisd or mount fails the request instead of blocking it forever.
+// Without it, operations are not bounded.
+func WithTimeouts(timeouts Timeouts) DriverOption {
+	return func(d *Driver) {
+		d.timeouts = timeouts
+	}
 }
 
 // NewDriver creates a new volume driver
@@ -29,18 +138,29 @@ func NewDriver(
 	mountPath string,
 	stratisMgr stratis.Manager,
 	mounter mount.Mounter,
+	opts ...DriverOption,
 ) *Driver {
-	return &Driver{
+	d := &Driver{
 		mountPath: mountPath,
 		stratis:   stratisMgr,
 		mounter:   mounter,
+		state:     state.New(""),
+		journal:   journal.New(""),
+		erase:     erase.Device,
+	}
+
+	for _, opt := range opts {
+		opt(d)
 	}
+
+	return d
 }
 
 // Create creates a new volume
-func (d *Driver) Create(req *volume.CreateRequest) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+func (d *Driver) Create(req *volume.CreateRequest) (err error) {
+	defer d.lockVolume(req.Name)()
+	ctx, finish := d.startOperation(context.Background(), "create volume "+req.Name, d.timeouts.Create)
+	defer func() { err = finish(err) }()
 
 	log.Debug("creating volume", "name", req.Name, "options", req.Options)
 
@@ -49,63 +169,196 @@ func (d *Driver) Create(req *volume.CreateRequest) error {
 		return err
 	}
 
-	// 2. Parse size from options (optional for Stratis - thin provisioning)
-	var sizeLimit *uint64
-	if sizeStr := req.Options["size"]; sizeStr != "" {
-		size, err := parseSize(sizeStr)
-		if err != nil {
-			return fmt.Errorf("invalid size %q: %w", sizeStr, err)
-		}
-		sizeLimit = &size
+	// 2. Parse options
+	opts, err := parseOptions(req.Options)
+	if err != nil {
+		return err
 	}
+	sizeLimit := opts.SizeLimit
 
-	// 3. Check uniqueness
-	if fs, err := d.stratis.GetByName(req.Name); err == nil && fs != nil {
-		return fmt.Errorf("volume %s already exists", req.Name)
+	// 3. Check uniqueness. Podman and Compose may create a volume again;
+	// that succeeds as long as the options are equivalent.
+	if fs, err := d.stratis.GetByName(ctx, req.Name); err == nil && fs != nil {
+		return d.checkExisting(req.Name, fs, opts)
 	} else if err != nil && !errors.Is(err, stratis.ErrNotFound) {
 		return fmt.Errorf("check existing volume: %w", err)
 	}
 
-	// 4. Create filesystem
-	fs, err := d.stratis.Create(req.Name, sizeLimit)
+	// 4. Create filesystem. It may be created even if Create fails, e.g.
+	// when its context is done meanwhile, so the failure is rolled back.
+	tx, err := d.begin(opCreate, req.Name)
+	if err != nil {
+		return err
+	}
+	defer func() { err = d.settle(tx, req.Name, err) }()
+
+	if err := record(tx, stepCreateFilesystem, req.Name); err != nil {
+		return err
+	}
+	fs, err := d.stratis.Create(ctx, req.Name, sizeLimit)
+	for errors.Is(err, stratis.ErrNoSpace) {
+		// Removed volumes in the trash make way for new ones, oldest first
+		freed, freeErr := d.freeTrash(ctx)
+		if freeErr != nil {
+			log.Warn("failed to free space from the trash", "error", freeErr)
+		}
+		if !freed {
+			break
+		}
+		fs, err = d.stratis.Create(ctx, req.Name, sizeLimit)
+	}
+	if errors.Is(err, stratis.ErrAlreadyExists) {
+		// Someone else's filesystem; leave it alone
+		if err := tx.Forget(); err != nil {
+			log.Warn("failed to journal that a filesystem already existed", "name", req.Name, "error", err)
+		}
+		return errVolumeExists(req.Name)
+	}
 	if err != nil {
 		return fmt.Errorf("create filesystem: %w", err)
 	}
 
+	canonical := opts.canonical()
+	// Replace whatever a volume of the same name left behind, such as its
+	// mount references or the time it became unreferenced
+	err = d.state.Put(req.Name, state.Volume{
+		Options:     canonical,
+		Fingerprint: fingerprint(canonical),
+		Created:     time.Now(),
+		Protected:   opts.Protected,
+		Ephemeral:   opts.Ephemeral,
+		TTL:         opts.TTL,
+		SecureErase: opts.SecureErase,
+	})
+	if err != nil && (opts.Protected || opts.Ephemeral || opts.TTL > 0 || opts.SecureErase != nil) {
+		// Protection, expiry and erasure only hold while they are stored
+		return fmt.Errorf("store volume state: %w", err)
+	}
+	if err != nil {
+		// A later create compares against the filesystem itself instead
+		log.Warn("failed to store volume options", "name", req.Name, "error", err)
+	}
+
 	if sizeLimit != nil {
 		log.Info("volume created", "name", req.Name, "sizeLimit", *sizeLimit)
 	} else {
 		log.Info("volume created (thin provisioned)", "name", req.Name, "device", fs.DevicePath)
 	}
+
+	attrs := map[string]any{"device": fs.DevicePath}
+	if sizeLimit != nil {
+		attrs["sizeLimit"] = *sizeLimit
+	}
+	if opts.Protected {
+		attrs["protected"] = true
+	}
+	if opts.Ephemeral {
+		attrs["ephemeral"] = true
+	}
+	if opts.TTL > 0 {
+		attrs["ttl"] = opts.TTL.String()
+	}
+	if opts.SecureErase != nil {
+		attrs["secureErase"] = *opts.SecureErase
+	}
+	d.events.Publish(events.Created, req.Name, attrs)
+	return nil
+}
+
+// checkExisting checks a create request for a volume that already exists
+// against the options it was created with. A request with equivalent options
+// succeeds without changing anything, but for protecting the volume if it
+// asks for that; one with other options fails, saying how they differ.
+func (d *Driver) checkExisting(name string, fs *stratis.Filesystem, requested volumeOptions) error {
+	existing := optionsOf(fs).canonical()
+	existingFingerprint := fingerprint(existing)
+	if vol, ok := d.state.Get(name); ok && vol.Fingerprint != "" {
+		existing, existingFingerprint = vol.Options, vol.Fingerprint
+	}
+
+	want := requested.canonical()
+	if fingerprint(want) != existingFingerprint {
+		return errVolumeConflict(name, diffOptions(existing, want))
+	}
+
+	// Protection is not part of what makes a create the same, but a request
+	// for it must not be dropped silently
+	if vol, _ := d.state.Get(name); requested.Protected && !vol.Protected {
+		err := d.state.Update(name, func(v *state.Volume) {
+			v.Protected = true
+		})
+		if err != nil {
+			return fmt.Errorf("store volume protection: %w", err)
+		}
+		log.Info("volume protection changed", "name", name, "protected", true)
+	}
+
+	log.Info("volume already exists with the same options", "name", name)
 	return nil
 }
 
 // Remove removes a volume
-func (d *Driver) Remove(req *volume.RemoveRequest) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+func (d *Driver) Remove(req *volume.RemoveRequest) (err error) {
+	defer d.lockVolume(req.Name)()
+	ctx, finish := d.startOperation(context.Background(), "remove volume "+req.Name, d.removeTimeout(req.Name))
+	defer func() { err = finish(err) }()
 
 	log.Debug("removing volume", "name", req.Name)
 
-	// Check if filesystem exists
-	fs, err := d.stratis.GetByName(req.Name)
+	if vol, _ := d.state.Get(req.Name); vol.Protected {
+		return errVolumeProtected(req.Name)
+	}
+
+	// A removal that fails here leaves a usable volume, so it is not rolled
+	// back; the journal only lets Recover finish one cut short by a crash.
+	// A volume to be erased is out of sight before the erase starts.
+	tx, err := d.begin(opRemove, req.Name)
 	if err != nil {
-		if errors.Is(err, stratis.ErrNotFound) {
-			return fmt.Errorf("volume %s not found", req.Name)
+		return err
+	}
+	defer func() {
+		if err := tx.End(); err != nil {
+			log.Warn("failed to end journaled operation", "name", req.Name, "error", err)
 		}
-		return fmt.Errorf("get volume: %w", err)
+	}()
+
+	entry, err := d.removeVolume(ctx, tx, req.Name)
+	if err != nil {
+		return err
+	}
+
+	if entry != "" {
+		log.Info("volume moved to trash", "name", req.Name, "entry", entry, "retention", d.trashRetention)
+		d.events.Publish(events.Removed, req.Name, map[string]any{"trash": entry})
+		return nil
+	}
+	log.Info("volume removed", "name", req.Name)
+	d.events.Publish(events.Removed, req.Name, nil)
+	return nil
+}
+
+// removeVolume unmounts a volume, removes its mount point and deletes its
+// filesystem and state, erasing it first if it asks for that. With the trash
+// enabled, the filesystem and state are moved to the trash instead, and the
+// name there is returned. Steps that are
+// not undone on failure are journaled in tx.
+func (d *Driver) removeVolume(ctx context.Context, tx *journal.Tx, name string) (string, error) {
+	// Check if filesystem exists
+	fs, err := d.getVolume(ctx, name)
+	if err != nil {
+		return "", err
 	}
 
 	// Check if mounted and unmount if necessary
-	mountPoint := d.mountPointPath(req.Name)
-	mounted, err := d.mounter.IsMounted(mountPoint)
+	mountPoint := d.mountPointPath(name)
+	mounted, err := d.mounter.IsMounted(ctx, mountPoint)
 	if err != nil {
-		return fmt.Errorf("check mount status: %w", err)
+		return "", fmt.Errorf("check mount status: %w", err)
 	}
 
 	if mounted {
-		if err := d.mounter.Unmount(mountPoint); err != nil {
-			return fmt.Errorf("unmount: %w", err)
+		if err := d.mounter.Unmount(ctx, mountPoint); err != nil {
+			return "", fmt.Errorf("unmount: %w", err)
 		}
 	}
 
@@ -114,35 +367,58 @@ func (d *Driver) Remove(req *volume.RemoveRequest) error {
 		log.Warn("failed to remove mount directory", "path", mountPoint, "error", err)
 	}
 
-	// Delete filesystem
-	if err := d.stratis.Delete(fs.Name); err != nil {
-		return fmt.Errorf("delete filesystem: %w", err)
+	if d.trashRetention > 0 {
+		return d.moveToTrash(ctx, tx, fs.Name)
+	}
+	if d.wantsSecureErase(name) {
+		return "", d.eraseRemoved(ctx, tx, name)
 	}
 
-	log.Info("volume removed", "name", req.Name)
-	return nil
+	if err := d.destroyFilesystem(ctx, name, fs); err != nil {
+		return "", err
+	}
+
+	if err := d.state.Delete(name); err != nil {
+		log.Warn("failed to remove volume state", "name", name, "error", err)
+	}
+	return "", nil
+}
+
+// getVolume returns the filesystem of a volume. Removed volumes, in the
+// trash or waiting to be erased, are not volumes.
+func (d *Driver) getVolume(ctx context.Context, name string) (*stratis.Filesystem, error) {
+	if isHidden(name) {
+		return nil, errVolumeNotFound(name)
+	}
+
+	fs, err := d.stratis.GetByName(ctx, name)
+	if err != nil {
+		if errors.Is(err, stratis.ErrNotFound) {
+			return nil, errVolumeNotFound(name)
+		}
+		return nil, fmt.Errorf("get volume: %w", err)
+	}
+	return fs, nil
 }
 
 // Mount mounts a volume
-func (d *Driver) Mount(req *volume.MountRequest) (*volume.MountResponse, error) {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+func (d *Driver) Mount(req *volume.MountRequest) (_ *volume.MountResponse, err error) {
+	defer d.lockVolume(req.Name)()
+	ctx, finish := d.startOperation(context.Background(), "mount volume "+req.Name, d.timeouts.Mount)
+	defer func() { err = finish(err) }()
 
 	log.Debug("mounting volume", "name", req.Name, "id", req.ID)
 
 	// Check if filesystem exists
-	fs, err := d.stratis.GetByName(req.Name)
+	fs, err := d.getVolume(ctx, req.Name)
 	if err != nil {
-		if errors.Is(err, stratis.ErrNotFound) {
-			return nil, fmt.Errorf("volume %s not found", req.Name)
-		}
-		return nil, fmt.Errorf("get volume: %w", err)
+		return nil, err
 	}
 
 	mountPoint := d.mountPointPath(req.Name)
 
 	// Check if already mounted
-	existingMount, err := d.mounter.GetMountPoint(fs.DevicePath)
+	existingMount, err := d.mounter.GetMountPoint(ctx, fs.DevicePath)
 	if err != nil {
 		return nil, fmt.Errorf("check existing mount: %w", err)
 	}
@@ -152,13 +428,26 @@ func (d *Driver) Mount(req *volume.MountRequest) (*volume.MountResponse, error)
 		if existingMount == mountPoint {
 			// Mounted at correct path
 			log.Debug("volume already mounted", "name", req.Name, "path", mountPoint)
+			if err := d.addMountRef(req.Name, req.ID); err != nil {
+				return nil, err
+			}
 			return &volume.MountResponse{Mountpoint: mountPoint}, nil
 		}
 		// Mounted elsewhere
 		return nil, fmt.Errorf("volume %s is mounted at %s instead of %s", req.Name, existingMount, mountPoint)
 	}
 
+	// A mount that fails halfway is rolled back to an unmounted volume
+	tx, err := d.begin(opMount, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = d.settle(tx, req.Name, err) }()
+
 	// Create mount directory
+	if err := record(tx, stepMakeMountPoint, mountPoint); err != nil {
+		return nil, err
+	}
 	if err := d.prepareMountPoint(mountPoint); err != nil {
 		return nil, fmt.Errorf("prepare mount point: %w", err)
 	}
@@ -167,34 +456,43 @@ func (d *Driver) Mount(req *volume.MountRequest) (*volume.MountResponse, error)
 	fsType := "xfs"
 
 	// Mount the filesystem
-	if err := d.mounter.Mount(fs.DevicePath, mountPoint, fsType); err != nil {
+	if err := record(tx, stepMount, mountPoint); err != nil {
+		return nil, err
+	}
+	if err := d.mounter.Mount(ctx, fs.DevicePath, mountPoint, fsType); err != nil {
 		return nil, fmt.Errorf("mount: %w", err)
 	}
 
+	if err := record(tx, stepMountRef, req.ID); err != nil {
+		return nil, err
+	}
+	if err := d.addMountRef(req.Name, req.ID); err != nil {
+		return nil, err
+	}
+
 	log.Info("volume mounted", "name", req.Name, "device", fs.DevicePath, "path", mountPoint, "fs", fsType)
+	d.events.Publish(events.Mounted, req.Name, map[string]any{"id": req.ID, "mountpoint": mountPoint})
 	return &volume.MountResponse{Mountpoint: mountPoint}, nil
 }
 
 // Unmount unmounts a volume
-func (d *Driver) Unmount(req *volume.UnmountRequest) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
+func (d *Driver) Unmount(req *volume.UnmountRequest) (err error) {
+	defer d.lockVolume(req.Name)()
+	ctx, finish := d.startOperation(context.Background(), "unmount volume "+req.Name, d.timeouts.Unmount)
+	defer func() { err = finish(err) }()
 
 	log.Debug("unmounting volume", "name", req.Name, "id", req.ID)
 
 	// Check if filesystem exists
-	fs, err := d.stratis.GetByName(req.Name)
+	fs, err := d.getVolume(ctx, req.Name)
 	if err != nil {
-		if errors.Is(err, stratis.ErrNotFound) {
-			return fmt.Errorf("volume %s not found", req.Name)
-		}
-		return fmt.Errorf("get volume: %w", err)
+		return err
 	}
 
 	mountPoint := d.mountPointPath(req.Name)
 
 	// Check if mounted at expected location
-	existingMount, err := d.mounter.GetMountPoint(fs.DevicePath)
+	existingMount, err := d.mounter.GetMountPoint(ctx, fs.DevicePath)
 	if err != nil {
 		return fmt.Errorf("check mount status: %w", err)
 	}
@@ -202,15 +500,24 @@ func (d *Driver) Unmount(req *volume.UnmountRequest) error {
 	if existingMount == "" {
 		// Not mounted
 		log.Debug("volume not mounted", "name", req.Name)
-		return nil
+		return d.removeMountRef(req.Name, req.ID)
 	}
 
 	if existingMount != mountPoint {
 		return fmt.Errorf("volume %s is mounted at %s instead of expected %s", req.Name, existingMount, mountPoint)
 	}
 
+	// Keep the volume mounted while other callers still use it
+	if err := d.removeMountRef(req.Name, req.ID); err != nil {
+		return err
+	}
+	if vol, _ := d.state.Get(req.Name); len(vol.MountIDs) > 0 {
+		log.Debug("volume still in use", "name", req.Name, "mounts", len(vol.MountIDs))
+		return nil
+	}
+
 	// Unmount
-	if err := d.mounter.Unmount(mountPoint); err != nil {
+	if err := d.mounter.Unmount(ctx, mountPoint); err != nil {
 		return fmt.Errorf("unmount: %w", err)
 	}
 
@@ -220,26 +527,88 @@ func (d *Driver) Unmount(req *volume.UnmountRequest) error {
 	}
 
 	log.Info("volume unmounted", "name", req.Name)
+	d.events.Publish(events.Unmounted, req.Name, map[string]any{"id": req.ID})
 	return nil
 }
 
+// UnmountIdle unmounts every volume that is mounted at its mount point but
+// has no mount references left, e.g. one left behind by a crashed caller.
+// It gives up when ctx is done.
+func (d *Driver) UnmountIdle(ctx context.Context) error {
+	defer d.lockPool()()
+
+	listCtx, finish := d.startOperation(ctx, "list volumes", d.timeouts.Query)
+	filesystems, err := d.stratis.List(listCtx)
+	if err = finish(err); err != nil {
+		return fmt.Errorf("list filesystems: %w", err)
+	}
+
+	var errs []error
+	for _, fs := range filesystems {
+		if vol, _ := d.state.Get(fs.Name); len(vol.MountIDs) > 0 {
+			continue
+		}
+
+		start := time.Now()
+		unmounted, err := d.unmountIdle(ctx, fs)
+		if unmounted || err != nil {
+			d.auditOperation(ctx, "unmount", fs.Name, nil, start, err)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if unmounted {
+			log.Info("idle volume unmounted", "name", fs.Name)
+			d.events.Publish(events.Unmounted, fs.Name, nil)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// unmountIdle unmounts fs if it is mounted at its mount point, within the
+// unmount timeout, and reports whether it did
+func (d *Driver) unmountIdle(ctx context.Context, fs stratis.Filesystem) (_ bool, err error) {
+	ctx, finish := d.startOperation(ctx, "unmount idle volume "+fs.Name, d.timeouts.Unmount)
+	defer func() { err = finish(err) }()
+
+	mountPoint := d.mountPointPath(fs.Name)
+	existingMount, err := d.mounter.GetMountPoint(ctx, fs.DevicePath)
+	if err != nil {
+		return false, fmt.Errorf("check mount status of %s: %w", fs.Name, err)
+	}
+	if existingMount != mountPoint {
+		return false, nil
+	}
+
+	if err := d.mounter.Unmount(ctx, mountPoint); err != nil {
+		return false, fmt.Errorf("unmount %s: %w", fs.Name, err)
+	}
+	if err := os.Remove(mountPoint); err != nil && !os.IsNotExist(err) {
+		log.Warn("failed to remove mountpoint directory", "path", mountPoint, "error", err)
+	}
+	return true, nil
+}
+
 // Path returns the mount path for a volume
-func (d *Driver) Path(req *volume.PathRequest) (*volume.PathResponse, error) {
+func (d *Driver) Path(req *volume.PathRequest) (_ *volume.PathResponse, err error) {
+	defer d.rlockVolume(req.Name)()
+	ctx, finish := d.startOperation(context.Background(), "get path of volume "+req.Name, d.timeouts.Query)
+	defer func() { err = finish(err) }()
+
 	log.Debug("getting path", "name", req.Name)
 
 	// Check if filesystem exists
-	fs, err := d.stratis.GetByName(req.Name)
+	fs, err := d.getVolume(ctx, req.Name)
 	if err != nil {
-		if errors.Is(err, stratis.ErrNotFound) {
-			return nil, fmt.Errorf("volume %s not found", req.Name)
-		}
-		return nil, fmt.Errorf("get volume: %w", err)
+		return nil, err
 	}
 
 	mountPoint := d.mountPointPath(req.Name)
 
 	// Check if mounted
-	existingMount, err := d.mounter.GetMountPoint(fs.DevicePath)
+	existingMount, err := d.mounter.GetMountPoint(ctx, fs.DevicePath)
 	if err != nil {
 		return nil, fmt.Errorf("check mount status: %w", err)
 	}
@@ -256,21 +625,22 @@ func (d *Driver) Path(req *volume.PathRequest) (*volume.PathResponse, error) {
 }
 
 // Get returns information about a volume
-func (d *Driver) Get(req *volume.GetRequest) (*volume.GetResponse, error) {
+func (d *Driver) Get(req *volume.GetRequest) (_ *volume.GetResponse, err error) {
+	defer d.rlockVolume(req.Name)()
+	ctx, finish := d.startOperation(context.Background(), "get volume "+req.Name, d.timeouts.Query)
+	defer func() { err = finish(err) }()
+
 	log.Debug("getting volume info", "name", req.Name)
 
-	fs, err := d.stratis.GetByName(req.Name)
+	fs, err := d.getVolume(ctx, req.Name)
 	if err != nil {
-		if errors.Is(err, stratis.ErrNotFound) {
-			return nil, fmt.Errorf("volume %s not found", req.Name)
-		}
-		return nil, fmt.Errorf("get volume: %w", err)
+		return nil, err
 	}
 
 	mountPoint := d.mountPointPath(req.Name)
 
 	// Check if mounted
-	existingMount, err := d.mounter.GetMountPoint(fs.DevicePath)
+	existingMount, err := d.mounter.GetMountPoint(ctx, fs.DevicePath)
 	if err != nil {
 		// Non-fatal, just set mountpoint to empty
 		existingMount = ""
@@ -290,6 +660,23 @@ func (d *Driver) Get(req *volume.GetRequest) (*volume.GetResponse, error) {
 	if fs.SizeLimit != nil {
 		status["sizeLimit"] = *fs.SizeLimit
 	}
+	vol, _ := d.state.Get(req.Name)
+	status["protected"] = vol.Protected
+	if vol.Ephemeral {
+		status["ephemeral"] = true
+	}
+	if vol.TTL > 0 {
+		status["ttl"] = vol.TTL.String()
+	}
+	status["secureErase"] = d.wantsSecureErase(req.Name)
+	if d.containers != nil {
+		// Podman may be down; the rest of the status is still worth having
+		if refs, err := d.volumeReferences(ctx); err != nil {
+			log.Debug("failed to list containers referencing volume", "name", req.Name, "error", err)
+		} else {
+			status["containers"] = append([]string{}, refs[req.Name]...)
+		}
+	}
 
 	return &volume.GetResponse{
 		Volume: &volume.Volume{
@@ -301,20 +688,28 @@ func (d *Driver) Get(req *volume.GetRequest) (*volume.GetResponse, error) {
 }
 
 // List returns all volumes
-func (d *Driver) List() (*volume.ListResponse, error) {
+func (d *Driver) List() (_ *volume.ListResponse, err error) {
+	d.pool.RLock()
+	defer d.pool.RUnlock()
+	ctx, finish := d.startOperation(context.Background(), "list volumes", d.timeouts.Query)
+	defer func() { err = finish(err) }()
+
 	log.Debug("listing volumes")
 
-	filesystems, err := d.stratis.List()
+	filesystems, err := d.stratis.List(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("list filesystems: %w", err)
 	}
 
 	var volumes []*volume.Volume
 	for _, fs := range filesystems {
+		if isHidden(fs.Name) {
+			continue
+		}
 		mountPoint := d.mountPointPath(fs.Name)
 
 		// Check if mounted
-		existingMount, _ := d.mounter.GetMountPoint(fs.DevicePath)
+		existingMount, _ := d.mounter.GetMountPoint(ctx, fs.DevicePath)
 		var currentMountPoint string
 		if existingMount == mountPoint {
 			currentMountPoint = mountPoint
@@ -338,6 +733,90 @@ func (d *Driver) Capabilities() *volume.CapabilitiesResponse {
 	}
 }
 
+// startOperation bounds the operation described by desc to timeout, returning
+// its context and the function that ends it. finish releases the context and
+// words the error returned to the caller: it says when the deadline passed,
+// and what to do about failures outside the plugin's control.
+func (d *Driver) startOperation(parent context.Context, desc string, timeout time.Duration) (ctx context.Context, finish func(error) error) {
+	if timeout <= 0 {
+		return parent, func(err error) error { return explain(desc, err) }
+	}
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	return ctx, func(err error) error {
+		cancel()
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && parent.Err() == nil {
+			return fmt.Errorf("%s timed out after %s: %w", desc, timeout, err)
+		}
+		return explain(desc, err)
+	}
+}
+
+// auditOperation records the outcome of an operation on a volume that
+// started at start in the audit log. Without an actor in ctx, the plugin
+// ran it of its own accord.
+func (d *Driver) auditOperation(ctx context.Context, op, name string, options map[string]string, start time.Time, err error) {
+	if audit.ActorFromContext(ctx) == "" {
+		ctx = audit.WithActor(ctx, audit.ActorPlugin)
+	}
+	d.audit.RecordOperation(ctx, op, name, options, start, err)
+}
+
+// lockVolume locks a volume for an operation that changes it and returns the
+// function that unlocks it. Operations on other volumes can run meanwhile.
+func (d *Driver) lockVolume(name string) (unlock func()) {
+	d.pool.RLock()
+	unlockVolume := d.volumes.Lock(name)
+	return func() {
+		unlockVolume()
+		d.pool.RUnlock()
+	}
+}
+
+// rlockVolume locks a volume for an operation that only inspects it and
+// returns the function that unlocks it
+func (d *Driver) rlockVolume(name string) (unlock func()) {
+	d.pool.RLock()
+	unlockVolume := d.volumes.RLock(name)
+	return func() {
+		unlockVolume()
+		d.pool.RUnlock()
+	}
+}
+
+// lockPool locks every volume at once, for operations that act on the whole
+// pool, and returns the function that unlocks it
+func (d *Driver) lockPool() (unlock func()) {
+	d.pool.Lock()
+	return d.pool.Unlock
+}
+
+// addMountRef records that the caller id holds the volume mounted
+func (d *Driver) addMountRef(name, id string) error {
+	err := d.state.Update(name, func(v *state.Volume) {
+		if !slices.Contains(v.MountIDs, id) {
+			v.MountIDs = append(v.MountIDs, id)
+		}
+		v.LastUsed = time.Now()
+	})
+	if err != nil {
+		return fmt.Errorf("save mount reference: %w", err)
+	}
+	return nil
+}
+
+// removeMountRef records that the caller id no longer holds the volume mounted
+func (d *Driver) removeMountRef(name, id string) error {
+	err := d.state.Update(name, func(v *state.Volume) {
+		v.MountIDs = slices.DeleteFunc(v.MountIDs, func(s string) bool { return s == id })
+		v.LastUsed = time.Now()
+	})
+	if err != nil {
+		return fmt.Errorf("save mount reference: %w", err)
+	}
+	return nil
+}
+
 // mountPointPath returns the mount point path for a volume
 func (d *Driver) mountPointPath(name string) string {
 	return filepath.Join(d.mountPath, name)
@@ -0,0 +1,70 @@
+package driver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis/stratistest"
+)
+
+// TestDriver_FakeStratisd runs a volume's life through the DBus backend
+// against a fake stratisd
+func TestDriver_FakeStratisd(t *testing.T) {
+	s := stratistest.Start(t)
+	s.AddPool("podman_vols")
+
+	mgr, err := stratis.NewDBusManager("podman_vols", stratis.WithConnectFunc(func() (stratis.DBusConnection, error) {
+		conn, err := s.Connect()
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}))
+	if err != nil {
+		t.Fatalf("NewDBusManager() error = %v", err)
+	}
+	t.Cleanup(func() { mgr.Close() })
+
+	mounter := newFakeMounter()
+	d := NewDriver(t.TempDir(), mgr, mounter)
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol1", Options: map[string]string{"size": "1GiB"}}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); !errors.Is(err, stratis.ErrAlreadyExists) {
+		t.Errorf("Create() of an existing volume error = %v, want ErrAlreadyExists", err)
+	}
+
+	got, err := d.Get(&volume.GetRequest{Name: "vol1"})
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Volume.Status["sizeLimit"] != uint64(1<<30) || got.Volume.Status["device"] != "/dev/stratis/podman_vols/vol1" {
+		t.Errorf("Get() status = %v, want a 1 GiB limit and the stratis device", got.Volume.Status)
+	}
+
+	mounted, err := d.Mount(&volume.MountRequest{Name: "vol1", ID: "c1"})
+	if err != nil {
+		t.Fatalf("Mount() error = %v", err)
+	}
+	if err := d.Unmount(&volume.UnmountRequest{Name: "vol1", ID: "c1"}); err != nil {
+		t.Fatalf("Unmount() error = %v", err)
+	}
+	if mounted.Mountpoint == "" {
+		t.Error("Mount() returned no mount point")
+	}
+
+	s.Fail("DestroyFilesystems", "Filesystem vol1 is busy")
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol1"}); !errors.Is(err, stratis.ErrBusy) {
+		t.Errorf("Remove() of a busy volume error = %v, want ErrBusy", err)
+	}
+
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if names := s.Filesystems("podman_vols"); len(names) != 0 {
+		t.Errorf("filesystems after Remove() = %v, want none", names)
+	}
+}
@@ -0,0 +1,429 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis/conformance"
+)
+
+func TestMain(m *testing.M) {
+	log.Setup(false)
+	os.Exit(m.Run())
+}
+
+// fakeManager is an in-memory stratis.Manager that fails the test when two
+// calls touch the same filesystem at once
+type fakeManager struct {
+	t     *testing.T
+	mu    sync.Mutex
+	fs    map[string]*stratis.Filesystem
+	busy  map[string]bool
+	delay time.Duration
+	// nextID numbers the UUIDs of new filesystems
+	nextID int
+	// block, if set, is waited on by Create of the named filesystem
+	block map[string]chan struct{}
+}
+
+func newFakeManager(t *testing.T) *fakeManager {
+	return &fakeManager{
+		t:     t,
+		fs:    make(map[string]*stratis.Filesystem),
+		busy:  make(map[string]bool),
+		block: make(map[string]chan struct{}),
+	}
+}
+
+// enter marks name busy for the duration of a call
+func (m *fakeManager) enter(name string) func() {
+	m.mu.Lock()
+	if m.busy[name] {
+		m.t.Errorf("concurrent stratis calls on %s", name)
+	}
+	m.busy[name] = true
+	m.mu.Unlock()
+
+	time.Sleep(m.delay)
+
+	return func() {
+		m.mu.Lock()
+		m.busy[name] = false
+		m.mu.Unlock()
+	}
+}
+
+func (m *fakeManager) PoolExists(context.Context) (bool, error) { return true, nil }
+
+func (m *fakeManager) List(context.Context) ([]stratis.Filesystem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var list []stratis.Filesystem
+	for _, fs := range m.fs {
+		list = append(list, *fs)
+	}
+	return list, nil
+}
+
+func (m *fakeManager) Create(ctx context.Context, name string, sizeLimit *uint64) (*stratis.Filesystem, error) {
+	defer m.enter(name)()
+
+	m.mu.Lock()
+	block := m.block[name]
+	m.mu.Unlock()
+	if block != nil {
+		select {
+		case <-block:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.fs[name]; ok {
+		return nil, fmt.Errorf("create filesystem %s: %w", name, stratis.ErrAlreadyExists)
+	}
+	return m.add(name, sizeLimit), nil
+}
+
+// add records a new filesystem, as large as its limit or stratisd's
+// default, and returns a copy. Must be called with mu held.
+func (m *fakeManager) add(name string, sizeLimit *uint64) *stratis.Filesystem {
+	m.nextID++
+	fs := &stratis.Filesystem{
+		Name:       name,
+		Pool:       "pool",
+		DevicePath: "/dev/stratis/pool/" + name,
+		Total:      1 << 40,
+		Free:       1 << 40,
+		UUID:       fmt.Sprintf("fake-%d", m.nextID),
+	}
+	if sizeLimit != nil {
+		limit := *sizeLimit
+		fs.Total, fs.Free, fs.SizeLimit = limit, limit, &limit
+	}
+	m.fs[name] = fs
+
+	c := *fs
+	return &c
+}
+
+func (m *fakeManager) Delete(_ context.Context, name string) error {
+	defer m.enter(name)()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.fs[name]; !ok {
+		return stratis.ErrNotFound
+	}
+	delete(m.fs, name)
+	return nil
+}
+
+func (m *fakeManager) Rename(_ context.Context, name, newName string) error {
+	defer m.enter(name)()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fs, ok := m.fs[name]
+	if !ok {
+		return stratis.ErrNotFound
+	}
+	if name == newName {
+		return nil
+	}
+	if _, ok := m.fs[newName]; ok {
+		return fmt.Errorf("rename filesystem %s: %w", newName, stratis.ErrAlreadyExists)
+	}
+	delete(m.fs, name)
+	fs.Name, fs.DevicePath = newName, "/dev/stratis/pool/"+newName
+	m.fs[newName] = fs
+	return nil
+}
+
+func (m *fakeManager) GetByName(_ context.Context, name string) (*stratis.Filesystem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fs, ok := m.fs[name]
+	if !ok {
+		return nil, stratis.ErrNotFound
+	}
+	c := *fs
+	return &c, nil
+}
+
+func (m *fakeManager) Snapshot(_ context.Context, origin, name string) (*stratis.Filesystem, error) {
+	defer m.enter(name)()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	src, ok := m.fs[origin]
+	if !ok {
+		return nil, stratis.ErrNotFound
+	}
+	if _, ok := m.fs[name]; ok {
+		return nil, fmt.Errorf("snapshot filesystem %s: %w", name, stratis.ErrAlreadyExists)
+	}
+	return m.add(name, src.SizeLimit), nil
+}
+
+// The driver's tests rely on the fake behaving as the real backends do
+func TestFakeManager_Conformance(t *testing.T) {
+	conformance.Run(t, func(t *testing.T) stratis.Manager {
+		return newFakeManager(t)
+	})
+}
+
+// fakeMounter records mounts in memory and rejects double mounts and
+// unmounts of targets that are not mounted
+type fakeMounter struct {
+	mu     sync.Mutex
+	mounts map[string]string // target -> source
+}
+
+func newFakeMounter() *fakeMounter {
+	return &fakeMounter{mounts: make(map[string]string)}
+}
+
+func (m *fakeMounter) Mount(_ context.Context, source, target, fsType string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.mounts[target]; ok {
+		return fmt.Errorf("%s is already mounted", target)
+	}
+	m.mounts[target] = source
+	return nil
+}
+
+func (m *fakeMounter) Unmount(_ context.Context, target string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.mounts[target]; !ok {
+		return fmt.Errorf("%s is not mounted", target)
+	}
+	delete(m.mounts, target)
+	return nil
+}
+
+func (m *fakeMounter) IsMounted(_ context.Context, target string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.mounts[target]
+	return ok, nil
+}
+
+func (m *fakeMounter) GetMountPoint(_ context.Context, source string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for target, s := range m.mounts {
+		if s == source {
+			return target, nil
+		}
+	}
+	return "", nil
+}
+
+func TestDriver_ConcurrentMountUnmountSameVolume(t *testing.T) {
+	mgr := newFakeManager(t)
+	mounter := newFakeMounter()
+	d := NewDriver(t.TempDir(), mgr, mounter)
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	const workers = 20
+	const rounds = 25
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := fmt.Sprintf("c%d", w)
+			for range rounds {
+				if _, err := d.Mount(&volume.MountRequest{Name: "vol1", ID: id}); err != nil {
+					t.Errorf("Mount(%s) error = %v", id, err)
+					return
+				}
+				if _, err := d.Get(&volume.GetRequest{Name: "vol1"}); err != nil {
+					t.Errorf("Get() error = %v", err)
+				}
+				if _, err := d.Path(&volume.PathRequest{Name: "vol1"}); err != nil {
+					t.Errorf("Path() while mounted by %s error = %v", id, err)
+				}
+				if _, err := d.List(); err != nil {
+					t.Errorf("List() error = %v", err)
+				}
+				if err := d.Unmount(&volume.UnmountRequest{Name: "vol1", ID: id}); err != nil {
+					t.Errorf("Unmount(%s) error = %v", id, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if mounted, _ := mounter.IsMounted(context.Background(), d.mountPointPath("vol1")); mounted {
+		t.Error("volume still mounted after every caller unmounted it")
+	}
+	if vol, _ := d.state.Get("vol1"); len(vol.MountIDs) != 0 {
+		t.Errorf("mount references left: %v", vol.MountIDs)
+	}
+}
+
+func TestDriver_ConcurrentCreateSameVolume(t *testing.T) {
+	mgr := newFakeManager(t)
+	mgr.delay = time.Millisecond
+	d := NewDriver(t.TempDir(), mgr, newFakeMounter())
+
+	const workers = 10
+
+	var created atomic.Int64
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err == nil {
+				created.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Creating a volume again with the same options succeeds
+	if got := created.Load(); got != workers {
+		t.Errorf("%d concurrent creates succeeded, want %d", got, workers)
+	}
+	if filesystems, _ := mgr.List(context.Background()); len(filesystems) != 1 {
+		t.Errorf("%d filesystems created, want 1", len(filesystems))
+	}
+}
+
+func TestDriver_ConcurrentCreateRemove(t *testing.T) {
+	mgr := newFakeManager(t)
+	d := NewDriver(t.TempDir(), mgr, newFakeMounter())
+
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				var err error
+				if w%2 == 0 {
+					err = d.Create(&volume.CreateRequest{Name: "vol1"})
+				} else {
+					err = d.Remove(&volume.RemoveRequest{Name: "vol1"})
+				}
+				// Losing the race is fine; only unexpected errors count
+				if err != nil && !isExpectedRaceError(err) {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func isExpectedRaceError(err error) bool {
+	return errors.Is(err, stratis.ErrAlreadyExists) || errors.Is(err, stratis.ErrNotFound)
+}
+
+func TestDriver_SlowCreateDoesNotBlockOtherVolumes(t *testing.T) {
+	mgr := newFakeManager(t)
+	release := make(chan struct{})
+	mgr.block["slow"] = release
+	d := NewDriver(t.TempDir(), mgr, newFakeMounter())
+
+	slowDone := make(chan error, 1)
+	go func() {
+		slowDone <- d.Create(&volume.CreateRequest{Name: "slow"})
+	}()
+
+	fastDone := make(chan error, 1)
+	go func() {
+		if err := d.Create(&volume.CreateRequest{Name: "fast"}); err != nil {
+			fastDone <- err
+			return
+		}
+		_, err := d.Mount(&volume.MountRequest{Name: "fast", ID: "c1"})
+		fastDone <- err
+	}()
+
+	select {
+	case err := <-fastDone:
+		if err != nil {
+			t.Fatalf("operations on fast volume failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("operations on another volume were blocked by a slow create")
+	}
+
+	close(release)
+	if err := <-slowDone; err != nil {
+		t.Fatalf("slow Create() error = %v", err)
+	}
+}
+
+func TestDriver_CreateTimesOut(t *testing.T) {
+	mgr := newFakeManager(t)
+	release := make(chan struct{})
+	defer close(release)
+	mgr.block["vol1"] = release
+	d := NewDriver(t.TempDir(), mgr, newFakeMounter(), WithTimeouts(Timeouts{Create: 50 * time.Millisecond}))
+
+	err := d.Create(&volume.CreateRequest{Name: "vol1"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Create() error = %v, want deadline exceeded", err)
+	}
+	if !strings.Contains(err.Error(), "create volume vol1 timed out after 50ms") {
+		t.Errorf("Create() error = %q, want it to say the create timed out", err)
+	}
+
+	// Other operations are not affected by the timeout
+	if _, err := d.List(); err != nil {
+		t.Errorf("List() error = %v", err)
+	}
+}
+
+func TestVolumeLocks_ReleasesUnusedLocks(t *testing.T) {
+	var locks volumeLocks
+
+	unlock := locks.Lock("vol1")
+	runlock := locks.RLock("vol2")
+	if len(locks.locks) != 2 {
+		t.Fatalf("got %d locks, want 2", len(locks.locks))
+	}
+
+	unlock()
+	runlock()
+	if len(locks.locks) != 0 {
+		t.Errorf("got %d locks after unlocking, want 0", len(locks.locks))
+	}
+}
+
+var _ stratis.Manager = (*fakeManager)(nil)
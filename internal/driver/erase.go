@@ -0,0 +1,234 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kriansa/podman-volume-stratis/internal/erase"
+	"github.com/kriansa/podman-volume-stratis/internal/journal"
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+// eraseLogStep is how many percent of a device are erased between progress logs
+const eraseLogStep = 10
+
+// erasePrefix starts the filesystem names of removed volumes that are being
+// erased. Like those in the trash, they never clash with a volume.
+const erasePrefix = ".erase-"
+
+// WithSecureErase erases volumes created without the secure_erase option
+// before their filesystem is destroyed, so their data cannot be recovered
+// from the blocks given back to the pool. Without it, only volumes created
+// with secure_erase=true are.
+func WithSecureErase(byDefault bool) DriverOption {
+	return func(d *Driver) {
+		d.secureErase = byDefault
+	}
+}
+
+// wantsSecureErase reports whether a volume, or removed volume in the
+// trash, is erased before its filesystem is destroyed
+func (d *Driver) wantsSecureErase(name string) bool {
+	if vol, _ := d.state.Get(name); vol.SecureErase != nil {
+		return *vol.SecureErase
+	}
+	return d.secureErase
+}
+
+// eraseName returns the name of the filesystem of a volume removed at the
+// given time while it is being erased
+func eraseName(name string, removed time.Time) string {
+	return erasePrefix + strconv.FormatInt(removed.UnixMilli(), 10) + "-" + name
+}
+
+// isErasing reports whether a filesystem is a removed volume being erased
+func isErasing(fsName string) bool {
+	return strings.HasPrefix(fsName, erasePrefix)
+}
+
+// isHidden reports whether a filesystem is a removed volume, in the trash or
+// being erased, rather than a volume
+func isHidden(fsName string) bool {
+	return isTrash(fsName) || isErasing(fsName)
+}
+
+// removeTimeout returns how long removing a volume may take: the remove
+// timeout, plus the erase timeout when the volume is erased rather than
+// moved to the trash
+func (d *Driver) removeTimeout(name string) time.Duration {
+	if d.trashRetention > 0 || !d.wantsSecureErase(name) {
+		return d.timeouts.Remove
+	}
+	if d.timeouts.Remove <= 0 || d.timeouts.Erase <= 0 {
+		return 0
+	}
+	return d.timeouts.Remove + d.timeouts.Erase
+}
+
+// eraseRemoved erases and destroys a removed volume. It is renamed out of
+// Podman's sight first, so an erase that fails or is cut short, e.g. by
+// AbortErases, never leaves a partly erased volume that can be mounted
+// again; the expiry reaper finishes it then. The new name is journaled in
+// tx, so Recover can finish the rename. The caller holds the lock of name.
+func (d *Driver) eraseRemoved(ctx context.Context, tx *journal.Tx, name string) error {
+	entry := eraseName(name, time.Now())
+	if err := record(tx, stepRename, entry); err != nil {
+		return err
+	}
+	if err := d.renameVolume(ctx, name, entry); err != nil {
+		return fmt.Errorf("hide volume for erasing: %w", err)
+	}
+
+	unlock := d.volumes.Lock(entry)
+	defer unlock()
+
+	if err := d.destroyErasing(ctx, entry); err != nil {
+		log.Warn("failed to erase removed volume, retrying later", "name", name, "entry", entry, "error", err)
+	}
+	return nil
+}
+
+// finishErasing erases and destroys the removed volumes whose erase failed
+// or was cut short, e.g. by a crash. It carries on past failures, returning
+// them all.
+func (d *Driver) finishErasing(ctx context.Context) error {
+	d.pool.RLock()
+	listCtx, finish := d.startOperation(ctx, "list volumes", d.timeouts.Query)
+	filesystems, err := d.stratis.List(listCtx)
+	err = finish(err)
+	d.pool.RUnlock()
+	if err != nil {
+		return fmt.Errorf("list filesystems: %w", err)
+	}
+
+	var errs []error
+	for _, fs := range filesystems {
+		if !isErasing(fs.Name) {
+			continue
+		}
+
+		unlock := d.lockVolume(fs.Name)
+		err := d.destroyErasing(ctx, fs.Name)
+		unlock()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// destroyErasing erases and destroys a removed volume being erased, which
+// may already be gone, within the erase timeout. The caller holds the lock
+// of entry.
+func (d *Driver) destroyErasing(ctx context.Context, entry string) (err error) {
+	defer func(start time.Time) { d.auditOperation(ctx, "erase", entry, nil, start, err) }(time.Now())
+	ctx, finish := d.startOperation(ctx, "erase "+entry, d.timeouts.Erase)
+	defer func() { err = finish(err) }()
+	ctx, untrack := d.trackErase(ctx, entry)
+	defer untrack()
+
+	fs, err := d.stratis.GetByName(ctx, entry)
+	if err == nil {
+		if err = d.eraseVolume(ctx, entry, fs.DevicePath); err == nil {
+			if err = d.stratis.Delete(ctx, entry); err != nil {
+				err = fmt.Errorf("delete filesystem: %w", err)
+			}
+		}
+	}
+	if err != nil && !errors.Is(err, stratis.ErrNotFound) {
+		return err
+	}
+
+	if err := d.state.Delete(entry); err != nil {
+		log.Warn("failed to remove volume state", "name", entry, "error", err)
+	}
+	return nil
+}
+
+// trackErase makes the erase of entry one AbortErases cancels, until the
+// returned function is called
+func (d *Driver) trackErase(ctx context.Context, entry string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	d.erasingMu.Lock()
+	defer d.erasingMu.Unlock()
+	if d.erasing == nil {
+		d.erasing = make(map[string]context.CancelFunc)
+	}
+	d.erasing[entry] = cancel
+
+	return ctx, func() {
+		d.erasingMu.Lock()
+		defer d.erasingMu.Unlock()
+		delete(d.erasing, entry)
+		cancel()
+	}
+}
+
+// AbortErases cuts short the erases of removed volumes in progress, so a
+// stop or upgrade does not wait for them. The volumes stay out of sight,
+// partly erased, and the expiry reaper of this or the next process finishes
+// them.
+func (d *Driver) AbortErases() {
+	d.erasingMu.Lock()
+	defer d.erasingMu.Unlock()
+
+	for entry, cancel := range d.erasing {
+		log.Info("aborting erase of removed volume", "entry", entry)
+		cancel()
+	}
+}
+
+// destroyFilesystem destroys the unmounted filesystem of a volume, erasing
+// it first if the volume asks for that. name is the volume's name, or its
+// name in the trash.
+func (d *Driver) destroyFilesystem(ctx context.Context, name string, fs *stratis.Filesystem) error {
+	if d.wantsSecureErase(name) {
+		if err := d.eraseVolume(ctx, name, fs.DevicePath); err != nil {
+			return err
+		}
+	}
+
+	if err := d.stratis.Delete(ctx, fs.Name); err != nil {
+		return fmt.Errorf("delete filesystem: %w", err)
+	}
+	return nil
+}
+
+// eraseVolume erases the device of a volume, logging its progress. When
+// ctx is done first, the volume is left partly erased.
+func (d *Driver) eraseVolume(ctx context.Context, name, device string) error {
+	start := time.Now()
+	log.Info("erasing volume", "name", name, "device", device)
+
+	nextLog := eraseLogStep
+	method, err := d.erase(ctx, device, func(p erase.Progress) {
+		if p.Total == 0 {
+			return
+		}
+		percent := int(p.Done * 100 / p.Total)
+		if percent < nextLog {
+			return
+		}
+		nextLog = percent - percent%eraseLogStep + eraseLogStep
+		log.Info("erasing volume", "name", name, "method", p.Method, "percent", percent, "done", p.Done, "total", p.Total)
+	})
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("secure erase of %s stopped, leaving it partly erased: %w", name, err)
+	}
+	if errors.Is(err, erase.ErrThinProvisioned) {
+		// Retrying does not help until discards are enabled on the pool
+		log.Error("cannot securely erase volume, keeping its filesystem", "name", name, "device", device, "error", err)
+	}
+	if err != nil {
+		return fmt.Errorf("secure erase of %s: %w", name, err)
+	}
+
+	log.Info("volume erased", "name", name, "method", method, "duration", time.Since(start))
+	return nil
+}
@@ -0,0 +1,210 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+
+	"github.com/kriansa/podman-volume-stratis/internal/erase"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+// recordErase replaces the driver's eraser with one that records the devices
+// it is asked to erase, checking their filesystem still exists
+func recordErase(t *testing.T, d *Driver, mgr *fakeManager) *[]string {
+	var erased []string
+	d.erase = func(ctx context.Context, path string, progress func(erase.Progress)) (erase.Method, error) {
+		name := strings.TrimPrefix(path, "/dev/stratis/pool/")
+		if _, err := mgr.GetByName(ctx, name); err != nil {
+			t.Errorf("erasing %s after its filesystem was destroyed", path)
+		}
+		progress(erase.Progress{Method: erase.Discard, Done: 1 << 30, Total: 1 << 30})
+		erased = append(erased, path)
+		return erase.Discard, nil
+	}
+	return &erased
+}
+
+func TestDriver_RemoveSecureErase(t *testing.T) {
+	tests := []struct {
+		name      string
+		byDefault bool
+		option    string
+		wantErase bool
+	}{
+		{"off", false, "", false},
+		{"volume option", false, "true", true},
+		{"config default", true, "", true},
+		{"volume opts out", true, "false", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mgr := newFakeManager(t)
+			d := NewDriver(t.TempDir(), mgr, newFakeMounter(), WithSecureErase(tt.byDefault))
+			erased := recordErase(t, d, mgr)
+
+			opts := map[string]string{}
+			if tt.option != "" {
+				opts["secure_erase"] = tt.option
+			}
+			if err := d.Create(&volume.CreateRequest{Name: "vol1", Options: opts}); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+
+			resp, err := d.Get(&volume.GetRequest{Name: "vol1"})
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if resp.Volume.Status["secureErase"] != tt.wantErase {
+				t.Errorf("Get() status secureErase = %v, want %v", resp.Volume.Status["secureErase"], tt.wantErase)
+			}
+
+			if err := d.Remove(&volume.RemoveRequest{Name: "vol1"}); err != nil {
+				t.Fatalf("Remove() error = %v", err)
+			}
+			if got := len(*erased) > 0; got != tt.wantErase {
+				t.Errorf("erased %v, want erase %v", *erased, tt.wantErase)
+			}
+		})
+	}
+}
+
+func TestDriver_RemoveSecureEraseCutShort(t *testing.T) {
+	ctx := context.Background()
+	mgr := newFakeManager(t)
+	d := NewDriver(t.TempDir(), mgr, newFakeMounter(),
+		WithSecureErase(true), WithTimeouts(Timeouts{Remove: time.Hour, Erase: 20 * time.Millisecond}))
+	d.erase = func(ctx context.Context, _ string, _ func(erase.Progress)) (erase.Method, error) {
+		<-ctx.Done()
+		return erase.Zero, ctx.Err()
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	// Partly erased, but out of sight rather than usable
+	if _, err := d.Get(&volume.GetRequest{Name: "vol1"}); !errors.Is(err, stratis.ErrNotFound) {
+		t.Errorf("Get() after a cut short erase error = %v, want ErrNotFound", err)
+	}
+	filesystems, err := mgr.List(ctx)
+	if err != nil || len(filesystems) != 1 || !isErasing(filesystems[0].Name) {
+		t.Fatalf("filesystems after a cut short erase = %+v, %v, want vol1 waiting to be erased", filesystems, err)
+	}
+	if resp, err := d.List(); err != nil || len(resp.Volumes) != 0 {
+		t.Errorf("List() = %+v, %v, want no volumes", resp, err)
+	}
+
+	// The reaper finishes it
+	erased := recordErase(t, d, mgr)
+	if err := d.finishErasing(ctx); err != nil {
+		t.Fatalf("finishErasing() error = %v", err)
+	}
+	if want := []string{filesystems[0].DevicePath}; !slices.Equal(*erased, want) {
+		t.Errorf("erased %v, want %v", *erased, want)
+	}
+	if _, err := mgr.GetByName(ctx, filesystems[0].Name); !errors.Is(err, stratis.ErrNotFound) {
+		t.Errorf("GetByName() after finishErasing() error = %v, want ErrNotFound", err)
+	}
+	if _, ok := d.state.Get(filesystems[0].Name); ok {
+		t.Error("state of the erased volume left behind")
+	}
+}
+
+func TestDriver_RemoveSecureEraseOutlastsRemoveTimeout(t *testing.T) {
+	ctx := context.Background()
+	mgr := newFakeManager(t)
+	d := NewDriver(t.TempDir(), mgr, newFakeMounter(),
+		WithSecureErase(true), WithTimeouts(Timeouts{Remove: 20 * time.Millisecond, Erase: time.Hour}))
+	d.erase = func(ctx context.Context, _ string, _ func(erase.Progress)) (erase.Method, error) {
+		select {
+		case <-ctx.Done():
+			return erase.Zero, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+			return erase.Zero, nil
+		}
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if filesystems, err := mgr.List(ctx); err != nil || len(filesystems) != 0 {
+		t.Errorf("filesystems after Remove() = %+v, %v, want vol1 erased and destroyed", filesystems, err)
+	}
+}
+
+func TestDriver_AbortErases(t *testing.T) {
+	ctx := context.Background()
+	mgr := newFakeManager(t)
+	d := NewDriver(t.TempDir(), mgr, newFakeMounter(), WithSecureErase(true))
+	started := make(chan struct{})
+	d.erase = func(ctx context.Context, _ string, _ func(erase.Progress)) (erase.Method, error) {
+		close(started)
+		<-ctx.Done()
+		return erase.Zero, ctx.Err()
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	removed := make(chan error, 1)
+	go func() { removed <- d.Remove(&volume.RemoveRequest{Name: "vol1"}) }()
+
+	<-started
+	d.AbortErases()
+	select {
+	case err := <-removed:
+		if err != nil {
+			t.Fatalf("Remove() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Remove() still erasing after AbortErases()")
+	}
+
+	// Left out of sight for the reaper to finish
+	filesystems, err := mgr.List(ctx)
+	if err != nil || len(filesystems) != 1 || !isErasing(filesystems[0].Name) {
+		t.Errorf("filesystems after an aborted erase = %+v, %v, want vol1 waiting to be erased", filesystems, err)
+	}
+}
+
+func TestDriver_PurgeSecureErase(t *testing.T) {
+	ctx := context.Background()
+	mgr := newFakeManager(t)
+	d := NewDriver(t.TempDir(), mgr, newFakeMounter(), WithTrash(time.Hour))
+	erased := recordErase(t, d, mgr)
+
+	err := d.Create(&volume.CreateRequest{Name: "vol1", Options: map[string]string{"secure_erase": "true"}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if len(*erased) != 0 {
+		t.Fatalf("erased %v on the way to the trash, want it kept restorable", *erased)
+	}
+
+	purged, err := d.EmptyTrash(ctx)
+	if err != nil || len(purged) != 1 {
+		t.Fatalf("EmptyTrash() = %+v, %v, want vol1 destroyed", purged, err)
+	}
+	if want := []string{"/dev/stratis/pool/" + purged[0].Name}; !slices.Equal(*erased, want) {
+		t.Errorf("erased %v, want %v", *erased, want)
+	}
+	if _, err := mgr.GetByName(ctx, purged[0].Name); !errors.Is(err, stratis.ErrNotFound) {
+		t.Errorf("trash entry error = %v, want it destroyed", err)
+	}
+}
@@ -0,0 +1,94 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+// ErrProtected is returned when removing a volume that is protected against
+// removal
+var ErrProtected = errors.New("volume is protected")
+
+// Error is a failed volume operation, worded for Podman users. It wraps its
+// cause, so callers can still check for the stratis errors with errors.Is.
+type Error struct {
+	msg string
+	err error
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// errVolumeNotFound is returned for operations on a volume that does not exist
+func errVolumeNotFound(name string) error {
+	return &Error{msg: fmt.Sprintf("volume %s not found", name), err: stratis.ErrNotFound}
+}
+
+// errVolumeExists is returned when creating a volume whose name is taken
+func errVolumeExists(name string) error {
+	return &Error{msg: fmt.Sprintf("volume %s already exists", name), err: stratis.ErrAlreadyExists}
+}
+
+// errVolumeConflict is returned when creating a volume that already exists
+// with other options; diff says how they differ
+func errVolumeConflict(name, diff string) error {
+	return &Error{
+		msg: fmt.Sprintf("volume %s already exists with different options (%s)", name, diff),
+		err: stratis.ErrAlreadyExists,
+	}
+}
+
+// errVolumeProtected is returned when removing a protected volume
+func errVolumeProtected(name string) error {
+	return &Error{
+		msg: fmt.Sprintf("volume %s is protected against removal; run 'podman-volume-stratis unprotect %s' to allow it", name, name),
+		err: ErrProtected,
+	}
+}
+
+// errTrashEntryNotFound is returned for a removed volume that is not in the trash
+func errTrashEntryNotFound(entry string) error {
+	return &Error{msg: fmt.Sprintf("%s is not in the trash", entry), err: stratis.ErrNotFound}
+}
+
+// hints say what to do about failures outside the plugin's control
+var hints = []struct {
+	err  error
+	hint string
+}{
+	{stratis.ErrStratisdUnavailable, "stratisd is not responding; check that the stratisd service is running"},
+	{stratis.ErrPoolNotFound, "the Stratis pool does not exist; check the pool setting of the plugin against 'stratis pool list'"},
+	{stratis.ErrLocked, "the Stratis pool is locked; unlock it with 'stratis pool start'"},
+	{stratis.ErrNoSpace, "the Stratis pool is out of space; add a device with 'stratis pool add-data' or remove unused volumes"},
+	{stratis.ErrBusy, "the volume is in use; stop the containers and processes using it and try again"},
+	{syscall.EBUSY, "the volume is in use; stop the containers and processes using it and try again"},
+}
+
+// explain words err, the failure of the operation described by desc, for
+// Podman users. Errors already worded, and those without a hint, are
+// returned as they are.
+func explain(desc string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var worded *Error
+	if errors.As(err, &worded) {
+		return err
+	}
+
+	for _, h := range hints {
+		if errors.Is(err, h.err) {
+			return &Error{msg: fmt.Sprintf("%s failed: %s (%v)", desc, h.hint, err), err: err}
+		}
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+	"github.com/kriansa/podman-volume-stratis/internal/fault"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+func TestExplain(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "no space",
+			err:  fmt.Errorf("create filesystem: %w", stratis.ErrNoSpace),
+			want: "create volume vol1 failed: the Stratis pool is out of space; add a device with 'stratis pool add-data' or remove unused volumes (create filesystem: not enough space in pool)",
+		},
+		{
+			name: "unmount busy",
+			err:  fmt.Errorf("unmount: %w", syscall.EBUSY),
+			want: "create volume vol1 failed: the volume is in use; stop the containers and processes using it and try again (unmount: device or resource busy)",
+		},
+		{
+			name: "already worded",
+			err:  errVolumeNotFound("vol1"),
+			want: "volume vol1 not found",
+		},
+		{
+			name: "no hint",
+			err:  errors.New("invalid size"),
+			want: "invalid size",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := explain("create volume vol1", tt.err)
+			if got.Error() != tt.want {
+				t.Errorf("explain() = %q, want %q", got, tt.want)
+			}
+			if !errors.Is(got, tt.err) {
+				t.Errorf("explain() = %v, does not wrap %v", got, tt.err)
+			}
+		})
+	}
+}
+
+func TestDriver_ErrorsWrapStratisErrors(t *testing.T) {
+	d := NewDriver(t.TempDir(), newFakeManager(t), newFakeMounter())
+
+	_, err := d.Mount(&volume.MountRequest{Name: "missing", ID: "c1"})
+	if !errors.Is(err, stratis.ErrNotFound) || !strings.Contains(err.Error(), "volume missing not found") {
+		t.Errorf("Mount() error = %v, want volume missing not found", err)
+	}
+}
+
+func TestDriver_InjectedFaults(t *testing.T) {
+	in, err := fault.NewInjector([]fault.Rule{
+		{Method: "Create", Error: "timeout", Sequence: "x"},
+		{Method: "Unmount", Error: "ebusy", Sequence: "x"},
+	}, 1)
+	if err != nil {
+		t.Fatalf("NewInjector() error = %v", err)
+	}
+	d := NewDriver(t.TempDir(),
+		fault.WrapManager(newFakeManager(t), in),
+		fault.WrapMounter(newFakeMounter(), in),
+		WithTimeouts(Timeouts{Create: 20 * time.Millisecond}),
+	)
+
+	// A stratisd that does not answer fails the request, not the plugin
+	err = d.Create(&volume.CreateRequest{Name: "vol1"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Create() error = %v, want a timeout", err)
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("second Create() error = %v", err)
+	}
+
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol1", ID: "c1"}); err != nil {
+		t.Fatalf("Mount() error = %v", err)
+	}
+	err = d.Unmount(&volume.UnmountRequest{Name: "vol1", ID: "c1"})
+	if !errors.Is(err, syscall.EBUSY) || !strings.Contains(err.Error(), "the volume is in use") {
+		t.Errorf("Unmount() error = %v, want EBUSY with a hint", err)
+	}
+	if err := d.Unmount(&volume.UnmountRequest{Name: "vol1", ID: "c1"}); err != nil {
+		t.Errorf("Unmount() retry error = %v", err)
+	}
+}
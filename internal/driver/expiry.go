@@ -0,0 +1,201 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/kriansa/podman-volume-stratis/internal/audit"
+	"github.com/kriansa/podman-volume-stratis/internal/events"
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+	"github.com/kriansa/podman-volume-stratis/internal/podman"
+	"github.com/kriansa/podman-volume-stratis/internal/state"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+// Why the reaper destroys a volume
+const (
+	// expiredEphemeral is an ephemeral volume whose last mount reference
+	// was released
+	expiredEphemeral = "ephemeral"
+	// expiredTTL is a volume that went unused for longer than its ttl
+	expiredTTL = "ttl"
+)
+
+// expiry returns why a volume is due to be destroyed by now, or "" if it is
+// not. Volumes in use and protected volumes never are.
+func expiry(vol state.Volume, now time.Time) string {
+	if vol.Protected || len(vol.MountIDs) > 0 {
+		return ""
+	}
+
+	// Only a volume that was ever mounted has been released
+	if vol.Ephemeral && !vol.LastUsed.IsZero() {
+		return expiredEphemeral
+	}
+
+	if vol.TTL > 0 {
+		since := vol.Created
+		if vol.LastUsed.After(since) {
+			since = vol.LastUsed
+		}
+		if !since.IsZero() && now.Sub(since) >= vol.TTL {
+			return expiredTTL
+		}
+	}
+	return ""
+}
+
+// ReapExpired periodically destroys ephemeral volumes that were released
+// and volumes unused for longer than their ttl, and finishes erasing removed
+// volumes whose erase failed or was cut short. Blocks until ctx is cancelled.
+func (d *Driver) ReapExpired(ctx context.Context, interval time.Duration) {
+	ctx = audit.WithActor(ctx, audit.ActorReaper)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := d.reapExpired(ctx, time.Now()); err != nil {
+			log.Warn("expiry reaper failed", "error", err)
+		}
+		if err := d.finishErasing(ctx); err != nil {
+			log.Warn("failed to erase removed volumes", "error", err)
+		}
+	}
+}
+
+// reapExpired destroys the volumes that expired by now and returns their
+// names. It carries on past failures, returning them all.
+func (d *Driver) reapExpired(ctx context.Context, now time.Time) ([]string, error) {
+	var due []string
+	for name, vol := range d.state.All() {
+		if !isHidden(name) && expiry(vol, now) != "" {
+			due = append(due, name)
+		}
+	}
+	slices.Sort(due)
+
+	var reaped []string
+	var errs []error
+	for _, name := range due {
+		ok, err := d.reapVolume(ctx, name, now)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			reaped = append(reaped, name)
+		}
+	}
+	return reaped, errors.Join(errs...)
+}
+
+// reapVolume destroys a volume if it is still expired once locked, as it
+// may have been mounted again meanwhile, and reports whether it did. One a
+// container still uses is kept until it no longer does.
+func (d *Driver) reapVolume(ctx context.Context, name string, now time.Time) (bool, error) {
+	ok, err := d.disposeVolume(ctx, name, func(vol state.Volume) (string, error) {
+		return expiry(vol, now), nil
+	})
+	if errors.Is(err, podman.ErrVolumeInUse) {
+		log.Debug("keeping expired volume a container uses", "name", name)
+		return false, nil
+	}
+	return ok, err
+}
+
+// disposeVolume gets rid of a volume the plugin decided to, and reports
+// whether it did. check is called with the state of the volume while it is
+// locked, and returns why it goes, or "" to keep it. With a container
+// engine, the volume is removed through the engine, so the engine's record
+// of it goes too; the engine calls Remove, so the lock is not held
+// meanwhile. Volumes the engine has no record of, and all volumes without
+// an engine, are destroyed directly.
+func (d *Driver) disposeVolume(ctx context.Context, name string, check func(state.Volume) (string, error)) (disposed bool, err error) {
+	// Only volumes that are due are audited, not every check
+	var reason string
+	defer func(start time.Time) {
+		if reason != "" {
+			d.auditOperation(ctx, "dispose", name, map[string]string{"reason": reason}, start, err)
+		}
+	}(time.Now())
+
+	if d.containers != nil {
+		unlock := d.lockVolume(name)
+		vol, _ := d.state.Get(name)
+		reason, err = check(vol)
+		unlock()
+		if reason == "" || err != nil {
+			return false, err
+		}
+
+		err = d.containers.RemoveVolume(ctx, name)
+		if err == nil {
+			log.Info("volume removed through the container engine", "name", name, "reason", reason)
+			return true, nil
+		}
+		if !errors.Is(err, podman.ErrNoSuchVolume) {
+			return false, fmt.Errorf("remove %s volume %s: %w", reason, name, err)
+		}
+	}
+
+	defer d.lockVolume(name)()
+
+	vol, ok := d.state.Get(name)
+	reason, err = check(vol)
+	if !ok {
+		// Gone meanwhile; nothing to dispose of
+		reason = ""
+	}
+	if reason == "" || err != nil {
+		return false, err
+	}
+	return d.destroyVolume(ctx, name, reason)
+}
+
+// destroyVolume removes a volume the plugin decided to get rid of, for
+// reason, and reports whether it did; false when it was gone already. Like
+// a removal, it goes to the trash when that is enabled. The caller holds
+// the lock of the volume.
+func (d *Driver) destroyVolume(ctx context.Context, name, reason string) (_ bool, err error) {
+	ctx, finish := d.startOperation(ctx, "destroy "+reason+" volume "+name, d.removeTimeout(name))
+	defer func() { err = finish(err) }()
+
+	tx, err := d.begin(opRemove, name)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err := tx.End(); err != nil {
+			log.Warn("failed to end journaled operation", "name", name, "error", err)
+		}
+	}()
+
+	entry, err := d.removeVolume(ctx, tx, name)
+	if errors.Is(err, stratis.ErrNotFound) {
+		// Destroyed behind the plugin's back; nothing left to do
+		if err := d.state.Delete(name); err != nil {
+			log.Warn("failed to remove volume state", "name", name, "error", err)
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("destroy %s volume %s: %w", reason, name, err)
+	}
+
+	log.Info("volume destroyed", "name", name, "reason", reason, "trash", entry)
+	attrs := map[string]any{"reason": reason}
+	if entry != "" {
+		attrs["trash"] = entry
+	}
+	d.events.Publish(events.Removed, name, attrs)
+	return true, nil
+}
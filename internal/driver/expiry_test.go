@@ -0,0 +1,191 @@
+package driver
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+
+	"github.com/kriansa/podman-volume-stratis/internal/audit"
+	"github.com/kriansa/podman-volume-stratis/internal/state"
+)
+
+func TestExpiry(t *testing.T) {
+	now := time.Now()
+	hourAgo := now.Add(-time.Hour)
+
+	tests := []struct {
+		name string
+		vol  state.Volume
+		want string
+	}{
+		{"plain", state.Volume{Created: hourAgo, LastUsed: hourAgo}, ""},
+		{"ephemeral never mounted", state.Volume{Ephemeral: true, Created: hourAgo}, ""},
+		{"ephemeral mounted", state.Volume{Ephemeral: true, LastUsed: now, MountIDs: []string{"c1"}}, ""},
+		{"ephemeral released", state.Volume{Ephemeral: true, LastUsed: now}, expiredEphemeral},
+		{"ttl not reached", state.Volume{TTL: 2 * time.Hour, Created: hourAgo}, ""},
+		{"ttl reached since created", state.Volume{TTL: time.Hour, Created: hourAgo}, expiredTTL},
+		{"ttl counted from last use", state.Volume{TTL: time.Hour, Created: hourAgo, LastUsed: now}, ""},
+		{"ttl while mounted", state.Volume{TTL: time.Hour, Created: hourAgo, MountIDs: []string{"c1"}}, ""},
+		{"protected", state.Volume{Ephemeral: true, LastUsed: now, Protected: true}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expiry(tt.vol, now); got != tt.want {
+				t.Errorf("expiry() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDriver_ReapExpired(t *testing.T) {
+	ctx := context.Background()
+	mgr := newFakeManager(t)
+	d := NewDriver(t.TempDir(), mgr, newFakeMounter())
+
+	volumes := map[string]map[string]string{
+		"eph":   {"ephemeral": "true"},
+		"ttl":   {"ttl": "4h"},
+		"plain": nil,
+	}
+	for name, opts := range volumes {
+		if err := d.Create(&volume.CreateRequest{Name: name, Options: opts}); err != nil {
+			t.Fatalf("Create(%s) error = %v", name, err)
+		}
+	}
+
+	resp, err := d.Get(&volume.GetRequest{Name: "ttl"})
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if resp.Volume.Status["ttl"] != "4h0m0s" {
+		t.Errorf("Get() status ttl = %v, want 4h0m0s", resp.Volume.Status["ttl"])
+	}
+
+	// Nothing expires before the ephemeral volume was used and released
+	if reaped, err := d.reapExpired(ctx, time.Now()); err != nil || len(reaped) != 0 {
+		t.Fatalf("reapExpired() = %v, %v, want nothing reaped", reaped, err)
+	}
+
+	if _, err := d.Mount(&volume.MountRequest{Name: "eph", ID: "c1"}); err != nil {
+		t.Fatalf("Mount() error = %v", err)
+	}
+	if reaped, _ := d.reapExpired(ctx, time.Now()); len(reaped) != 0 {
+		t.Fatalf("reapExpired() while mounted = %v, want nothing reaped", reaped)
+	}
+	if err := d.Unmount(&volume.UnmountRequest{Name: "eph", ID: "c1"}); err != nil {
+		t.Fatalf("Unmount() error = %v", err)
+	}
+
+	reaped, err := d.reapExpired(ctx, time.Now())
+	if err != nil {
+		t.Fatalf("reapExpired() error = %v", err)
+	}
+	if !slices.Equal(reaped, []string{"eph"}) {
+		t.Errorf("reapExpired() after release = %v, want [eph]", reaped)
+	}
+
+	reaped, err = d.reapExpired(ctx, time.Now().Add(4*time.Hour))
+	if err != nil {
+		t.Fatalf("reapExpired() error = %v", err)
+	}
+	if !slices.Equal(reaped, []string{"ttl"}) {
+		t.Errorf("reapExpired() after the ttl = %v, want [ttl]", reaped)
+	}
+
+	list, _ := mgr.List(ctx)
+	if len(list) != 1 || list[0].Name != "plain" {
+		t.Errorf("filesystems after reaping = %+v, want only plain", list)
+	}
+	if _, ok := d.state.Get("eph"); ok {
+		t.Error("state of a reaped volume kept")
+	}
+}
+
+func TestDriver_AuditsOperationsOutsidePluginRequests(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.Open(path, 0, 0)
+	if err != nil {
+		t.Fatalf("audit.Open() error = %v", err)
+	}
+	defer auditLog.Close()
+	d := NewDriver(t.TempDir(), newFakeManager(t), newFakeMounter(), WithAudit(auditLog))
+
+	if err := d.Create(&volume.CreateRequest{Name: "eph", Options: map[string]string{"ephemeral": "true"}}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "eph", ID: "c1"}); err != nil {
+		t.Fatalf("Mount() error = %v", err)
+	}
+	if err := d.Unmount(&volume.UnmountRequest{Name: "eph", ID: "c1"}); err != nil {
+		t.Fatalf("Unmount() error = %v", err)
+	}
+
+	adminCtx := audit.WithActor(ctx, audit.ActorAdmin)
+	if err := d.SetProtected(adminCtx, "missing", true); err == nil {
+		t.Fatal("SetProtected() of a missing volume succeeded")
+	}
+	if _, err := d.reapExpired(audit.WithActor(ctx, audit.ActorReaper), time.Now()); err != nil {
+		t.Fatalf("reapExpired() error = %v", err)
+	}
+
+	// Plugin requests are audited by the server, not the driver
+	entries, err := audit.Tail(path, 10)
+	if err != nil {
+		t.Fatalf("audit.Tail() error = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("audit entries = %+v, want 2", entries)
+	}
+	if e := entries[0]; e.Operation != "protect" || e.Volume != "missing" || e.Actor != audit.ActorAdmin || e.Result != audit.ResultError {
+		t.Errorf("first entry = %+v, want a failed protect of missing by admin", e)
+	}
+	if e := entries[1]; e.Operation != "dispose" || e.Volume != "eph" || e.Actor != audit.ActorReaper ||
+		e.Options["reason"] != expiredEphemeral || e.Result != audit.ResultOK {
+		t.Errorf("second entry = %+v, want the reaper disposing of eph", e)
+	}
+}
+
+func TestDriver_CreateInvalidExpiry(t *testing.T) {
+	d := NewDriver(t.TempDir(), newFakeManager(t), newFakeMounter())
+
+	for _, opts := range []map[string]string{
+		{"ephemeral": "sometimes"},
+		{"ttl": "forever"},
+		{"ttl": "-1h"},
+	} {
+		if err := d.Create(&volume.CreateRequest{Name: "vol1", Options: opts}); err == nil {
+			t.Errorf("Create() with %v succeeded, want an error", opts)
+		}
+	}
+}
+
+func TestDriver_ReapExpiredThroughEngine(t *testing.T) {
+	ctx := context.Background()
+	engine := &fakeEngine{inUse: []string{"kept"}}
+	d := NewDriver(t.TempDir(), newFakeManager(t), newFakeMounter(), WithContainers(engine, OrphanPolicy{}))
+	engine.d = d
+
+	for _, name := range []string{"gone", "kept"} {
+		err := d.Create(&volume.CreateRequest{Name: name, Options: map[string]string{"ttl": "1h"}})
+		if err != nil {
+			t.Fatalf("Create(%s) error = %v", name, err)
+		}
+	}
+
+	reaped, err := d.reapExpired(ctx, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("reapExpired() error = %v", err)
+	}
+	if !slices.Equal(reaped, []string{"gone"}) || !slices.Equal(engine.removed, []string{"gone"}) {
+		t.Errorf("reaped %v, engine removed %v, want only gone", reaped, engine.removed)
+	}
+	if _, ok := d.state.Get("kept"); !ok {
+		t.Error("expired volume a container uses was destroyed")
+	}
+}
@@ -0,0 +1,210 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/kriansa/podman-volume-stratis/internal/journal"
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+// Kinds of journaled operations
+const (
+	opCreate = "create"
+	opMount  = "mount"
+	opRemove = "remove"
+)
+
+// Steps of journaled operations, each with an action that undoes it
+const (
+	// stepCreateFilesystem creates the filesystem of the volume
+	stepCreateFilesystem = "create_filesystem"
+	// stepMakeMountPoint makes the mount point directory, the target
+	stepMakeMountPoint = "make_mount_point"
+	// stepMount mounts the volume at the target
+	stepMount = "mount"
+	// stepMountRef records the target as holding the volume mounted
+	stepMountRef = "mount_ref"
+)
+
+// Steps of removals, which are finished rather than undone
+const (
+	// stepRename moves the state and filesystem of the volume to the target
+	// name, out of Podman's sight
+	stepRename = "rename"
+)
+
+// begin records the start of an operation in the journal
+func (d *Driver) begin(kind, name string) (*journal.Tx, error) {
+	tx, err := d.journal.Begin(kind, name)
+	if err != nil {
+		return nil, fmt.Errorf("journal %s: %w", kind, err)
+	}
+	return tx, nil
+}
+
+// record records the intent of a step before it runs
+func record(tx *journal.Tx, action, target string) error {
+	if err := tx.Record(action, target); err != nil {
+		return fmt.Errorf("journal %s: %w", action, err)
+	}
+	return nil
+}
+
+// settle ends a journaled operation that returned err. The steps of a failed
+// operation are undone first; if that fails too, the operation stays in the
+// journal for Recover to roll back on the next start.
+func (d *Driver) settle(tx *journal.Tx, name string, err error) error {
+	if err != nil {
+		// The operation's context may be what failed it
+		if rollbackErr := d.rollback(context.Background(), name, tx.Steps()); rollbackErr != nil {
+			log.Error("rollback failed, retrying on next start", "name", name, "error", rollbackErr)
+			return fmt.Errorf("%w (and rollback failed: %v)", err, rollbackErr)
+		}
+	}
+
+	if endErr := tx.End(); endErr != nil {
+		log.Warn("failed to end journaled operation", "name", name, "error", endErr)
+	}
+	return err
+}
+
+// rollback undoes the steps of an operation on a volume, last first, within
+// the remove timeout
+func (d *Driver) rollback(ctx context.Context, name string, steps []journal.Step) (err error) {
+	if len(steps) == 0 {
+		return nil
+	}
+
+	ctx, finish := d.startOperation(ctx, "roll back volume "+name, d.timeouts.Remove)
+	defer func() { err = finish(err) }()
+
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		log.Info("rolling back", "name", name, "step", step.Action, "target", step.Target)
+		if err := d.undo(ctx, name, step); err != nil {
+			return fmt.Errorf("undo %s: %w", step.Action, err)
+		}
+	}
+	return nil
+}
+
+// undo undoes a step, which may or may not have run
+func (d *Driver) undo(ctx context.Context, name string, step journal.Step) error {
+	switch step.Action {
+	case stepCreateFilesystem:
+		err := d.stratis.Delete(ctx, name)
+		if err != nil && !errors.Is(err, stratis.ErrNotFound) {
+			return err
+		}
+		return d.state.Delete(name)
+	case stepMakeMountPoint:
+		err := os.Remove(step.Target)
+		if errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.ENOTDIR) {
+			// Not a directory the mount made; prepareMountPoint refused it
+			log.Warn("leaving mount point alone", "path", step.Target, "error", err)
+			return nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	case stepMount:
+		mounted, err := d.mounter.IsMounted(ctx, step.Target)
+		if err != nil || !mounted {
+			return err
+		}
+		return d.mounter.Unmount(ctx, step.Target)
+	case stepMountRef:
+		return d.removeMountRef(name, step.Target)
+	default:
+		return fmt.Errorf("unknown step %q", step.Action)
+	}
+}
+
+// Recover deals with the operations a previous process left unfinished, e.g.
+// by crashing. Creates and mounts, which Podman never saw succeed, are rolled
+// back; removals are finished, as Podman asked for the volume to go. An
+// operation that cannot be dealt with stays in the journal for the next start.
+func (d *Driver) Recover(ctx context.Context) error {
+	defer d.lockPool()()
+
+	var errs []error
+	for _, op := range d.journal.Pending() {
+		tx, err := d.journal.Resume(op.ID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		start := time.Now()
+		switch op.Kind {
+		case opRemove:
+			err = d.finishRemove(ctx, tx, op.Volume)
+		default:
+			err = d.rollback(ctx, op.Volume, op.Steps)
+		}
+		d.auditOperation(ctx, "recover", op.Volume, map[string]string{"kind": op.Kind}, start, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("recover %s of volume %s: %w", op.Kind, op.Volume, err))
+			continue
+		}
+
+		log.Info("recovered unfinished operation", "kind", op.Kind, "name", op.Volume, "started", op.Started)
+		if err := tx.End(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// finishRemove finishes removing a volume, which may already be gone
+func (d *Driver) finishRemove(ctx context.Context, tx *journal.Tx, name string) (err error) {
+	ctx, finish := d.startOperation(ctx, "remove volume "+name, d.removeTimeout(name))
+	defer func() { err = finish(err) }()
+
+	renamed, err := d.resumeRename(ctx, name, tx.Steps())
+	if renamed || err != nil {
+		return err
+	}
+
+	_, err = d.removeVolume(ctx, tx, name)
+	if errors.Is(err, stratis.ErrNotFound) {
+		if err := d.state.Delete(name); err != nil {
+			log.Warn("failed to remove volume state", "name", name, "error", err)
+		}
+		return nil
+	}
+	return err
+}
+
+// resumeRename brings the state and filesystem of a volume together again
+// when a rename, the last in steps, was cut short between the two, and
+// reports whether the filesystem got its new name
+func (d *Driver) resumeRename(ctx context.Context, name string, steps []journal.Step) (bool, error) {
+	newName := ""
+	for _, step := range steps {
+		if step.Action == stepRename {
+			newName = step.Target
+		}
+	}
+	if newName == "" {
+		return false, nil
+	}
+
+	_, err := d.stratis.GetByName(ctx, newName)
+	switch {
+	case err == nil:
+		log.Info("finishing rename of removed volume", "name", name, "to", newName)
+		return true, d.state.Rename(name, newName)
+	case errors.Is(err, stratis.ErrNotFound):
+		return false, d.state.Rename(newName, name)
+	default:
+		return false, fmt.Errorf("look up %s: %w", newName, err)
+	}
+}
@@ -0,0 +1,190 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+
+	"github.com/kriansa/podman-volume-stratis/internal/fault"
+	"github.com/kriansa/podman-volume-stratis/internal/journal"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+func newInjector(t *testing.T, rules ...fault.Rule) *fault.Injector {
+	t.Helper()
+
+	in, err := fault.NewInjector(rules, 1)
+	if err != nil {
+		t.Fatalf("NewInjector() error = %v", err)
+	}
+	return in
+}
+
+func TestDriver_CreateRollsBackPartialFailure(t *testing.T) {
+	mgr := newFakeManager(t)
+	// stratisd creates the filesystem, but the plugin never hears of it
+	in := newInjector(t, fault.Rule{Method: "Create", Error: "unavailable", After: true, Sequence: "x"})
+	j := journal.New("")
+	d := NewDriver(t.TempDir(), fault.WrapManager(mgr, in), newFakeMounter(), WithJournal(j))
+
+	err := d.Create(&volume.CreateRequest{Name: "vol1"})
+	if !errors.Is(err, stratis.ErrStratisdUnavailable) {
+		t.Fatalf("Create() error = %v, want the injected failure", err)
+	}
+	if _, err := mgr.GetByName(context.Background(), "vol1"); !errors.Is(err, stratis.ErrNotFound) {
+		t.Errorf("filesystem left behind by a failed Create(): %v", err)
+	}
+	if pending := j.Pending(); len(pending) != 0 {
+		t.Errorf("journal = %+v, want the rolled back create gone", pending)
+	}
+
+	// The name is free again
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err != nil {
+		t.Errorf("Create() after rollback error = %v", err)
+	}
+}
+
+func TestDriver_CreateLeavesExistingFilesystem(t *testing.T) {
+	mgr := newFakeManager(t)
+	d := NewDriver(t.TempDir(), mgr, newFakeMounter())
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	// A Create racing another client's loses to a filesystem that is not its own
+	in := newInjector(t, fault.Rule{Method: "GetByName", Error: "not_found", Sequence: "x"})
+	d = NewDriver(t.TempDir(), fault.WrapManager(mgr, in), newFakeMounter())
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); !errors.Is(err, stratis.ErrAlreadyExists) {
+		t.Fatalf("Create() error = %v, want ErrAlreadyExists", err)
+	}
+	if _, err := mgr.GetByName(context.Background(), "vol1"); err != nil {
+		t.Errorf("existing filesystem removed by a failed Create(): %v", err)
+	}
+}
+
+func TestDriver_MountRollsBackPartialFailure(t *testing.T) {
+	mgr := newFakeManager(t)
+	mounter := newFakeMounter()
+	in := newInjector(t, fault.Rule{Method: "Mount", Error: "eio", After: true, Sequence: "x"})
+	d := NewDriver(t.TempDir(), mgr, fault.WrapMounter(mounter, in))
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol1", ID: "c1"}); err == nil {
+		t.Fatal("Mount() succeeded, want the injected failure")
+	}
+	mountPoint := d.mountPointPath("vol1")
+	if mounted, _ := mounter.IsMounted(context.Background(), mountPoint); mounted {
+		t.Error("volume left mounted by a failed Mount()")
+	}
+	if _, err := os.Stat(mountPoint); !os.IsNotExist(err) {
+		t.Errorf("mount point left behind by a failed Mount(): %v", err)
+	}
+
+	if _, err := d.Mount(&volume.MountRequest{Name: "vol1", ID: "c1"}); err != nil {
+		t.Errorf("Mount() after rollback error = %v", err)
+	}
+}
+
+func TestDriver_FailedRollbackIsRecovered(t *testing.T) {
+	mgr := newFakeManager(t)
+	in := newInjector(t,
+		fault.Rule{Method: "Create", Error: "unavailable", After: true, Sequence: "x"},
+		fault.Rule{Method: "Delete", Error: "unavailable", Sequence: "x"},
+	)
+	path := filepath.Join(t.TempDir(), "journal.json")
+	j, err := journal.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	d := NewDriver(t.TempDir(), fault.WrapManager(mgr, in), newFakeMounter(), WithJournal(j))
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err == nil {
+		t.Fatal("Create() succeeded, want the injected failure")
+	}
+	if _, err := mgr.GetByName(context.Background(), "vol1"); err != nil {
+		t.Fatalf("GetByName() error = %v, want the filesystem the rollback failed to remove", err)
+	}
+
+	// The next process finds the create in the journal
+	j, err = journal.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	d = NewDriver(t.TempDir(), mgr, newFakeMounter(), WithJournal(j))
+	if err := d.Recover(context.Background()); err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+	if _, err := mgr.GetByName(context.Background(), "vol1"); !errors.Is(err, stratis.ErrNotFound) {
+		t.Errorf("GetByName() after Recover() error = %v, want ErrNotFound", err)
+	}
+	if pending := j.Pending(); len(pending) != 0 {
+		t.Errorf("journal after Recover() = %+v, want it empty", pending)
+	}
+}
+
+func TestDriver_Recover(t *testing.T) {
+	ctx := context.Background()
+	mgr := newFakeManager(t)
+	mounter := newFakeMounter()
+	j := journal.New("")
+	d := NewDriver(t.TempDir(), mgr, mounter, WithJournal(j))
+
+	for _, name := range []string{"created", "mounted", "removed"} {
+		if err := d.Create(&volume.CreateRequest{Name: name}); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+	if _, err := d.Mount(&volume.MountRequest{Name: "mounted", ID: "c1"}); err != nil {
+		t.Fatalf("Mount() error = %v", err)
+	}
+
+	// Operations cut short by a crash after their last step
+	crash := func(kind, name string, steps ...journal.Step) {
+		tx, err := j.Begin(kind, name)
+		if err != nil {
+			t.Fatalf("Begin() error = %v", err)
+		}
+		for _, step := range steps {
+			if err := tx.Record(step.Action, step.Target); err != nil {
+				t.Fatalf("Record() error = %v", err)
+			}
+		}
+	}
+	mountPoint := d.mountPointPath("mounted")
+	crash(opCreate, "created", journal.Step{Action: stepCreateFilesystem, Target: "created"})
+	crash(opMount, "mounted",
+		journal.Step{Action: stepMakeMountPoint, Target: mountPoint},
+		journal.Step{Action: stepMount, Target: mountPoint},
+		journal.Step{Action: stepMountRef, Target: "c1"},
+	)
+	crash(opRemove, "removed")
+	crash(opRemove, "gone")
+
+	if err := d.Recover(ctx); err != nil {
+		t.Fatalf("Recover() error = %v", err)
+	}
+
+	for _, name := range []string{"created", "removed"} {
+		if _, err := mgr.GetByName(ctx, name); !errors.Is(err, stratis.ErrNotFound) {
+			t.Errorf("GetByName(%s) after Recover() error = %v, want ErrNotFound", name, err)
+		}
+	}
+	if mounted, _ := mounter.IsMounted(ctx, mountPoint); mounted {
+		t.Error("mount rolled back by Recover() is still mounted")
+	}
+	if vol, _ := d.state.Get("mounted"); len(vol.MountIDs) != 0 {
+		t.Errorf("mount references after Recover() = %v, want none", vol.MountIDs)
+	}
+	if _, err := mgr.GetByName(ctx, "mounted"); err != nil {
+		t.Errorf("volume of a rolled back mount is gone: %v", err)
+	}
+	if pending := j.Pending(); len(pending) != 0 {
+		t.Errorf("journal after Recover() = %+v, want it empty", pending)
+	}
+}
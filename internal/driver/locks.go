@@ -0,0 +1,66 @@
+package driver
+
+import "sync"
+
+// volumeLocks hands out one read/write lock per volume name. Locks are
+// created on first use and dropped once nobody holds or waits for them, so
+// the map only grows with concurrency, not with the number of volumes.
+type volumeLocks struct {
+	mu    sync.Mutex
+	locks map[string]*volumeLock
+}
+
+type volumeLock struct {
+	sync.RWMutex
+	// refs counts holders and waiters; guarded by volumeLocks.mu
+	refs int
+}
+
+// acquire returns the lock for name, registering the caller as a user of it
+func (l *volumeLocks) acquire(name string) *volumeLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.locks == nil {
+		l.locks = make(map[string]*volumeLock)
+	}
+
+	lock, ok := l.locks[name]
+	if !ok {
+		lock = &volumeLock{}
+		l.locks[name] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+// release unregisters the caller, dropping the lock when it was the last user
+func (l *volumeLocks) release(name string, lock *volumeLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lock.refs--
+	if lock.refs == 0 {
+		delete(l.locks, name)
+	}
+}
+
+// Lock locks the volume for writing and returns the function that unlocks it
+func (l *volumeLocks) Lock(name string) (unlock func()) {
+	lock := l.acquire(name)
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.release(name, lock)
+	}
+}
+
+// RLock locks the volume for reading and returns the function that unlocks it
+func (l *volumeLocks) RLock(name string) (unlock func()) {
+	lock := l.acquire(name)
+	lock.RLock()
+	return func() {
+		lock.RUnlock()
+		l.release(name, lock)
+	}
+}
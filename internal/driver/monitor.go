@@ -0,0 +1,77 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	"github.com/kriansa/podman-volume-stratis/internal/events"
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+)
+
+// MonitorUsage periodically checks the usage of every volume and publishes a
+// UsageThreshold event when one rises above thresholdPercent. A volume is
+// reported again only after its usage drops back below the threshold.
+// Blocks until ctx is cancelled.
+func (d *Driver) MonitorUsage(ctx context.Context, interval time.Duration, thresholdPercent float64) {
+	above := make(map[string]bool)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		listCtx, finish := d.startOperation(ctx, "list volumes", d.timeouts.Query)
+		filesystems, err := d.stratis.List(listCtx)
+		if err = finish(err); err != nil {
+			log.Warn("usage monitor failed to list filesystems", "error", err)
+			continue
+		}
+
+		seen := make(map[string]bool, len(filesystems))
+		for _, fs := range filesystems {
+			if isHidden(fs.Name) {
+				continue
+			}
+			seen[fs.Name] = true
+
+			// Thin-provisioned volumes are measured against their logical size
+			capacity := fs.Total
+			if fs.SizeLimit != nil {
+				capacity = *fs.SizeLimit
+			}
+			if capacity == 0 {
+				continue
+			}
+
+			percent := float64(fs.Used) / float64(capacity) * 100
+			if percent < thresholdPercent {
+				above[fs.Name] = false
+				continue
+			}
+			if above[fs.Name] {
+				continue
+			}
+
+			above[fs.Name] = true
+			log.Warn("volume usage above threshold", "name", fs.Name, "percent", percent, "threshold", thresholdPercent)
+			d.events.Publish(events.UsageThreshold, fs.Name, map[string]any{
+				"used":      fs.Used,
+				"capacity":  capacity,
+				"percent":   percent,
+				"threshold": thresholdPercent,
+			})
+		}
+
+		// Forget volumes that no longer exist
+		for name := range above {
+			if !seen[name] {
+				delete(above, name)
+			}
+		}
+	}
+}
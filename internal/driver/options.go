@@ -0,0 +1,145 @@
+package driver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+// volumeOptions are the options a volume is created with, normalized so
+// that equivalent spellings, such as "1GiB" and "1073741824", are equal
+type volumeOptions struct {
+	// SizeLimit is the size limit in bytes, or nil for a thin provisioned
+	// volume without one
+	SizeLimit *uint64
+	// Ephemeral has the reaper destroy the volume once its last mount
+	// reference is released
+	Ephemeral bool
+	// TTL has the reaper destroy the volume once it has gone unused for
+	// that long; zero for no limit
+	TTL time.Duration
+	// SecureErase says whether the volume is erased before its filesystem
+	// is destroyed; nil for the driver's default
+	SecureErase *bool
+	// Protected makes Remove refuse the volume. Unlike the other options,
+	// it can be changed later, so it is not part of the canonical options.
+	Protected bool
+}
+
+// parseOptions parses the options of a create request. Options the plugin
+// does not know are ignored.
+func parseOptions(opts map[string]string) (volumeOptions, error) {
+	var o volumeOptions
+
+	// Size is optional for Stratis - thin provisioning
+	if sizeStr := opts["size"]; sizeStr != "" {
+		size, err := parseSize(sizeStr)
+		if err != nil {
+			return o, fmt.Errorf("invalid size %q: %w", sizeStr, err)
+		}
+		o.SizeLimit = &size
+	}
+
+	if ephemeral := opts["ephemeral"]; ephemeral != "" {
+		var err error
+		if o.Ephemeral, err = strconv.ParseBool(ephemeral); err != nil {
+			return o, fmt.Errorf("invalid ephemeral %q: must be true or false", ephemeral)
+		}
+	}
+
+	if ttl := opts["ttl"]; ttl != "" {
+		dur, err := time.ParseDuration(ttl)
+		if err != nil || dur <= 0 {
+			return o, fmt.Errorf("invalid ttl %q: must be a positive duration such as 4h", ttl)
+		}
+		o.TTL = dur
+	}
+
+	if secureErase := opts["secure_erase"]; secureErase != "" {
+		erase, err := strconv.ParseBool(secureErase)
+		if err != nil {
+			return o, fmt.Errorf("invalid secure_erase %q: must be true or false", secureErase)
+		}
+		o.SecureErase = &erase
+	}
+
+	if protected := opts["protected"]; protected != "" {
+		var err error
+		if o.Protected, err = strconv.ParseBool(protected); err != nil {
+			return o, fmt.Errorf("invalid protected %q: must be true or false", protected)
+		}
+	}
+
+	return o, nil
+}
+
+// optionsOf returns the options an existing filesystem has, for volumes
+// created before options were stored
+func optionsOf(fs *stratis.Filesystem) volumeOptions {
+	return volumeOptions{SizeLimit: fs.SizeLimit}
+}
+
+// canonical returns the options as strings by option name, leaving out those
+// that are not set. Equivalent options have equal canonical forms.
+func (o volumeOptions) canonical() map[string]string {
+	c := make(map[string]string)
+	if o.SizeLimit != nil {
+		c["size"] = strconv.FormatUint(*o.SizeLimit, 10)
+	}
+	if o.Ephemeral {
+		c["ephemeral"] = "true"
+	}
+	if o.TTL > 0 {
+		c["ttl"] = o.TTL.String()
+	}
+	if o.SecureErase != nil {
+		c["secure_erase"] = strconv.FormatBool(*o.SecureErase)
+	}
+	return c
+}
+
+// fingerprint returns a digest of canonical options, equal for equal options
+func fingerprint(canonical map[string]string) string {
+	h := sha256.New()
+	for _, key := range slices.Sorted(maps.Keys(canonical)) {
+		fmt.Fprintf(h, "%s=%s\n", key, canonical[key])
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// diffOptions describes how the requested canonical options differ from
+// those a volume has, one option at a time, e.g.
+// "size: existing 1073741824, requested 2147483648"
+func diffOptions(existing, requested map[string]string) string {
+	keys := slices.Collect(maps.Keys(existing))
+	for key := range requested {
+		if _, ok := existing[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	var diffs []string
+	for _, key := range keys {
+		have, want := existing[key], requested[key]
+		if have == want {
+			continue
+		}
+		diffs = append(diffs, fmt.Sprintf("%s: existing %s, requested %s", key, orNone(have), orNone(want)))
+	}
+	return strings.Join(diffs, "; ")
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
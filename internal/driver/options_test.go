@@ -0,0 +1,94 @@
+package driver
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+func TestDiffOptions(t *testing.T) {
+	tests := []struct {
+		name                string
+		existing, requested map[string]string
+		want                string
+	}{
+		{"same", map[string]string{"size": "1024"}, map[string]string{"size": "1024"}, ""},
+		{"changed", map[string]string{"size": "1024"}, map[string]string{"size": "2048"}, "size: existing 1024, requested 2048"},
+		{"added", map[string]string{}, map[string]string{"size": "2048"}, "size: existing none, requested 2048"},
+		{"removed", map[string]string{"size": "1024"}, nil, "size: existing 1024, requested none"},
+		{"several", map[string]string{"a": "1", "b": "2"}, map[string]string{"b": "3", "c": "4"}, "a: existing 1, requested none; b: existing 2, requested 3; c: existing none, requested 4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffOptions(tt.existing, tt.requested); got != tt.want {
+				t.Errorf("diffOptions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDriver_CreateIdempotent(t *testing.T) {
+	d := NewDriver(t.TempDir(), newFakeManager(t), newFakeMounter())
+	create := func(size string) error {
+		opts := map[string]string{}
+		if size != "" {
+			opts["size"] = size
+		}
+		return d.Create(&volume.CreateRequest{Name: "vol1", Options: opts})
+	}
+
+	if err := create("1GiB"); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		size    string
+		wantErr string
+	}{
+		{"same options", "1GiB", ""},
+		{"equivalent size", "1073741824", ""},
+		{"other size", "2GiB", "volume vol1 already exists with different options (size: existing 1073741824, requested 2147483648)"},
+		{"no size", "", "volume vol1 already exists with different options (size: existing 1073741824, requested none)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := create(tt.size)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Create() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Create() error = %v, want %q", err, tt.wantErr)
+			}
+			if !errors.Is(err, stratis.ErrAlreadyExists) {
+				t.Errorf("Create() error = %v, want it to wrap ErrAlreadyExists", err)
+			}
+		})
+	}
+}
+
+func TestDriver_CreateIdempotentWithoutStoredOptions(t *testing.T) {
+	d := NewDriver(t.TempDir(), newFakeManager(t), newFakeMounter())
+	if err := d.Create(&volume.CreateRequest{Name: "vol1", Options: map[string]string{"size": "1GiB"}}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	// A volume created before options were stored is compared with its filesystem
+	if err := d.state.Delete("vol1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "vol1", Options: map[string]string{"size": "1024MiB"}}); err != nil {
+		t.Errorf("Create() with the filesystem's size limit error = %v", err)
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); !errors.Is(err, stratis.ErrAlreadyExists) {
+		t.Errorf("Create() without the filesystem's size limit error = %v, want ErrAlreadyExists", err)
+	}
+}
@@ -0,0 +1,231 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/kriansa/podman-volume-stratis/internal/audit"
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+	"github.com/kriansa/podman-volume-stratis/internal/podman"
+	"github.com/kriansa/podman-volume-stratis/internal/state"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+// reasonOrphan is why CollectOrphans destroys a volume
+const reasonOrphan = "orphan"
+
+// errNoContainers is returned by CollectOrphans without a ContainerEngine
+var errNoContainers = errors.New("no container engine to ask for volume references; set podman_socket")
+
+// ContainerEngine is the local container engine, which lists its containers
+// and the volumes they reference
+type ContainerEngine interface {
+	Containers(ctx context.Context) ([]podman.Container, error)
+	// RemoveVolume removes a volume through the engine, so its record of the
+	// volume goes too; the engine calls Remove in turn. It fails with
+	// podman.ErrNoSuchVolume for a volume the engine has no record of, and
+	// podman.ErrVolumeInUse for one a container uses.
+	RemoveVolume(ctx context.Context, name string) error
+}
+
+// OrphanAction is what CollectOrphans does about orphaned volumes
+type OrphanAction string
+
+const (
+	// OrphanReport logs orphaned volumes and leaves them alone
+	OrphanReport OrphanAction = "report"
+	// OrphanRemove removes orphaned volumes
+	OrphanRemove OrphanAction = "remove"
+	// OrphanIgnore only keeps track of when volumes became unreferenced
+	OrphanIgnore OrphanAction = "ignore"
+)
+
+// OrphanPolicy says when a volume no container references is an orphan
+// and what to do about it
+type OrphanPolicy struct {
+	// Threshold is how long a volume must have been unreferenced
+	Threshold time.Duration
+	// Action is what to do about orphans; the zero value reports them
+	Action OrphanAction
+}
+
+// Orphan is a volume no container has referenced for longer than the
+// orphan threshold
+type Orphan struct {
+	Volume string `json:"volume"`
+	// Unreferenced is when the plugin first found no container referencing it
+	Unreferenced time.Time `json:"unreferenced"`
+	// Action is what the policy asked for
+	Action OrphanAction `json:"action"`
+	// Removed reports whether the volume was removed
+	Removed bool `json:"removed,omitempty"`
+	// Error is why the removal failed or was refused, if it was
+	Error string `json:"error,omitempty"`
+}
+
+// WithContainers asks the container engine which containers reference each
+// volume, to name them in Get and to find orphaned volumes, and removes the
+// volumes the plugin gets rid of through it. Without it, the first two are
+// not done, and such volumes are destroyed behind the engine's back.
+func WithContainers(engine ContainerEngine, policy OrphanPolicy) DriverOption {
+	return func(d *Driver) {
+		d.containers = engine
+		d.orphans = policy
+	}
+}
+
+// volumeReferences returns the names of the containers referencing each
+// volume, sorted
+func (d *Driver) volumeReferences(ctx context.Context) (map[string][]string, error) {
+	containers, err := d.containers.Containers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list containers: %w", err)
+	}
+
+	refs := make(map[string][]string)
+	for _, ctr := range containers {
+		for _, name := range ctr.Volumes {
+			if !slices.Contains(refs[name], ctr.Name) {
+				refs[name] = append(refs[name], ctr.Name)
+			}
+		}
+	}
+	for _, names := range refs {
+		slices.Sort(names)
+	}
+	return refs, nil
+}
+
+// CollectOrphans asks the container engine which volumes its containers
+// reference, keeps track of since when each volume has not been, and
+// reports or removes those unreferenced for longer than the orphan
+// threshold, according to the orphan policy. With dryRun, nothing is
+// removed. Protected and mounted volumes are never removed; failures to
+// remove one are reported in its Orphan.
+func (d *Driver) CollectOrphans(ctx context.Context, dryRun bool) ([]Orphan, error) {
+	if d.containers == nil {
+		return nil, errNoContainers
+	}
+
+	refsCtx, finish := d.startOperation(ctx, "list container references", d.timeouts.Query)
+	refs, err := d.volumeReferences(refsCtx)
+	if err = finish(err); err != nil {
+		return nil, err
+	}
+
+	d.pool.RLock()
+	listCtx, finish := d.startOperation(ctx, "list volumes", d.timeouts.Query)
+	filesystems, err := d.stratis.List(listCtx)
+	err = finish(err)
+	d.pool.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("list filesystems: %w", err)
+	}
+
+	action := d.orphans.Action
+	if action == "" || (dryRun && action == OrphanRemove) {
+		action = OrphanReport
+	}
+
+	now := time.Now()
+	orphans := []Orphan{}
+	for _, fs := range filesystems {
+		if isHidden(fs.Name) {
+			continue
+		}
+
+		since, err := d.trackReferences(ctx, fs.Name, len(refs[fs.Name]) > 0, now)
+		if errors.Is(err, stratis.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Warn("failed to track volume references", "name", fs.Name, "error", err)
+			continue
+		}
+		if since.IsZero() || now.Sub(since) < d.orphans.Threshold || action == OrphanIgnore {
+			continue
+		}
+
+		orphan := Orphan{Volume: fs.Name, Unreferenced: since, Action: action}
+		if action == OrphanRemove {
+			if err := d.removeOrphan(ctx, fs.Name, now); err != nil {
+				orphan.Error = err.Error()
+			} else {
+				orphan.Removed = true
+			}
+		}
+
+		log.Info("orphaned volume found",
+			"name", fs.Name, "unreferenced", since, "action", action, "removed", orphan.Removed, "error", orphan.Error)
+		orphans = append(orphans, orphan)
+	}
+
+	return orphans, nil
+}
+
+// trackReferences records whether a volume is referenced or mounted now,
+// and returns since when it has been neither; zero while it still is. It
+// fails with ErrNotFound once the volume is gone.
+func (d *Driver) trackReferences(ctx context.Context, name string, referenced bool, now time.Time) (_ time.Time, err error) {
+	defer d.lockVolume(name)()
+	ctx, finish := d.startOperation(ctx, "track references of "+name, d.timeouts.Query)
+	defer func() { err = finish(err) }()
+
+	// Removed since it was listed; its state must not come back
+	if _, err := d.getVolume(ctx, name); err != nil {
+		return time.Time{}, err
+	}
+
+	var since time.Time
+	err = d.state.Update(name, func(v *state.Volume) {
+		switch {
+		case referenced || len(v.MountIDs) > 0:
+			v.Unreferenced = time.Time{}
+		case v.Unreferenced.IsZero():
+			v.Unreferenced = now
+		}
+		since = v.Unreferenced
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("store references of %s: %w", name, err)
+	}
+	return since, nil
+}
+
+// removeOrphan removes an orphaned volume, unless it was mounted or
+// protected meanwhile
+func (d *Driver) removeOrphan(ctx context.Context, name string, now time.Time) error {
+	_, err := d.disposeVolume(ctx, name, func(vol state.Volume) (string, error) {
+		switch {
+		case vol.Protected:
+			return "", errVolumeProtected(name)
+		case len(vol.MountIDs) > 0 || vol.Unreferenced.IsZero() || now.Sub(vol.Unreferenced) < d.orphans.Threshold:
+			return "", fmt.Errorf("volume %s is in use again", name)
+		}
+		return reasonOrphan, nil
+	})
+	return err
+}
+
+// MonitorOrphans periodically runs CollectOrphans. Blocks until ctx is
+// cancelled.
+func (d *Driver) MonitorOrphans(ctx context.Context, interval time.Duration) {
+	ctx = audit.WithActor(ctx, audit.ActorReaper)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := d.CollectOrphans(ctx, false); err != nil {
+			log.Warn("orphan collection failed", "error", err)
+		}
+	}
+}
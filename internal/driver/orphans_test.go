@@ -0,0 +1,228 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+
+	"github.com/kriansa/podman-volume-stratis/internal/podman"
+	"github.com/kriansa/podman-volume-stratis/internal/state"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+// fakeContainers is a ContainerEngine with a fixed list of containers and
+// no record of any volume
+type fakeContainers []podman.Container
+
+func (f *fakeContainers) Containers(context.Context) ([]podman.Container, error) {
+	return *f, nil
+}
+
+func (f *fakeContainers) RemoveVolume(_ context.Context, name string) error {
+	return fmt.Errorf("volume %s: %w", name, podman.ErrNoSuchVolume)
+}
+
+// fakeEngine is a ContainerEngine that knows every volume and removes them
+// through the driver, as Podman does through the plugin
+type fakeEngine struct {
+	fakeContainers
+	d       *Driver
+	inUse   []string
+	removed []string
+}
+
+func (f *fakeEngine) RemoveVolume(_ context.Context, name string) error {
+	if slices.Contains(f.inUse, name) {
+		return fmt.Errorf("volume %s: %w", name, podman.ErrVolumeInUse)
+	}
+	if err := f.d.Remove(&volume.RemoveRequest{Name: name}); err != nil {
+		return err
+	}
+	f.removed = append(f.removed, name)
+	return nil
+}
+
+func TestDriver_GetNamesContainers(t *testing.T) {
+	containers := &fakeContainers{
+		{ID: "1", Name: "web", Volumes: []string{"vol1"}},
+		{ID: "2", Name: "backup", Volumes: []string{"vol1", "vol2"}},
+	}
+	d := NewDriver(t.TempDir(), newFakeManager(t), newFakeMounter(), WithContainers(containers, OrphanPolicy{}))
+
+	for _, name := range []string{"vol1", "vol3"} {
+		if err := d.Create(&volume.CreateRequest{Name: name}); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"vol1", []string{"backup", "web"}},
+		{"vol3", []string{}},
+	}
+	for _, tt := range tests {
+		resp, err := d.Get(&volume.GetRequest{Name: tt.name})
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if got := resp.Volume.Status["containers"]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Get(%s) status containers = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDriver_CollectOrphans(t *testing.T) {
+	ctx := context.Background()
+	mgr := newFakeManager(t)
+	containers := &fakeContainers{{ID: "1", Name: "web", Volumes: []string{"used"}}}
+	d := NewDriver(t.TempDir(), mgr, newFakeMounter(),
+		WithContainers(containers, OrphanPolicy{Threshold: time.Hour, Action: OrphanRemove}))
+
+	for name, opts := range map[string]map[string]string{
+		"used":   nil,
+		"idle":   nil,
+		"locked": {"protected": "true"},
+	} {
+		if err := d.Create(&volume.CreateRequest{Name: name, Options: opts}); err != nil {
+			t.Fatalf("Create(%s) error = %v", name, err)
+		}
+	}
+
+	// The first pass only notes which volumes are unreferenced
+	orphans, err := d.CollectOrphans(ctx, false)
+	if err != nil || len(orphans) != 0 {
+		t.Fatalf("CollectOrphans() = %+v, %v, want no orphans yet", orphans, err)
+	}
+	for _, name := range []string{"idle", "locked"} {
+		d.state.Update(name, func(v *state.Volume) { v.Unreferenced = time.Now().Add(-2 * time.Hour) })
+	}
+
+	orphans, err = d.CollectOrphans(ctx, true)
+	if err != nil {
+		t.Fatalf("CollectOrphans(dry run) error = %v", err)
+	}
+	if len(orphans) != 2 || orphans[0].Action != OrphanReport || orphans[1].Action != OrphanReport {
+		t.Fatalf("CollectOrphans(dry run) = %+v, want idle and locked reported", orphans)
+	}
+
+	orphans, err = d.CollectOrphans(ctx, false)
+	if err != nil {
+		t.Fatalf("CollectOrphans() error = %v", err)
+	}
+	got := make(map[string]Orphan)
+	for _, o := range orphans {
+		got[o.Volume] = o
+	}
+	if !got["idle"].Removed {
+		t.Errorf("idle orphan = %+v, want it removed", got["idle"])
+	}
+	if got["locked"].Removed || !strings.Contains(got["locked"].Error, "protected") {
+		t.Errorf("locked orphan = %+v, want it kept as protected", got["locked"])
+	}
+	if _, err := mgr.GetByName(ctx, "idle"); err == nil {
+		t.Error("idle volume still exists")
+	}
+
+	// A container referencing a volume again resets it
+	*containers = append(*containers, podman.Container{ID: "2", Name: "db", Volumes: []string{"locked"}})
+	if orphans, _ := d.CollectOrphans(ctx, false); len(orphans) != 0 {
+		t.Errorf("CollectOrphans() after referencing = %+v, want no orphans", orphans)
+	}
+	if vol, _ := d.state.Get("locked"); !vol.Unreferenced.IsZero() {
+		t.Errorf("locked unreferenced since %v, want it cleared", vol.Unreferenced)
+	}
+}
+
+func TestDriver_CollectOrphansWithoutPodman(t *testing.T) {
+	d := NewDriver(t.TempDir(), newFakeManager(t), newFakeMounter())
+	if _, err := d.CollectOrphans(context.Background(), false); err == nil {
+		t.Error("CollectOrphans() without a container engine succeeded, want an error")
+	}
+}
+
+func TestDriver_TrackReferencesKeepsRemovedVolumesGone(t *testing.T) {
+	ctx := context.Background()
+	d := NewDriver(t.TempDir(), newFakeManager(t), newFakeMounter(), WithContainers(&fakeContainers{}, OrphanPolicy{}))
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	// A collection that listed vol1 before it was removed
+	if _, err := d.trackReferences(ctx, "vol1", false, time.Now()); !errors.Is(err, stratis.ErrNotFound) {
+		t.Errorf("trackReferences() error = %v, want ErrNotFound", err)
+	}
+	if vol, ok := d.state.Get("vol1"); ok {
+		t.Errorf("state of removed vol1 came back: %+v", vol)
+	}
+}
+
+func TestDriver_RecreatedVolumeStartsAfresh(t *testing.T) {
+	ctx := context.Background()
+	d := NewDriver(t.TempDir(), newFakeManager(t), newFakeMounter(),
+		WithContainers(&fakeContainers{}, OrphanPolicy{Threshold: time.Hour, Action: OrphanRemove}))
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	d.state.Update("vol1", func(v *state.Volume) {
+		v.Unreferenced = time.Now().Add(-2 * time.Hour)
+		v.LastUsed = time.Now().Add(-2 * time.Hour)
+	})
+
+	// Destroyed behind the plugin's back, leaving its state, then created again
+	if err := d.stratis.Delete(ctx, "vol1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Create() again error = %v", err)
+	}
+	if vol, _ := d.state.Get("vol1"); !vol.Unreferenced.IsZero() || !vol.LastUsed.IsZero() {
+		t.Errorf("re-created volume state = %+v, want it to start afresh", vol)
+	}
+
+	orphans, err := d.CollectOrphans(ctx, false)
+	if err != nil || len(orphans) != 0 {
+		t.Errorf("CollectOrphans() = %+v, %v, want the new volume kept", orphans, err)
+	}
+}
+
+func TestDriver_CollectOrphansRemovesThroughEngine(t *testing.T) {
+	ctx := context.Background()
+	mgr := newFakeManager(t)
+	engine := &fakeEngine{}
+	d := NewDriver(t.TempDir(), mgr, newFakeMounter(),
+		WithContainers(engine, OrphanPolicy{Threshold: time.Hour, Action: OrphanRemove}))
+	engine.d = d
+
+	if err := d.Create(&volume.CreateRequest{Name: "idle"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := d.CollectOrphans(ctx, false); err != nil {
+		t.Fatalf("CollectOrphans() error = %v", err)
+	}
+	d.state.Update("idle", func(v *state.Volume) { v.Unreferenced = time.Now().Add(-2 * time.Hour) })
+
+	orphans, err := d.CollectOrphans(ctx, false)
+	if err != nil || len(orphans) != 1 || !orphans[0].Removed {
+		t.Fatalf("CollectOrphans() = %+v, %v, want idle removed", orphans, err)
+	}
+	if !slices.Equal(engine.removed, []string{"idle"}) {
+		t.Errorf("engine removed %v, want [idle]", engine.removed)
+	}
+	if _, err := mgr.GetByName(ctx, "idle"); !errors.Is(err, stratis.ErrNotFound) {
+		t.Errorf("GetByName(idle) error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,36 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+	"github.com/kriansa/podman-volume-stratis/internal/state"
+)
+
+// SetProtected protects a volume against removal, or lifts its protection
+func (d *Driver) SetProtected(ctx context.Context, name string, protected bool) (err error) {
+	defer d.lockVolume(name)()
+	op := "unprotect"
+	if protected {
+		op = "protect"
+	}
+	defer func(start time.Time) { d.auditOperation(ctx, op, name, nil, start, err) }(time.Now())
+	ctx, finish := d.startOperation(ctx, "protect volume "+name, d.timeouts.Query)
+	defer func() { err = finish(err) }()
+
+	if _, err := d.getVolume(ctx, name); err != nil {
+		return err
+	}
+
+	err = d.state.Update(name, func(v *state.Volume) {
+		v.Protected = protected
+	})
+	if err != nil {
+		return fmt.Errorf("store volume protection: %w", err)
+	}
+
+	log.Info("volume protection changed", "name", name, "protected", protected)
+	return nil
+}
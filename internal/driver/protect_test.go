@@ -0,0 +1,86 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/docker/go-plugins-helpers/volume"
+
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+func TestDriver_ProtectedVolume(t *testing.T) {
+	ctx := context.Background()
+	d := NewDriver(t.TempDir(), newFakeManager(t), newFakeMounter())
+
+	err := d.Create(&volume.CreateRequest{Name: "vol1", Options: map[string]string{"protected": "maybe"}})
+	if err == nil {
+		t.Fatal("Create() with protected=maybe succeeded, want an error")
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol1", Options: map[string]string{"protected": "true"}}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	// Protection is not part of what makes a create the same
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err != nil {
+		t.Errorf("Create() again without protected error = %v", err)
+	}
+
+	resp, err := d.Get(&volume.GetRequest{Name: "vol1"})
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if resp.Volume.Status["protected"] != true {
+		t.Errorf("Get() status protected = %v, want true", resp.Volume.Status["protected"])
+	}
+
+	err = d.Remove(&volume.RemoveRequest{Name: "vol1"})
+	if !errors.Is(err, ErrProtected) {
+		t.Fatalf("Remove() error = %v, want ErrProtected", err)
+	}
+	if !strings.Contains(err.Error(), "podman-volume-stratis unprotect vol1") {
+		t.Errorf("Remove() error = %q, want it to say how to lift the protection", err)
+	}
+
+	if err := d.SetProtected(ctx, "vol1", false); err != nil {
+		t.Fatalf("SetProtected(false) error = %v", err)
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol1"}); err != nil {
+		t.Errorf("Remove() after unprotecting error = %v", err)
+	}
+}
+
+func TestDriver_CreateExistingProtects(t *testing.T) {
+	d := NewDriver(t.TempDir(), newFakeManager(t), newFakeMounter())
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol1", Options: map[string]string{"protected": "true"}}); err != nil {
+		t.Fatalf("Create() again with protected=true error = %v", err)
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol1"}); !errors.Is(err, ErrProtected) {
+		t.Errorf("Remove() after creating again with protected=true error = %v, want ErrProtected", err)
+	}
+}
+
+func TestDriver_SetProtected(t *testing.T) {
+	ctx := context.Background()
+	d := NewDriver(t.TempDir(), newFakeManager(t), newFakeMounter())
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if err := d.SetProtected(ctx, "vol1", true); err != nil {
+		t.Fatalf("SetProtected(true) error = %v", err)
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol1"}); !errors.Is(err, ErrProtected) {
+		t.Errorf("Remove() of a volume protected later error = %v, want ErrProtected", err)
+	}
+
+	if err := d.SetProtected(ctx, "missing", true); !errors.Is(err, stratis.ErrNotFound) {
+		t.Errorf("SetProtected() of a missing volume error = %v, want ErrNotFound", err)
+	}
+}
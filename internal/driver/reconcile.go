@@ -0,0 +1,333 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+	"github.com/kriansa/podman-volume-stratis/internal/procmounts"
+	"github.com/kriansa/podman-volume-stratis/internal/state"
+)
+
+// FindingKind identifies a kind of inconsistency found by Reconcile
+type FindingKind string
+
+const (
+	// StrayMount is a Stratis device mounted under the mount path that is not
+	// a filesystem of the pool
+	StrayMount FindingKind = "stray_mount"
+	// MisplacedMount is a filesystem of the pool mounted somewhere other than
+	// its mount point
+	MisplacedMount FindingKind = "misplaced_mount"
+	// OrphanDir is an empty directory under the mount path with no matching
+	// filesystem
+	OrphanDir FindingKind = "orphan_dir"
+	// StaleState is persisted state that no longer matches reality, such as
+	// mount references of a volume that is not mounted
+	StaleState FindingKind = "stale_state"
+)
+
+// ReconcileAction is what Reconcile does about a kind of finding
+type ReconcileAction string
+
+const (
+	// ReconcileReport logs the finding and leaves it alone
+	ReconcileReport ReconcileAction = "report"
+	// ReconcileFix repairs the finding
+	ReconcileFix ReconcileAction = "fix"
+	// ReconcileIgnore skips the finding silently
+	ReconcileIgnore ReconcileAction = "ignore"
+)
+
+// ReconcilePolicy maps each kind of finding to an action. Kinds missing from
+// the policy are reported.
+type ReconcilePolicy map[FindingKind]ReconcileAction
+
+// action returns the action for a kind of finding
+func (p ReconcilePolicy) action(kind FindingKind) ReconcileAction {
+	if a, ok := p[kind]; ok && a != "" {
+		return a
+	}
+	return ReconcileReport
+}
+
+// dryRun returns a copy of the policy that reports whatever it would fix
+func (p ReconcilePolicy) dryRun() ReconcilePolicy {
+	dry := make(ReconcilePolicy, len(p))
+	for kind, action := range p {
+		if action == ReconcileFix {
+			action = ReconcileReport
+		}
+		dry[kind] = action
+	}
+	return dry
+}
+
+// Finding is an inconsistency between stratisd, the mount table, the mount
+// path and the persisted state
+type Finding struct {
+	Kind FindingKind `json:"kind"`
+	// Volume is the affected volume, if any
+	Volume string `json:"volume,omitempty"`
+	// Path is the affected mount point or directory
+	Path string `json:"path,omitempty"`
+	// Device is the mounted device, for mount findings
+	Device string `json:"device,omitempty"`
+	// Detail describes the inconsistency
+	Detail string `json:"detail"`
+	// Action is what the policy asked for
+	Action ReconcileAction `json:"action"`
+	// Fixed reports whether the finding was repaired
+	Fixed bool `json:"fixed,omitempty"`
+	// Error is why the repair failed, if it did
+	Error string `json:"error,omitempty"`
+}
+
+// Reconcile compares the filesystems reported by stratisd with the mount
+// table, the directories under the mount path and the persisted state, and
+// reports or fixes each inconsistency according to the reconcile policy. With
+// dryRun, nothing is fixed. Mount findings are handled first, so directories
+// left behind by a fixed mount are found in the same pass. It gives up when
+// ctx is done.
+func (d *Driver) Reconcile(ctx context.Context, dryRun bool) ([]Finding, error) {
+	defer d.lockPool()()
+
+	policy := d.reconcile
+	if dryRun {
+		policy = policy.dryRun()
+	}
+
+	listCtx, finish := d.startOperation(ctx, "list volumes", d.timeouts.Query)
+	filesystems, err := d.stratis.List(listCtx)
+	if err = finish(err); err != nil {
+		return nil, fmt.Errorf("list filesystems: %w", err)
+	}
+
+	names := make(map[string]bool, len(filesystems))
+	devices := make(map[string]string, len(filesystems))
+	for _, fs := range filesystems {
+		names[fs.Name] = true
+		devices[fs.DevicePath] = fs.Name
+		if resolved, err := filepath.EvalSymlinks(fs.DevicePath); err == nil {
+			devices[resolved] = fs.Name
+		}
+	}
+
+	mounts, err := procmounts.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("read mount table: %w", err)
+	}
+	addMountDevices(devices, mounts)
+
+	findings := d.applyPolicy(ctx, policy, checkMounts(mounts, devices, d.mountPath))
+
+	// Fixes may have unmounted things; look at the mount table again
+	if slices.ContainsFunc(findings, func(f Finding) bool { return f.Fixed }) {
+		if mounts, err = procmounts.Parse(); err != nil {
+			return findings, fmt.Errorf("read mount table: %w", err)
+		}
+		addMountDevices(devices, mounts)
+	}
+
+	findings = append(findings, d.applyPolicy(ctx, policy, checkState(d.state.All(), names, mounts, devices, d.mountPath))...)
+
+	dirs, err := checkDirs(d.mountPath, names, mounts)
+	if err != nil {
+		return findings, err
+	}
+	findings = append(findings, d.applyPolicy(ctx, policy, dirs)...)
+
+	return findings, nil
+}
+
+// applyPolicy logs and fixes findings as the policy asks, dropping ignored ones
+func (d *Driver) applyPolicy(ctx context.Context, policy ReconcilePolicy, findings []Finding) []Finding {
+	var kept []Finding
+	for _, f := range findings {
+		f.Action = policy.action(f.Kind)
+		if f.Action == ReconcileIgnore {
+			continue
+		}
+
+		if f.Action == ReconcileFix {
+			start := time.Now()
+			err := d.fix(ctx, f)
+			d.auditOperation(ctx, "reconcile", f.Volume, map[string]string{"kind": string(f.Kind), "path": f.Path}, start, err)
+			if err != nil {
+				f.Error = err.Error()
+				log.Error("failed to fix reconciliation finding", "kind", f.Kind, "volume", f.Volume, "path", f.Path, "error", err)
+			} else {
+				f.Fixed = true
+				log.Info("fixed reconciliation finding", "kind", f.Kind, "volume", f.Volume, "path", f.Path, "detail", f.Detail)
+			}
+		} else {
+			log.Warn("reconciliation finding", "kind", f.Kind, "volume", f.Volume, "path", f.Path, "detail", f.Detail)
+		}
+
+		kept = append(kept, f)
+	}
+	return kept
+}
+
+// fix repairs a single finding. Must be called with the pool locked.
+func (d *Driver) fix(ctx context.Context, f Finding) error {
+	switch f.Kind {
+	case StrayMount, MisplacedMount:
+		ctx, finish := d.startOperation(ctx, "unmount "+f.Path, d.timeouts.Unmount)
+		return finish(d.mounter.Unmount(ctx, f.Path))
+	case OrphanDir:
+		// Remove only removes empty directories, so anything written since
+		// the check is kept
+		return os.Remove(f.Path)
+	case StaleState:
+		// Only findings about an existing volume have a mount point
+		if f.Path == "" {
+			return d.state.Delete(f.Volume)
+		}
+		return d.state.Update(f.Volume, func(v *state.Volume) { v.MountIDs = nil })
+	default:
+		return fmt.Errorf("unknown finding kind %q", f.Kind)
+	}
+}
+
+// addMountDevices adds to devices the device paths in the mount table that
+// resolve to a filesystem of the pool. The mount table may name a device by
+// another of its links than stratisd does, e.g. /dev/mapper/stratis-1-…
+// rather than /dev/stratis/<pool>/<name>.
+func addMountDevices(devices map[string]string, mounts []procmounts.Entry) {
+	for _, m := range mounts {
+		if _, ok := devices[m.Device]; ok || !filepath.IsAbs(m.Device) {
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(m.Device)
+		if err != nil {
+			continue
+		}
+		if name, ok := devices[resolved]; ok {
+			devices[m.Device] = name
+		}
+	}
+}
+
+// checkMounts finds Stratis devices mounted under mountPath that are not in
+// the pool, and pool filesystems mounted anywhere but their mount point.
+// devices maps device paths, raw and resolved, and the links to them in the
+// mount table (see addMountDevices) to filesystem names.
+func checkMounts(mounts []procmounts.Entry, devices map[string]string, mountPath string) []Finding {
+	var findings []Finding
+	for _, m := range mounts {
+		name, inPool := devices[m.Device]
+		switch {
+		case inPool && m.MountPoint != filepath.Join(mountPath, name):
+			findings = append(findings, Finding{
+				Kind:   MisplacedMount,
+				Volume: name,
+				Path:   m.MountPoint,
+				Device: m.Device,
+				Detail: fmt.Sprintf("mounted at %s instead of %s", m.MountPoint, filepath.Join(mountPath, name)),
+			})
+		case !inPool && isStratisDevice(m.Device) && isUnder(m.MountPoint, mountPath):
+			findings = append(findings, Finding{
+				Kind:   StrayMount,
+				Path:   m.MountPoint,
+				Device: m.Device,
+				Detail: fmt.Sprintf("%s is not a filesystem of the pool", m.Device),
+			})
+		}
+	}
+	return findings
+}
+
+// checkState finds state of volumes that no longer exist, and mount
+// references of volumes that are not mounted at their mount point
+func checkState(volumes map[string]state.Volume, names map[string]bool, mounts []procmounts.Entry, devices map[string]string, mountPath string) []Finding {
+	mounted := make(map[string]bool)
+	for _, m := range mounts {
+		if name, ok := devices[m.Device]; ok && m.MountPoint == filepath.Join(mountPath, name) {
+			mounted[name] = true
+		}
+	}
+
+	var findings []Finding
+	for name, v := range volumes {
+		switch {
+		case !names[name]:
+			findings = append(findings, Finding{
+				Kind:   StaleState,
+				Volume: name,
+				Detail: "state kept for a filesystem that no longer exists",
+			})
+		case len(v.MountIDs) > 0 && !mounted[name]:
+			findings = append(findings, Finding{
+				Kind:   StaleState,
+				Volume: name,
+				Path:   filepath.Join(mountPath, name),
+				Detail: fmt.Sprintf("%d mount references but the volume is not mounted", len(v.MountIDs)),
+			})
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool { return findings[i].Volume < findings[j].Volume })
+	return findings
+}
+
+// checkDirs finds empty directories directly under mountPath that are not
+// mount points and have no matching filesystem
+func checkDirs(mountPath string, names map[string]bool, mounts []procmounts.Entry) ([]Finding, error) {
+	entries, err := os.ReadDir(mountPath)
+	if err != nil {
+		return nil, fmt.Errorf("read mount path: %w", err)
+	}
+
+	mountPoints := make(map[string]bool, len(mounts))
+	for _, m := range mounts {
+		mountPoints[m.MountPoint] = true
+	}
+
+	var findings []Finding
+	for _, entry := range entries {
+		if !entry.IsDir() || names[entry.Name()] {
+			continue
+		}
+
+		path := filepath.Join(mountPath, entry.Name())
+		if mountPoints[path] {
+			continue
+		}
+
+		children, err := os.ReadDir(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return findings, fmt.Errorf("read directory %s: %w", path, err)
+		}
+		if len(children) > 0 {
+			continue
+		}
+
+		findings = append(findings, Finding{
+			Kind:   OrphanDir,
+			Path:   path,
+			Detail: "empty directory with no matching filesystem",
+		})
+	}
+	return findings, nil
+}
+
+// isStratisDevice reports whether a mounted device is a Stratis filesystem
+func isStratisDevice(device string) bool {
+	return strings.HasPrefix(device, "/dev/stratis/") || strings.HasPrefix(device, "/dev/mapper/stratis-")
+}
+
+// isUnder reports whether path is strictly inside dir
+func isUnder(path, dir string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
@@ -0,0 +1,178 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kriansa/podman-volume-stratis/internal/procmounts"
+	"github.com/kriansa/podman-volume-stratis/internal/state"
+)
+
+func TestCheckMounts(t *testing.T) {
+	devices := map[string]string{
+		"/dev/stratis/pool/vol1":         "vol1",
+		"/dev/mapper/stratis-1-abc-fs-1": "vol1",
+		"/dev/stratis/pool/vol2":         "vol2",
+	}
+
+	tests := []struct {
+		name  string
+		mount procmounts.Entry
+		want  FindingKind
+	}{
+		{
+			name:  "correctly mounted",
+			mount: procmounts.Entry{Device: "/dev/mapper/stratis-1-abc-fs-1", MountPoint: "/mnt/vol1"},
+		},
+		{
+			name:  "mounted under another volume's name",
+			mount: procmounts.Entry{Device: "/dev/stratis/pool/vol2", MountPoint: "/mnt/vol1"},
+			want:  MisplacedMount,
+		},
+		{
+			name:  "mounted outside the mount path",
+			mount: procmounts.Entry{Device: "/dev/mapper/stratis-1-abc-fs-1", MountPoint: "/srv/data"},
+			want:  MisplacedMount,
+		},
+		{
+			name:  "unknown stratis device under the mount path",
+			mount: procmounts.Entry{Device: "/dev/mapper/stratis-1-def-fs-9", MountPoint: "/mnt/old"},
+			want:  StrayMount,
+		},
+		{
+			name:  "unknown stratis device elsewhere",
+			mount: procmounts.Entry{Device: "/dev/mapper/stratis-1-def-fs-9", MountPoint: "/srv/other"},
+		},
+		{
+			name:  "non-stratis device under the mount path",
+			mount: procmounts.Entry{Device: "/dev/sdb1", MountPoint: "/mnt/usb"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings := checkMounts([]procmounts.Entry{tt.mount}, devices, "/mnt")
+
+			if tt.want == "" {
+				if len(findings) != 0 {
+					t.Fatalf("got findings %+v, want none", findings)
+				}
+				return
+			}
+			if len(findings) != 1 || findings[0].Kind != tt.want {
+				t.Fatalf("got findings %+v, want one %s", findings, tt.want)
+			}
+			if findings[0].Path != tt.mount.MountPoint {
+				t.Errorf("Path = %q, want %q", findings[0].Path, tt.mount.MountPoint)
+			}
+		})
+	}
+}
+
+func TestAddMountDevices(t *testing.T) {
+	dir := t.TempDir()
+	device := filepath.Join(dir, "dm-3")
+	if err := os.WriteFile(device, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	stratisLink := filepath.Join(dir, "vol1")
+	mapperLink := filepath.Join(dir, "stratis-1-abc-thin-fs-1")
+	for _, link := range []string{stratisLink, mapperLink} {
+		if err := os.Symlink(device, link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// As Reconcile builds it from stratisd's device path
+	devices := map[string]string{stratisLink: "vol1", device: "vol1"}
+	mounts := []procmounts.Entry{
+		{Device: mapperLink, MountPoint: "/mnt/vol1"},
+		{Device: filepath.Join(dir, "missing"), MountPoint: "/mnt/other"},
+		{Device: "tmpfs", MountPoint: "/tmp"},
+	}
+	addMountDevices(devices, mounts)
+
+	if devices[mapperLink] != "vol1" {
+		t.Errorf("devices[%s] = %q, want vol1", mapperLink, devices[mapperLink])
+	}
+	if len(devices) != 3 {
+		t.Errorf("devices = %v, want only the mapper link added", devices)
+	}
+	if findings := checkMounts(mounts[:1], devices, "/mnt"); len(findings) != 0 {
+		t.Errorf("checkMounts() = %+v, want the mapper mount taken as vol1's", findings)
+	}
+}
+
+func TestCheckState(t *testing.T) {
+	volumes := map[string]state.Volume{
+		"gone":    {},
+		"mounted": {MountIDs: []string{"c1"}},
+		"stale":   {MountIDs: []string{"c2"}},
+		"idle":    {},
+	}
+	names := map[string]bool{"mounted": true, "stale": true, "idle": true}
+	devices := map[string]string{"/dev/stratis/pool/mounted": "mounted"}
+	mounts := []procmounts.Entry{{Device: "/dev/stratis/pool/mounted", MountPoint: "/mnt/mounted"}}
+
+	findings := checkState(volumes, names, mounts, devices, "/mnt")
+
+	if len(findings) != 2 {
+		t.Fatalf("got %d findings, want 2: %+v", len(findings), findings)
+	}
+	if f := findings[0]; f.Volume != "gone" || f.Path != "" {
+		t.Errorf("first finding = %+v, want state of missing volume gone", f)
+	}
+	if f := findings[1]; f.Volume != "stale" || f.Path != "/mnt/stale" {
+		t.Errorf("second finding = %+v, want mount references of stale", f)
+	}
+}
+
+func TestCheckDirs(t *testing.T) {
+	base := t.TempDir()
+	for _, dir := range []string{"vol1", "orphan", "nonempty", "mounted"} {
+		if err := os.Mkdir(filepath.Join(base, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(base, "nonempty", "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "plainfile"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[string]bool{"vol1": true}
+	mounts := []procmounts.Entry{{Device: "/dev/sdb1", MountPoint: filepath.Join(base, "mounted")}}
+
+	findings, err := checkDirs(base, names, mounts)
+	if err != nil {
+		t.Fatalf("checkDirs() error = %v", err)
+	}
+
+	if len(findings) != 1 || findings[0].Kind != OrphanDir || findings[0].Path != filepath.Join(base, "orphan") {
+		t.Errorf("got findings %+v, want only the orphan directory", findings)
+	}
+}
+
+func TestReconcilePolicy(t *testing.T) {
+	policy := ReconcilePolicy{
+		StrayMount: ReconcileFix,
+		OrphanDir:  ReconcileIgnore,
+	}
+
+	if got := policy.action(MisplacedMount); got != ReconcileReport {
+		t.Errorf("unset kind action = %q, want %q", got, ReconcileReport)
+	}
+
+	dry := policy.dryRun()
+	if got := dry.action(StrayMount); got != ReconcileReport {
+		t.Errorf("dry run fix action = %q, want %q", got, ReconcileReport)
+	}
+	if got := dry.action(OrphanDir); got != ReconcileIgnore {
+		t.Errorf("dry run ignore action = %q, want %q", got, ReconcileIgnore)
+	}
+	if policy[StrayMount] != ReconcileFix {
+		t.Error("dryRun modified the original policy")
+	}
+}
@@ -0,0 +1,319 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kriansa/podman-volume-stratis/internal/audit"
+	"github.com/kriansa/podman-volume-stratis/internal/events"
+	"github.com/kriansa/podman-volume-stratis/internal/journal"
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+	"github.com/kriansa/podman-volume-stratis/internal/state"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+	"github.com/kriansa/podman-volume-stratis/internal/validation"
+)
+
+// trashPrefix starts the filesystem names of removed volumes in the trash.
+// Volume names cannot start with a dot, so they never clash with a volume.
+const trashPrefix = ".trash-"
+
+// TrashEntry is a removed volume kept in the trash
+type TrashEntry struct {
+	// Name is the name of its filesystem in the trash
+	Name string `json:"name"`
+	// Volume is the name the volume had
+	Volume string `json:"volume"`
+	// Removed is when the volume was removed
+	Removed time.Time `json:"removed"`
+	// Expires is when the reaper destroys it; zero while the trash is
+	// kept until emptied
+	Expires time.Time `json:"expires,omitzero"`
+	// Used is the space it takes in bytes
+	Used uint64 `json:"used"`
+}
+
+// WithTrash keeps removed volumes in the trash for retention before they
+// are destroyed, so they can be restored. Without it, or with a retention of
+// zero, Remove destroys volumes right away.
+func WithTrash(retention time.Duration) DriverOption {
+	return func(d *Driver) {
+		d.trashRetention = retention
+	}
+}
+
+// trashName returns the name of the filesystem of a volume removed at the
+// given time
+func trashName(name string, removed time.Time) string {
+	return trashPrefix + strconv.FormatInt(removed.UnixMilli(), 10) + "-" + name
+}
+
+// isTrash reports whether a filesystem is in the trash
+func isTrash(fsName string) bool {
+	return strings.HasPrefix(fsName, trashPrefix)
+}
+
+// parseTrashName returns the volume name and removal time of a filesystem
+// in the trash
+func parseTrashName(fsName string) (name string, removed time.Time, ok bool) {
+	rest, ok := strings.CutPrefix(fsName, trashPrefix)
+	if !ok {
+		return "", time.Time{}, false
+	}
+	stamp, name, ok := strings.Cut(rest, "-")
+	if !ok || name == "" {
+		return "", time.Time{}, false
+	}
+	millis, err := strconv.ParseInt(stamp, 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return name, time.UnixMilli(millis), true
+}
+
+// moveToTrash moves a removed volume into the trash and returns its name
+// there. The name is journaled first, so Recover can finish the move.
+func (d *Driver) moveToTrash(ctx context.Context, tx *journal.Tx, name string) (string, error) {
+	entry := trashName(name, time.Now())
+	if err := record(tx, stepRename, entry); err != nil {
+		return "", err
+	}
+	if err := d.renameVolume(ctx, name, entry); err != nil {
+		return "", fmt.Errorf("move volume to trash: %w", err)
+	}
+	return entry, nil
+}
+
+// renameVolume renames the state and then the filesystem of a volume, so
+// its settings, such as secure_erase, are never lost in between. On failure
+// the state is moved back.
+func (d *Driver) renameVolume(ctx context.Context, name, newName string) error {
+	err := d.state.Rename(name, newName)
+	if err == nil {
+		err = d.stratis.Rename(ctx, name, newName)
+	}
+	if err != nil {
+		if err := d.state.Rename(newName, name); err != nil {
+			log.Warn("failed to move volume state back", "name", name, "error", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// Trash returns the removed volumes in the trash, oldest first
+func (d *Driver) Trash(ctx context.Context) (_ []TrashEntry, err error) {
+	d.pool.RLock()
+	defer d.pool.RUnlock()
+	ctx, finish := d.startOperation(ctx, "list trash", d.timeouts.Query)
+	defer func() { err = finish(err) }()
+
+	return d.trashEntries(ctx)
+}
+
+// trashEntries lists the trash, oldest first
+func (d *Driver) trashEntries(ctx context.Context) ([]TrashEntry, error) {
+	filesystems, err := d.stratis.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list filesystems: %w", err)
+	}
+
+	entries := []TrashEntry{}
+	for _, fs := range filesystems {
+		name, removed, ok := parseTrashName(fs.Name)
+		if !ok {
+			continue
+		}
+		entry := TrashEntry{Name: fs.Name, Volume: name, Removed: removed, Used: fs.Used}
+		if d.trashRetention > 0 {
+			entry.Expires = removed.Add(d.trashRetention)
+		}
+		entries = append(entries, entry)
+	}
+
+	slices.SortFunc(entries, func(a, b TrashEntry) int {
+		return a.Removed.Compare(b.Removed)
+	})
+	return entries, nil
+}
+
+// Restore brings a removed volume back from the trash. entry is either the
+// name of its filesystem in the trash or the name of the volume, for the
+// one removed last. The volume is restored under its own name, or under as
+// if that is set, and the name it got is returned.
+func (d *Driver) Restore(ctx context.Context, entry, as string) (_ string, err error) {
+	defer d.lockPool()()
+	var options map[string]string
+	if as != "" {
+		options = map[string]string{"as": as}
+	}
+	defer func(start time.Time) { d.auditOperation(ctx, "restore", entry, options, start, err) }(time.Now())
+	ctx, finish := d.startOperation(ctx, "restore "+entry, d.timeouts.Remove)
+	defer func() { err = finish(err) }()
+
+	entries, err := d.trashEntries(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var found *TrashEntry
+	for i := range entries {
+		if entries[i].Name == entry || entries[i].Volume == entry {
+			// The last one removed is the last in the list
+			found = &entries[i]
+		}
+	}
+	if found == nil {
+		return "", errTrashEntryNotFound(entry)
+	}
+
+	name := as
+	if name == "" {
+		name = found.Volume
+	}
+	if err := validation.ValidateVolumeName(name); err != nil {
+		return "", err
+	}
+
+	if err := d.renameVolume(ctx, found.Name, name); err != nil {
+		if errors.Is(err, stratis.ErrAlreadyExists) {
+			return "", &Error{
+				msg: fmt.Sprintf("volume %s already exists; restore %s under another name", name, found.Name),
+				err: err,
+			}
+		}
+		return "", fmt.Errorf("restore volume: %w", err)
+	}
+	if vol, ok := d.state.Get(name); ok && (vol.Ephemeral || vol.TTL > 0) {
+		// Start afresh, or the reaper would destroy it again right away
+		err := d.state.Update(name, func(v *state.Volume) {
+			v.MountIDs = nil
+			v.LastUsed = time.Time{}
+			v.Created = time.Now()
+		})
+		if err != nil {
+			log.Warn("failed to reset restored volume expiry", "name", name, "error", err)
+		}
+	}
+
+	log.Info("volume restored from trash", "name", name, "entry", found.Name)
+	d.events.Publish(events.Restored, name, map[string]any{"trash": found.Name})
+	return name, nil
+}
+
+// EmptyTrash destroys every removed volume in the trash and returns those
+// it destroyed. It carries on past failures, returning them all.
+func (d *Driver) EmptyTrash(ctx context.Context) ([]TrashEntry, error) {
+	return d.purgeTrash(ctx, func(TrashEntry) bool { return true })
+}
+
+// ReapTrash periodically destroys the removed volumes whose retention has
+// passed. Blocks until ctx is cancelled.
+func (d *Driver) ReapTrash(ctx context.Context, interval time.Duration) {
+	ctx = audit.WithActor(ctx, audit.ActorReaper)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := d.reapTrash(ctx, time.Now()); err != nil {
+			log.Warn("trash reaper failed", "error", err)
+		}
+	}
+}
+
+// reapTrash destroys the removed volumes that expired by now
+func (d *Driver) reapTrash(ctx context.Context, now time.Time) ([]TrashEntry, error) {
+	if d.trashRetention <= 0 {
+		return nil, nil
+	}
+	return d.purgeTrash(ctx, func(e TrashEntry) bool { return !now.Before(e.Expires) })
+}
+
+// purgeTrash destroys the removed volumes in the trash that match
+func (d *Driver) purgeTrash(ctx context.Context, match func(TrashEntry) bool) ([]TrashEntry, error) {
+	d.pool.RLock()
+	listCtx, finish := d.startOperation(ctx, "list trash", d.timeouts.Query)
+	entries, err := d.trashEntries(listCtx)
+	err = finish(err)
+	d.pool.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var purged []TrashEntry
+	var errs []error
+	for _, entry := range entries {
+		if !match(entry) {
+			continue
+		}
+
+		unlock := d.lockVolume(entry.Name)
+		err := d.purge(ctx, entry)
+		unlock()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		purged = append(purged, entry)
+	}
+	return purged, errors.Join(errs...)
+}
+
+// freeTrash destroys the oldest removed volume in the trash, for a create
+// that ran out of space, and reports whether there was one. The caller
+// holds the lock of another volume.
+func (d *Driver) freeTrash(ctx context.Context) (bool, error) {
+	entries, err := d.trashEntries(ctx)
+	if err != nil || len(entries) == 0 {
+		return false, err
+	}
+
+	entry := entries[0]
+	unlock := d.volumes.Lock(entry.Name)
+	defer unlock()
+
+	log.Warn("pool out of space, destroying the oldest volume in the trash", "entry", entry.Name, "used", entry.Used)
+	if err := d.purge(ctx, entry); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// purge destroys a removed volume in the trash, which may already be gone.
+// The caller holds the lock of entry.
+func (d *Driver) purge(ctx context.Context, entry TrashEntry) (err error) {
+	defer func(start time.Time) {
+		d.auditOperation(ctx, "purge", entry.Volume, map[string]string{"trash": entry.Name}, start, err)
+	}(time.Now())
+	timeout := d.timeouts.Remove
+	if d.wantsSecureErase(entry.Name) {
+		timeout = d.timeouts.Erase
+	}
+	ctx, finish := d.startOperation(ctx, "destroy "+entry.Name, timeout)
+	defer func() { err = finish(err) }()
+
+	fs, err := d.stratis.GetByName(ctx, entry.Name)
+	if err == nil {
+		err = d.destroyFilesystem(ctx, entry.Name, fs)
+	}
+	if err != nil && !errors.Is(err, stratis.ErrNotFound) {
+		return fmt.Errorf("destroy %s: %w", entry.Name, err)
+	}
+	if err := d.state.Delete(entry.Name); err != nil {
+		log.Warn("failed to remove volume state", "name", entry.Name, "error", err)
+	}
+
+	log.Info("volume destroyed from trash", "name", entry.Volume, "entry", entry.Name, "removed", entry.Removed)
+	d.events.Publish(events.Purged, entry.Volume, map[string]any{"trash": entry.Name})
+	return nil
+}
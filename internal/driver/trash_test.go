@@ -0,0 +1,213 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/volume"
+
+	"github.com/kriansa/podman-volume-stratis/internal/fault"
+	"github.com/kriansa/podman-volume-stratis/internal/journal"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+func TestParseTrashName(t *testing.T) {
+	removed := time.UnixMilli(1760000000123)
+
+	tests := []struct {
+		fsName  string
+		want    string
+		wantOK  bool
+		removed time.Time
+	}{
+		{trashName("vol1", removed), "vol1", true, removed},
+		{trashName("my-vol-2", removed), "my-vol-2", true, removed},
+		{"vol1", "", false, time.Time{}},
+		{".trash-vol1", "", false, time.Time{}},
+		{".trash-123-", "", false, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		name, removed, ok := parseTrashName(tt.fsName)
+		if name != tt.want || ok != tt.wantOK || !removed.Equal(tt.removed) {
+			t.Errorf("parseTrashName(%q) = %q, %v, %v, want %q, %v, %v",
+				tt.fsName, name, removed, ok, tt.want, tt.removed, tt.wantOK)
+		}
+	}
+}
+
+func TestDriver_RemoveMovesToTrash(t *testing.T) {
+	ctx := context.Background()
+	d := NewDriver(t.TempDir(), newFakeManager(t), newFakeMounter(), WithTrash(time.Hour))
+
+	err := d.Create(&volume.CreateRequest{Name: "vol1", Options: map[string]string{"size": "1GiB"}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := d.Remove(&volume.RemoveRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	// The volume is gone for Podman
+	list, err := d.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(list.Volumes) != 0 {
+		t.Errorf("List() = %d volumes, want the removed one hidden", len(list.Volumes))
+	}
+	if _, err := d.Get(&volume.GetRequest{Name: "vol1"}); !errors.Is(err, stratis.ErrNotFound) {
+		t.Errorf("Get() of a removed volume error = %v, want ErrNotFound", err)
+	}
+
+	entries, err := d.Trash(ctx)
+	if err != nil {
+		t.Fatalf("Trash() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Volume != "vol1" {
+		t.Fatalf("Trash() = %+v, want vol1", entries)
+	}
+	if got := entries[0].Expires.Sub(entries[0].Removed); got != time.Hour {
+		t.Errorf("entry expires %s after removal, want 1h", got)
+	}
+	if _, err := d.Get(&volume.GetRequest{Name: entries[0].Name}); !errors.Is(err, stratis.ErrNotFound) {
+		t.Errorf("Get() of a trash entry error = %v, want ErrNotFound", err)
+	}
+
+	// The name is free for a new volume, so restoring takes another one
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Create() after Remove() error = %v", err)
+	}
+	if _, err := d.Restore(ctx, "vol1", ""); !errors.Is(err, stratis.ErrAlreadyExists) {
+		t.Errorf("Restore() over an existing volume error = %v, want ErrAlreadyExists", err)
+	}
+	name, err := d.Restore(ctx, "vol1", "vol1-old")
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if name != "vol1-old" {
+		t.Errorf("Restore() = %q, want vol1-old", name)
+	}
+
+	// The restored volume keeps its options
+	vol, _ := d.state.Get("vol1-old")
+	if vol.Options["size"] != "1073741824" {
+		t.Errorf("restored volume options = %v, want those it was created with", vol.Options)
+	}
+	if entries, _ := d.Trash(ctx); len(entries) != 0 {
+		t.Errorf("Trash() after Restore() = %+v, want it empty", entries)
+	}
+}
+
+func TestDriver_ReapTrash(t *testing.T) {
+	ctx := context.Background()
+	mgr := newFakeManager(t)
+	d := NewDriver(t.TempDir(), mgr, newFakeMounter(), WithTrash(time.Hour))
+
+	for _, name := range []string{"vol1", "vol2"} {
+		if err := d.Create(&volume.CreateRequest{Name: name}); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		if err := d.Remove(&volume.RemoveRequest{Name: name}); err != nil {
+			t.Fatalf("Remove() error = %v", err)
+		}
+	}
+
+	purged, err := d.reapTrash(ctx, time.Now())
+	if err != nil || len(purged) != 0 {
+		t.Fatalf("reapTrash() before expiry = %+v, %v, want nothing purged", purged, err)
+	}
+
+	purged, err = d.reapTrash(ctx, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("reapTrash() error = %v", err)
+	}
+	if len(purged) != 2 {
+		t.Errorf("reapTrash() after expiry = %+v, want both purged", purged)
+	}
+	if list, _ := mgr.List(ctx); len(list) != 0 {
+		t.Errorf("filesystems after reapTrash() = %+v, want none", list)
+	}
+}
+
+func TestDriver_CreateFreesTrashWhenOutOfSpace(t *testing.T) {
+	ctx := context.Background()
+	mgr := newFakeManager(t)
+	oldest := trashName("old", time.Now().Add(-2*time.Hour))
+	newest := trashName("new", time.Now().Add(-time.Hour))
+	mgr.add(oldest, nil)
+	mgr.add(newest, nil)
+
+	in := newInjector(t, fault.Rule{Method: "Create", Error: "no_space", Sequence: "x"})
+	d := NewDriver(t.TempDir(), fault.WrapManager(mgr, in), newFakeMounter(), WithTrash(24*time.Hour))
+
+	if err := d.Create(&volume.CreateRequest{Name: "vol1"}); err != nil {
+		t.Fatalf("Create() error = %v, want it to succeed after freeing the trash", err)
+	}
+
+	if _, err := mgr.GetByName(ctx, oldest); !errors.Is(err, stratis.ErrNotFound) {
+		t.Errorf("oldest trash entry error = %v, want it destroyed", err)
+	}
+	if _, err := mgr.GetByName(ctx, newest); err != nil {
+		t.Errorf("newest trash entry destroyed, only one was needed: %v", err)
+	}
+}
+
+func TestDriver_RecoverMoveToTrash(t *testing.T) {
+	tests := []struct {
+		name      string
+		fsRenamed bool
+	}{
+		{"state moved", false},
+		{"filesystem moved too", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			mgr := newFakeManager(t)
+			j := journal.New("")
+			d := NewDriver(t.TempDir(), mgr, newFakeMounter(), WithJournal(j), WithTrash(time.Hour))
+
+			err := d.Create(&volume.CreateRequest{Name: "vol1", Options: map[string]string{"secure_erase": "true"}})
+			if err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+
+			// A removal cut short by a crash while moving the volume to the trash
+			entry := trashName("vol1", time.Now().Add(-time.Minute))
+			tx, err := j.Begin(opRemove, "vol1")
+			if err != nil {
+				t.Fatalf("Begin() error = %v", err)
+			}
+			if err := tx.Record(stepRename, entry); err != nil {
+				t.Fatalf("Record() error = %v", err)
+			}
+			if err := d.state.Rename("vol1", entry); err != nil {
+				t.Fatal(err)
+			}
+			if tt.fsRenamed {
+				if err := mgr.Rename(ctx, "vol1", entry); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := d.Recover(ctx); err != nil {
+				t.Fatalf("Recover() error = %v", err)
+			}
+
+			entries, err := d.Trash(ctx)
+			if err != nil || len(entries) != 1 {
+				t.Fatalf("Trash() = %+v, %v, want vol1 in the trash", entries, err)
+			}
+			if vol, _ := d.state.Get(entries[0].Name); vol.SecureErase == nil || !*vol.SecureErase {
+				t.Errorf("state of %s = %+v, want secure_erase carried over", entries[0].Name, vol)
+			}
+			if _, ok := d.state.Get("vol1"); ok {
+				t.Error("state of vol1 left behind")
+			}
+		})
+	}
+}
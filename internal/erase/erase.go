@@ -0,0 +1,168 @@
+// Package erase wipes the contents of block devices before their space is
+// given back to the pool
+package erase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// blkdiscard is the BLKDISCARD ioctl, _IO(0x12, 119)
+const blkdiscard = 0x1277
+
+const (
+	// discardChunk is how much is discarded at a time, between checks for
+	// cancellation and progress reports
+	discardChunk = 1 << 30
+	// zeroChunk is how much is overwritten with zeros at a time
+	zeroChunk = 4 << 20
+)
+
+// ErrThinProvisioned means a device cannot discard and may be thin
+// provisioned, so it is not overwritten with zeros: that would allocate its
+// whole virtual size in the pool, which may not have the room
+var ErrThinProvisioned = errors.New("device cannot discard and may be thin provisioned; enable discards on the pool to erase it")
+
+// sysfsRoot is where block device attributes are read; replaced in tests
+var sysfsRoot = "/sys"
+
+// Method is how a device was erased
+type Method string
+
+const (
+	// Discard discards every block of the device, so a thin device reads
+	// back zeros and gives its space back to the pool
+	Discard Method = "discard"
+	// Zero overwrites the device with zeros, for devices that cannot discard
+	Zero Method = "zero"
+)
+
+// Progress is how far erasing a device got
+type Progress struct {
+	Method Method
+	// Done is how many bytes are erased
+	Done uint64
+	// Total is the size of the device in bytes
+	Total uint64
+}
+
+// Device erases the device at path, which must not be in use. It discards
+// every block, falling back to overwriting them with zeros when the device
+// cannot discard, e.g. a regular file. Device-mapper devices, such as the
+// dm-thin devices of Stratis filesystems, are never overwritten; erasing one
+// that cannot discard fails with ErrThinProvisioned. It calls progress after
+// each chunk and stops when ctx is done, leaving the device partly erased.
+func Device(ctx context.Context, path string, progress func(Progress)) (Method, error) {
+	// O_EXCL makes opening a block device fail while it is mounted
+	f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_EXCL, 0)
+	if err != nil {
+		return "", fmt.Errorf("open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", fmt.Errorf("get size of %s: %w", path, err)
+	}
+	total := uint64(size)
+
+	done, err := discard(ctx, f, total, progress)
+	if err == nil {
+		return Discard, nil
+	}
+	if done > 0 || !unsupported(err) {
+		return Discard, fmt.Errorf("discard %s: %w", path, err)
+	}
+
+	thin, err := deviceMapper(f)
+	if err != nil {
+		return "", fmt.Errorf("check device type of %s: %w", path, err)
+	}
+	if thin {
+		return "", fmt.Errorf("erase %s: %w", path, ErrThinProvisioned)
+	}
+
+	if err := zero(ctx, f, total, progress); err != nil {
+		return Zero, fmt.Errorf("zero %s: %w", path, err)
+	}
+	return Zero, nil
+}
+
+// discard discards the device a chunk at a time and returns how much it did
+func discard(ctx context.Context, f *os.File, total uint64, progress func(Progress)) (uint64, error) {
+	var done uint64
+	for done < total {
+		if err := ctx.Err(); err != nil {
+			return done, err
+		}
+
+		length := min(uint64(discardChunk), total-done)
+		span := [2]uint64{done, length}
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blkdiscard, uintptr(unsafe.Pointer(&span)))
+		if errno != 0 {
+			return done, errno
+		}
+
+		done += length
+		progress(Progress{Method: Discard, Done: done, Total: total})
+	}
+	return done, nil
+}
+
+// unsupported reports whether err means the device cannot discard at all
+func unsupported(err error) bool {
+	return errors.Is(err, syscall.ENOTTY) || errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.EINVAL)
+}
+
+// deviceMapper reports whether f is a device-mapper block device. Which
+// target a device uses takes asking device-mapper, so every one of them is
+// taken to be possibly thin provisioned.
+func deviceMapper(f *os.File) (bool, error) {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(f.Fd()), &st); err != nil {
+		return false, err
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return false, nil
+	}
+	return isDeviceMapper(uint64(st.Rdev))
+}
+
+// isDeviceMapper reports whether the block device numbered rdev is a
+// device-mapper device, which has a dm directory in sysfs
+func isDeviceMapper(rdev uint64) (bool, error) {
+	major := (rdev>>8)&0xfff | (rdev>>32)&^0xfff
+	minor := rdev&0xff | (rdev>>12)&^0xff
+	_, err := os.Stat(filepath.Join(sysfsRoot, "dev/block", fmt.Sprintf("%d:%d", major, minor), "dm"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// zero overwrites the device with zeros a chunk at a time and flushes them
+// to it
+func zero(ctx context.Context, f *os.File, total uint64, progress func(Progress)) error {
+	buf := make([]byte, zeroChunk)
+	var done uint64
+	for done < total {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		length := min(uint64(len(buf)), total-done)
+		if _, err := f.WriteAt(buf[:length], int64(done)); err != nil {
+			return err
+		}
+
+		done += length
+		progress(Progress{Method: Zero, Done: done, Total: total})
+	}
+	return f.Sync()
+}
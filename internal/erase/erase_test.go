@@ -0,0 +1,109 @@
+package erase
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// image writes a file of size random bytes, standing in for a device that
+// cannot discard
+func image(t *testing.T, size int) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "device.img")
+	data := make([]byte, size)
+	rand.Read(data)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDevice_ZeroesWhenDiscardIsUnsupported(t *testing.T) {
+	size := 2*zeroChunk + 4096
+	path := image(t, size)
+
+	var last Progress
+	reports := 0
+	method, err := Device(context.Background(), path, func(p Progress) {
+		last = p
+		reports++
+	})
+	if err != nil {
+		t.Fatalf("Device() error = %v", err)
+	}
+	if method != Zero {
+		t.Errorf("Device() method = %s, want %s", method, Zero)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != size || !bytes.Equal(data, make([]byte, size)) {
+		t.Errorf("device has %d bytes after erase, want %d zeros", len(data), size)
+	}
+
+	if reports != 3 || last.Done != uint64(size) || last.Total != uint64(size) {
+		t.Errorf("progress reported %d times ending at %+v, want 3 reports ending at %d", reports, last, size)
+	}
+}
+
+func TestDevice_Cancelled(t *testing.T) {
+	path := image(t, 4*zeroChunk)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var done uint64
+	_, err := Device(ctx, path, func(p Progress) {
+		done = p.Done
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Device() error = %v, want context.Canceled", err)
+	}
+	if done != zeroChunk {
+		t.Errorf("erased %d bytes before stopping, want one chunk of %d", done, zeroChunk)
+	}
+}
+
+func TestIsDeviceMapper(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "dev/block/253:3/dm"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "dev/block/259:0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { sysfsRoot = old }(sysfsRoot)
+	sysfsRoot = root
+
+	tests := []struct {
+		name string
+		rdev uint64
+		want bool
+	}{
+		{"device-mapper", 253<<8 | 3, true},
+		{"nvme", 259 << 8, false},
+		{"unknown", 8<<8 | 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := isDeviceMapper(tt.rdev)
+			if err != nil || got != tt.want {
+				t.Errorf("isDeviceMapper() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDevice_Missing(t *testing.T) {
+	_, err := Device(context.Background(), filepath.Join(t.TempDir(), "missing"), func(Progress) {})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Device() error = %v, want ErrNotExist", err)
+	}
+}
@@ -0,0 +1,192 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Type identifies the kind of volume event
+type Type string
+
+const (
+	// Created is emitted after a volume is created
+	Created Type = "created"
+	// Removed is emitted after a volume is removed
+	Removed Type = "removed"
+	// Mounted is emitted after a volume is mounted
+	Mounted Type = "mounted"
+	// Unmounted is emitted after a volume is unmounted
+	Unmounted Type = "unmounted"
+	// Restored is emitted after a removed volume is restored from the trash
+	Restored Type = "restored"
+	// Purged is emitted after a removed volume is destroyed for good
+	Purged Type = "purged"
+	// UsageThreshold is emitted when a volume's usage crosses the configured threshold
+	UsageThreshold Type = "usage_threshold"
+	// Missed starts a replay whose cursor is older than the oldest buffered
+	// event. Its "missed" attribute is how many events are no longer
+	// buffered, and its Seq is the last of them, so it works as a cursor.
+	// When the cursor is from another epoch, its "restarted" attribute is
+	// true, and the events the earlier process published after the cursor
+	// are missed too, however many they were.
+	Missed Type = "missed"
+)
+
+// subscriberBuffer is how many events a subscriber may lag behind before
+// it is disconnected
+const subscriberBuffer = 256
+
+// Event is a single volume lifecycle event
+type Event struct {
+	// Seq is a monotonically increasing sequence number, usable as a replay
+	// cursor along with Epoch
+	Seq uint64 `json:"seq"`
+	// Epoch identifies the process that published the event; sequence
+	// numbers start over in each one
+	Epoch      string         `json:"epoch"`
+	Time       time.Time      `json:"time"`
+	Type       Type           `json:"type"`
+	Volume     string         `json:"volume"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+}
+
+// Cursor is a replay position: the sequence number of the last event seen,
+// and the epoch it was published in. An empty Epoch is taken to be the
+// current one.
+type Cursor struct {
+	Seq   uint64
+	Epoch string
+}
+
+// Bus fans out events to subscribers and keeps a bounded history for replay.
+// A nil *Bus is valid and discards all events.
+type Bus struct {
+	mu          sync.Mutex
+	epoch       string
+	seq         uint64
+	history     []Event
+	historySize int
+	subscribers map[chan Event]struct{}
+}
+
+// NewBus creates a Bus that keeps the last historySize events for replay
+func NewBus(historySize int) *Bus {
+	return &Bus{
+		epoch:       newEpoch(),
+		historySize: historySize,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// newEpoch returns a random epoch, so cursors from an earlier process never
+// match the events of this one
+func newEpoch() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// Publish records an event and delivers it to all subscribers
+func (b *Bus) Publish(typ Type, volume string, attrs map[string]any) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e := Event{
+		Seq:        b.seq,
+		Epoch:      b.epoch,
+		Time:       time.Now().UTC(),
+		Type:       typ,
+		Volume:     volume,
+		Attributes: attrs,
+	}
+
+	b.history = append(b.history, e)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			// Subscriber is too slow; drop it so it reconnects with its cursor
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the buffered events after the since cursor and a channel
+// with all events published from now on. A nil since skips the replay, and
+// a since of 0 replays the whole history. If since is from another epoch,
+// or ahead of the current sequence (e.g. the plugin restarted), the whole
+// history is replayed too. When events after since are no longer buffered,
+// or were published by another process, the replay starts with a Missed
+// event. The channel is closed when cancel is called or when the subscriber
+// falls too far behind.
+func (b *Bus) Subscribe(since *Cursor) (replay []Event, ch <-chan Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if since != nil {
+		replay = b.replay(*since)
+	}
+
+	sub := make(chan Event, subscriberBuffer)
+	b.subscribers[sub] = struct{}{}
+
+	cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[sub]; ok {
+			delete(b.subscribers, sub)
+			close(sub)
+		}
+	}
+
+	return replay, sub, cancel
+}
+
+// replay returns the buffered events after the since cursor, preceded by a
+// Missed event if some of them are no longer buffered. Must be called with
+// mu held.
+func (b *Bus) replay(since Cursor) []Event {
+	restarted := since.Epoch != "" && since.Epoch != b.epoch
+	seq := since.Seq
+	if restarted || seq > b.seq {
+		seq = 0
+	}
+
+	oldest := b.seq + 1
+	if len(b.history) > 0 {
+		oldest = b.history[0].Seq
+	}
+
+	var replay []Event
+	if seq+1 < oldest || restarted {
+		attrs := map[string]any{"missed": oldest - 1 - seq}
+		if restarted {
+			attrs["restarted"] = true
+		}
+		replay = append(replay, Event{
+			Seq:        oldest - 1,
+			Epoch:      b.epoch,
+			Time:       time.Now().UTC(),
+			Type:       Missed,
+			Attributes: attrs,
+		})
+	}
+	for _, e := range b.history {
+		if e.Seq > seq {
+			replay = append(replay, e)
+		}
+	}
+	return replay
+}
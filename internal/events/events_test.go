@@ -0,0 +1,128 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestBus_SubscribeReplay(t *testing.T) {
+	bus := NewBus(3)
+	for _, name := range []string{"vol1", "vol2", "vol3", "vol4"} {
+		bus.Publish(Created, name, nil)
+	}
+
+	cursor := func(seq uint64) *Cursor { return &Cursor{Seq: seq} }
+	epochCursor := func(seq uint64, epoch string) *Cursor { return &Cursor{Seq: seq, Epoch: epoch} }
+
+	tests := []struct {
+		name          string
+		since         *Cursor
+		wantMissed    uint64
+		wantRestarted bool
+		wantNames     []string
+	}{
+		{"no cursor skips replay", nil, 0, false, nil},
+		{"cursor inside history", cursor(2), 0, false, []string{"vol3", "vol4"}},
+		{"cursor just before history", cursor(1), 0, false, []string{"vol2", "vol3", "vol4"}},
+		{"zero cursor replays everything", cursor(0), 1, false, []string{"vol2", "vol3", "vol4"}},
+		{"cursor at head", cursor(4), 0, false, nil},
+		{"cursor ahead after restart", cursor(100), 1, false, []string{"vol2", "vol3", "vol4"}},
+		{"cursor of this epoch", epochCursor(2, bus.epoch), 0, false, []string{"vol3", "vol4"}},
+		{"cursor of an earlier epoch", epochCursor(2, "earlier"), 1, true, []string{"vol2", "vol3", "vol4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replay, _, cancel := bus.Subscribe(tt.since)
+			defer cancel()
+
+			if tt.wantMissed > 0 {
+				if len(replay) == 0 || replay[0].Type != Missed {
+					t.Fatalf("replay = %+v, want it to start with a missed event", replay)
+				}
+				if got := replay[0].Attributes["missed"]; got != tt.wantMissed || replay[0].Seq != 1 {
+					t.Errorf("missed event = %+v, want %d missed up to seq 1", replay[0], tt.wantMissed)
+				}
+				if got := replay[0].Attributes["restarted"] == true; got != tt.wantRestarted {
+					t.Errorf("missed event restarted = %v, want %v", got, tt.wantRestarted)
+				}
+				replay = replay[1:]
+			}
+
+			if len(replay) != len(tt.wantNames) {
+				t.Fatalf("replayed %d events, want %d", len(replay), len(tt.wantNames))
+			}
+			for i, e := range replay {
+				if e.Volume != tt.wantNames[i] {
+					t.Errorf("replay[%d] = %q, want %q", i, e.Volume, tt.wantNames[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBus_SubscribeEmpty(t *testing.T) {
+	bus := NewBus(3)
+
+	replay, _, cancel := bus.Subscribe(&Cursor{})
+	defer cancel()
+	if len(replay) != 0 {
+		t.Errorf("replay of an empty history = %+v, want nothing", replay)
+	}
+}
+
+func TestBus_SubscribeAfterRestart(t *testing.T) {
+	before := NewBus(3)
+	before.Publish(Created, "vol1", nil)
+	bus := NewBus(3)
+
+	// A new bus has a new epoch, even before it published anything
+	replay, _, cancel := bus.Subscribe(&Cursor{Seq: 1, Epoch: before.epoch})
+	defer cancel()
+	if len(replay) != 1 || replay[0].Type != Missed || replay[0].Attributes["restarted"] != true {
+		t.Errorf("replay of a cursor from before a restart = %+v, want a missed event", replay)
+	}
+}
+
+func TestBus_LiveEvents(t *testing.T) {
+	bus := NewBus(10)
+
+	_, ch, cancel := bus.Subscribe(nil)
+	bus.Publish(Mounted, "vol1", map[string]any{"id": "c1"})
+
+	e := <-ch
+	if e.Type != Mounted || e.Volume != "vol1" || e.Seq != 1 {
+		t.Errorf("got event %+v, want mounted vol1 with seq 1", e)
+	}
+
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Error("channel should be closed after cancel")
+	}
+
+	// Cancelling twice must be safe
+	cancel()
+}
+
+func TestBus_SlowSubscriberDropped(t *testing.T) {
+	bus := NewBus(10)
+
+	_, ch, cancel := bus.Subscribe(nil)
+	defer cancel()
+
+	for range subscriberBuffer + 1 {
+		bus.Publish(Created, "vol", nil)
+	}
+
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("received %d events before disconnect, want %d", n, subscriberBuffer)
+	}
+}
+
+func TestBus_NilIsNoop(t *testing.T) {
+	var bus *Bus
+	bus.Publish(Removed, "vol1", nil)
+}
@@ -0,0 +1,225 @@
+// Package fault wraps a stratis.Manager and a mount.Mounter to inject
+// failures into their calls: errors, latency and panics, per method, by
+// probability or by a sequence of calls. It is for chaos testing how the
+// driver copes with stratisd timeouts, partial failures and busy unmounts,
+// and is only wired in by debug builds.
+package fault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+// Methods are the methods faults can be injected into
+var Methods = []string{
+	// stratis.Manager
+	"PoolExists", "List", "Create", "Delete", "Rename", "GetByName", "Snapshot",
+	// mount.Mounter
+	"Mount", "Unmount", "IsMounted", "GetMountPoint",
+}
+
+// namedErrors maps the names of injectable errors onto the errors the
+// backends and mounters return
+var namedErrors = map[string]error{
+	"not_found":      stratis.ErrNotFound,
+	"already_exists": stratis.ErrAlreadyExists,
+	"pool_not_found": stratis.ErrPoolNotFound,
+	"no_space":       stratis.ErrNoSpace,
+	"busy":           stratis.ErrBusy,
+	"locked":         stratis.ErrLocked,
+	"unavailable":    stratis.ErrStratisdUnavailable,
+	"ebusy":          syscall.EBUSY,
+	"einval":         syscall.EINVAL,
+	"eio":            syscall.EIO,
+}
+
+// timeoutError is the error that makes a call hang until its context is done,
+// as a call to an unresponsive stratisd does
+const timeoutError = "timeout"
+
+// Rule describes a fault to inject into the calls of a method
+type Rule struct {
+	// Method is the method to inject into, e.g. "Create" or "Unmount"
+	Method string `toml:"method"`
+	// Error is the error the call fails with: a name such as "busy",
+	// "no_space" or "ebusy", "timeout" to hang until the context is done,
+	// or any other text for an error with that message. Empty for no error.
+	Error string `toml:"error"`
+	// Latency delays the call, unless its context is done first
+	Latency time.Duration `toml:"latency"`
+	// Panic makes the call panic
+	Panic bool `toml:"panic"`
+	// After makes the call go through before failing, as when stratisd
+	// acts on a request but its reply is lost
+	After bool `toml:"after"`
+	// Probability is the chance of a call being faulted, from 0 to 1. With
+	// neither it nor Sequence set, every call is.
+	Probability float64 `toml:"probability"`
+	// Sequence picks the calls to fault in order: "x" faults a call and "."
+	// lets it through, e.g. ".x" faults the second call. Calls past its end
+	// go through.
+	Sequence string `toml:"sequence"`
+}
+
+// Validate checks that the rule can be injected
+func (r Rule) Validate() error {
+	if !slices.Contains(Methods, r.Method) {
+		return fmt.Errorf("fault method must be one of %s, got %q", strings.Join(Methods, ", "), r.Method)
+	}
+	if r.Error == "" && r.Latency == 0 && !r.Panic {
+		return fmt.Errorf("fault for %s injects nothing: set error, latency or panic", r.Method)
+	}
+	if r.Latency < 0 {
+		return fmt.Errorf("fault latency for %s cannot be negative", r.Method)
+	}
+	if r.Probability < 0 || r.Probability > 1 {
+		return fmt.Errorf("fault probability for %s must be between 0 and 1, got %v", r.Method, r.Probability)
+	}
+	if strings.Trim(r.Sequence, "x.") != "" {
+		return fmt.Errorf("fault sequence for %s may only have 'x' and '.', got %q", r.Method, r.Sequence)
+	}
+	if r.Probability != 0 && r.Sequence != "" {
+		return fmt.Errorf("fault for %s has both a probability and a sequence", r.Method)
+	}
+	return nil
+}
+
+// err returns the error the rule injects, or nil
+func (r Rule) err() error {
+	if r.Error == "" || r.Error == timeoutError {
+		return nil
+	}
+	if err, ok := namedErrors[r.Error]; ok {
+		return err
+	}
+	return errors.New(r.Error)
+}
+
+// rule is a Rule with the calls it has seen
+type rule struct {
+	Rule
+	calls int
+}
+
+// Injector decides which calls to fault and injects the faults
+type Injector struct {
+	mu    sync.Mutex
+	rules []*rule
+	rand  *rand.Rand
+}
+
+// NewInjector creates an injector of the given rules. Probabilities are
+// drawn from seed, so a run can be repeated; a seed of 0 picks one at random.
+func NewInjector(rules []Rule, seed uint64) (*Injector, error) {
+	in := &Injector{}
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+		in.rules = append(in.rules, &rule{Rule: r})
+	}
+
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	in.rand = rand.New(rand.NewPCG(seed, seed))
+	log.Warn("fault injection enabled", "rules", len(rules), "seed", seed)
+	return in, nil
+}
+
+// pick returns the rules that fault this call of method
+func (in *Injector) pick(method string) []Rule {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	var picked []Rule
+	for _, r := range in.rules {
+		if r.Method != method {
+			continue
+		}
+		call := r.calls
+		r.calls++
+
+		switch {
+		case r.Sequence != "":
+			if call < len(r.Sequence) && r.Sequence[call] == 'x' {
+				picked = append(picked, r.Rule)
+			}
+		case r.Probability != 0:
+			if in.rand.Float64() < r.Probability {
+				picked = append(picked, r.Rule)
+			}
+		default:
+			picked = append(picked, r.Rule)
+		}
+	}
+	return picked
+}
+
+// do runs call, the call of method, with the faults picked for it
+func (in *Injector) do(ctx context.Context, method string, call func() error) error {
+	rules := in.pick(method)
+	if len(rules) == 0 {
+		return call()
+	}
+
+	var after []Rule
+	for _, r := range rules {
+		if r.After {
+			after = append(after, r)
+			continue
+		}
+		if err := inject(ctx, method, r); err != nil {
+			return err
+		}
+	}
+
+	if err := call(); err != nil {
+		return err
+	}
+
+	for _, r := range after {
+		if err := inject(ctx, method, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inject injects the fault of a rule
+func inject(ctx context.Context, method string, r Rule) error {
+	log.Warn("injecting fault", "method", method, "error", r.Error, "latency", r.Latency, "panic", r.Panic, "after", r.After)
+
+	if r.Latency > 0 {
+		timer := time.NewTimer(r.Latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("injected %s latency in %s: %w", r.Latency, method, ctx.Err())
+		}
+	}
+
+	if r.Panic {
+		panic(fmt.Sprintf("injected panic in %s", method))
+	}
+
+	if r.Error == timeoutError {
+		<-ctx.Done()
+		return fmt.Errorf("injected timeout in %s: %w", method, ctx.Err())
+	}
+	if err := r.err(); err != nil {
+		return fmt.Errorf("injected fault in %s: %w", method, err)
+	}
+	return nil
+}
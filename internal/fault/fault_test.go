@@ -0,0 +1,169 @@
+package fault
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+func TestMain(m *testing.M) {
+	log.Setup(false)
+	os.Exit(m.Run())
+}
+
+func newManager(t *testing.T, rules ...Rule) *Manager {
+	t.Helper()
+
+	next, err := stratis.NewMemoryManager("pool", t.TempDir())
+	if err != nil {
+		t.Fatalf("NewMemoryManager() error = %v", err)
+	}
+	in, err := NewInjector(rules, 1)
+	if err != nil {
+		t.Fatalf("NewInjector() error = %v", err)
+	}
+	return WrapManager(next, in)
+}
+
+func TestRule_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr string
+	}{
+		{"error", Rule{Method: "Create", Error: "no_space"}, ""},
+		{"latency by sequence", Rule{Method: "Unmount", Latency: time.Second, Sequence: "..x"}, ""},
+		{"unknown method", Rule{Method: "Format", Error: "busy"}, "fault method"},
+		{"nothing injected", Rule{Method: "Create", Probability: 0.5}, "injects nothing"},
+		{"probability out of range", Rule{Method: "Create", Error: "busy", Probability: 2}, "between 0 and 1"},
+		{"bad sequence", Rule{Method: "Create", Error: "busy", Sequence: "x-x"}, "may only have"},
+		{"probability and sequence", Rule{Method: "Create", Error: "busy", Probability: 0.5, Sequence: "x"}, "both"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManager_Sequence(t *testing.T) {
+	m := newManager(t, Rule{Method: "Create", Error: "no_space", Sequence: ".x"})
+	ctx := context.Background()
+
+	if _, err := m.Create(ctx, "first", nil); err != nil {
+		t.Fatalf("first Create() error = %v", err)
+	}
+	if _, err := m.Create(ctx, "second", nil); !errors.Is(err, stratis.ErrNoSpace) {
+		t.Errorf("second Create() error = %v, want ErrNoSpace", err)
+	}
+	if _, err := m.Create(ctx, "third", nil); err != nil {
+		t.Errorf("third Create() error = %v, want the sequence to be over", err)
+	}
+
+	// Faults before the call leave the backend alone
+	if _, err := m.GetByName(ctx, "second"); !errors.Is(err, stratis.ErrNotFound) {
+		t.Errorf("GetByName() of the failed filesystem error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestManager_After(t *testing.T) {
+	m := newManager(t, Rule{Method: "Create", Error: "reply lost", After: true})
+	ctx := context.Background()
+
+	if _, err := m.Create(ctx, "vol", nil); err == nil || !strings.Contains(err.Error(), "reply lost") {
+		t.Fatalf("Create() error = %v, want the injected error", err)
+	}
+	if _, err := m.GetByName(ctx, "vol"); err != nil {
+		t.Errorf("GetByName() error = %v, want the filesystem created anyway", err)
+	}
+}
+
+func TestManager_Probability(t *testing.T) {
+	m := newManager(t, Rule{Method: "List", Error: "unavailable", Probability: 0.5})
+	ctx := context.Background()
+
+	failed := 0
+	for range 200 {
+		if _, err := m.List(ctx); errors.Is(err, stratis.ErrStratisdUnavailable) {
+			failed++
+		}
+	}
+	if failed < 50 || failed > 150 {
+		t.Errorf("%d of 200 calls failed, want about half", failed)
+	}
+}
+
+func TestManager_Timeout(t *testing.T) {
+	m := newManager(t,
+		Rule{Method: "Delete", Error: "timeout"},
+		Rule{Method: "GetByName", Latency: time.Hour},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := m.Delete(ctx, "vol"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Delete() error = %v, want the context's error", err)
+	}
+	if _, err := m.GetByName(ctx, "vol"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetByName() error = %v, want the context's error", err)
+	}
+}
+
+func TestManager_Panic(t *testing.T) {
+	m := newManager(t, Rule{Method: "PoolExists", Panic: true})
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("PoolExists() did not panic")
+		}
+	}()
+	m.PoolExists(context.Background())
+}
+
+// fakeMounter mounts nothing and reports nothing mounted
+type fakeMounter struct{ unmounts int }
+
+func (m *fakeMounter) Mount(context.Context, string, string, string) error { return nil }
+func (m *fakeMounter) Unmount(context.Context, string) error {
+	m.unmounts++
+	return nil
+}
+func (m *fakeMounter) IsMounted(context.Context, string) (bool, error)       { return false, nil }
+func (m *fakeMounter) GetMountPoint(context.Context, string) (string, error) { return "", nil }
+
+func TestMounter_Errno(t *testing.T) {
+	in, err := NewInjector([]Rule{{Method: "Unmount", Error: "ebusy", Sequence: "x"}}, 1)
+	if err != nil {
+		t.Fatalf("NewInjector() error = %v", err)
+	}
+	next := &fakeMounter{}
+	m := WrapMounter(next, in)
+	ctx := context.Background()
+
+	if err := m.Unmount(ctx, "/mnt/vol"); !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("Unmount() error = %v, want EBUSY", err)
+	}
+	if err := m.Unmount(ctx, "/mnt/vol"); err != nil {
+		t.Errorf("second Unmount() error = %v", err)
+	}
+	if next.unmounts != 1 {
+		t.Errorf("mounter unmounted %d times, want once", next.unmounts)
+	}
+}
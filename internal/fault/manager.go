@@ -0,0 +1,97 @@
+package fault
+
+import (
+	"context"
+
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+// Manager is a stratis.Manager that injects faults into the calls of
+// another one
+type Manager struct {
+	next   stratis.Manager
+	faults *Injector
+}
+
+// WrapManager returns next with the faults of in injected
+func WrapManager(next stratis.Manager, in *Injector) *Manager {
+	return &Manager{next: next, faults: in}
+}
+
+// PoolExists checks if the configured pool exists
+func (m *Manager) PoolExists(ctx context.Context) (bool, error) {
+	var exists bool
+	err := m.faults.do(ctx, "PoolExists", func() (err error) {
+		exists, err = m.next.PoolExists(ctx)
+		return err
+	})
+	return exists, err
+}
+
+// List returns all filesystems in the pool
+func (m *Manager) List(ctx context.Context) ([]stratis.Filesystem, error) {
+	var filesystems []stratis.Filesystem
+	err := m.faults.do(ctx, "List", func() (err error) {
+		filesystems, err = m.next.List(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return filesystems, nil
+}
+
+// Create creates a new filesystem with the given name and optional size limit
+func (m *Manager) Create(ctx context.Context, name string, sizeLimit *uint64) (*stratis.Filesystem, error) {
+	var fs *stratis.Filesystem
+	err := m.faults.do(ctx, "Create", func() (err error) {
+		fs, err = m.next.Create(ctx, name, sizeLimit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// Snapshot creates the filesystem name as a snapshot of origin
+func (m *Manager) Snapshot(ctx context.Context, origin, name string) (*stratis.Filesystem, error) {
+	var fs *stratis.Filesystem
+	err := m.faults.do(ctx, "Snapshot", func() (err error) {
+		fs, err = m.next.Snapshot(ctx, origin, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// Delete removes the filesystem with the given name
+func (m *Manager) Delete(ctx context.Context, name string) error {
+	return m.faults.do(ctx, "Delete", func() error {
+		return m.next.Delete(ctx, name)
+	})
+}
+
+// Rename renames the filesystem name to newName
+func (m *Manager) Rename(ctx context.Context, name, newName string) error {
+	return m.faults.do(ctx, "Rename", func() error {
+		return m.next.Rename(ctx, name, newName)
+	})
+}
+
+// GetByName returns the filesystem with the given name
+func (m *Manager) GetByName(ctx context.Context, name string) (*stratis.Filesystem, error) {
+	var fs *stratis.Filesystem
+	err := m.faults.do(ctx, "GetByName", func() (err error) {
+		fs, err = m.next.GetByName(ctx, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+var _ stratis.Manager = (*Manager)(nil)
@@ -0,0 +1,55 @@
+package fault
+
+import (
+	"context"
+
+	"github.com/kriansa/podman-volume-stratis/internal/mount"
+)
+
+// Mounter is a mount.Mounter that injects faults into the calls of another
+// one
+type Mounter struct {
+	next   mount.Mounter
+	faults *Injector
+}
+
+// WrapMounter returns next with the faults of in injected
+func WrapMounter(next mount.Mounter, in *Injector) *Mounter {
+	return &Mounter{next: next, faults: in}
+}
+
+// Mount mounts the source device to the target directory
+func (m *Mounter) Mount(ctx context.Context, source, target, fsType string) error {
+	return m.faults.do(ctx, "Mount", func() error {
+		return m.next.Mount(ctx, source, target, fsType)
+	})
+}
+
+// Unmount unmounts the target directory
+func (m *Mounter) Unmount(ctx context.Context, target string) error {
+	return m.faults.do(ctx, "Unmount", func() error {
+		return m.next.Unmount(ctx, target)
+	})
+}
+
+// IsMounted checks if the target is mounted
+func (m *Mounter) IsMounted(ctx context.Context, target string) (bool, error) {
+	var mounted bool
+	err := m.faults.do(ctx, "IsMounted", func() (err error) {
+		mounted, err = m.next.IsMounted(ctx, target)
+		return err
+	})
+	return mounted, err
+}
+
+// GetMountPoint returns the mount point for a source device
+func (m *Mounter) GetMountPoint(ctx context.Context, source string) (string, error) {
+	var mountPoint string
+	err := m.faults.do(ctx, "GetMountPoint", func() (err error) {
+		mountPoint, err = m.next.GetMountPoint(ctx, source)
+		return err
+	})
+	return mountPoint, err
+}
+
+var _ mount.Mounter = (*Mounter)(nil)
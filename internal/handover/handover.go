@@ -0,0 +1,210 @@
+package handover
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// envHandover is set for a process started by Start. Its value tells
+	// whether the inherited socket belongs to systemd.
+	envHandover = "PODMAN_VOLUME_STRATIS_HANDOVER"
+
+	modeActivated = "activated"
+	modeOwned     = "owned"
+
+	// File descriptors passed to the new process, after stdin/stdout/stderr
+	listenerFD = 3
+	readyFD    = 4
+	resumeFD   = 5
+
+	// Sent by the new process once it has started, and once it is serving
+	preparedMessage = "prepared"
+	readyMessage    = "ready"
+	// Sent by the previous process once it has stopped serving
+	resumeMessage = "resume"
+)
+
+var (
+	// inherited is set once the socket from a previous process has been taken
+	inherited bool
+	// readyFile carries the messages of this process to the previous one
+	readyFile *os.File
+)
+
+// Inherited returns the plugin socket passed by the previous process during
+// an upgrade, or nil if this process was not started by a handover.
+// activated reports whether the socket is owned by systemd.
+func Inherited() (listener net.Listener, activated bool, err error) {
+	mode, ok := os.LookupEnv(envHandover)
+	if !ok {
+		return nil, false, nil
+	}
+	os.Unsetenv(envHandover)
+
+	file := os.NewFile(listenerFD, "handover-listener")
+	defer file.Close()
+
+	listener, err = net.FileListener(file)
+	if err != nil {
+		return nil, false, fmt.Errorf("use inherited socket: %w", err)
+	}
+
+	inherited = true
+	return listener, mode == modeActivated, nil
+}
+
+// Prepared tells the previous process that this one has started, and waits
+// until that one has finished its in-flight requests and saved its state, so
+// only then may this one load it. It must be called before Inherited, and is
+// a no-op if this process was not started by a handover.
+func Prepared() error {
+	if _, ok := os.LookupEnv(envHandover); !ok {
+		return nil
+	}
+
+	readyFile = os.NewFile(readyFD, "handover-ready")
+	if _, err := fmt.Fprintln(readyFile, preparedMessage); err != nil {
+		return fmt.Errorf("signal start: %w", err)
+	}
+
+	resume := os.NewFile(resumeFD, "handover-resume")
+	defer resume.Close()
+
+	line, err := bufio.NewReader(resume).ReadString('\n')
+	if strings.TrimSpace(line) != resumeMessage {
+		if err == nil {
+			err = fmt.Errorf("unexpected message %q", line)
+		}
+		return fmt.Errorf("previous process did not hand over: %w", err)
+	}
+	return nil
+}
+
+// Ready tells the previous process that this one is now serving, so it can
+// exit. It is a no-op if this process was not started by a handover.
+func Ready() error {
+	if !inherited {
+		return nil
+	}
+
+	file := readyFile
+	if file == nil {
+		file = os.NewFile(readyFD, "handover-ready")
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintln(file, readyMessage); err != nil {
+		return fmt.Errorf("signal readiness: %w", err)
+	}
+	return nil
+}
+
+// Start executes a new instance of the running binary with the same arguments,
+// passing it the listening socket. Once the new process reports that it has
+// started, stop is called to stop serving and drain in-flight requests; the new
+// process only loads its state and serves after that. Start returns once the
+// new process reports that it is serving with its pid, or fails if it does not
+// report either within timeout. Whether stop was called tells the caller if it
+// must resume serving on failure. activated tells the new process whether the
+// socket belongs to systemd.
+func Start(listenerFile *os.File, activated bool, timeout time.Duration, stop func()) (pid int, err error) {
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("create readiness pipe: %w", err)
+	}
+	defer readyR.Close()
+
+	resumeR, resumeW, err := os.Pipe()
+	if err != nil {
+		readyW.Close()
+		return 0, fmt.Errorf("create resume pipe: %w", err)
+	}
+	defer resumeW.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		readyW.Close()
+		resumeR.Close()
+		return 0, fmt.Errorf("find executable: %w", err)
+	}
+
+	mode := modeOwned
+	if activated {
+		mode = modeActivated
+	}
+
+	// WATCHDOG_PID names us; without it the new process pings the watchdog
+	// itself once it becomes the main PID
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		return strings.HasPrefix(kv, "WATCHDOG_PID=")
+	})
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(env, envHandover+"="+mode)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{listenerFile, readyW, resumeR}
+
+	err = cmd.Start()
+	readyW.Close()
+	resumeR.Close()
+	if err != nil {
+		return 0, fmt.Errorf("start new process: %w", err)
+	}
+
+	messages := make(chan error, 2)
+	go func() {
+		reader := bufio.NewReader(readyR)
+		for _, want := range []string{preparedMessage, readyMessage} {
+			line, err := reader.ReadString('\n')
+			if strings.TrimSpace(line) != want {
+				if err == nil {
+					err = fmt.Errorf("unexpected message %q", line)
+				}
+				messages <- fmt.Errorf("new process exited before becoming ready: %w", err)
+				return
+			}
+			messages <- nil
+		}
+	}()
+
+	err = await(messages, timeout)
+	if err == nil {
+		// The new process may go ahead once we no longer serve
+		stop()
+		if _, err = fmt.Fprintln(resumeW, resumeMessage); err != nil {
+			err = fmt.Errorf("resume new process: %w", err)
+		}
+	}
+	if err == nil {
+		err = await(messages, timeout)
+	}
+
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return 0, err
+	}
+
+	// The new process outlives us; don't keep it as a zombie-in-waiting
+	pid = cmd.Process.Pid
+	_ = cmd.Process.Release()
+	return pid, nil
+}
+
+// await waits for the next message of the new process
+func await(messages <-chan error, timeout time.Duration) error {
+	select {
+	case err := <-messages:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("new process not ready after %v", timeout)
+	}
+}
@@ -0,0 +1,108 @@
+package handover
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const childGreeting = "served by new process"
+
+func TestMain(m *testing.M) {
+	// When started by Start, act as the new plugin process: wait for the
+	// old one to stop, take the socket, report readiness and serve a single
+	// connection
+	if _, ok := os.LookupEnv(envHandover); ok {
+		os.Exit(runChild())
+	}
+	os.Exit(m.Run())
+}
+
+func runChild() int {
+	if err := Prepared(); err != nil {
+		fmt.Fprintln(os.Stderr, "prepared:", err)
+		return 1
+	}
+
+	listener, _, err := Inherited()
+	if err != nil || listener == nil {
+		fmt.Fprintln(os.Stderr, "no inherited listener:", err)
+		return 1
+	}
+	if err := Ready(); err != nil {
+		fmt.Fprintln(os.Stderr, "ready:", err)
+		return 1
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		return 1
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, childGreeting)
+	return 0
+}
+
+func TestStart_HandsOverSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugin.sock")
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unixListener := listener.(*net.UnixListener)
+	unixListener.SetUnlinkOnClose(false)
+
+	file, err := unixListener.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	// The old process stops serving once the new one has started; the
+	// socket must stay in place
+	stopped := false
+	stop := func() {
+		listener.Close()
+		stopped = true
+	}
+
+	if _, err := Start(file, false, 10*time.Second, stop); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if !stopped {
+		t.Fatal("Start() did not stop the old process")
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("socket path disappeared during handover: %v", err)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("dial after handover: %v", err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read from new process: %v", err)
+	}
+	if string(got) != childGreeting {
+		t.Errorf("got %q, want %q", got, childGreeting)
+	}
+}
+
+func TestReady_NoopWithoutHandover(t *testing.T) {
+	if err := Prepared(); err != nil {
+		t.Errorf("Prepared() error = %v", err)
+	}
+	if err := Ready(); err != nil {
+		t.Errorf("Ready() error = %v", err)
+	}
+}
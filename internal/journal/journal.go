@@ -0,0 +1,259 @@
+// Package journal records operations of several steps while they run, so a
+// failure halfway, or a crash, does not leave their first steps behind. The
+// intent of each step is written down before the step runs; an operation
+// leaves the journal once it has finished or been rolled back.
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Operation is an unfinished operation on a volume
+type Operation struct {
+	// ID identifies the operation in the journal
+	ID uint64 `json:"id"`
+	// Kind is what the operation does, e.g. "create"
+	Kind string `json:"kind"`
+	// Volume is the volume the operation acts on
+	Volume string `json:"volume"`
+	// Started is when the operation began
+	Started time.Time `json:"started"`
+	// Steps are the steps the operation has begun, in order. The last one
+	// may or may not have run.
+	Steps []Step `json:"steps,omitempty"`
+}
+
+// Step is a step of an operation
+type Step struct {
+	// Action is what the step does, e.g. "mount"
+	Action string `json:"action"`
+	// Target is what the step acts on, e.g. a path
+	Target string `json:"target,omitempty"`
+}
+
+func (o *Operation) clone() Operation {
+	c := *o
+	c.Steps = slices.Clone(o.Steps)
+	return c
+}
+
+// Journal keeps the unfinished operations in memory and persists them as a
+// JSON file. Every change is written through to disk before it returns.
+type Journal struct {
+	mu   sync.Mutex
+	path string
+	ops  map[uint64]*Operation
+	next uint64
+}
+
+// New creates an empty journal. If path is empty, the journal is
+// memory-only.
+func New(path string) *Journal {
+	return &Journal{
+		path: path,
+		ops:  make(map[uint64]*Operation),
+		next: 1,
+	}
+}
+
+// Open loads the journal from path. A missing file yields an empty journal.
+func Open(path string) (*Journal, error) {
+	j := New(path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return j, nil
+		}
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+
+	var ops []*Operation
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return nil, fmt.Errorf("parse journal: %w", err)
+	}
+	for _, op := range ops {
+		j.ops[op.ID] = op
+		j.next = max(j.next, op.ID+1)
+	}
+
+	return j, nil
+}
+
+// Pending returns a copy of the unfinished operations, oldest first
+func (j *Journal) Pending() []Operation {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	pending := make([]Operation, 0, len(j.ops))
+	for _, op := range j.ops {
+		pending = append(pending, op.clone())
+	}
+	sort.Slice(pending, func(a, b int) bool { return pending[a].ID < pending[b].ID })
+	return pending
+}
+
+// Begin records the start of an operation of the given kind on a volume
+func (j *Journal) Begin(kind, volume string) (*Tx, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	op := &Operation{
+		ID:      j.next,
+		Kind:    kind,
+		Volume:  volume,
+		Started: time.Now(),
+	}
+	j.next++
+	j.ops[op.ID] = op
+
+	if err := j.save(); err != nil {
+		delete(j.ops, op.ID)
+		return nil, err
+	}
+	return &Tx{journal: j, id: op.ID}, nil
+}
+
+// Resume returns the transaction of an unfinished operation, to finish it
+// or roll it back
+func (j *Journal) Resume(id uint64) (*Tx, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.ops[id]; !ok {
+		return nil, fmt.Errorf("operation %d is not in the journal", id)
+	}
+	return &Tx{journal: j, id: id}, nil
+}
+
+// update applies fn to an operation and persists the result, undoing the
+// change if it cannot be persisted
+func (j *Journal) update(id uint64, fn func(op *Operation)) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	op, ok := j.ops[id]
+	if !ok {
+		return fmt.Errorf("operation %d is not in the journal", id)
+	}
+	prev := op.clone()
+	fn(op)
+
+	if err := j.save(); err != nil {
+		*op = prev
+		return err
+	}
+	return nil
+}
+
+// remove forgets an operation and persists the result. An operation that
+// has ended stays forgotten even if that cannot be persisted yet; the next
+// change persists it.
+func (j *Journal) remove(id uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.ops[id]; !ok {
+		return nil
+	}
+	delete(j.ops, id)
+	return j.save()
+}
+
+// save atomically writes the journal file. Must be called with mu held.
+func (j *Journal) save() error {
+	if j.path == "" {
+		return nil
+	}
+
+	ops := make([]*Operation, 0, len(j.ops))
+	for _, op := range j.ops {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(a, b int) bool { return ops[a].ID < ops[b].ID })
+
+	data, err := json.MarshalIndent(ops, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode journal: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return fmt.Errorf("create journal directory: %w", err)
+	}
+
+	// The intent must be on disk before the step runs
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return fmt.Errorf("replace journal: %w", err)
+	}
+	return nil
+}
+
+// Tx is an operation in progress
+type Tx struct {
+	journal *Journal
+	id      uint64
+}
+
+// Record records the intent of a step before it runs
+func (tx *Tx) Record(action, target string) error {
+	return tx.journal.update(tx.id, func(op *Operation) {
+		op.Steps = append(op.Steps, Step{Action: action, Target: target})
+	})
+}
+
+// Forget drops the last step recorded, for one that turned out to change
+// nothing, so it is not undone. Like End, it holds even if it cannot be
+// persisted yet.
+func (tx *Tx) Forget() error {
+	j := tx.journal
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	op, ok := j.ops[tx.id]
+	if !ok || len(op.Steps) == 0 {
+		return nil
+	}
+	op.Steps = op.Steps[:len(op.Steps)-1]
+	return j.save()
+}
+
+// Steps returns the steps recorded so far, in order
+func (tx *Tx) Steps() []Step {
+	tx.journal.mu.Lock()
+	defer tx.journal.mu.Unlock()
+
+	op, ok := tx.journal.ops[tx.id]
+	if !ok {
+		return nil
+	}
+	return slices.Clone(op.Steps)
+}
+
+// End removes the operation from the journal, once it has finished or been
+// rolled back
+func (tx *Tx) End() error {
+	return tx.journal.remove(tx.id)
+}
@@ -0,0 +1,93 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal_PersistAndReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.json")
+
+	j, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	done, err := j.Begin("create", "vol1")
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if err := done.Record("create_filesystem", "vol1"); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if err := done.End(); err != nil {
+		t.Fatalf("End() error = %v", err)
+	}
+
+	cut, err := j.Begin("mount", "vol2")
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	for _, step := range []Step{{"make_mount_point", "/mnt/vol2"}, {"mount", "/mnt/vol2"}, {"mount_ref", "c1"}} {
+		if err := cut.Record(step.Action, step.Target); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+	if err := cut.Forget(); err != nil {
+		t.Fatalf("Forget() error = %v", err)
+	}
+
+	reloaded, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() after save error = %v", err)
+	}
+
+	pending := reloaded.Pending()
+	if len(pending) != 1 {
+		t.Fatalf("Pending() = %+v, want the unfinished mount", pending)
+	}
+	op := pending[0]
+	if op.Kind != "mount" || op.Volume != "vol2" || op.Started.IsZero() {
+		t.Errorf("Pending()[0] = %+v, want the mount of vol2", op)
+	}
+	if len(op.Steps) != 2 || op.Steps[1] != (Step{"mount", "/mnt/vol2"}) {
+		t.Errorf("Steps = %+v, want the two steps left after Forget()", op.Steps)
+	}
+
+	// New operations never reuse the IDs of reloaded ones
+	next, err := reloaded.Begin("create", "vol3")
+	if err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+	if next.id <= op.ID {
+		t.Errorf("new operation ID = %d, want more than %d", next.id, op.ID)
+	}
+
+	tx, err := reloaded.Resume(op.ID)
+	if err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if err := tx.End(); err != nil {
+		t.Fatalf("End() error = %v", err)
+	}
+	if _, err := reloaded.Resume(op.ID); err == nil {
+		t.Error("Resume() of an ended operation succeeded")
+	}
+}
+
+func TestJournal_BeginFailsWhenNotPersisted(t *testing.T) {
+	dir := t.TempDir()
+	// The journal's directory cannot be created under a file
+	if err := os.WriteFile(filepath.Join(dir, "file"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	j := New(filepath.Join(dir, "file", "journal.json"))
+
+	if _, err := j.Begin("create", "vol1"); err == nil {
+		t.Fatal("Begin() succeeded without persisting the operation")
+	}
+	if pending := j.Pending(); len(pending) != 0 {
+		t.Errorf("Pending() = %+v, want nothing after a failed Begin()", pending)
+	}
+}
@@ -0,0 +1,210 @@
+package mount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+	"github.com/kriansa/podman-volume-stratis/internal/procmounts"
+)
+
+// BindMounter implements Mounter for sources that are directories, such as
+// those of the memory backend. As root it bind mounts them; without root,
+// where mounting is not allowed, it replaces the mount point with a symlink
+// to the source instead.
+type BindMounter struct {
+	basePath string // Base mount path for validation
+	symlink  bool   // Symlink instead of bind mounting
+}
+
+// NewBindMounter creates a bind mounter, which symlinks when not running as root
+func NewBindMounter(basePath string) *BindMounter {
+	return &BindMounter{
+		basePath: basePath,
+		symlink:  os.Geteuid() != 0,
+	}
+}
+
+// checkTarget rejects targets outside the base path
+func (m *BindMounter) checkTarget(target string) (string, error) {
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return "", fmt.Errorf("get absolute path: %w", err)
+	}
+
+	absBase, err := filepath.Abs(m.basePath)
+	if err != nil {
+		return "", fmt.Errorf("get absolute base path: %w", err)
+	}
+
+	if !strings.HasPrefix(absTarget, absBase+"/") && absTarget != absBase {
+		return "", fmt.Errorf("mount target %q is not under base path %q", target, m.basePath)
+	}
+	return absTarget, nil
+}
+
+// Mount bind mounts, or symlinks, the source directory to the target
+// directory. fsType is ignored.
+func (m *BindMounter) Mount(ctx context.Context, source, target, fsType string) error {
+	absTarget, err := m.checkTarget(target)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("mount %s to %s: %w", source, target, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("mount %s to %s: source is not a directory", source, target)
+	}
+
+	if m.symlink {
+		log.Debug("symlinking directory", "source", source, "target", absTarget)
+
+		// The target is the empty directory the driver prepared
+		if err := os.Remove(absTarget); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("mount %s to %s: %w", source, target, err)
+		}
+		if err := os.Symlink(source, absTarget); err != nil {
+			return fmt.Errorf("mount %s to %s: %w", source, target, err)
+		}
+		return nil
+	}
+
+	log.Debug("bind mounting directory", "source", source, "target", absTarget)
+
+	err = runSyscall(ctx, "mount", absTarget, func() error {
+		return syscall.Mount(source, absTarget, "", syscall.MS_BIND, "")
+	})
+	if err != nil {
+		return fmt.Errorf("mount %s to %s: %w", source, target, err)
+	}
+	return nil
+}
+
+// Unmount unmounts, or removes the symlink at, the target directory
+func (m *BindMounter) Unmount(ctx context.Context, target string) error {
+	if m.symlink {
+		log.Debug("removing symlink", "target", target)
+
+		info, err := os.Lstat(target)
+		if err != nil {
+			return fmt.Errorf("unmount %s: %w", target, err)
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("unmount %s: %w", target, syscall.EINVAL)
+		}
+		if err := os.Remove(target); err != nil {
+			return fmt.Errorf("unmount %s: %w", target, err)
+		}
+		return nil
+	}
+
+	log.Debug("unmounting", "target", target)
+
+	err := runSyscall(ctx, "unmount", target, func() error {
+		return syscall.Unmount(target, 0)
+	})
+	if err != nil {
+		return fmt.Errorf("unmount %s: %w", target, err)
+	}
+	return nil
+}
+
+// IsMounted checks if the target is mounted, or is a symlink
+func (m *BindMounter) IsMounted(ctx context.Context, target string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return false, fmt.Errorf("get absolute path: %w", err)
+	}
+	if err := settle(ctx, absTarget); err != nil {
+		return false, err
+	}
+
+	if m.symlink {
+		info, err := os.Lstat(absTarget)
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return info.Mode()&os.ModeSymlink != 0, nil
+	}
+
+	mounts, err := procmounts.Parse()
+	if err != nil {
+		return false, fmt.Errorf("unable to parse mounts: %w", err)
+	}
+
+	for _, mount := range mounts {
+		if mount.MountPoint == absTarget {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetMountPoint returns where the source directory is mounted, or symlinked,
+// under the base path. The mount table names the device of a bind mount, not
+// its directory, so mount points are matched by the directory they show.
+func (m *BindMounter) GetMountPoint(ctx context.Context, source string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	absBase, err := filepath.Abs(m.basePath)
+	if err != nil {
+		return "", fmt.Errorf("get absolute base path: %w", err)
+	}
+
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	if m.symlink {
+		entries, err := os.ReadDir(absBase)
+		if err != nil {
+			return "", fmt.Errorf("read base path: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.Type()&os.ModeSymlink == 0 {
+				continue
+			}
+			path := filepath.Join(absBase, entry.Name())
+			if info, err := os.Stat(path); err == nil && os.SameFile(info, sourceInfo) {
+				return path, nil
+			}
+		}
+		return "", nil
+	}
+
+	mounts, err := procmounts.Parse()
+	if err != nil {
+		return "", fmt.Errorf("unable to parse mounts: %w", err)
+	}
+
+	for _, mount := range mounts {
+		if !strings.HasPrefix(mount.MountPoint, absBase+"/") {
+			continue
+		}
+		if info, err := os.Stat(mount.MountPoint); err == nil && os.SameFile(info, sourceInfo) {
+			return mount.MountPoint, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,59 @@
+package mount
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+)
+
+func TestMain(m *testing.M) {
+	log.Setup(false)
+	os.Exit(m.Run())
+}
+
+func TestBindMounter_Symlink(t *testing.T) {
+	ctx := context.Background()
+	base := t.TempDir()
+	source := t.TempDir()
+	target := filepath.Join(base, "vol1")
+
+	m := &BindMounter{basePath: base, symlink: true}
+
+	if err := os.Mkdir(target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Mount(ctx, source, target, "xfs"); err != nil {
+		t.Fatalf("Mount() error = %v", err)
+	}
+
+	if mounted, err := m.IsMounted(ctx, target); err != nil || !mounted {
+		t.Errorf("IsMounted() = %v, %v, want true", mounted, err)
+	}
+	if got, err := m.GetMountPoint(ctx, source); err != nil || got != target {
+		t.Errorf("GetMountPoint() = %q, %v, want %q", got, err, target)
+	}
+
+	if err := m.Unmount(ctx, target); err != nil {
+		t.Fatalf("Unmount() error = %v", err)
+	}
+	if mounted, err := m.IsMounted(ctx, target); err != nil || mounted {
+		t.Errorf("IsMounted() after Unmount() = %v, %v, want false", mounted, err)
+	}
+	if got, err := m.GetMountPoint(ctx, source); err != nil || got != "" {
+		t.Errorf("GetMountPoint() after Unmount() = %q, %v, want none", got, err)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("Unmount() removed the source: %v", err)
+	}
+}
+
+func TestBindMounter_RejectsTargetOutsideBase(t *testing.T) {
+	m := &BindMounter{basePath: t.TempDir(), symlink: true}
+
+	if err := m.Mount(context.Background(), t.TempDir(), "/etc/vol1", "xfs"); err == nil {
+		t.Error("Mount() outside the base path succeeded, want an error")
+	}
+}
@@ -1,14 +1,34 @@
 package mount
 
-// Mounter defines the interface for mount/unmount operations
+import (
+	"context"
+	"fmt"
+)
+
+// Mounter defines the interface for mount/unmount operations. Every operation
+// gives up when its context is done, returning an error that wraps the
+// context's error. A syscall given up on may still take effect, so IsMounted
+// and later syscalls on its target wait for it to return first.
 type Mounter interface {
 	// Mount mounts the source device to the target directory
-	Mount(source, target, fsType string) error
+	Mount(ctx context.Context, source, target, fsType string) error
 	// Unmount unmounts the target directory
-	Unmount(target string) error
+	Unmount(ctx context.Context, target string) error
 	// IsMounted checks if the target is mounted
-	IsMounted(target string) (bool, error)
+	IsMounted(ctx context.Context, target string) (bool, error)
 	// GetMountPoint returns the mount point for a source device
 	// Returns empty string if not mounted
-	GetMountPoint(source string) (string, error)
+	GetMountPoint(ctx context.Context, source string) (string, error)
+}
+
+// NewMounter creates a Mounter of the given kind: "syscall" or "bind"
+func NewMounter(kind, basePath string) (Mounter, error) {
+	switch kind {
+	case "syscall":
+		return NewSyscallMounter(basePath), nil
+	case "bind":
+		return NewBindMounter(basePath), nil
+	default:
+		return nil, fmt.Errorf("unknown mounter: %s (use 'syscall' or 'bind')", kind)
+	}
 }
@@ -1,9 +1,11 @@
 package mount
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/kriansa/podman-volume-stratis/internal/procmounts"
@@ -23,7 +25,7 @@ func NewSyscallMounter(basePath string) *SyscallMounter {
 }
 
 // Mount mounts the source device to the target directory
-func (m *SyscallMounter) Mount(source, target, fsType string) error {
+func (m *SyscallMounter) Mount(ctx context.Context, source, target, fsType string) error {
 	// Validate target is under base path
 	absTarget, err := filepath.Abs(target)
 	if err != nil {
@@ -42,7 +44,10 @@ func (m *SyscallMounter) Mount(source, target, fsType string) error {
 	log.Debug("mounting filesystem", "source", source, "target", target, "type", fsType)
 
 	// Mount with no special flags
-	if err := syscall.Mount(source, target, fsType, 0, ""); err != nil {
+	err = runSyscall(ctx, "mount", absTarget, func() error {
+		return syscall.Mount(source, target, fsType, 0, "")
+	})
+	if err != nil {
 		return fmt.Errorf("mount %s to %s: %w", source, target, err)
 	}
 
@@ -51,10 +56,13 @@ func (m *SyscallMounter) Mount(source, target, fsType string) error {
 }
 
 // Unmount unmounts the target directory
-func (m *SyscallMounter) Unmount(target string) error {
+func (m *SyscallMounter) Unmount(ctx context.Context, target string) error {
 	log.Debug("unmounting", "target", target)
 
-	if err := syscall.Unmount(target, 0); err != nil {
+	err := runSyscall(ctx, "unmount", target, func() error {
+		return syscall.Unmount(target, 0)
+	})
+	if err != nil {
 		return fmt.Errorf("unmount %s: %w", target, err)
 	}
 
@@ -63,11 +71,18 @@ func (m *SyscallMounter) Unmount(target string) error {
 }
 
 // IsMounted checks if the target is mounted
-func (m *SyscallMounter) IsMounted(target string) (bool, error) {
+func (m *SyscallMounter) IsMounted(ctx context.Context, target string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
 	absTarget, err := filepath.Abs(target)
 	if err != nil {
 		return false, fmt.Errorf("get absolute path: %w", err)
 	}
+	if err := settle(ctx, absTarget); err != nil {
+		return false, err
+	}
 
 	mounts, err := procmounts.Parse()
 	if err != nil {
@@ -84,7 +99,11 @@ func (m *SyscallMounter) IsMounted(target string) (bool, error) {
 }
 
 // GetMountPoint returns the mount point for a source device
-func (m *SyscallMounter) GetMountPoint(source string) (string, error) {
+func (m *SyscallMounter) GetMountPoint(ctx context.Context, source string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
 	// Resolve source to absolute path
 	absSource, err := filepath.EvalSymlinks(source)
 	if err != nil {
@@ -106,3 +125,69 @@ func (m *SyscallMounter) GetMountPoint(source string) (string, error) {
 
 	return "", nil
 }
+
+// abandoned holds the syscalls that outlived their context until they return,
+// by target. The driver serialises the operations on a volume, so there is at
+// most one per target.
+var abandoned = struct {
+	sync.Mutex
+	targets map[string]chan struct{}
+}{targets: map[string]chan struct{}{}}
+
+// settle waits until a syscall abandoned on target has returned, so that what
+// comes next sees its outcome: a rollback must not find a volume unmounted
+// while the mount it gave up on can still succeed. It fails if ctx is done
+// first.
+func settle(ctx context.Context, target string) error {
+	target = filepath.Clean(target)
+	abandoned.Lock()
+	finished, ok := abandoned.targets[target]
+	abandoned.Unlock()
+	if !ok {
+		return nil
+	}
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("wait for abandoned syscall on %s: %w", target, ctx.Err())
+	}
+}
+
+// runSyscall runs fn, a syscall named op on target, returning early when ctx
+// is done. Mount syscalls cannot be interrupted, so fn keeps running in the
+// background; later syscalls and mount checks on target wait for it to
+// return first.
+func runSyscall(ctx context.Context, op, target string, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := settle(ctx, target); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		target = filepath.Clean(target)
+		finished := make(chan struct{})
+		abandoned.Lock()
+		abandoned.targets[target] = finished
+		abandoned.Unlock()
+
+		go func() {
+			err := <-done
+			abandoned.Lock()
+			delete(abandoned.targets, target)
+			abandoned.Unlock()
+			close(finished)
+			log.Warn("syscall finished after its deadline", "op", op, "target", target, "error", err)
+		}()
+		return ctx.Err()
+	}
+}
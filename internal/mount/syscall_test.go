@@ -0,0 +1,46 @@
+package mount
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunSyscall_LaterCallsWaitForAbandonedOne(t *testing.T) {
+	target := "/mnt/vol1"
+	release := make(chan struct{})
+	ran := make(chan struct{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := runSyscall(ctx, "mount", target, func() error {
+		<-release
+		close(ran)
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("runSyscall() error = %v, want DeadlineExceeded", err)
+	}
+
+	// Still in flight: a check with a short deadline gives up
+	waitCtx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := settle(waitCtx, target); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("settle() while in flight error = %v, want DeadlineExceeded", err)
+	}
+
+	// Once it returns, the next syscall on the target sees its outcome
+	close(release)
+	err = runSyscall(context.Background(), "unmount", target, func() error {
+		select {
+		case <-ran:
+			return nil
+		default:
+			return errors.New("ran before the abandoned syscall returned")
+		}
+	})
+	if err != nil {
+		t.Errorf("runSyscall() after the abandoned one error = %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+// Package podman talks to the local Podman REST API, to learn which
+// containers reference which volumes and to remove volumes
+package podman
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	// ErrNoSuchVolume is returned for a volume Podman has no record of
+	ErrNoSuchVolume = errors.New("no such volume")
+	// ErrVolumeInUse is returned for a volume a container still uses
+	ErrVolumeInUse = errors.New("volume in use")
+)
+
+// Container is a container and the named volumes it references
+type Container struct {
+	ID   string
+	Name string
+	// Volumes are the names of the volumes it mounts
+	Volumes []string
+}
+
+// Client talks to Podman over its API socket
+type Client struct {
+	http *http.Client
+}
+
+// NewClient creates a Client for the Podman API socket at socketPath, e.g.
+// /run/podman/podman.sock
+func NewClient(socketPath string) *Client {
+	return &Client{
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+}
+
+// listedContainer is a container as listed by the Docker-compatible API,
+// which reports the name of each volume mount
+type listedContainer struct {
+	ID     string   `json:"Id"`
+	Names  []string `json:"Names"`
+	Mounts []struct {
+		Type string `json:"Type"`
+		Name string `json:"Name"`
+	} `json:"Mounts"`
+}
+
+// Containers lists every container, running or not
+func (c *Client) Containers(ctx context.Context) ([]Container, error) {
+	// The host is ignored by the unix dialer
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://podman/containers/json?all=true", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("connect to podman: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+
+	var listed []listedContainer
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		return nil, fmt.Errorf("decode containers: %w", err)
+	}
+
+	containers := make([]Container, 0, len(listed))
+	for _, l := range listed {
+		ctr := Container{ID: l.ID, Name: l.ID}
+		if len(l.Names) > 0 {
+			ctr.Name = strings.TrimPrefix(l.Names[0], "/")
+		}
+		for _, m := range l.Mounts {
+			if m.Type == "volume" && m.Name != "" {
+				ctr.Volumes = append(ctr.Volumes, m.Name)
+			}
+		}
+		containers = append(containers, ctr)
+	}
+	return containers, nil
+}
+
+// RemoveVolume removes a volume through Podman, which drops its record of the
+// volume and asks the volume's plugin to remove it
+func (c *Client) RemoveVolume(ctx context.Context, name string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, "http://podman/volumes/"+url.PathEscape(name), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("connect to podman: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("volume %s: %w", name, ErrNoSuchVolume)
+	case http.StatusConflict:
+		return fmt.Errorf("volume %s: %w", name, ErrVolumeInUse)
+	default:
+		return statusError(resp)
+	}
+}
+
+// statusError describes an unexpected response, with Podman's message
+func statusError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("podman returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,109 @@
+package podman
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// serve runs a stand-in for the Podman API on a unix socket and returns a
+// Client for it
+func serve(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "podman.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	return NewClient(path)
+}
+
+func TestClient_Containers(t *testing.T) {
+	client := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers/json" || r.URL.Query().Get("all") != "true" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+			{"Id": "abc", "Names": ["/web"], "Mounts": [
+				{"Type": "volume", "Name": "data", "Driver": "stratis"},
+				{"Type": "bind", "Source": "/etc/hosts"},
+				{"Type": "volume", "Name": "cache", "Driver": "local"}
+			]},
+			{"Id": "def", "Names": [], "Mounts": []}
+		]`))
+	})
+
+	got, err := client.Containers(context.Background())
+	if err != nil {
+		t.Fatalf("Containers() error = %v", err)
+	}
+
+	want := []Container{
+		{ID: "abc", Name: "web", Volumes: []string{"data", "cache"}},
+		{ID: "def", Name: "def"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Containers() = %+v, want %+v", got, want)
+	}
+}
+
+func TestClient_ContainersError(t *testing.T) {
+	client := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database is locked", http.StatusInternalServerError)
+	})
+
+	_, err := client.Containers(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "database is locked") {
+		t.Errorf("Containers() error = %v, want Podman's message", err)
+	}
+
+	missing := NewClient(filepath.Join(t.TempDir(), "missing.sock"))
+	if _, err := missing.Containers(context.Background()); err == nil {
+		t.Error("Containers() without Podman succeeded, want an error")
+	}
+}
+
+func TestClient_RemoveVolume(t *testing.T) {
+	var removed []string
+	client := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		name, ok := strings.CutPrefix(r.URL.Path, "/volumes/")
+		switch {
+		case r.Method != http.MethodDelete || !ok:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		case name == "busy":
+			http.Error(w, "volume is being used", http.StatusConflict)
+		case name == "unknown":
+			http.Error(w, "no such volume", http.StatusNotFound)
+		default:
+			removed = append(removed, name)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	ctx := context.Background()
+	if err := client.RemoveVolume(ctx, "data"); err != nil {
+		t.Fatalf("RemoveVolume() error = %v", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"data"}) {
+		t.Errorf("removed %v, want [data]", removed)
+	}
+
+	if err := client.RemoveVolume(ctx, "busy"); !errors.Is(err, ErrVolumeInUse) {
+		t.Errorf("RemoveVolume(busy) error = %v, want ErrVolumeInUse", err)
+	}
+	if err := client.RemoveVolume(ctx, "unknown"); !errors.Is(err, ErrNoSuchVolume) {
+		t.Errorf("RemoveVolume(unknown) error = %v, want ErrNoSuchVolume", err)
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/docker/go-plugins-helpers/sdk"
+	"github.com/docker/go-plugins-helpers/volume"
+
+	"github.com/kriansa/podman-volume-stratis/internal/audit"
+)
+
+const manifest = `{"Implements": ["VolumeDriver"]}`
+
+// Server serves the Docker volume plugin protocol for a volume.Driver.
+// Unlike volume.Handler, it knows which process is calling, so every
+// driver call can be recorded in the audit log.
+type Server struct {
+	driver volume.Driver
+	audit  *audit.Logger
+	http   *http.Server
+}
+
+// New creates a Server for the driver. auditLog may be nil to disable auditing.
+func New(driver volume.Driver, auditLog *audit.Logger) *Server {
+	s := &Server{
+		driver: driver,
+		audit:  auditLog,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/Plugin.Activate", s.activate)
+	mux.HandleFunc("/VolumeDriver.Create", s.create)
+	mux.HandleFunc("/VolumeDriver.Remove", s.remove)
+	mux.HandleFunc("/VolumeDriver.Mount", s.mount)
+	mux.HandleFunc("/VolumeDriver.Unmount", s.unmount)
+	mux.HandleFunc("/VolumeDriver.Path", s.path)
+	mux.HandleFunc("/VolumeDriver.Get", s.get)
+	mux.HandleFunc("/VolumeDriver.List", s.list)
+	mux.HandleFunc("/VolumeDriver.Capabilities", s.capabilities)
+
+	s.http = &http.Server{
+		Handler:     mux,
+		ConnContext: audit.WithPeerCredentials,
+	}
+
+	return s
+}
+
+// Serve accepts connections on the listener until the server is shut down.
+// It returns http.ErrServerClosed after Shutdown.
+func (s *Server) Serve(l net.Listener) error {
+	return s.http.Serve(l)
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests to
+// finish, or for ctx to expire
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.http.Shutdown(ctx)
+}
+
+func (s *Server) activate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", sdk.DefaultContentTypeV1_1)
+	fmt.Fprintln(w, manifest)
+}
+
+func (s *Server) create(w http.ResponseWriter, r *http.Request) {
+	req := &volume.CreateRequest{}
+	if err := sdk.DecodeRequest(w, r, req); err != nil {
+		return
+	}
+	err := s.audited(r.Context(), "create", req.Name, req.Options, func() error {
+		return s.driver.Create(req)
+	})
+	respond(w, struct{}{}, err)
+}
+
+func (s *Server) remove(w http.ResponseWriter, r *http.Request) {
+	req := &volume.RemoveRequest{}
+	if err := sdk.DecodeRequest(w, r, req); err != nil {
+		return
+	}
+	err := s.audited(r.Context(), "remove", req.Name, nil, func() error {
+		return s.driver.Remove(req)
+	})
+	respond(w, struct{}{}, err)
+}
+
+func (s *Server) mount(w http.ResponseWriter, r *http.Request) {
+	req := &volume.MountRequest{}
+	if err := sdk.DecodeRequest(w, r, req); err != nil {
+		return
+	}
+	var res *volume.MountResponse
+	err := s.audited(r.Context(), "mount", req.Name, map[string]string{"id": req.ID}, func() (err error) {
+		res, err = s.driver.Mount(req)
+		return err
+	})
+	respond(w, res, err)
+}
+
+func (s *Server) unmount(w http.ResponseWriter, r *http.Request) {
+	req := &volume.UnmountRequest{}
+	if err := sdk.DecodeRequest(w, r, req); err != nil {
+		return
+	}
+	err := s.audited(r.Context(), "unmount", req.Name, map[string]string{"id": req.ID}, func() error {
+		return s.driver.Unmount(req)
+	})
+	respond(w, struct{}{}, err)
+}
+
+func (s *Server) path(w http.ResponseWriter, r *http.Request) {
+	req := &volume.PathRequest{}
+	if err := sdk.DecodeRequest(w, r, req); err != nil {
+		return
+	}
+	var res *volume.PathResponse
+	err := s.audited(r.Context(), "path", req.Name, nil, func() (err error) {
+		res, err = s.driver.Path(req)
+		return err
+	})
+	respond(w, res, err)
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	req := &volume.GetRequest{}
+	if err := sdk.DecodeRequest(w, r, req); err != nil {
+		return
+	}
+	var res *volume.GetResponse
+	err := s.audited(r.Context(), "get", req.Name, nil, func() (err error) {
+		res, err = s.driver.Get(req)
+		return err
+	})
+	respond(w, res, err)
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	var res *volume.ListResponse
+	err := s.audited(r.Context(), "list", "", nil, func() (err error) {
+		res, err = s.driver.List()
+		return err
+	})
+	respond(w, res, err)
+}
+
+func (s *Server) capabilities(w http.ResponseWriter, r *http.Request) {
+	var res *volume.CapabilitiesResponse
+	_ = s.audited(r.Context(), "capabilities", "", nil, func() error {
+		res = s.driver.Capabilities()
+		return nil
+	})
+	respond(w, res, nil)
+}
+
+// audited runs a driver call and records its outcome in the audit log
+func (s *Server) audited(ctx context.Context, op, name string, options map[string]string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	s.audit.RecordOperation(ctx, op, name, options, start, err)
+	return err
+}
+
+// respond encodes the driver result or error in the plugin protocol format
+func respond(w http.ResponseWriter, res any, err error) {
+	if err != nil {
+		sdk.EncodeResponse(w, volume.NewErrorResponse(err.Error()), true)
+		return
+	}
+	sdk.EncodeResponse(w, res, false)
+}
@@ -0,0 +1,212 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Volume holds what the plugin knows about a volume beyond what stratisd stores
+type Volume struct {
+	// MountIDs are the callers currently holding the volume mounted
+	MountIDs []string `json:"mount_ids,omitempty"`
+	// LastUsed is when the volume was last mounted or unmounted
+	LastUsed time.Time `json:"last_used,omitzero"`
+	// Created is when the volume was created, or restored from the trash
+	Created time.Time `json:"created,omitzero"`
+	// Options are the normalized options the volume was created with
+	Options map[string]string `json:"options,omitempty"`
+	// Fingerprint is a digest of Options, to tell whether another create
+	// asks for the same volume
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Protected makes the plugin refuse to remove the volume
+	Protected bool `json:"protected,omitempty"`
+	// Ephemeral has the volume destroyed once its last mount reference is
+	// released
+	Ephemeral bool `json:"ephemeral,omitempty"`
+	// TTL has the volume destroyed once it has gone unused for that long
+	TTL time.Duration `json:"ttl,omitempty"`
+	// SecureErase says whether the volume is erased before its filesystem
+	// is destroyed; nil for the plugin's default
+	SecureErase *bool `json:"secure_erase,omitempty"`
+	// Unreferenced is when the plugin first found no container referencing
+	// the volume, since it last found one that did
+	Unreferenced time.Time `json:"unreferenced,omitzero"`
+}
+
+// clone returns a deep copy of the volume
+func (v *Volume) clone() Volume {
+	c := *v
+	c.MountIDs = slices.Clone(v.MountIDs)
+	c.Options = maps.Clone(v.Options)
+	if v.SecureErase != nil {
+		erase := *v.SecureErase
+		c.SecureErase = &erase
+	}
+	return c
+}
+
+// Store keeps per-volume state in memory and persists it as a JSON file.
+// Every change is written through to disk.
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	volumes map[string]*Volume
+	dirty   bool
+}
+
+// New creates an empty store. If path is empty, the store is memory-only.
+func New(path string) *Store {
+	return &Store{
+		path:    path,
+		volumes: make(map[string]*Volume),
+	}
+}
+
+// Open loads the store from path. A missing file yields an empty store.
+func Open(path string) (*Store, error) {
+	s := New(path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("read state file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &s.volumes); err != nil {
+		return nil, fmt.Errorf("parse state file: %w", err)
+	}
+	if s.volumes == nil {
+		s.volumes = make(map[string]*Volume)
+	}
+
+	return s, nil
+}
+
+// Get returns a copy of the state of a volume
+func (s *Store) Get(name string) (Volume, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.volumes[name]
+	if !ok {
+		return Volume{}, false
+	}
+	return v.clone(), true
+}
+
+// All returns a copy of the state of every volume
+func (s *Store) All() map[string]Volume {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := make(map[string]Volume, len(s.volumes))
+	for name, v := range s.volumes {
+		all[name] = v.clone()
+	}
+	return all
+}
+
+// Update applies fn to the state of a volume, creating it if needed, and
+// persists the result
+func (s *Store) Update(name string, fn func(v *Volume)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.volumes[name]
+	if !ok {
+		v = &Volume{}
+		s.volumes[name] = v
+	}
+	fn(v)
+
+	s.dirty = true
+	return s.save()
+}
+
+// Put replaces the state of a volume with v, dropping whatever was kept for
+// an earlier volume of the same name, and persists the result
+func (s *Store) Put(name string, v Volume) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v = v.clone()
+	s.volumes[name] = &v
+
+	s.dirty = true
+	return s.save()
+}
+
+// Delete forgets a volume and persists the result
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.volumes[name]; !ok {
+		return nil
+	}
+	delete(s.volumes, name)
+
+	s.dirty = true
+	return s.save()
+}
+
+// Rename moves the state of a volume to newName, replacing any state there,
+// and persists the result
+func (s *Store) Rename(name, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.volumes[name]
+	if !ok || name == newName {
+		return nil
+	}
+	delete(s.volumes, name)
+	s.volumes[newName] = v
+
+	s.dirty = true
+	return s.save()
+}
+
+// Save persists any changes that could not be written earlier
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.save()
+}
+
+// save atomically writes the state file if there are unsaved changes.
+// Must be called with mu held.
+func (s *Store) save() error {
+	if !s.dirty || s.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.volumes, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write state file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("replace state file: %w", err)
+	}
+
+	s.dirty = false
+	return nil
+}
@@ -0,0 +1,112 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore_PersistAndReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	if err := s.Update("vol1", func(v *Volume) { v.MountIDs = append(v.MountIDs, "c1") }); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := s.Update("vol2", func(v *Volume) {}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := s.Delete("vol2"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	reloaded, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() after save error = %v", err)
+	}
+
+	v, ok := reloaded.Get("vol1")
+	if !ok {
+		t.Fatal("vol1 missing after reload")
+	}
+	if len(v.MountIDs) != 1 || v.MountIDs[0] != "c1" {
+		t.Errorf("MountIDs = %v, want [c1]", v.MountIDs)
+	}
+	if _, ok := reloaded.Get("vol2"); ok {
+		t.Error("vol2 should have been deleted")
+	}
+}
+
+func TestStore_GetReturnsCopy(t *testing.T) {
+	s := New("")
+	_ = s.Update("vol1", func(v *Volume) { v.MountIDs = []string{"c1"} })
+
+	v, _ := s.Get("vol1")
+	v.MountIDs[0] = "changed"
+
+	again, _ := s.Get("vol1")
+	if again.MountIDs[0] != "c1" {
+		t.Errorf("store was modified through a copy: %v", again.MountIDs)
+	}
+}
+
+func TestStore_MemoryOnly(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+
+	s := New("")
+	if err := s.Update("vol1", func(v *Volume) {}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("memory-only store wrote %d files", len(entries))
+	}
+}
+
+func TestStore_Rename(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s := New(path)
+	_ = s.Update("vol1", func(v *Volume) { v.Fingerprint = "sha256:1" })
+
+	if err := s.Rename("vol1", "vol2"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+
+	reloaded, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if _, ok := reloaded.Get("vol1"); ok {
+		t.Error("vol1 still has state after Rename()")
+	}
+	if v, _ := reloaded.Get("vol2"); v.Fingerprint != "sha256:1" {
+		t.Errorf("vol2 fingerprint = %q, want the state of vol1", v.Fingerprint)
+	}
+}
+
+func TestStore_PutReplaces(t *testing.T) {
+	s := New("")
+	_ = s.Update("vol1", func(v *Volume) {
+		v.MountIDs = []string{"c1"}
+		v.Unreferenced = time.Now()
+	})
+
+	if err := s.Put("vol1", Volume{Fingerprint: "sha256:2"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	v, _ := s.Get("vol1")
+	if v.Fingerprint != "sha256:2" || len(v.MountIDs) != 0 || !v.Unreferenced.IsZero() {
+		t.Errorf("Get() after Put() = %+v, want only the new state", v)
+	}
+}
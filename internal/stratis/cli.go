@@ -2,18 +2,30 @@ package stratis
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"math/big"
 	"os/exec"
 	"regexp"
-	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/kriansa/podman-volume-stratis/internal/log"
 )
 
-// CLIManager implements Manager using the stratis CLI
+// cliWaitDelay is how long a stratis process killed for its deadline may keep
+// its output open before it is abandoned
+const cliWaitDelay = time.Second
+
+// CLIManager implements Manager using the stratis CLI. Filesystems are read
+// from stratisd's engine state report, which has exact sizes; with stratis-cli
+// versions that cannot report, the rounded table output is parsed instead.
 type CLIManager struct {
 	pool string
+	// reportUnsupported is set once stratis-cli fails to produce a report
+	reportUnsupported atomic.Bool
 }
 
 // NewCLIManager creates a new Stratis CLI manager for the given pool
@@ -23,24 +35,47 @@ func NewCLIManager(pool string) *CLIManager {
 	}
 }
 
-// stratis runs a stratis command and returns the output
-func (m *CLIManager) stratis(args ...string) ([]byte, error) {
-	cmd := exec.Command("stratis", args...)
+// stratis runs a stratis command and returns the output. The process is
+// killed when ctx is done.
+func (m *CLIManager) stratis(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "stratis", args...)
+	cmd.WaitDelay = cliWaitDelay
 	output, err := cmd.CombinedOutput()
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		// The process was killed; its exit status tells nothing
+		return output, fmt.Errorf("stratis %s: %w", strings.Join(args, " "), ctxErr)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// stratis ran and failed; its output says why
+		message := strings.TrimSpace(string(output))
+		if message == "" {
+			message = err.Error()
+		}
+		return output, newError("stratis "+strings.Join(args, " "), 0, message)
+	}
 	if err != nil {
-		return output, fmt.Errorf("stratis %s: %w (output: %q)", strings.Join(args, " "), err, string(output))
+		return output, fmt.Errorf("stratis %s: %w", strings.Join(args, " "), err)
 	}
 	return output, nil
 }
 
 // PoolExists checks if the configured pool exists
-func (m *CLIManager) PoolExists() (bool, error) {
+func (m *CLIManager) PoolExists(ctx context.Context) (bool, error) {
 	log.Debug("checking pool exists", "pool", m.pool)
 
-	_, err := m.stratis("pool", "list", "--name", m.pool)
+	report, ok, err := m.report(ctx)
 	if err != nil {
-		// Check if it's a "pool not found" error
-		if strings.Contains(err.Error(), "pool which does not exist") {
+		return false, fmt.Errorf("check pool: %w", err)
+	}
+	if ok {
+		_, exists := report.pool(m.pool)
+		return exists, nil
+	}
+
+	_, err = m.stratis(ctx, "pool", "list", "--name", m.pool)
+	if err != nil {
+		if errors.Is(err, ErrPoolNotFound) {
 			return false, nil
 		}
 		return false, fmt.Errorf("check pool: %w", err)
@@ -50,10 +85,26 @@ func (m *CLIManager) PoolExists() (bool, error) {
 }
 
 // List returns all filesystems in the pool
-func (m *CLIManager) List() ([]Filesystem, error) {
+func (m *CLIManager) List(ctx context.Context) ([]Filesystem, error) {
 	log.Debug("listing filesystems", "pool", m.pool)
 
-	output, err := m.stratis("fs", "list", m.pool)
+	report, ok, err := m.report(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list filesystems: %w", err)
+	}
+	if ok {
+		pool, exists := report.pool(m.pool)
+		if !exists {
+			return nil, fmt.Errorf("list filesystems: %w: %s", ErrPoolNotFound, m.pool)
+		}
+		filesystems := make([]Filesystem, 0, len(pool.Filesystems))
+		for _, fs := range pool.Filesystems {
+			filesystems = append(filesystems, pool.filesystem(fs))
+		}
+		return filesystems, nil
+	}
+
+	output, err := m.stratis(ctx, "fs", "list", m.pool)
 	if err != nil {
 		return nil, fmt.Errorf("list filesystems: %w", err)
 	}
@@ -61,7 +112,8 @@ func (m *CLIManager) List() ([]Filesystem, error) {
 	return m.parseFilesystemTable(string(output))
 }
 
-// parseFilesystemTable parses the table output from stratis fs list
+// parseFilesystemTable parses the table output from stratis fs list, for
+// stratis-cli versions without reports. Sizes in it are rounded.
 // Example output:
 // Pool          Filesystem   Total / Used / Free / Limit       Device                          UUID
 // podman_vols   vol1         1 GiB / 74 MiB / 950 MiB / None   /dev/stratis/podman_vols/vol1   ad719e64-ae83-4997-bf2e-787c7824be0e
@@ -81,7 +133,7 @@ func (m *CLIManager) parseFilesystemTable(output string) ([]Filesystem, error) {
 
 		fs, err := m.parseFilesystemTableLine(line)
 		if err != nil {
-			log.Debug("failed to parse fs line", "line", line, "error", err)
+			log.Warn("failed to parse stratis fs list line", "line", line, "error", err)
 			continue
 		}
 
@@ -112,7 +164,7 @@ func (m *CLIManager) parseFilesystemTableLine(line string) (*Filesystem, error)
 	uuid := matches[5]
 
 	// Parse the size info: "1 GiB / 74 MiB / 950 MiB / None"
-	total, used, free, sizeLimit, err := parseSizeInfo(sizeInfo)
+	total, used, _, sizeLimit, err := parseSizeInfo(sizeInfo)
 	if err != nil {
 		return nil, fmt.Errorf("parse size info: %w", err)
 	}
@@ -123,7 +175,7 @@ func (m *CLIManager) parseFilesystemTableLine(line string) (*Filesystem, error)
 		DevicePath: devicePath,
 		Total:      total,
 		Used:       used,
-		Free:       free,
+		Free:       freeBytes(total, used),
 		SizeLimit:  sizeLimit,
 		UUID:       uuid,
 	}, nil
@@ -164,20 +216,21 @@ func parseSizeInfo(s string) (total, used, free uint64, sizeLimit *uint64, err e
 	return total, used, free, sizeLimit, nil
 }
 
-// parseSize parses a size string like "1 GiB" or "74 MiB" to bytes
+// parseSize parses a size string like "1 GiB" or "74 MiB" to bytes. Decimal
+// values are computed exactly, though stratis-cli has already rounded them.
 func parseSize(s string) (uint64, error) {
 	parts := strings.Fields(s)
 	if len(parts) != 2 {
 		return 0, fmt.Errorf("expected 'value unit' format, got %q", s)
 	}
 
-	value, err := strconv.ParseFloat(parts[0], 64)
-	if err != nil {
-		return 0, fmt.Errorf("parse value: %w", err)
+	value, ok := new(big.Rat).SetString(parts[0])
+	if !ok || value.Sign() < 0 {
+		return 0, fmt.Errorf("parse value: invalid number %q", parts[0])
 	}
 
 	unit := strings.ToUpper(parts[1])
-	var multiplier float64
+	var multiplier uint64
 	switch unit {
 	case "B":
 		multiplier = 1
@@ -201,11 +254,16 @@ func parseSize(s string) (uint64, error) {
 		return 0, fmt.Errorf("unknown unit %q", unit)
 	}
 
-	return uint64(value * multiplier), nil
+	bytes := value.Mul(value, new(big.Rat).SetUint64(multiplier))
+	whole := new(big.Int).Quo(bytes.Num(), bytes.Denom())
+	if !whole.IsUint64() {
+		return 0, fmt.Errorf("size %q out of range", s)
+	}
+	return whole.Uint64(), nil
 }
 
 // Create creates a new filesystem with the given name and optional size limit
-func (m *CLIManager) Create(name string, sizeLimit *uint64) (*Filesystem, error) {
+func (m *CLIManager) Create(ctx context.Context, name string, sizeLimit *uint64) (*Filesystem, error) {
 	log.Debug("creating filesystem", "name", name, "pool", m.pool, "sizeLimit", sizeLimit)
 
 	args := []string{"fs", "create"}
@@ -214,12 +272,12 @@ func (m *CLIManager) Create(name string, sizeLimit *uint64) (*Filesystem, error)
 	}
 	args = append(args, m.pool, name)
 
-	if _, err := m.stratis(args...); err != nil {
+	if _, err := m.stratis(ctx, args...); err != nil {
 		return nil, fmt.Errorf("create filesystem: %w", err)
 	}
 
 	// Get the created filesystem
-	fs, err := m.GetByName(name)
+	fs, err := m.GetByName(ctx, name)
 	if err != nil {
 		return nil, fmt.Errorf("get created filesystem: %w", err)
 	}
@@ -228,6 +286,23 @@ func (m *CLIManager) Create(name string, sizeLimit *uint64) (*Filesystem, error)
 	return fs, nil
 }
 
+// Snapshot creates the filesystem name as a snapshot of origin
+func (m *CLIManager) Snapshot(ctx context.Context, origin, name string) (*Filesystem, error) {
+	log.Debug("snapshotting filesystem", "origin", origin, "name", name, "pool", m.pool)
+
+	if _, err := m.stratis(ctx, "fs", "snapshot", m.pool, origin, name); err != nil {
+		return nil, fmt.Errorf("snapshot filesystem: %w", err)
+	}
+
+	fs, err := m.GetByName(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("get snapshot filesystem: %w", err)
+	}
+
+	log.Debug("filesystem snapshotted", "origin", origin, "name", name, "device", fs.DevicePath)
+	return fs, nil
+}
+
 // formatSize formats a size in bytes to a string suitable for stratis (e.g., "1GiB")
 func formatSize(bytes uint64) string {
 	// Stratis accepts sizes like "1GiB", "500MiB", etc.
@@ -251,10 +326,10 @@ func formatSize(bytes uint64) string {
 }
 
 // Delete removes the filesystem with the given name
-func (m *CLIManager) Delete(name string) error {
+func (m *CLIManager) Delete(ctx context.Context, name string) error {
 	log.Debug("deleting filesystem", "name", name, "pool", m.pool)
 
-	if _, err := m.stratis("fs", "destroy", m.pool, name); err != nil {
+	if _, err := m.stratis(ctx, "fs", "destroy", m.pool, name); err != nil {
 		return fmt.Errorf("delete filesystem: %w", err)
 	}
 
@@ -262,15 +337,44 @@ func (m *CLIManager) Delete(name string) error {
 	return nil
 }
 
+// Rename renames the filesystem name to newName
+func (m *CLIManager) Rename(ctx context.Context, name, newName string) error {
+	log.Debug("renaming filesystem", "name", name, "newName", newName, "pool", m.pool)
+
+	if _, err := m.stratis(ctx, "fs", "rename", m.pool, name, newName); err != nil {
+		return fmt.Errorf("rename filesystem: %w", err)
+	}
+
+	log.Debug("filesystem renamed", "name", name, "newName", newName)
+	return nil
+}
+
 // GetByName returns the filesystem with the given name
 // Returns nil if not found
-func (m *CLIManager) GetByName(name string) (*Filesystem, error) {
+func (m *CLIManager) GetByName(ctx context.Context, name string) (*Filesystem, error) {
 	log.Debug("getting filesystem by name", "name", name, "pool", m.pool)
 
-	output, err := m.stratis("fs", "list", "--name="+name, m.pool)
+	report, ok, err := m.report(ctx)
 	if err != nil {
-		// Check if it's a "filesystem not found" error
-		if strings.Contains(err.Error(), "filesystem which does not exist") {
+		return nil, fmt.Errorf("get filesystem: %w", err)
+	}
+	if ok {
+		pool, exists := report.pool(m.pool)
+		if !exists {
+			return nil, fmt.Errorf("get filesystem: %w: %s", ErrPoolNotFound, m.pool)
+		}
+		for _, fs := range pool.Filesystems {
+			if fs.Name == name {
+				f := pool.filesystem(fs)
+				return &f, nil
+			}
+		}
+		return nil, ErrNotFound
+	}
+
+	output, err := m.stratis(ctx, "fs", "list", "--name="+name, m.pool)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
 			return nil, ErrNotFound
 		}
 		return nil, fmt.Errorf("get filesystem: %w", err)
@@ -279,7 +383,8 @@ func (m *CLIManager) GetByName(name string) (*Filesystem, error) {
 	return m.parseDetailedOutput(string(output))
 }
 
-// parseDetailedOutput parses the detailed output from stratis fs list --name
+// parseDetailedOutput parses the detailed output from stratis fs list --name,
+// for stratis-cli versions without reports
 // Example:
 // UUID: 5944af0c-f520-4773-9006-edcd79a66d50
 // Name: vol1
@@ -324,10 +429,6 @@ func (m *CLIManager) parseDetailedOutput(output string) (*Filesystem, error) {
 					fs.Used = size
 				}
 			}
-		} else if val, ok := strings.CutPrefix(line, "Free:"); ok {
-			if size, err := parseSize(strings.TrimSpace(val)); err == nil {
-				fs.Free = size
-			}
 		} else if val, ok := strings.CutPrefix(line, "Size Limit:"); ok {
 			if limitStr := strings.TrimSpace(val); limitStr != "None" {
 				if limit, err := parseSize(limitStr); err == nil {
@@ -340,6 +441,7 @@ func (m *CLIManager) parseDetailedOutput(output string) (*Filesystem, error) {
 	if fs.Name == "" {
 		return nil, fmt.Errorf("failed to parse filesystem details")
 	}
+	fs.Free = freeBytes(fs.Total, fs.Used)
 
 	return fs, nil
 }
@@ -0,0 +1,166 @@
+package stratis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+)
+
+// sectorSize is the unit stratisd counts some sizes in
+const sectorSize = 512
+
+// engineReport is the part of stratisd's engine_state_report the plugin uses
+type engineReport struct {
+	Pools []reportPool `json:"pools"`
+}
+
+type reportPool struct {
+	Name        string             `json:"name"`
+	Filesystems []reportFilesystem `json:"filesystems"`
+}
+
+type reportFilesystem struct {
+	Name      string     `json:"name"`
+	UUID      string     `json:"uuid"`
+	Size      reportSize `json:"size"`
+	Used      reportSize `json:"used"`
+	SizeLimit reportSize `json:"size_limit"`
+}
+
+// reportSize is a size in the engine report. stratisd writes sizes as
+// strings of exact bytes, or sectors on some versions, e.g. "1073741824" or
+// "2097152 sectors". A missing size, or "Unavailable", is unknown.
+type reportSize struct {
+	Bytes uint64
+	Known bool
+}
+
+func (s *reportSize) UnmarshalJSON(data []byte) error {
+	*s = reportSize{}
+
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	var text string
+	switch v := raw.(type) {
+	case nil:
+		return nil
+	case float64:
+		// Numbers are exact only up to 2^53; parse them as written
+		text = string(data)
+	case string:
+		text = v
+	default:
+		return fmt.Errorf("unexpected size %s", data)
+	}
+
+	fields := strings.Fields(text)
+	if len(fields) == 0 || fields[0] == "Unavailable" {
+		return nil
+	}
+	if len(fields) > 2 {
+		return fmt.Errorf("unexpected size %q", text)
+	}
+
+	n, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("unexpected size %q: %w", text, err)
+	}
+
+	unit := ""
+	if len(fields) == 2 {
+		unit = strings.ToLower(fields[1])
+	}
+	switch unit {
+	case "", "b", "bytes":
+	case "sectors":
+		n *= sectorSize
+	default:
+		return fmt.Errorf("unexpected size unit in %q", text)
+	}
+
+	s.Bytes, s.Known = n, true
+	return nil
+}
+
+// parseReport parses an engine_state_report
+func parseReport(data []byte) (*engineReport, error) {
+	var report engineReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("parse stratis report: %w", err)
+	}
+	return &report, nil
+}
+
+// pool returns the configured pool from the report, if it exists
+func (r *engineReport) pool(name string) (*reportPool, bool) {
+	for i := range r.Pools {
+		if r.Pools[i].Name == name {
+			return &r.Pools[i], true
+		}
+	}
+	return nil, false
+}
+
+// filesystem converts a filesystem of the report
+func (p *reportPool) filesystem(fs reportFilesystem) Filesystem {
+	f := Filesystem{
+		Name:       fs.Name,
+		Pool:       p.Name,
+		DevicePath: "/dev/stratis/" + p.Name + "/" + fs.Name,
+		Total:      fs.Size.Bytes,
+		Used:       fs.Used.Bytes,
+		UUID:       fs.UUID,
+	}
+	f.Free = freeBytes(f.Total, f.Used)
+	if fs.SizeLimit.Known {
+		limit := fs.SizeLimit.Bytes
+		f.SizeLimit = &limit
+	}
+	return f
+}
+
+// report returns stratisd's engine state report. ok is false when stratis-cli
+// cannot produce one, and the table output must be parsed instead. Only a
+// stratis-cli too old to have the command is never asked again; a report
+// that cannot be parsed is asked for again next time.
+func (m *CLIManager) report(ctx context.Context) (report *engineReport, ok bool, err error) {
+	if m.reportUnsupported.Load() {
+		return nil, false, nil
+	}
+
+	output, err := m.stratis(ctx, "report", "engine_state_report")
+	if err != nil {
+		if !unsupportedCommand(err, output) {
+			return nil, false, err
+		}
+		log.Info("stratis-cli has no report command, parsing table output instead")
+		m.reportUnsupported.Store(true)
+		return nil, false, nil
+	}
+
+	report, err = parseReport(output)
+	if err != nil {
+		log.Warn("unrecognized stratis report, parsing table output instead", "error", err)
+		return nil, false, nil
+	}
+
+	return report, true, nil
+}
+
+// unsupportedCommand reports whether stratis-cli ran and rejected the
+// command with a usage error, as it does for commands it does not have
+func unsupportedCommand(err error, output []byte) bool {
+	var cliErr *Error
+	if !errors.As(err, &cliErr) {
+		return false
+	}
+	return strings.Contains(string(output), "invalid choice") || strings.HasPrefix(string(output), "usage:")
+}
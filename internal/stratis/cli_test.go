@@ -0,0 +1,170 @@
+package stratis
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseReport(t *testing.T) {
+	report, err := parseReport([]byte(`{
+		"pools": [
+			{
+				"name": "podman_vols",
+				"uuid": "0a3c9e1a",
+				"filesystems": [
+					{"name": "vol1", "uuid": "5944af0c", "size": "1073741824", "used": "77594624", "size_limit": "1073741824"},
+					{"name": "vol2", "uuid": "ad719e64", "size": "2097152 sectors", "used": "Unavailable"},
+					{"name": "vol3", "uuid": "c0ffee00", "size": 1099511627776, "used": "1099511627777"}
+				]
+			},
+			{"name": "other", "filesystems": []}
+		],
+		"errored_pools": []
+	}`))
+	if err != nil {
+		t.Fatalf("parseReport() error = %v", err)
+	}
+
+	pool, ok := report.pool("podman_vols")
+	if !ok {
+		t.Fatal("pool podman_vols not found")
+	}
+	if _, ok := report.pool("missing"); ok {
+		t.Error("pool missing found")
+	}
+
+	vol1 := pool.filesystem(pool.Filesystems[0])
+	if vol1.Total != 1073741824 || vol1.Used != 77594624 || vol1.Free != 1073741824-77594624 {
+		t.Errorf("vol1 sizes = %d/%d/%d, want exact bytes", vol1.Total, vol1.Used, vol1.Free)
+	}
+	if vol1.SizeLimit == nil || *vol1.SizeLimit != 1073741824 {
+		t.Errorf("vol1 SizeLimit = %v, want 1073741824", vol1.SizeLimit)
+	}
+	if vol1.DevicePath != "/dev/stratis/podman_vols/vol1" || vol1.UUID != "5944af0c" {
+		t.Errorf("vol1 = %+v, want its device and UUID", vol1)
+	}
+
+	vol2 := pool.filesystem(pool.Filesystems[1])
+	if vol2.Total != 1073741824 || vol2.Used != 0 || vol2.SizeLimit != nil {
+		t.Errorf("vol2 = %+v, want 1 GiB in sectors, unknown usage and no limit", vol2)
+	}
+
+	// Used may briefly exceed the logical size while stratisd extends it
+	if vol3 := pool.filesystem(pool.Filesystems[2]); vol3.Free != 0 {
+		t.Errorf("vol3 Free = %d, want 0", vol3.Free)
+	}
+}
+
+func TestParseReport_RejectsUnknownSizes(t *testing.T) {
+	_, err := parseReport([]byte(`{"pools": [{"name": "p", "filesystems": [{"name": "fs", "size": "1 GiB"}]}]}`))
+	if err == nil {
+		t.Error("parseReport() accepted a rounded size")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+	}{
+		{"512 B", 512},
+		{"1 GiB", 1 << 30},
+		{"74 MiB", 74 << 20},
+		{"1.1 TiB", 1209462790553},
+		// A float64 multiplication gives 2009
+		{"2.01 KB", 2010},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if err != nil {
+			t.Errorf("parseSize(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+// fakeStratis puts a stratis script in PATH that runs body
+func fakeStratis(t *testing.T, body string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	script := "#!/bin/sh\n" + body + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "stratis"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestCLIManager_FallsBackToTable(t *testing.T) {
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeStratis(t, `echo "$1" >> `+calls+`
+case "$1" in
+report)
+	echo "stratis: error: argument subcommand: invalid choice: 'report'" >&2
+	exit 2 ;;
+fs)
+	echo "Pool          Filesystem   Total / Used / Free / Limit       Device                          UUID"
+	echo "podman_vols   vol1         1 GiB / 74 MiB / 950 MiB / None   /dev/stratis/podman_vols/vol1   ad719e64" ;;
+esac`)
+
+	m := NewCLIManager("podman_vols")
+	for range 2 {
+		filesystems, err := m.List(context.Background())
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if len(filesystems) != 1 || filesystems[0].Name != "vol1" || filesystems[0].Free != 1<<30-74<<20 {
+			t.Fatalf("List() = %+v, want vol1 from the table", filesystems)
+		}
+	}
+
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "report\nfs\nfs\n" {
+		t.Errorf("stratis called with %q, want one report attempt", got)
+	}
+}
+
+func TestCLIManager_RetriesReportAfterParseFailure(t *testing.T) {
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeStratis(t, `if grep -q report `+calls+` 2>/dev/null; then
+	report='{"pools": [{"name": "podman_vols", "filesystems": [{"name": "vol1", "size": "1073741824", "used": "0"}]}]}'
+else
+	report='{"pools": ['
+fi
+echo "$1" >> `+calls+`
+case "$1" in
+report)
+	echo "$report" ;;
+fs)
+	echo "Pool          Filesystem   Total / Used / Free / Limit       Device                          UUID"
+	echo "podman_vols   vol1         1 GiB / 74 MiB / 950 MiB / None   /dev/stratis/podman_vols/vol1   ad719e64" ;;
+esac`)
+
+	m := NewCLIManager("podman_vols")
+	for range 2 {
+		filesystems, err := m.List(context.Background())
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		if len(filesystems) != 1 || filesystems[0].Name != "vol1" {
+			t.Fatalf("List() = %+v, want vol1", filesystems)
+		}
+	}
+
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "report\nfs\nreport\n" {
+		t.Errorf("stratis called with %q, want the report asked for again", got)
+	}
+}
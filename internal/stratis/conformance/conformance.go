@@ -0,0 +1,286 @@
+// Package conformance checks that an implementation of stratis.Manager
+// behaves as the driver expects of every backend: the same results for the
+// same operations, and the same shared errors for the same failures.
+//
+// A backend's tests run it with a function that returns a manager of a new,
+// empty pool:
+//
+//	func TestConformance(t *testing.T) {
+//		conformance.Run(t, func(t *testing.T) stratis.Manager {
+//			return newManagerOfEmptyPool(t)
+//		})
+//	}
+package conformance
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+)
+
+// testLimit is the size limit the suite creates filesystems with; every
+// backend accepts it
+const testLimit = 1 << 30
+
+// NewManager returns a manager of a new pool with no filesystems, which is
+// cleaned up when the test ends
+type NewManager func(t *testing.T) stratis.Manager
+
+// Run runs the conformance suite against the managers newManager returns,
+// one per subtest
+func Run(t *testing.T, newManager NewManager) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, m stratis.Manager)
+	}{
+		{"PoolExists", testPoolExists},
+		{"CreateWithoutLimit", testCreateWithoutLimit},
+		{"CreateWithLimit", testCreateWithLimit},
+		{"CreateDuplicate", testCreateDuplicate},
+		{"NotFound", testNotFound},
+		{"ListConsistent", testListConsistent},
+		{"Delete", testDelete},
+		{"Rename", testRename},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newManager(t))
+		})
+	}
+}
+
+func testPoolExists(t *testing.T, m stratis.Manager) {
+	exists, err := m.PoolExists(context.Background())
+	if err != nil || !exists {
+		t.Fatalf("PoolExists() = %v, %v, want true", exists, err)
+	}
+
+	filesystems, err := m.List(context.Background())
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(filesystems) != 0 {
+		t.Errorf("List() = %d filesystems, want none in a new pool", len(filesystems))
+	}
+}
+
+func testCreateWithoutLimit(t *testing.T, m stratis.Manager) {
+	fs := mustCreate(t, m, "thin", nil)
+
+	if fs.SizeLimit != nil {
+		t.Errorf("SizeLimit = %d, want none", *fs.SizeLimit)
+	}
+	if fs.Total == 0 {
+		t.Error("Total = 0, want the size of the filesystem")
+	}
+	checkFilesystem(t, fs, "thin")
+}
+
+func testCreateWithLimit(t *testing.T, m stratis.Manager) {
+	limit := uint64(testLimit)
+	fs := mustCreate(t, m, "limited", &limit)
+
+	if fs.SizeLimit == nil || *fs.SizeLimit != limit {
+		t.Errorf("SizeLimit = %v, want %d", fs.SizeLimit, limit)
+	}
+	// A limited filesystem is created as large as its limit
+	if fs.Total != limit {
+		t.Errorf("Total = %d, want the limit %d", fs.Total, limit)
+	}
+	checkFilesystem(t, fs, "limited")
+}
+
+func testCreateDuplicate(t *testing.T, m stratis.Manager) {
+	ctx := context.Background()
+	limit := uint64(testLimit)
+	thin := mustCreate(t, m, "thin", nil)
+	mustCreate(t, m, "limited", &limit)
+
+	if _, err := m.Create(ctx, "thin", nil); !errors.Is(err, stratis.ErrAlreadyExists) {
+		t.Errorf("Create() of a taken name error = %v, want ErrAlreadyExists", err)
+	}
+	if _, err := m.Create(ctx, "limited", &limit); !errors.Is(err, stratis.ErrAlreadyExists) {
+		t.Errorf("Create() of a taken name with the same limit error = %v, want ErrAlreadyExists", err)
+	}
+
+	// The filesystem that was there is left alone
+	got, err := m.GetByName(ctx, "thin")
+	if err != nil {
+		t.Fatalf("GetByName() error = %v", err)
+	}
+	if got.UUID != thin.UUID {
+		t.Errorf("UUID = %q after a duplicate Create(), want %q", got.UUID, thin.UUID)
+	}
+}
+
+func testNotFound(t *testing.T, m stratis.Manager) {
+	ctx := context.Background()
+	mustCreate(t, m, "present", nil)
+
+	// A missing filesystem is not mistaken for a missing pool
+	fs, err := m.GetByName(ctx, "missing")
+	if !errors.Is(err, stratis.ErrNotFound) || errors.Is(err, stratis.ErrPoolNotFound) {
+		t.Errorf("GetByName() of a missing filesystem = %v, %v, want ErrNotFound", fs, err)
+	}
+	err = m.Delete(ctx, "missing")
+	if !errors.Is(err, stratis.ErrNotFound) || errors.Is(err, stratis.ErrPoolNotFound) {
+		t.Errorf("Delete() of a missing filesystem error = %v, want ErrNotFound", err)
+	}
+}
+
+func testListConsistent(t *testing.T, m stratis.Manager) {
+	ctx := context.Background()
+	limit := uint64(testLimit)
+	created := map[string]*stratis.Filesystem{
+		"thin":    mustCreate(t, m, "thin", nil),
+		"limited": mustCreate(t, m, "limited", &limit),
+	}
+
+	filesystems, err := m.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(filesystems) != len(created) {
+		t.Fatalf("List() = %d filesystems, want %d", len(filesystems), len(created))
+	}
+
+	for i := range filesystems {
+		listed := &filesystems[i]
+		want, ok := created[listed.Name]
+		if !ok {
+			t.Errorf("List() has %q, which was not created", listed.Name)
+			continue
+		}
+		checkFilesystem(t, listed, listed.Name)
+		checkSame(t, "List()", listed, want)
+
+		got, err := m.GetByName(ctx, listed.Name)
+		if err != nil {
+			t.Errorf("GetByName(%q) error = %v", listed.Name, err)
+			continue
+		}
+		checkSame(t, "GetByName()", got, listed)
+	}
+}
+
+func testDelete(t *testing.T, m stratis.Manager) {
+	ctx := context.Background()
+	first := mustCreate(t, m, "gone", nil)
+	mustCreate(t, m, "kept", nil)
+
+	if err := m.Delete(ctx, "gone"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	if _, err := m.GetByName(ctx, "gone"); !errors.Is(err, stratis.ErrNotFound) {
+		t.Errorf("GetByName() after Delete() error = %v, want ErrNotFound", err)
+	}
+	filesystems, err := m.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(filesystems) != 1 || filesystems[0].Name != "kept" {
+		t.Errorf("List() after Delete() = %+v, want only kept", filesystems)
+	}
+	if err := m.Delete(ctx, "gone"); !errors.Is(err, stratis.ErrNotFound) {
+		t.Errorf("second Delete() error = %v, want ErrNotFound", err)
+	}
+
+	// The name is free again, for a new filesystem
+	again := mustCreate(t, m, "gone", nil)
+	if again.UUID == first.UUID {
+		t.Errorf("recreated filesystem has the UUID %q of the deleted one", again.UUID)
+	}
+}
+
+func testRename(t *testing.T, m stratis.Manager) {
+	ctx := context.Background()
+	limit := uint64(testLimit)
+	before := mustCreate(t, m, "old", &limit)
+	mustCreate(t, m, "taken", nil)
+
+	if err := m.Rename(ctx, "old", "new"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+
+	if _, err := m.GetByName(ctx, "old"); !errors.Is(err, stratis.ErrNotFound) {
+		t.Errorf("GetByName() of the old name error = %v, want ErrNotFound", err)
+	}
+	after, err := m.GetByName(ctx, "new")
+	if err != nil {
+		t.Fatalf("GetByName() of the new name error = %v", err)
+	}
+	checkFilesystem(t, after, "new")
+	if after.UUID != before.UUID {
+		t.Errorf("UUID = %q after Rename(), want %q", after.UUID, before.UUID)
+	}
+	if after.SizeLimit == nil || *after.SizeLimit != limit {
+		t.Errorf("SizeLimit = %v after Rename(), want %d", after.SizeLimit, limit)
+	}
+
+	if err := m.Rename(ctx, "new", "taken"); !errors.Is(err, stratis.ErrAlreadyExists) {
+		t.Errorf("Rename() to a taken name error = %v, want ErrAlreadyExists", err)
+	}
+	if err := m.Rename(ctx, "missing", "other"); !errors.Is(err, stratis.ErrNotFound) {
+		t.Errorf("Rename() of a missing filesystem error = %v, want ErrNotFound", err)
+	}
+
+	// The old name is free again
+	mustCreate(t, m, "old", nil)
+}
+
+func mustCreate(t *testing.T, m stratis.Manager, name string, sizeLimit *uint64) *stratis.Filesystem {
+	t.Helper()
+
+	fs, err := m.Create(context.Background(), name, sizeLimit)
+	if err != nil {
+		t.Fatalf("Create(%q) error = %v", name, err)
+	}
+	if fs == nil {
+		t.Fatalf("Create(%q) returned no filesystem", name)
+	}
+	return fs
+}
+
+// checkFilesystem checks the fields every backend must fill in
+func checkFilesystem(t *testing.T, fs *stratis.Filesystem, name string) {
+	t.Helper()
+
+	if fs.Name != name {
+		t.Errorf("Name = %q, want %q", fs.Name, name)
+	}
+	if fs.Pool == "" {
+		t.Errorf("%s: Pool is empty", name)
+	}
+	if fs.DevicePath == "" {
+		t.Errorf("%s: DevicePath is empty", name)
+	}
+	if fs.UUID == "" {
+		t.Errorf("%s: UUID is empty", name)
+	}
+	// Free is derived the same way by every backend
+	if fs.Used <= fs.Total && fs.Free != fs.Total-fs.Used {
+		t.Errorf("%s: Free = %d, want Total - Used = %d", name, fs.Free, fs.Total-fs.Used)
+	}
+	if fs.Used > fs.Total && fs.Free != 0 {
+		t.Errorf("%s: Free = %d with Used over Total, want 0", name, fs.Free)
+	}
+}
+
+// checkSame checks that two results describe the same filesystem. Usage
+// may change between calls, so it is not compared.
+func checkSame(t *testing.T, op string, got, want *stratis.Filesystem) {
+	t.Helper()
+
+	if got.Name != want.Name || got.Pool != want.Pool || got.DevicePath != want.DevicePath ||
+		got.UUID != want.UUID || got.Total != want.Total {
+		t.Errorf("%s = %+v, want %+v", op, *got, *want)
+	}
+	if (got.SizeLimit == nil) != (want.SizeLimit == nil) ||
+		got.SizeLimit != nil && *got.SizeLimit != *want.SizeLimit {
+		t.Errorf("%s %s: SizeLimit = %v, want %v", op, got.Name, got.SizeLimit, want.SizeLimit)
+	}
+}
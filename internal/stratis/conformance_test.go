@@ -0,0 +1,57 @@
+package stratis_test
+
+import (
+	"testing"
+
+	"github.com/kriansa/podman-volume-stratis/internal/stratis"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis/conformance"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis/stratistest"
+)
+
+func TestConformance_DBus(t *testing.T) {
+	conformance.Run(t, func(t *testing.T) stratis.Manager {
+		s := stratistest.Start(t)
+		s.AddPool("podman_vols")
+
+		m, err := stratis.NewDBusManager("podman_vols", stratis.WithConnectFunc(func() (stratis.DBusConnection, error) {
+			conn, err := s.Connect()
+			if err != nil {
+				return nil, err
+			}
+			return conn, nil
+		}))
+		if err != nil {
+			t.Fatalf("NewDBusManager() error = %v", err)
+		}
+		t.Cleanup(func() { m.Close() })
+		return m
+	})
+}
+
+func TestConformance_CLI(t *testing.T) {
+	conformance.Run(t, func(t *testing.T) stratis.Manager {
+		stratistest.InstallCLI(t, "podman_vols")
+		return stratis.NewCLIManager("podman_vols")
+	})
+}
+
+func TestConformance_Loopfile(t *testing.T) {
+	conformance.Run(t, func(t *testing.T) stratis.Manager {
+		stratis.FakeLoopTools(t)
+		m, err := stratis.NewLoopfileManager("dev", t.TempDir())
+		if err != nil {
+			t.Fatalf("NewLoopfileManager() error = %v", err)
+		}
+		return m
+	})
+}
+
+func TestConformance_Memory(t *testing.T) {
+	conformance.Run(t, func(t *testing.T) stratis.Manager {
+		m, err := stratis.NewMemoryManager("dev", t.TempDir())
+		if err != nil {
+			t.Fatalf("NewMemoryManager() error = %v", err)
+		}
+		return m
+	})
+}
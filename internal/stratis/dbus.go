@@ -1,8 +1,10 @@
 package stratis
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/godbus/dbus/v5"
@@ -15,17 +17,34 @@ const (
 	dbusRootPath      = "/org/storage/stratis3"
 	dbusObjectManager = "org.freedesktop.DBus.ObjectManager"
 
-	// Interface versions - using r8 as the latest stable
-	dbusPoolInterface       = "org.storage.stratis3.pool.r8"
-	dbusFilesystemInterface = "org.storage.stratis3.filesystem.r8"
+	// createSignalTimeout is how long Create waits for stratisd to announce
+	// the new filesystem before querying it directly
+	createSignalTimeout = 5 * time.Second
 )
 
 // DBusManager implements Manager using the stratisd DBus API
 type DBusManager struct {
 	pool      string
-	poolPath  dbus.ObjectPath // cached pool object path
-	conn      DBusConnection
 	connectFn func() (DBusConnection, error) // for reconnection
+	cache     *objectCache
+	// rev is the revision of stratisd's API in use
+	rev revision
+
+	// initialConn is the connection given by WithConnection
+	initialConn DBusConnection
+
+	mu   sync.Mutex // guards the fields below
+	conn DBusConnection
+	// gen is incremented on every reconnect
+	gen uint64
+	// stopSignals stops following stratisd signals; nil if they are unavailable
+	stopSignals func()
+	closed      bool
+	// done is closed by Close, cutting reconnect backoffs short
+	done chan struct{}
+
+	// reconnectMu serializes reconnects
+	reconnectMu sync.Mutex
 }
 
 // DBusManagerOption is a functional option for DBusManager
@@ -34,16 +53,26 @@ type DBusManagerOption func(*DBusManager)
 // WithConnection sets a custom DBus connection (for testing)
 func WithConnection(conn DBusConnection) DBusManagerOption {
 	return func(m *DBusManager) {
-		m.conn = conn
+		m.initialConn = conn
 		m.connectFn = nil // disable reconnection when using custom connection
 	}
 }
 
+// WithConnectFunc sets how to connect, and reconnect, to the bus (for testing)
+func WithConnectFunc(connect func() (DBusConnection, error)) DBusManagerOption {
+	return func(m *DBusManager) {
+		m.initialConn = nil
+		m.connectFn = connect
+	}
+}
+
 // NewDBusManager creates a new Stratis DBus manager for the given pool
 func NewDBusManager(pool string, opts ...DBusManagerOption) (*DBusManager, error) {
 	m := &DBusManager{
 		pool:      pool,
 		connectFn: ConnectSystemBus,
+		cache:     newObjectCache(),
+		done:      make(chan struct{}),
 	}
 
 	for _, opt := range opts {
@@ -51,32 +80,61 @@ func NewDBusManager(pool string, opts ...DBusManagerOption) (*DBusManager, error
 	}
 
 	// Connect if no custom connection provided
-	if m.conn == nil {
-		conn, err := m.connectFn()
-		if err != nil {
+	conn := m.initialConn
+	if conn == nil {
+		var err error
+		if conn, err = m.connectFn(); err != nil {
 			return nil, fmt.Errorf("connect to system bus: %w", err)
 		}
-		m.conn = conn
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), negotiateTimeout)
+	defer cancel()
+	rev, err := negotiateRevision(ctx, conn)
+	if err != nil {
+		if m.initialConn == nil {
+			_ = conn.Close()
+		}
+		return nil, err
+	}
+	m.rev = rev
+	log.Debug("using stratisd DBus API", "revision", rev)
+
+	m.setConnection(conn)
+
 	return m, nil
 }
 
+// Revision returns the revision of stratisd's DBus API in use, e.g. "r8"
+func (m *DBusManager) Revision() string {
+	return m.rev.String()
+}
+
 // Close closes the DBus connection
 func (m *DBusManager) Close() error {
-	if m.conn != nil {
-		return m.conn.Close()
+	m.mu.Lock()
+	conn, stop := m.conn, m.stopSignals
+	if !m.closed && m.done != nil {
+		close(m.done)
+	}
+	m.closed = true
+	m.stopSignals = nil
+	m.mu.Unlock()
+
+	if stop != nil {
+		stop()
+	}
+	if conn != nil {
+		return conn.Close()
 	}
 	return nil
 }
 
 // getManagedObjects calls GetManagedObjects on the ObjectManager interface
 // Returns: map[ObjectPath]map[InterfaceName]map[PropertyName]Variant
-func (m *DBusManager) getManagedObjects() (map[dbus.ObjectPath]map[string]map[string]dbus.Variant, error) {
-	obj := m.conn.Object(dbusService, dbus.ObjectPath(dbusRootPath))
-
-	var result map[dbus.ObjectPath]map[string]map[string]dbus.Variant
-	call := obj.Call(dbusObjectManager+".GetManagedObjects", 0)
+func (m *DBusManager) getManagedObjects(ctx context.Context) (managedObjects, error) {
+	var result managedObjects
+	call := m.call(ctx, dbus.ObjectPath(dbusRootPath), dbusObjectManager+".GetManagedObjects", true)
 	if call.Err != nil {
 		return nil, fmt.Errorf("GetManagedObjects: %w", call.Err)
 	}
@@ -89,19 +147,22 @@ func (m *DBusManager) getManagedObjects() (map[dbus.ObjectPath]map[string]map[st
 }
 
 // findPoolPath finds the object path for our configured pool
-func (m *DBusManager) findPoolPath() (dbus.ObjectPath, error) {
-	// Return cached path if available
-	if m.poolPath != "" {
-		return m.poolPath, nil
-	}
-
-	objects, err := m.getManagedObjects()
-	if err != nil {
-		return "", err
-	}
+func (m *DBusManager) findPoolPath(ctx context.Context) (dbus.ObjectPath, error) {
+	var poolPath dbus.ObjectPath
+	err := m.readObjects(ctx, func(objects managedObjects) error {
+		var ok bool
+		if poolPath, ok = m.poolPathIn(objects); !ok {
+			return fmt.Errorf("%w: %s", ErrPoolNotFound, m.pool)
+		}
+		return nil
+	})
+	return poolPath, err
+}
 
+// poolPathIn finds the object path of our pool among objects
+func (m *DBusManager) poolPathIn(objects managedObjects) (dbus.ObjectPath, bool) {
 	for path, interfaces := range objects {
-		poolProps, ok := interfaces[dbusPoolInterface]
+		poolProps, ok := interfaces[m.rev.poolInterface()]
 		if !ok {
 			continue
 		}
@@ -113,39 +174,35 @@ func (m *DBusManager) findPoolPath() (dbus.ObjectPath, error) {
 
 		name, ok := nameVariant.Value().(string)
 		if ok && name == m.pool {
-			m.poolPath = path
-			return path, nil
+			return path, true
 		}
 	}
 
-	return "", fmt.Errorf("pool %q not found", m.pool)
+	return "", false
 }
 
 // findFilesystemPath finds the DBus object path for a filesystem by name
-func (m *DBusManager) findFilesystemPath(name string) (dbus.ObjectPath, error) {
-	poolPath, err := m.findPoolPath()
-	if err != nil {
-		return "", err
-	}
-
-	objects, err := m.getManagedObjects()
-	if err != nil {
-		return "", err
-	}
-
-	for path, interfaces := range objects {
-		fsProps, ok := interfaces[dbusFilesystemInterface]
+func (m *DBusManager) findFilesystemPath(ctx context.Context, name string) (dbus.ObjectPath, error) {
+	var fsPath dbus.ObjectPath
+	err := m.readObjects(ctx, func(objects managedObjects) error {
+		poolPath, ok := m.poolPathIn(objects)
 		if !ok {
-			continue
+			return fmt.Errorf("%w: %s", ErrPoolNotFound, m.pool)
 		}
 
-		// Check pool
-		poolVariant, ok := fsProps["Pool"]
-		if !ok {
-			continue
+		if fsPath, _, ok = m.filesystemIn(objects, poolPath, name); !ok {
+			return ErrNotFound
 		}
-		fsPoolPath, ok := poolVariant.Value().(dbus.ObjectPath)
-		if !ok || fsPoolPath != poolPath {
+		return nil
+	})
+	return fsPath, err
+}
+
+// filesystemIn finds a filesystem of the pool by name among objects
+func (m *DBusManager) filesystemIn(objects managedObjects, poolPath dbus.ObjectPath, name string) (dbus.ObjectPath, map[string]dbus.Variant, bool) {
+	for path, interfaces := range objects {
+		fsProps, ok := interfaces[m.rev.filesystemInterface()]
+		if !ok || !belongsTo(fsProps, poolPath) {
 			continue
 		}
 
@@ -156,11 +213,21 @@ func (m *DBusManager) findFilesystemPath(name string) (dbus.ObjectPath, error) {
 		}
 		fsName, ok := nameVariant.Value().(string)
 		if ok && fsName == name {
-			return path, nil
+			return path, fsProps, true
 		}
 	}
 
-	return "", ErrNotFound
+	return "", nil, false
+}
+
+// belongsTo reports whether the filesystem properties name poolPath as its pool
+func belongsTo(fsProps map[string]dbus.Variant, poolPath dbus.ObjectPath) bool {
+	poolVariant, ok := fsProps["Pool"]
+	if !ok {
+		return false
+	}
+	fsPoolPath, ok := poolVariant.Value().(dbus.ObjectPath)
+	return ok && fsPoolPath == poolPath
 }
 
 // parseFilesystemFromProps creates a Filesystem from DBus property map
@@ -216,13 +283,11 @@ func (m *DBusManager) parseFilesystemFromProps(props map[string]dbus.Variant) (*
 		}
 	}
 
-	// Calculate Free
-	if fs.Total > fs.Used {
-		fs.Free = fs.Total - fs.Used
-	}
+	fs.Free = freeBytes(fs.Total, fs.Used)
 
-	// SizeLimit - optional property (bool, string) tuple
-	if v, ok := props["SizeLimit"]; ok {
+	// SizeLimit - optional property (bool, string) tuple, in revisions
+	// with size limits
+	if v, ok := props["SizeLimit"]; ok && m.rev.sizeLimits() {
 		if limit := extractOptionalString(v); limit != nil {
 			var limitBytes uint64
 			if _, err := fmt.Sscanf(*limit, "%d", &limitBytes); err == nil {
@@ -256,20 +321,21 @@ func extractOptionalString(v dbus.Variant) *string {
 }
 
 // checkReturnCode checks the stratisd return code tuple (return_code, message)
-func checkReturnCode(returnCode uint16, message string) error {
+// of method op
+func checkReturnCode(op string, returnCode uint16, message string) error {
 	if returnCode == 0 {
 		return nil
 	}
-	return fmt.Errorf("stratisd error (code %d): %s", returnCode, message)
+	return newError(op, returnCode, message)
 }
 
 // PoolExists checks if the configured pool exists
-func (m *DBusManager) PoolExists() (bool, error) {
+func (m *DBusManager) PoolExists(ctx context.Context) (bool, error) {
 	log.Debug("checking pool exists via dbus", "pool", m.pool)
 
-	_, err := m.findPoolPath()
+	_, err := m.findPoolPath(ctx)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
+		if errors.Is(err, ErrPoolNotFound) {
 			return false, nil
 		}
 		return false, fmt.Errorf("check pool: %w", err)
@@ -279,141 +345,83 @@ func (m *DBusManager) PoolExists() (bool, error) {
 }
 
 // List returns all filesystems in the pool
-func (m *DBusManager) List() ([]Filesystem, error) {
+func (m *DBusManager) List(ctx context.Context) ([]Filesystem, error) {
 	log.Debug("listing filesystems via dbus", "pool", m.pool)
 
-	poolPath, err := m.findPoolPath()
-	if err != nil {
-		return nil, fmt.Errorf("find pool: %w", err)
-	}
-
-	objects, err := m.getManagedObjects()
-	if err != nil {
-		return nil, fmt.Errorf("get managed objects: %w", err)
-	}
-
 	var filesystems []Filesystem
-
-	for _, interfaces := range objects {
-		fsProps, ok := interfaces[dbusFilesystemInterface]
+	err := m.readObjects(ctx, func(objects managedObjects) error {
+		poolPath, ok := m.poolPathIn(objects)
 		if !ok {
-			continue
+			return fmt.Errorf("find pool: %w: %s", ErrPoolNotFound, m.pool)
 		}
 
-		// Check if this filesystem belongs to our pool
-		poolVariant, ok := fsProps["Pool"]
-		if !ok {
-			continue
-		}
+		for _, interfaces := range objects {
+			fsProps, ok := interfaces[m.rev.filesystemInterface()]
+			if !ok || !belongsTo(fsProps, poolPath) {
+				continue
+			}
 
-		fsPoolPath, ok := poolVariant.Value().(dbus.ObjectPath)
-		if !ok || fsPoolPath != poolPath {
-			continue
-		}
+			fs, err := m.parseFilesystemFromProps(fsProps)
+			if err != nil {
+				log.Debug("failed to parse filesystem", "error", err)
+				continue
+			}
 
-		fs, err := m.parseFilesystemFromProps(fsProps)
-		if err != nil {
-			log.Debug("failed to parse filesystem", "error", err)
-			continue
+			filesystems = append(filesystems, *fs)
 		}
-
-		filesystems = append(filesystems, *fs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return filesystems, nil
 }
 
 // GetByName returns the filesystem with the given name
-func (m *DBusManager) GetByName(name string) (*Filesystem, error) {
+func (m *DBusManager) GetByName(ctx context.Context, name string) (*Filesystem, error) {
 	log.Debug("getting filesystem by name via dbus", "name", name, "pool", m.pool)
 
-	poolPath, err := m.findPoolPath()
-	if err != nil {
-		return nil, fmt.Errorf("find pool: %w", err)
-	}
-
-	objects, err := m.getManagedObjects()
-	if err != nil {
-		return nil, fmt.Errorf("get managed objects: %w", err)
-	}
-
-	for _, interfaces := range objects {
-		fsProps, ok := interfaces[dbusFilesystemInterface]
-		if !ok {
-			continue
-		}
-
-		// Check pool
-		poolVariant, ok := fsProps["Pool"]
+	var fs *Filesystem
+	err := m.readObjects(ctx, func(objects managedObjects) error {
+		poolPath, ok := m.poolPathIn(objects)
 		if !ok {
-			continue
-		}
-		fsPoolPath, ok := poolVariant.Value().(dbus.ObjectPath)
-		if !ok || fsPoolPath != poolPath {
-			continue
+			return fmt.Errorf("find pool: %w: %s", ErrPoolNotFound, m.pool)
 		}
 
-		// Check name
-		nameVariant, ok := fsProps["Name"]
+		_, fsProps, ok := m.filesystemIn(objects, poolPath, name)
 		if !ok {
-			continue
-		}
-		fsName, ok := nameVariant.Value().(string)
-		if !ok || fsName != name {
-			continue
+			return ErrNotFound
 		}
 
-		return m.parseFilesystemFromProps(fsProps)
+		var err error
+		fs, err = m.parseFilesystemFromProps(fsProps)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, ErrNotFound
+	return fs, nil
 }
 
 // Create creates a new filesystem with the given name and optional size limit
-func (m *DBusManager) Create(name string, sizeLimit *uint64) (*Filesystem, error) {
+func (m *DBusManager) Create(ctx context.Context, name string, sizeLimit *uint64) (*Filesystem, error) {
 	log.Debug("creating filesystem via dbus", "name", name, "pool", m.pool, "sizeLimit", sizeLimit)
 
-	poolPath, err := m.findPoolPath()
+	specs, err := m.filesystemSpecs(name, sizeLimit)
 	if err != nil {
-		return nil, fmt.Errorf("find pool: %w", err)
-	}
-
-	poolObj := m.conn.Object(dbusService, poolPath)
-
-	// Build filesystem specs for CreateFilesystems
-	// The DBus signature is: a(s(bs)(bs))
-	// Each spec is a struct: (name: string, size: (bool, string), limit: (bool, string))
-	hasLimit := sizeLimit != nil
-	var limitStr string
-	if hasLimit {
-		limitStr = fmt.Sprintf("%d", *sizeLimit)
-	}
-
-	// Use proper struct types for DBus serialization
-	// Each inner (bool, string) tuple is a struct in DBus
-	type optionalString struct {
-		HasValue bool
-		Value    string
-	}
-	type filesystemSpec struct {
-		Name      string
-		Size      optionalString
-		SizeLimit optionalString
+		return nil, err
 	}
 
-	// When setting a size limit, we also need to set the initial size to the same value
-	// Otherwise stratisd uses a default size that may exceed the limit
-	specs := []filesystemSpec{
-		{
-			Name:      name,
-			Size:      optionalString{HasValue: hasLimit, Value: limitStr},
-			SizeLimit: optionalString{HasValue: hasLimit, Value: limitStr},
-		},
+	poolPath, err := m.findPoolPath(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("find pool: %w", err)
 	}
 
 	// Call CreateFilesystems
 	// Returns: ((changed: bool, results: [(path, name)]), return_code, message)
-	call := poolObj.Call(dbusPoolInterface+".CreateFilesystems", 0, specs)
+	call := m.call(ctx, poolPath, m.rev.poolInterface()+".CreateFilesystems", false, specs)
 	if call.Err != nil {
 		return nil, fmt.Errorf("CreateFilesystems: %w", call.Err)
 	}
@@ -434,24 +442,19 @@ func (m *DBusManager) Create(name string, sizeLimit *uint64) (*Filesystem, error
 		message = ""
 	}
 
-	if err := checkReturnCode(returnCode, message); err != nil {
+	if err := checkReturnCode("CreateFilesystems", returnCode, message); err != nil {
 		return nil, fmt.Errorf("create filesystem: %w", err)
 	}
 
-	// Invalidate pool path cache to refresh on next query
-	m.poolPath = ""
-
-	// Get the created filesystem with retry - DBus may take a moment to reflect the new filesystem
-	var fs *Filesystem
-	for i := range 10 {
-		fs, err = m.GetByName(name)
-		if err == nil {
-			break
-		}
-		if i < 9 {
-			time.Sleep(100 * time.Millisecond)
+	// stratisd changes nothing when the name is taken by a filesystem of
+	// the same size
+	if result, ok := call.Body[0].([]any); ok && len(result) > 0 {
+		if changed, ok := result[0].(bool); ok && !changed {
+			return nil, fmt.Errorf("create filesystem %s: %w", name, ErrAlreadyExists)
 		}
 	}
+
+	fs, err := m.awaitFilesystem(ctx, poolPath, name)
 	if err != nil {
 		return nil, fmt.Errorf("get created filesystem: %w", err)
 	}
@@ -460,40 +463,230 @@ func (m *DBusManager) Create(name string, sizeLimit *uint64) (*Filesystem, error
 	return fs, nil
 }
 
+// awaitFilesystem returns a filesystem stratisd has just created. stratisd
+// announces it with InterfacesAdded; the cache is given some time to pick it up.
+func (m *DBusManager) awaitFilesystem(ctx context.Context, poolPath dbus.ObjectPath, name string) (*Filesystem, error) {
+	found, err := m.waitObjects(ctx, createSignalTimeout, func(objects managedObjects) bool {
+		_, _, ok := m.filesystemIn(objects, poolPath, name)
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		// The signal was lost; ask stratisd directly
+		log.Debug("no signal for created filesystem, reloading objects", "name", name)
+		m.cache.invalidate()
+	}
+
+	return m.GetByName(ctx, name)
+}
+
+// Snapshot creates the filesystem name as a snapshot of origin
+func (m *DBusManager) Snapshot(ctx context.Context, origin, name string) (*Filesystem, error) {
+	log.Debug("snapshotting filesystem via dbus", "origin", origin, "name", name, "pool", m.pool)
+
+	poolPath, err := m.findPoolPath(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("find pool: %w", err)
+	}
+
+	originPath, err := m.findFilesystemPath(ctx, origin)
+	if err != nil {
+		return nil, fmt.Errorf("find origin filesystem: %w", err)
+	}
+
+	// Returns: ((changed: bool, path: object path), return_code, message)
+	call := m.call(ctx, poolPath, m.rev.poolInterface()+".SnapshotFilesystem", false, originPath, name)
+	if call.Err != nil {
+		return nil, fmt.Errorf("SnapshotFilesystem: %w", call.Err)
+	}
+
+	if len(call.Body) < 3 {
+		return nil, fmt.Errorf("unexpected response format from SnapshotFilesystem")
+	}
+
+	returnCode, ok := call.Body[1].(uint16)
+	if !ok {
+		return nil, fmt.Errorf("unexpected return code type: got %T", call.Body[1])
+	}
+
+	message, _ := call.Body[2].(string)
+
+	if err := checkReturnCode("SnapshotFilesystem", returnCode, message); err != nil {
+		return nil, fmt.Errorf("snapshot filesystem: %w", err)
+	}
+
+	if result, ok := call.Body[0].([]any); ok && len(result) > 0 {
+		if changed, ok := result[0].(bool); ok && !changed {
+			return nil, fmt.Errorf("snapshot filesystem %s: %w", name, ErrAlreadyExists)
+		}
+	}
+
+	fs, err := m.awaitFilesystem(ctx, poolPath, name)
+	if err != nil {
+		return nil, fmt.Errorf("get snapshot filesystem: %w", err)
+	}
+
+	log.Debug("filesystem snapshotted via dbus", "origin", origin, "name", name, "device", fs.DevicePath)
+	return fs, nil
+}
+
+// filesystemSpecs builds the CreateFilesystems argument for one filesystem,
+// in the signature of the revision in use:
+//   - before size limits: a(s(bs)), specs of (name, size)
+//   - since size limits: a(s(bs)(bs)), specs of (name, size, limit)
+//
+// Each (bool, string) tuple is an optional value, a struct in DBus.
+func (m *DBusManager) filesystemSpecs(name string, sizeLimit *uint64) (any, error) {
+	type optionalString struct {
+		HasValue bool
+		Value    string
+	}
+
+	hasLimit := sizeLimit != nil
+	var limitStr string
+	if hasLimit {
+		limitStr = fmt.Sprintf("%d", *sizeLimit)
+	}
+
+	if !m.rev.sizeLimits() {
+		if hasLimit {
+			return nil, fmt.Errorf("size limits need stratisd API revision %s or newer, stratisd offers %s",
+				revision(sizeLimitRevision), m.rev)
+		}
+		type filesystemSpec struct {
+			Name string
+			Size optionalString
+		}
+		return []filesystemSpec{{Name: name}}, nil
+	}
+
+	type filesystemSpec struct {
+		Name      string
+		Size      optionalString
+		SizeLimit optionalString
+	}
+
+	// When setting a size limit, we also need to set the initial size to the same value
+	// Otherwise stratisd uses a default size that may exceed the limit
+	return []filesystemSpec{
+		{
+			Name:      name,
+			Size:      optionalString{HasValue: hasLimit, Value: limitStr},
+			SizeLimit: optionalString{HasValue: hasLimit, Value: limitStr},
+		},
+	}, nil
+}
+
 // Delete removes the filesystem with the given name
-func (m *DBusManager) Delete(name string) error {
+func (m *DBusManager) Delete(ctx context.Context, name string) error {
 	log.Debug("deleting filesystem via dbus", "name", name, "pool", m.pool)
 
-	poolPath, err := m.findPoolPath()
+	for attempt := 1; ; attempt++ {
+		poolPath, err := m.findPoolPath(ctx)
+		if err != nil {
+			return fmt.Errorf("find pool: %w", err)
+		}
+
+		fsPath, err := m.findFilesystemPath(ctx, name)
+		if err != nil {
+			return fmt.Errorf("find filesystem: %w", err)
+		}
+
+		changed, err := m.destroyFilesystem(ctx, poolPath, fsPath)
+		if err != nil && !isUnknownObject(err) {
+			return err
+		}
+
+		if err == nil {
+			// Don't wait for InterfacesRemoved, so the name is free right away
+			m.cache.removeObject(fsPath)
+			if changed {
+				break
+			}
+		}
+
+		// Nothing at the cached paths: the mirror is out of date, e.g.
+		// stratisd restarted and its signals are still on their way
+		if attempt > 1 {
+			if err != nil {
+				return err
+			}
+			return ErrNotFound
+		}
+		log.Debug("filesystem not at its cached path, reloading objects", "name", name, "path", fsPath)
+		m.cache.invalidate()
+	}
+
+	log.Debug("filesystem deleted via dbus", "name", name)
+	return nil
+}
+
+// Rename renames the filesystem name to newName
+func (m *DBusManager) Rename(ctx context.Context, name, newName string) error {
+	log.Debug("renaming filesystem via dbus", "name", name, "newName", newName, "pool", m.pool)
+
+	poolPath, err := m.findPoolPath(ctx)
 	if err != nil {
 		return fmt.Errorf("find pool: %w", err)
 	}
 
-	// First, find the filesystem path
-	fsPath, err := m.findFilesystemPath(name)
+	fsPath, err := m.findFilesystemPath(ctx, name)
 	if err != nil {
 		return fmt.Errorf("find filesystem: %w", err)
 	}
 
-	poolObj := m.conn.Object(dbusService, poolPath)
+	// Returns: ((changed: bool, uuid: string), return_code, message)
+	call := m.call(ctx, fsPath, m.rev.filesystemInterface()+".SetName", false, newName)
+	if call.Err != nil {
+		return fmt.Errorf("SetName: %w", call.Err)
+	}
+
+	if len(call.Body) < 3 {
+		return fmt.Errorf("unexpected response format from SetName")
+	}
+
+	returnCode, ok := call.Body[1].(uint16)
+	if !ok {
+		return fmt.Errorf("unexpected return code type: got %T", call.Body[1])
+	}
+
+	message, _ := call.Body[2].(string)
+
+	if err := checkReturnCode("SetName", returnCode, message); err != nil {
+		return fmt.Errorf("rename filesystem: %w", err)
+	}
+
+	// stratisd announces the new name with PropertiesChanged
+	if _, err := m.awaitFilesystem(ctx, poolPath, newName); err != nil {
+		return fmt.Errorf("get renamed filesystem: %w", err)
+	}
+
+	log.Debug("filesystem renamed via dbus", "name", name, "newName", newName)
+	return nil
+}
 
+// destroyFilesystem destroys the filesystem at fsPath. changed is false if
+// stratisd had no filesystem there.
+func (m *DBusManager) destroyFilesystem(ctx context.Context, poolPath, fsPath dbus.ObjectPath) (changed bool, err error) {
 	// Call DestroyFilesystems with array of paths
 	fsPaths := []dbus.ObjectPath{fsPath}
 
 	// Returns: ((changed: bool, uuids: [string]), return_code, message)
-	call := poolObj.Call(dbusPoolInterface+".DestroyFilesystems", 0, fsPaths)
+	call := m.call(ctx, poolPath, m.rev.poolInterface()+".DestroyFilesystems", false, fsPaths)
 	if call.Err != nil {
-		return fmt.Errorf("DestroyFilesystems: %w", call.Err)
+		return false, fmt.Errorf("DestroyFilesystems: %w", call.Err)
 	}
 
 	// Parse the response
 	if len(call.Body) < 3 {
-		return fmt.Errorf("unexpected response format from DestroyFilesystems")
+		return false, fmt.Errorf("unexpected response format from DestroyFilesystems")
 	}
 
 	returnCode, ok := call.Body[1].(uint16)
 	if !ok {
-		return fmt.Errorf("unexpected return code type")
+		return false, fmt.Errorf("unexpected return code type")
 	}
 
 	message, ok := call.Body[2].(string)
@@ -501,13 +694,16 @@ func (m *DBusManager) Delete(name string) error {
 		message = ""
 	}
 
-	if err := checkReturnCode(returnCode, message); err != nil {
-		return fmt.Errorf("delete filesystem: %w", err)
+	if err := checkReturnCode("DestroyFilesystems", returnCode, message); err != nil {
+		return false, fmt.Errorf("delete filesystem: %w", err)
 	}
 
-	// Invalidate pool path cache
-	m.poolPath = ""
-
-	log.Debug("filesystem deleted via dbus", "name", name)
-	return nil
+	// Older replies without the changed flag are taken as done
+	changed = true
+	if result, ok := call.Body[0].([]any); ok && len(result) > 0 {
+		if c, ok := result[0].(bool); ok {
+			changed = c
+		}
+	}
+	return changed, nil
 }
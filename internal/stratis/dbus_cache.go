@@ -0,0 +1,375 @@
+package stratis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+)
+
+const (
+	dbusPropertiesInterface = "org.freedesktop.DBus.Properties"
+	dbusBusName             = "org.freedesktop.DBus"
+
+	signalInterfacesAdded   = dbusObjectManager + ".InterfacesAdded"
+	signalInterfacesRemoved = dbusObjectManager + ".InterfacesRemoved"
+	signalPropertiesChanged = dbusPropertiesInterface + ".PropertiesChanged"
+	signalNameOwnerChanged  = dbusBusName + ".NameOwnerChanged"
+
+	// signalBuffer is the size of the channel signals are received on
+	signalBuffer = 64
+
+	// waitPollInterval is how often waitObjects checks without a change
+	waitPollInterval = 100 * time.Millisecond
+
+	// loadAttempts is how many times a load racing with signals is retried
+	// before its reply is used without caching it
+	loadAttempts = 3
+)
+
+// managedObjects is a GetManagedObjects reply: the properties of every
+// interface of every object
+type managedObjects = map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+
+// objectCache mirrors the objects stratisd exports. It is loaded once with
+// GetManagedObjects and then kept current from InterfacesAdded,
+// InterfacesRemoved and PropertiesChanged signals.
+type objectCache struct {
+	mu sync.Mutex
+	// objects is nil until loaded, and reset when the mirror can no longer
+	// be trusted, e.g. when signals stop arriving
+	objects managedObjects
+	// live is set while signals are being applied. Without them the mirror
+	// cannot be kept current, so every read reloads it.
+	live bool
+	// seq counts signals and connection changes, so a load can tell whether
+	// it raced with one
+	seq uint64
+	// changed is closed and replaced on every change, waking up waiters
+	changed chan struct{}
+}
+
+func newObjectCache() *objectCache {
+	return &objectCache{changed: make(chan struct{})}
+}
+
+// notify wakes up everyone waiting for a change. Must be called with mu held.
+func (c *objectCache) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// invalidate drops the mirror, so the next read loads it from stratisd again
+func (c *objectCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.objects = nil
+	c.notify()
+}
+
+// setLive records whether signals are being applied to the mirror
+func (c *objectCache) setLive(live bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.live = live
+	c.seq++
+	c.objects = nil
+	c.notify()
+}
+
+// removeObject forgets an object right away, without waiting for the signal
+func (c *objectCache) removeObject(path dbus.ObjectPath) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.objects != nil {
+		delete(c.objects, path)
+		c.notify()
+	}
+}
+
+// apply updates the mirror from a stratisd signal. Signals that cannot be
+// understood invalidate the mirror instead.
+func (c *objectCache) apply(sig *dbus.Signal) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+
+	if sig.Name == signalNameOwnerChanged {
+		c.nameOwnerChanged(sig.Body)
+		return
+	}
+
+	if c.objects == nil {
+		// Not loaded yet; the load will see the change
+		return
+	}
+
+	var err error
+	switch sig.Name {
+	case signalInterfacesAdded:
+		err = c.interfacesAdded(sig.Body)
+	case signalInterfacesRemoved:
+		err = c.interfacesRemoved(sig.Body)
+	case signalPropertiesChanged:
+		err = c.propertiesChanged(sig.Path, sig.Body)
+	default:
+		return
+	}
+
+	if err != nil {
+		log.Debug("invalidating stratisd object cache", "signal", sig.Name, "path", sig.Path, "error", err)
+		c.objects = nil
+	}
+	c.notify()
+}
+
+// nameOwnerChanged handles NameOwnerChanged(s name, s old_owner, s new_owner)
+// for stratisd. Its objects do not survive a restart, so the mirror is dropped.
+// Must be called with mu held.
+func (c *objectCache) nameOwnerChanged(body []any) {
+	if len(body) < 3 || body[0] != dbusService {
+		return
+	}
+
+	if newOwner, _ := body[2].(string); newOwner == "" {
+		log.Warn("stratisd left the bus")
+	} else {
+		log.Info("stratisd joined the bus, reloading objects", "owner", newOwner)
+	}
+
+	c.objects = nil
+	c.notify()
+}
+
+// interfacesAdded handles InterfacesAdded(o object_path, a{sa{sv}} interfaces)
+func (c *objectCache) interfacesAdded(body []any) error {
+	if len(body) < 2 {
+		return fmt.Errorf("unexpected InterfacesAdded body")
+	}
+	path, ok := body[0].(dbus.ObjectPath)
+	if !ok {
+		return fmt.Errorf("unexpected object path type %T", body[0])
+	}
+	interfaces, ok := body[1].(map[string]map[string]dbus.Variant)
+	if !ok {
+		return fmt.Errorf("unexpected interfaces type %T", body[1])
+	}
+
+	obj, ok := c.objects[path]
+	if !ok {
+		obj = make(map[string]map[string]dbus.Variant, len(interfaces))
+		c.objects[path] = obj
+	}
+	for iface, props := range interfaces {
+		obj[iface] = props
+	}
+	return nil
+}
+
+// interfacesRemoved handles InterfacesRemoved(o object_path, as interfaces)
+func (c *objectCache) interfacesRemoved(body []any) error {
+	if len(body) < 2 {
+		return fmt.Errorf("unexpected InterfacesRemoved body")
+	}
+	path, ok := body[0].(dbus.ObjectPath)
+	if !ok {
+		return fmt.Errorf("unexpected object path type %T", body[0])
+	}
+	interfaces, ok := body[1].([]string)
+	if !ok {
+		return fmt.Errorf("unexpected interfaces type %T", body[1])
+	}
+
+	obj, ok := c.objects[path]
+	if !ok {
+		return nil
+	}
+	for _, iface := range interfaces {
+		delete(obj, iface)
+	}
+	if len(obj) == 0 {
+		delete(c.objects, path)
+	}
+	return nil
+}
+
+// propertiesChanged handles PropertiesChanged(s interface, a{sv} changed,
+// as invalidated). Invalidated properties carry no value, so they force a reload.
+func (c *objectCache) propertiesChanged(path dbus.ObjectPath, body []any) error {
+	if len(body) < 3 {
+		return fmt.Errorf("unexpected PropertiesChanged body")
+	}
+	iface, ok := body[0].(string)
+	if !ok {
+		return fmt.Errorf("unexpected interface type %T", body[0])
+	}
+	changed, ok := body[1].(map[string]dbus.Variant)
+	if !ok {
+		return fmt.Errorf("unexpected properties type %T", body[1])
+	}
+	if invalidated, _ := body[2].([]string); len(invalidated) > 0 {
+		return fmt.Errorf("properties invalidated: %v", invalidated)
+	}
+
+	props, ok := c.objects[path][iface]
+	if !ok {
+		// An interface we never saw added; only a reload can tell
+		return fmt.Errorf("change for unknown interface %s", iface)
+	}
+	for name, value := range changed {
+		props[name] = value
+	}
+	return nil
+}
+
+// subscribe asks stratisd's signals to be delivered on conn, of generation
+// gen, and starts applying them to the cache. It returns the function that
+// stops it. If the connection dies, a reconnect is started.
+func (m *DBusManager) subscribe(conn DBusConnection, gen uint64) (stop func(), err error) {
+	for _, member := range []string{"InterfacesAdded", "InterfacesRemoved"} {
+		err := conn.AddMatchSignal(
+			dbus.WithMatchSender(dbusService),
+			dbus.WithMatchInterface(dbusObjectManager),
+			dbus.WithMatchMember(member),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("subscribe to %s: %w", member, err)
+		}
+	}
+	err = conn.AddMatchSignal(
+		dbus.WithMatchSender(dbusService),
+		dbus.WithMatchInterface(dbusPropertiesInterface),
+		dbus.WithMatchMember("PropertiesChanged"),
+		dbus.WithMatchPathNamespace(dbusRootPath),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("subscribe to PropertiesChanged: %w", err)
+	}
+	// Tells when stratisd restarts, or leaves the bus
+	err = conn.AddMatchSignal(
+		dbus.WithMatchSender(dbusBusName),
+		dbus.WithMatchInterface(dbusBusName),
+		dbus.WithMatchMember("NameOwnerChanged"),
+		dbus.WithMatchArg(0, dbusService),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("subscribe to NameOwnerChanged: %w", err)
+	}
+
+	signals := make(chan *dbus.Signal, signalBuffer)
+	done := make(chan struct{})
+	conn.Signal(signals)
+	m.cache.setLive(true)
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig, ok := <-signals:
+				if !ok {
+					// The connection is gone; nothing keeps the mirror current
+					log.Warn("lost connection to the system bus, reconnecting")
+					m.cache.setLive(false)
+					go m.recoverConnection(gen)
+					return
+				}
+				m.cache.apply(sig)
+			}
+		}
+	}()
+
+	return func() {
+		conn.RemoveSignal(signals)
+		close(done)
+		m.cache.setLive(false)
+	}, nil
+}
+
+// readObjects runs fn on the mirrored stratisd objects, loading them first
+// if needed. fn runs with the cache locked and must not keep references to
+// the objects.
+func (m *DBusManager) readObjects(ctx context.Context, fn func(objects managedObjects) error) error {
+	c := m.cache
+	for attempt := 1; ; attempt++ {
+		c.mu.Lock()
+		if c.objects != nil {
+			defer c.mu.Unlock()
+			return fn(c.objects)
+		}
+		seq := c.seq
+		c.mu.Unlock()
+
+		// stratisd is called without holding the cache, as the call may
+		// have to wait for a reconnect
+		objects, err := m.getManagedObjects(ctx)
+		if err != nil {
+			return err
+		}
+		if objects == nil {
+			objects = make(managedObjects)
+		}
+
+		c.mu.Lock()
+		switch {
+		case c.objects != nil:
+			// Loaded meanwhile by someone else
+		case c.live && c.seq == seq:
+			c.objects = objects
+			c.notify()
+		case c.live && attempt < loadAttempts:
+			// Signals arrived meanwhile and may be missing from the reply
+		default:
+			// Without signals the mirror cannot be kept current, so the
+			// reply serves this read only
+			defer c.mu.Unlock()
+			return fn(objects)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// waitObjects waits until cond holds for the mirrored objects, or timeout
+// expires. It reports whether cond was met, and fails if ctx is done first.
+// The objects are checked on every change, and every waitPollInterval in
+// case there are no signals.
+func (m *DBusManager) waitObjects(ctx context.Context, timeout time.Duration, cond func(objects managedObjects) bool) (bool, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	poll := time.NewTicker(waitPollInterval)
+	defer poll.Stop()
+
+	for {
+		var met bool
+		var changed chan struct{}
+		err := m.readObjects(ctx, func(objects managedObjects) error {
+			met = cond(objects)
+			changed = m.cache.changed
+			return nil
+		})
+		if err != nil {
+			return false, err
+		}
+
+		if met {
+			return true, nil
+		}
+
+		select {
+		case <-changed:
+		case <-poll.C:
+		case <-deadline.C:
+			return false, nil
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
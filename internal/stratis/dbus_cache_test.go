@@ -0,0 +1,223 @@
+package stratis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const getManagedObjects = dbusObjectManager + ".GetManagedObjects"
+
+// newTestManager creates a manager over a mock connection exporting the
+// given pools and filesystems
+func newTestManager(t *testing.T, pool string, pools []mockPool, filesystems []mockFilesystem) (*DBusManager, *mockDBusConnection, *mockBusObject) {
+	t.Helper()
+
+	rootObj := &mockBusObject{
+		callResults: map[string]*dbus.Call{
+			introspectMethod:  introspectCall(testRevision),
+			getManagedObjects: {Body: []any{makeManagedObjects(pools, filesystems)}},
+		},
+	}
+	conn := &mockDBusConnection{
+		objects: map[dbus.ObjectPath]*mockBusObject{
+			dbus.ObjectPath(dbusRootPath): rootObj,
+		},
+	}
+
+	m, err := NewDBusManager(pool, WithConnection(conn))
+	if err != nil {
+		t.Fatalf("NewDBusManager() error = %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+
+	return m, conn, rootObj
+}
+
+// eventually fails the test if cond does not hold within a second
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func fsInterfaces(name string, poolPath dbus.ObjectPath, used string) map[string]map[string]dbus.Variant {
+	return map[string]map[string]dbus.Variant{
+		testRevision.filesystemInterface(): {
+			"Name":      dbus.MakeVariant(name),
+			"Pool":      dbus.MakeVariant(poolPath),
+			"Uuid":      dbus.MakeVariant("uuid-" + name),
+			"Devnode":   dbus.MakeVariant("/dev/stratis/test-pool/" + name),
+			"Size":      dbus.MakeVariant("1073741824"),
+			"Used":      dbus.MakeVariant([]any{true, used}),
+			"SizeLimit": dbus.MakeVariant([]any{false, ""}),
+		},
+	}
+}
+
+func TestDBusManager_CacheFollowsSignals(t *testing.T) {
+	poolPath := dbus.ObjectPath("/org/storage/stratis3/pool/1")
+	fsPath := dbus.ObjectPath("/org/storage/stratis3/filesystem/2")
+
+	m, conn, rootObj := newTestManager(t, "test-pool", []mockPool{{path: poolPath, name: "test-pool"}}, nil)
+
+	if _, err := m.GetByName(context.Background(), "vol1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetByName() before creation error = %v, want ErrNotFound", err)
+	}
+
+	conn.emit(dbus.ObjectPath(dbusRootPath), signalInterfacesAdded, fsPath, fsInterfaces("vol1", poolPath, "0"))
+	eventually(t, "filesystem to be added", func() bool {
+		_, err := m.GetByName(context.Background(), "vol1")
+		return err == nil
+	})
+
+	conn.emit(fsPath, signalPropertiesChanged, testRevision.filesystemInterface(), map[string]dbus.Variant{
+		"Used": dbus.MakeVariant([]any{true, "4096"}),
+	}, []string{})
+	eventually(t, "used bytes to change", func() bool {
+		fs, err := m.GetByName(context.Background(), "vol1")
+		return err == nil && fs.Used == 4096
+	})
+
+	conn.emit(dbus.ObjectPath(dbusRootPath), signalInterfacesRemoved, fsPath, []string{testRevision.filesystemInterface()})
+	eventually(t, "filesystem to be removed", func() bool {
+		_, err := m.GetByName(context.Background(), "vol1")
+		return errors.Is(err, ErrNotFound)
+	})
+
+	if got := rootObj.callCount(getManagedObjects); got != 1 {
+		t.Errorf("GetManagedObjects called %d times, want 1", got)
+	}
+}
+
+func TestDBusManager_InvalidatedPropertiesReload(t *testing.T) {
+	poolPath := dbus.ObjectPath("/org/storage/stratis3/pool/1")
+	fsPath := dbus.ObjectPath("/org/storage/stratis3/filesystem/2")
+
+	m, conn, rootObj := newTestManager(t, "test-pool",
+		[]mockPool{{path: poolPath, name: "test-pool"}},
+		[]mockFilesystem{{path: fsPath, name: "vol1", poolPath: poolPath, devnode: "/dev/stratis/test-pool/vol1", size: "1024"}},
+	)
+
+	if _, err := m.List(context.Background()); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	conn.emit(fsPath, signalPropertiesChanged, testRevision.filesystemInterface(), map[string]dbus.Variant{}, []string{"Used"})
+	eventually(t, "cache to reload", func() bool {
+		if _, err := m.List(context.Background()); err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		return rootObj.callCount(getManagedObjects) == 2
+	})
+}
+
+func TestDBusManager_CreateWaitsForInterfacesAdded(t *testing.T) {
+	poolPath := dbus.ObjectPath("/org/storage/stratis3/pool/1")
+	fsPath := dbus.ObjectPath("/org/storage/stratis3/filesystem/2")
+
+	m, conn, rootObj := newTestManager(t, "test-pool", []mockPool{{path: poolPath, name: "test-pool"}}, nil)
+
+	// stratisd replies first and announces the filesystem a bit later
+	conn.objects[poolPath] = &mockBusObject{
+		callResults: map[string]*dbus.Call{
+			testRevision.poolInterface() + ".CreateFilesystems": {
+				Body: []any{[]any{true, [][]any{{fsPath, "vol1"}}}, uint16(0), ""},
+			},
+		},
+		onCall: map[string]func(){
+			testRevision.poolInterface() + ".CreateFilesystems": func() {
+				time.AfterFunc(50*time.Millisecond, func() {
+					conn.emit(dbus.ObjectPath(dbusRootPath), signalInterfacesAdded, fsPath, fsInterfaces("vol1", poolPath, "0"))
+				})
+			},
+		},
+	}
+
+	fs, err := m.Create(context.Background(), "vol1", nil)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if fs.Name != "vol1" || fs.DevicePath != "/dev/stratis/test-pool/vol1" {
+		t.Errorf("Create() = %+v, want vol1", fs)
+	}
+
+	// Only the initial load; the new filesystem came from the signal
+	if got := rootObj.callCount(getManagedObjects); got != 1 {
+		t.Errorf("GetManagedObjects called %d times, want 1", got)
+	}
+}
+
+func TestDBusManager_SnapshotWaitsForInterfacesAdded(t *testing.T) {
+	poolPath := dbus.ObjectPath("/org/storage/stratis3/pool/1")
+	originPath := dbus.ObjectPath("/org/storage/stratis3/filesystem/2")
+	snapPath := dbus.ObjectPath("/org/storage/stratis3/filesystem/3")
+
+	m, conn, _ := newTestManager(t, "test-pool",
+		[]mockPool{{path: poolPath, name: "test-pool"}},
+		[]mockFilesystem{{path: originPath, name: "vol1", poolPath: poolPath, devnode: "/dev/stratis/test-pool/vol1", size: "1024"}},
+	)
+
+	conn.objects[poolPath] = &mockBusObject{
+		callResults: map[string]*dbus.Call{
+			testRevision.poolInterface() + ".SnapshotFilesystem": {
+				Body: []any{[]any{true, snapPath}, uint16(0), ""},
+			},
+		},
+		onCall: map[string]func(){
+			testRevision.poolInterface() + ".SnapshotFilesystem": func() {
+				time.AfterFunc(50*time.Millisecond, func() {
+					conn.emit(dbus.ObjectPath(dbusRootPath), signalInterfacesAdded, snapPath, fsInterfaces("snap1", poolPath, "0"))
+				})
+			},
+		},
+	}
+
+	fs, err := m.Snapshot(context.Background(), "vol1", "snap1")
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if fs.Name != "snap1" || fs.DevicePath != "/dev/stratis/test-pool/snap1" {
+		t.Errorf("Snapshot() = %+v, want snap1", fs)
+	}
+
+	if _, err := m.Snapshot(context.Background(), "missing", "snap2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Snapshot() of a missing origin error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestDBusManager_CreateGivesUpAtDeadline(t *testing.T) {
+	poolPath := dbus.ObjectPath("/org/storage/stratis3/pool/1")
+
+	m, conn, _ := newTestManager(t, "test-pool", []mockPool{{path: poolPath, name: "test-pool"}}, nil)
+
+	// stratisd hangs
+	release := make(chan struct{})
+	defer close(release)
+	conn.objects[poolPath] = &mockBusObject{
+		onCall: map[string]func(){
+			testRevision.poolInterface() + ".CreateFilesystems": func() { <-release },
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := m.Create(ctx, "vol1", nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Create() error = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Create() returned after %v, want shortly after the deadline", elapsed)
+	}
+}
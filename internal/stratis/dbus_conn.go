@@ -1,13 +1,29 @@
 package stratis
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
 	"github.com/godbus/dbus/v5"
+	"github.com/kriansa/podman-volume-stratis/internal/log"
 )
 
 // DBusConnection abstracts the godbus connection for testability
 type DBusConnection interface {
 	// Object returns a BusObject for the given destination and path
 	Object(dest string, path dbus.ObjectPath) dbus.BusObject
+	// AddMatchSignal asks the bus to deliver signals matching the options
+	AddMatchSignal(options ...dbus.MatchOption) error
+	// Signal registers ch to receive signals. ch is closed when the
+	// connection is closed.
+	Signal(ch chan<- *dbus.Signal)
+	// RemoveSignal stops delivering signals to ch
+	RemoveSignal(ch chan<- *dbus.Signal)
 	// Close closes the connection
 	Close() error
 }
@@ -21,6 +37,18 @@ func (c *systemDBusConnection) Object(dest string, path dbus.ObjectPath) dbus.Bu
 	return c.conn.Object(dest, path)
 }
 
+func (c *systemDBusConnection) AddMatchSignal(options ...dbus.MatchOption) error {
+	return c.conn.AddMatchSignal(options...)
+}
+
+func (c *systemDBusConnection) Signal(ch chan<- *dbus.Signal) {
+	c.conn.Signal(ch)
+}
+
+func (c *systemDBusConnection) RemoveSignal(ch chan<- *dbus.Signal) {
+	c.conn.RemoveSignal(ch)
+}
+
 func (c *systemDBusConnection) Close() error {
 	return c.conn.Close()
 }
@@ -33,3 +61,242 @@ func ConnectSystemBus() (DBusConnection, error) {
 	}
 	return &systemDBusConnection{conn: conn}, nil
 }
+
+const (
+	// reconnectAttempts is how many times reconnect tries to connect
+	reconnectAttempts = 5
+	// reconnectInitialBackoff is the wait after the first failed attempt; it
+	// doubles after every further one, up to reconnectMaxBackoff
+	reconnectInitialBackoff = 100 * time.Millisecond
+	reconnectMaxBackoff     = 5 * time.Second
+
+	// restartRetryDelay is how long an idempotent call waits before being
+	// retried when stratisd is not on the bus, e.g. while it restarts
+	restartRetryDelay = time.Second
+)
+
+// connection returns the current bus connection and its generation, which
+// changes on every reconnect
+func (m *DBusManager) connection() (DBusConnection, uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.conn, m.gen
+}
+
+// call invokes a method of a stratisd object. When the bus connection is
+// lost it reconnects, and when stratisd has left the bus it drops the object
+// cache; idempotent calls are then retried once. The call is abandoned when
+// ctx is done. Failures to reach stratisd wrap ErrStratisdUnavailable.
+func (m *DBusManager) call(ctx context.Context, path dbus.ObjectPath, method string, idempotent bool, args ...any) *dbus.Call {
+	call := m.callRetrying(ctx, path, method, idempotent, args...)
+	if call.Err != nil && (isConnectionError(call.Err) || isServiceUnavailable(call.Err)) {
+		call.Err = fmt.Errorf("%w: %w", ErrStratisdUnavailable, call.Err)
+	}
+	return call
+}
+
+// callRetrying implements call, without classifying its failures
+func (m *DBusManager) callRetrying(ctx context.Context, path dbus.ObjectPath, method string, idempotent bool, args ...any) *dbus.Call {
+	conn, gen := m.connection()
+	call := conn.Object(dbusService, path).CallWithContext(ctx, method, 0, args...)
+	if call.Err == nil {
+		return call
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		return call
+	case isConnectionError(call.Err):
+		log.Warn("lost connection to the system bus, reconnecting", "error", call.Err)
+		if err := m.reconnect(ctx, gen); err != nil {
+			call.Err = fmt.Errorf("%w (reconnect failed: %v)", call.Err, err)
+			return call
+		}
+	case isServiceUnavailable(call.Err):
+		// Object paths do not survive a stratisd restart
+		m.cache.invalidate()
+		if idempotent {
+			select {
+			case <-time.After(restartRetryDelay):
+			case <-ctx.Done():
+				call.Err = ctx.Err()
+				return call
+			}
+		}
+	default:
+		return call
+	}
+
+	if !idempotent {
+		return call
+	}
+
+	log.Debug("retrying stratisd call", "method", method, "error", call.Err)
+	conn, _ = m.connection()
+	return conn.Object(dbusService, path).CallWithContext(ctx, method, 0, args...)
+}
+
+// reconnect replaces the connection of generation failedGen with a new one,
+// retrying with backoff. It does nothing if the connection was already
+// replaced meanwhile, and gives up when ctx is done or the manager is
+// closed.
+func (m *DBusManager) reconnect(ctx context.Context, failedGen uint64) error {
+	m.reconnectMu.Lock()
+	defer m.reconnectMu.Unlock()
+
+	m.mu.Lock()
+	gen, closed := m.gen, m.closed
+	m.mu.Unlock()
+
+	if closed {
+		return fmt.Errorf("manager is closed")
+	}
+	if gen != failedGen {
+		return nil
+	}
+	if m.connectFn == nil {
+		return fmt.Errorf("reconnection is disabled")
+	}
+
+	backoff := reconnectInitialBackoff
+	for attempt := 1; ; attempt++ {
+		conn, err := m.connectFn()
+		if err == nil {
+			m.setConnection(conn)
+			log.Info("reconnected to the system bus", "attempts", attempt)
+			return nil
+		}
+		if attempt == reconnectAttempts {
+			return fmt.Errorf("connect to system bus: %w", err)
+		}
+
+		log.Debug("failed to connect to the system bus", "attempt", attempt, "retry_in", backoff, "error", err)
+		if err := m.wait(ctx, backoff); err != nil {
+			return err
+		}
+		backoff = min(backoff*2, reconnectMaxBackoff)
+	}
+}
+
+// recoverConnection reconnects in the background after the connection of
+// generation gen died, until it succeeds or the manager is closed
+func (m *DBusManager) recoverConnection(gen uint64) {
+	if m.connectFn == nil {
+		return
+	}
+
+	for {
+		err := m.reconnect(context.Background(), gen)
+		if err == nil {
+			return
+		}
+
+		m.mu.Lock()
+		closed := m.closed
+		m.mu.Unlock()
+		if closed {
+			return
+		}
+
+		log.Warn("failed to reconnect to the system bus, retrying", "retry_in", reconnectMaxBackoff, "error", err)
+		if m.wait(context.Background(), reconnectMaxBackoff) != nil {
+			return
+		}
+	}
+}
+
+// wait waits for d to pass, failing when ctx is done or the manager is
+// closed first
+func (m *DBusManager) wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-m.done:
+		return fmt.Errorf("manager is closed")
+	}
+}
+
+// setConnection makes conn the current connection, closing the previous
+// one, and follows stratisd's signals on it
+func (m *DBusManager) setConnection(conn DBusConnection) {
+	m.mu.Lock()
+	old, stopOld := m.conn, m.stopSignals
+	m.conn = conn
+	m.gen++
+	gen := m.gen
+	m.stopSignals = nil
+	m.mu.Unlock()
+
+	if stopOld != nil {
+		stopOld()
+	}
+	if old != nil {
+		_ = old.Close()
+	}
+
+	// Serve reads from a local mirror kept current by stratisd signals
+	stop, err := m.subscribe(conn, gen)
+	if err != nil {
+		log.Warn("stratisd signals unavailable, querying stratisd on every read", "error", err)
+		m.cache.setLive(false)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.gen != gen || m.closed {
+		stop()
+		return
+	}
+	m.stopSignals = stop
+}
+
+// isConnectionError reports whether err means the bus connection is dead
+func isConnectionError(err error) bool {
+	return errors.Is(err, dbus.ErrClosed) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// isServiceUnavailable reports whether err means stratisd is not on the bus
+func isServiceUnavailable(err error) bool {
+	var dbusErr dbus.Error
+	if !errors.As(err, &dbusErr) {
+		return false
+	}
+
+	switch dbusErr.Name {
+	case "org.freedesktop.DBus.Error.ServiceUnknown",
+		"org.freedesktop.DBus.Error.NameHasNoOwner",
+		"org.freedesktop.DBus.Error.NoReply":
+		return true
+	default:
+		return false
+	}
+}
+
+// isUnknownObject reports whether err says the called object does not exist,
+// as when calling a path stratisd exported before restarting
+func isUnknownObject(err error) bool {
+	var dbusErr dbus.Error
+	if !errors.As(err, &dbusErr) {
+		return false
+	}
+
+	switch dbusErr.Name {
+	case "org.freedesktop.DBus.Error.UnknownObject",
+		"org.freedesktop.DBus.Error.UnknownInterface",
+		"org.freedesktop.DBus.Error.UnknownMethod":
+		return true
+	default:
+		return false
+	}
+}
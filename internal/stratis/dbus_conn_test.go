@@ -0,0 +1,232 @@
+package stratis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// connector hands out a new mock connection on every connect
+type connector struct {
+	mu    sync.Mutex
+	conns []*mockDBusConnection
+	// objects builds the root object of the next connection
+	objects func(n int) *mockBusObject
+}
+
+func (c *connector) connect() (DBusConnection, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn := &mockDBusConnection{
+		objects: map[dbus.ObjectPath]*mockBusObject{
+			dbus.ObjectPath(dbusRootPath): c.objects(len(c.conns)),
+		},
+	}
+	c.conns = append(c.conns, conn)
+	return conn, nil
+}
+
+func (c *connector) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.conns)
+}
+
+func (c *connector) conn(n int) *mockDBusConnection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conns[n]
+}
+
+func poolObjects(poolPath dbus.ObjectPath) *mockBusObject {
+	return &mockBusObject{
+		callResults: map[string]*dbus.Call{
+			introspectMethod:  introspectCall(testRevision),
+			getManagedObjects: {Body: []any{makeManagedObjects([]mockPool{{path: poolPath, name: "test-pool"}}, nil)}},
+		},
+	}
+}
+
+func deadObject() *mockBusObject {
+	return &mockBusObject{
+		callResults: map[string]*dbus.Call{
+			introspectMethod:  introspectCall(testRevision),
+			getManagedObjects: {Err: dbus.ErrClosed},
+		},
+	}
+}
+
+func TestDBusManager_ReconnectsAndRetriesReads(t *testing.T) {
+	poolPath := dbus.ObjectPath("/org/storage/stratis3/pool/1")
+	c := &connector{objects: func(n int) *mockBusObject {
+		if n == 0 {
+			return deadObject()
+		}
+		return poolObjects(poolPath)
+	}}
+
+	m, err := NewDBusManager("test-pool", WithConnectFunc(c.connect))
+	if err != nil {
+		t.Fatalf("NewDBusManager() error = %v", err)
+	}
+	defer m.Close()
+
+	exists, err := m.PoolExists(context.Background())
+	if err != nil {
+		t.Fatalf("PoolExists() error = %v", err)
+	}
+	if !exists {
+		t.Error("PoolExists() = false after reconnect, want true")
+	}
+	if got := c.count(); got != 2 {
+		t.Errorf("connected %d times, want 2", got)
+	}
+}
+
+func TestDBusManager_DoesNotRetryCreate(t *testing.T) {
+	poolPath := dbus.ObjectPath("/org/storage/stratis3/pool/1")
+	c := &connector{objects: func(n int) *mockBusObject { return poolObjects(poolPath) }}
+
+	m, err := NewDBusManager("test-pool", WithConnectFunc(c.connect))
+	if err != nil {
+		t.Fatalf("NewDBusManager() error = %v", err)
+	}
+	defer m.Close()
+
+	// Load the cache while the connection works
+	if _, err := m.List(context.Background()); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	poolObj := &mockBusObject{
+		callResults: map[string]*dbus.Call{
+			testRevision.poolInterface() + ".CreateFilesystems": {Err: dbus.ErrClosed},
+		},
+	}
+	c.conn(0).objects[poolPath] = poolObj
+
+	_, err = m.Create(context.Background(), "vol1", nil)
+	if err == nil || !strings.Contains(err.Error(), "connection closed") {
+		t.Fatalf("Create() error = %v, want connection closed", err)
+	}
+	if !errors.Is(err, ErrStratisdUnavailable) {
+		t.Errorf("Create() error = %v, want ErrStratisdUnavailable", err)
+	}
+	if got := poolObj.callCount(testRevision.poolInterface() + ".CreateFilesystems"); got != 1 {
+		t.Errorf("CreateFilesystems called %d times, want 1", got)
+	}
+	if got := c.count(); got != 2 {
+		t.Errorf("connected %d times, want 2", got)
+	}
+}
+
+func TestDBusManager_ReconnectsWhenSignalsStop(t *testing.T) {
+	poolPath := dbus.ObjectPath("/org/storage/stratis3/pool/1")
+	c := &connector{objects: func(n int) *mockBusObject { return poolObjects(poolPath) }}
+
+	m, err := NewDBusManager("test-pool", WithConnectFunc(c.connect))
+	if err != nil {
+		t.Fatalf("NewDBusManager() error = %v", err)
+	}
+	defer m.Close()
+
+	c.conn(0).disconnect()
+
+	eventually(t, "reconnect", func() bool { return c.count() == 2 })
+	eventually(t, "signals on the new connection", func() bool {
+		conn := c.conn(1)
+		conn.mu.Lock()
+		defer conn.mu.Unlock()
+		return len(conn.signals) == 1
+	})
+}
+
+func TestDBusManager_ReconnectBackoffStopsEarly(t *testing.T) {
+	poolPath := dbus.ObjectPath("/org/storage/stratis3/pool/1")
+	c := &connector{objects: func(n int) *mockBusObject { return poolObjects(poolPath) }}
+	var closeOnce sync.Once
+	connect := func() (DBusConnection, error) {
+		if c.count() > 0 {
+			return nil, errors.New("bus is down")
+		}
+		return c.connect()
+	}
+
+	m, err := NewDBusManager("test-pool", WithConnectFunc(connect))
+	if err != nil {
+		t.Fatalf("NewDBusManager() error = %v", err)
+	}
+	defer closeOnce.Do(func() { m.Close() })
+	_, gen := m.connection()
+
+	// Backing off between attempts would take well over a second
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := m.reconnect(ctx, gen); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("reconnect() error = %v, want DeadlineExceeded", err)
+	}
+
+	reconnected := make(chan error, 1)
+	go func() { reconnected <- m.reconnect(context.Background(), gen) }()
+	time.Sleep(20 * time.Millisecond)
+	closeOnce.Do(func() { m.Close() })
+
+	select {
+	case err := <-reconnected:
+		if err == nil || !strings.Contains(err.Error(), "closed") {
+			t.Errorf("reconnect() after Close() error = %v, want manager closed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reconnect() kept backing off after Close()")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("reconnect() took %s, want it cut short", elapsed)
+	}
+}
+
+func TestDBusManager_ReloadsWhenStratisdRestarts(t *testing.T) {
+	poolPath := dbus.ObjectPath("/org/storage/stratis3/pool/1")
+
+	m, conn, rootObj := newTestManager(t, "test-pool", []mockPool{{path: poolPath, name: "test-pool"}}, nil)
+
+	if _, err := m.List(context.Background()); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	conn.emit(dbus.ObjectPath("/org/freedesktop/DBus"), signalNameOwnerChanged, dbusService, ":1.10", ":1.42")
+
+	eventually(t, "cache to reload", func() bool {
+		if _, err := m.List(context.Background()); err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		return rootObj.callCount(getManagedObjects) == 2
+	})
+}
+
+func TestIsServiceUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"service unknown", dbus.Error{Name: "org.freedesktop.DBus.Error.ServiceUnknown"}, true},
+		{"no reply", dbus.Error{Name: "org.freedesktop.DBus.Error.NoReply"}, true},
+		{"stratisd error", dbus.Error{Name: "org.freedesktop.DBus.Error.Failed"}, false},
+		{"connection closed", dbus.ErrClosed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isServiceUnavailable(tt.err); got != tt.want {
+				t.Errorf("isServiceUnavailable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package stratis
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+const (
+	// dbusManagerInterfacePrefix names the interface of stratisd's root
+	// object, one per revision: org.storage.stratis3.Manager.r0, .r1, ...
+	dbusManagerInterfacePrefix = dbusService + ".Manager.r"
+
+	// minRevision and maxRevision are the oldest and newest revisions of
+	// stratisd's API this plugin can use. stratisd 3.N exports rN and every
+	// older revision.
+	minRevision = 0
+	maxRevision = 8
+
+	// sizeLimitRevision is the first revision with filesystem size limits
+	sizeLimitRevision = 5
+
+	// negotiateTimeout bounds finding out which revisions stratisd offers
+	negotiateTimeout = 30 * time.Second
+)
+
+// revision is a revision of stratisd's DBus API. Every revision has its own
+// set of interfaces, which may differ in methods and properties.
+type revision int
+
+// String returns the revision as stratisd names it, e.g. "r8"
+func (r revision) String() string {
+	return "r" + strconv.Itoa(int(r))
+}
+
+// poolInterface returns the name of the pool interface of this revision
+func (r revision) poolInterface() string {
+	return dbusService + ".pool." + r.String()
+}
+
+// filesystemInterface returns the name of the filesystem interface of this revision
+func (r revision) filesystemInterface() string {
+	return dbusService + ".filesystem." + r.String()
+}
+
+// sizeLimits reports whether filesystems have a size limit in this revision.
+// Before it, CreateFilesystems takes (name, size) specs and filesystems have
+// no SizeLimit property.
+func (r revision) sizeLimits() bool {
+	return r >= sizeLimitRevision
+}
+
+// negotiateRevision asks stratisd which revisions of its API it offers and
+// picks the newest one this plugin supports. A restarted stratisd keeps
+// offering it, even after an upgrade, so it is negotiated only once.
+func negotiateRevision(ctx context.Context, conn DBusConnection) (revision, error) {
+	var data string
+	err := conn.Object(dbusService, dbus.ObjectPath(dbusRootPath)).
+		CallWithContext(ctx, "org.freedesktop.DBus.Introspectable.Introspect", 0).
+		Store(&data)
+	if err != nil {
+		return 0, fmt.Errorf("introspect stratisd: %w", err)
+	}
+
+	var node introspect.Node
+	if err := xml.Unmarshal([]byte(data), &node); err != nil {
+		return 0, fmt.Errorf("parse stratisd introspection data: %w", err)
+	}
+
+	interfaces := make([]string, 0, len(node.Interfaces))
+	for _, iface := range node.Interfaces {
+		interfaces = append(interfaces, iface.Name)
+	}
+	return pickRevision(interfaces)
+}
+
+// pickRevision picks the newest supported revision among the interfaces of
+// stratisd's root object
+func pickRevision(interfaces []string) (revision, error) {
+	best, found := revision(0), false
+	var offered []string
+	for _, iface := range interfaces {
+		suffix, ok := strings.CutPrefix(iface, dbusManagerInterfacePrefix)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(suffix)
+		if err != nil {
+			continue
+		}
+		offered = append(offered, "r"+suffix)
+
+		if n < minRevision || n > maxRevision {
+			continue
+		}
+		if r := revision(n); !found || r > best {
+			best, found = r, true
+		}
+	}
+
+	if !found {
+		if len(offered) == 0 {
+			return 0, fmt.Errorf("stratisd offers no %s* interface", dbusManagerInterfacePrefix)
+		}
+		return 0, fmt.Errorf("stratisd offers API revisions %s, but only %s to %s are supported",
+			strings.Join(offered, ", "), revision(minRevision), revision(maxRevision))
+	}
+	return best, nil
+}
+
+// DetectRevision connects to the system bus and reports the revision of
+// stratisd's API the DBus backend would use
+func DetectRevision(ctx context.Context) (string, error) {
+	conn, err := ConnectSystemBus()
+	if err != nil {
+		return "", fmt.Errorf("connect to system bus: %w", err)
+	}
+	defer conn.Close()
+
+	rev, err := negotiateRevision(ctx, conn)
+	if err != nil {
+		return "", err
+	}
+	return rev.String(), nil
+}
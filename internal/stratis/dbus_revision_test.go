@@ -0,0 +1,132 @@
+package stratis
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestPickRevision(t *testing.T) {
+	tests := []struct {
+		name       string
+		interfaces []string
+		want       revision
+		wantErr    string
+	}{
+		{
+			name:       "newest supported",
+			interfaces: []string{"org.storage.stratis3.Manager.r0", "org.storage.stratis3.Manager.r6", "org.storage.stratis3.Manager.r7"},
+			want:       7,
+		},
+		{
+			name:       "newer than supported are skipped",
+			interfaces: []string{"org.storage.stratis3.Manager.r8", "org.storage.stratis3.Manager.r9", "org.storage.stratis3.Manager.r10"},
+			want:       8,
+		},
+		{
+			name:       "other interfaces are ignored",
+			interfaces: []string{"org.freedesktop.DBus.ObjectManager", "org.storage.stratis3.Manager.rx", "org.storage.stratis3.Manager.r2"},
+			want:       2,
+		},
+		{
+			name:       "only unsupported revisions",
+			interfaces: []string{"org.storage.stratis3.Manager.r9"},
+			wantErr:    "stratisd offers API revisions r9, but only r0 to r8 are supported",
+		},
+		{
+			name:       "not stratisd",
+			interfaces: []string{"org.freedesktop.DBus.ObjectManager"},
+			wantErr:    "stratisd offers no org.storage.stratis3.Manager.r* interface",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pickRevision(tt.interfaces)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("pickRevision() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("pickRevision() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("pickRevision() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDBusManager_NegotiatesRevision(t *testing.T) {
+	poolPath := dbus.ObjectPath("/org/storage/stratis3/pool/1")
+	objects := makeManagedObjects([]mockPool{{path: poolPath, name: "test-pool"}}, nil)
+
+	// An older stratisd only exports the older revision's interfaces
+	old := revision(4)
+	objects[poolPath] = map[string]map[string]dbus.Variant{
+		old.poolInterface(): {"Name": dbus.MakeVariant("test-pool")},
+	}
+	conn := &mockDBusConnection{
+		objects: map[dbus.ObjectPath]*mockBusObject{
+			dbus.ObjectPath(dbusRootPath): {
+				callResults: map[string]*dbus.Call{
+					introspectMethod:  introspectCall(0, 1, 2, 3, 4),
+					getManagedObjects: {Body: []any{objects}},
+				},
+			},
+		},
+	}
+
+	m, err := NewDBusManager("test-pool", WithConnection(conn))
+	if err != nil {
+		t.Fatalf("NewDBusManager() error = %v", err)
+	}
+	defer m.Close()
+
+	if got := m.Revision(); got != "r4" {
+		t.Errorf("Revision() = %s, want r4", got)
+	}
+	if exists, err := m.PoolExists(context.Background()); err != nil || !exists {
+		t.Errorf("PoolExists() = %v, %v, want true", exists, err)
+	}
+}
+
+func TestFilesystemSpecs(t *testing.T) {
+	limit := uint64(1 << 30)
+
+	tests := []struct {
+		name      string
+		rev       revision
+		sizeLimit *uint64
+		want      string
+		wantErr   bool
+	}{
+		{name: "with size limits", rev: 8, sizeLimit: &limit, want: "a(s(bs)(bs))"},
+		{name: "before size limits", rev: 4, want: "a(s(bs))"},
+		{name: "limit before size limits", rev: 4, sizeLimit: &limit, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &DBusManager{pool: "test-pool", rev: tt.rev}
+
+			specs, err := m.filesystemSpecs("vol1", tt.sizeLimit)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "r5 or newer") {
+					t.Fatalf("filesystemSpecs() error = %v, want size limits unsupported", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("filesystemSpecs() error = %v", err)
+			}
+			if got := dbus.SignatureOf(specs).String(); got != tt.want {
+				t.Errorf("signature = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
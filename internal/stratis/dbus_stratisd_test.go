@@ -0,0 +1,143 @@
+package stratis
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kriansa/podman-volume-stratis/internal/stratis/stratistest"
+)
+
+// newFakeStratisdManager creates a manager for pool on a fake stratisd
+func newFakeStratisdManager(t *testing.T, s *stratistest.Stratisd, pool string) *DBusManager {
+	t.Helper()
+
+	m, err := NewDBusManager(pool, WithConnectFunc(func() (DBusConnection, error) {
+		conn, err := s.Connect()
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}))
+	if err != nil {
+		t.Fatalf("NewDBusManager() error = %v", err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestDBusManager_FakeStratisd(t *testing.T) {
+	s := stratistest.Start(t)
+	s.AddPool("podman_vols")
+	m := newFakeStratisdManager(t, s, "podman_vols")
+	ctx := context.Background()
+
+	if m.Revision() != "r8" {
+		t.Errorf("Revision() = %s, want r8", m.Revision())
+	}
+	if exists, err := m.PoolExists(ctx); err != nil || !exists {
+		t.Fatalf("PoolExists() = %v, %v, want true", exists, err)
+	}
+
+	limit := uint64(1 << 30)
+	fs, err := m.Create(ctx, "vol1", &limit)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if fs.Total != limit || fs.SizeLimit == nil || *fs.SizeLimit != limit || fs.DevicePath != "/dev/stratis/podman_vols/vol1" {
+		t.Errorf("Create() = %+v, want a 1 GiB filesystem limited to its size", fs)
+	}
+
+	if _, err := m.Create(ctx, "vol1", &limit); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("Create() of a taken name error = %v, want ErrAlreadyExists", err)
+	}
+
+	small := uint64(1 << 20)
+	var stratisErr *Error
+	if _, err := m.Create(ctx, "tiny", &small); !errors.As(err, &stratisErr) || stratisErr.Code != 1 {
+		t.Errorf("Create() below the minimum size error = %v, want a stratisd error", err)
+	}
+
+	if _, err := m.Create(ctx, "thin", nil); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	s.Fail("CreateFilesystems", "Not enough space in pool to create the filesystem")
+	if _, err := m.Create(ctx, "vol2", nil); !errors.Is(err, ErrNoSpace) {
+		t.Errorf("Create() on a full pool error = %v, want ErrNoSpace", err)
+	}
+
+	snap, err := m.Snapshot(ctx, "vol1", "snap1")
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if snap.SizeLimit == nil || *snap.SizeLimit != limit || snap.UUID == fs.UUID {
+		t.Errorf("Snapshot() = %+v, want the limit of the origin and its own UUID", snap)
+	}
+
+	s.SetUsed("podman_vols", "vol1", 100<<20)
+	eventually(t, "used bytes to change", func() bool {
+		got, err := m.GetByName(ctx, "vol1")
+		return err == nil && got.Used == 100<<20
+	})
+
+	list, err := m.List(ctx)
+	if err != nil || len(list) != 3 {
+		t.Fatalf("List() = %+v, %v, want vol1, thin and snap1", list, err)
+	}
+
+	if err := m.Delete(ctx, "vol1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := m.GetByName(ctx, "vol1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetByName() after Delete() error = %v, want ErrNotFound", err)
+	}
+	if err := m.Delete(ctx, "vol1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() of a missing filesystem error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestDBusManager_FakeStratisdBeforeSizeLimits(t *testing.T) {
+	s := stratistest.Start(t, stratistest.WithRevision(4))
+	s.AddPool("podman_vols")
+	m := newFakeStratisdManager(t, s, "podman_vols")
+	ctx := context.Background()
+
+	if m.Revision() != "r4" {
+		t.Errorf("Revision() = %s, want r4", m.Revision())
+	}
+
+	fs, err := m.Create(ctx, "vol1", nil)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if fs.SizeLimit != nil {
+		t.Errorf("Create() = %+v, want no size limit", fs)
+	}
+
+	limit := uint64(1 << 30)
+	if _, err := m.Create(ctx, "vol2", &limit); err == nil {
+		t.Error("Create() with a size limit succeeded on r4, want an error")
+	}
+}
+
+func TestDBusManager_FakeStratisdRestart(t *testing.T) {
+	s := stratistest.Start(t)
+	s.AddPool("podman_vols")
+	m := newFakeStratisdManager(t, s, "podman_vols")
+	ctx := context.Background()
+
+	if _, err := m.Create(ctx, "vol1", nil); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	s.Restart()
+
+	// The filesystem is back under a new path, which Delete must use
+	if err := m.Delete(ctx, "vol1"); err != nil {
+		t.Fatalf("Delete() after restart error = %v", err)
+	}
+	if names := s.Filesystems("podman_vols"); len(names) != 0 {
+		t.Errorf("filesystems after Delete() = %v, want none", names)
+	}
+}
@@ -2,33 +2,83 @@ package stratis
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/godbus/dbus/v5"
 	"github.com/kriansa/podman-volume-stratis/internal/log"
+	"github.com/kriansa/podman-volume-stratis/internal/stratis/stratistest"
 )
 
 func TestMain(m *testing.M) {
+	// The fake stratis-cli of the CLI conformance tests is this binary
+	stratistest.RunCLI()
+
 	// Initialize logger for tests
 	log.Setup(false)
 	os.Exit(m.Run())
 }
 
+// testRevision is the revision of stratisd's API the mocks offer
+const testRevision revision = maxRevision
+
+const introspectMethod = "org.freedesktop.DBus.Introspectable.Introspect"
+
+// introspectCall is the introspection reply of a stratisd root object
+// offering the given revisions
+func introspectCall(revs ...revision) *dbus.Call {
+	var b strings.Builder
+	b.WriteString(`<node name="/org/storage/stratis3">`)
+	b.WriteString(`<interface name="org.freedesktop.DBus.ObjectManager"></interface>`)
+	for _, r := range revs {
+		fmt.Fprintf(&b, `<interface name="%s%d"></interface>`, dbusManagerInterfacePrefix, r)
+	}
+	b.WriteString(`</node>`)
+	return &dbus.Call{Body: []any{b.String()}}
+}
+
 // mockBusObject implements dbus.BusObject for testing
 type mockBusObject struct {
 	callResults map[string]*dbus.Call
+	// onCall, if set, runs before a method returns its result
+	onCall map[string]func()
+	calls  map[string]int
+	mu     sync.Mutex
 }
 
 func (m *mockBusObject) Call(method string, flags dbus.Flags, args ...any) *dbus.Call {
+	m.mu.Lock()
+	if m.calls == nil {
+		m.calls = make(map[string]int)
+	}
+	m.calls[method]++
+	m.mu.Unlock()
+
+	if fn, ok := m.onCall[method]; ok {
+		fn()
+	}
 	if call, ok := m.callResults[method]; ok {
 		return call
 	}
 	return &dbus.Call{Err: dbus.ErrMsgNoObject}
 }
 
-func (m *mockBusObject) CallWithContext(_ context.Context, method string, flags dbus.Flags, args ...any) *dbus.Call {
-	return m.Call(method, flags, args...)
+// CallWithContext gives up when ctx is done, like godbus does
+func (m *mockBusObject) CallWithContext(ctx context.Context, method string, flags dbus.Flags, args ...any) *dbus.Call {
+	done := make(chan *dbus.Call, 1)
+	go func() { done <- m.Call(method, flags, args...) }()
+
+	select {
+	case call := <-done:
+		return call
+	case <-ctx.Done():
+		return &dbus.Call{Err: ctx.Err()}
+	}
 }
 
 func (m *mockBusObject) Go(method string, flags dbus.Flags, ch chan *dbus.Call, args ...any) *dbus.Call {
@@ -67,9 +117,55 @@ func (m *mockBusObject) Path() dbus.ObjectPath {
 	return dbus.ObjectPath(dbusRootPath)
 }
 
+// callCount returns how many times method was called
+func (m *mockBusObject) callCount(method string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls[method]
+}
+
 // mockDBusConnection implements DBusConnection for testing
 type mockDBusConnection struct {
 	objects map[dbus.ObjectPath]*mockBusObject
+
+	mu      sync.Mutex
+	signals []chan<- *dbus.Signal
+}
+
+func (m *mockDBusConnection) AddMatchSignal(options ...dbus.MatchOption) error {
+	return nil
+}
+
+func (m *mockDBusConnection) Signal(ch chan<- *dbus.Signal) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.signals = append(m.signals, ch)
+}
+
+func (m *mockDBusConnection) RemoveSignal(ch chan<- *dbus.Signal) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.signals = slices.DeleteFunc(m.signals, func(c chan<- *dbus.Signal) bool { return c == ch })
+}
+
+// disconnect closes every registered signal channel, as godbus does when
+// the connection dies
+func (m *mockDBusConnection) disconnect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ch := range m.signals {
+		close(ch)
+	}
+	m.signals = nil
+}
+
+// emit delivers a signal from stratisd to every registered channel
+func (m *mockDBusConnection) emit(path dbus.ObjectPath, name string, body ...any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, ch := range m.signals {
+		ch <- &dbus.Signal{Sender: dbusService, Path: path, Name: name, Body: body}
+	}
 }
 
 func (m *mockDBusConnection) Object(dest string, path dbus.ObjectPath) dbus.BusObject {
@@ -90,7 +186,7 @@ func makeManagedObjects(pools []mockPool, filesystems []mockFilesystem) map[dbus
 
 	for _, p := range pools {
 		result[p.path] = map[string]map[string]dbus.Variant{
-			dbusPoolInterface: {
+			testRevision.poolInterface(): {
 				"Name": dbus.MakeVariant(p.name),
 			},
 		}
@@ -98,7 +194,7 @@ func makeManagedObjects(pools []mockPool, filesystems []mockFilesystem) map[dbus
 
 	for _, fs := range filesystems {
 		result[fs.path] = map[string]map[string]dbus.Variant{
-			dbusFilesystemInterface: {
+			testRevision.filesystemInterface(): {
 				"Name":      dbus.MakeVariant(fs.name),
 				"Pool":      dbus.MakeVariant(fs.poolPath),
 				"Uuid":      dbus.MakeVariant(fs.uuid),
@@ -174,6 +270,7 @@ func TestDBusManager_PoolExists(t *testing.T) {
 
 			rootObj := &mockBusObject{
 				callResults: map[string]*dbus.Call{
+					introspectMethod: introspectCall(testRevision),
 					dbusObjectManager + ".GetManagedObjects": {
 						Body: []any{managedObjects},
 					},
@@ -191,7 +288,7 @@ func TestDBusManager_PoolExists(t *testing.T) {
 				t.Fatalf("NewDBusManager() error = %v", err)
 			}
 
-			got, err := m.PoolExists()
+			got, err := m.PoolExists(context.Background())
 			if (err != nil) != tt.wantErr {
 				t.Errorf("PoolExists() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -268,6 +365,7 @@ func TestDBusManager_List(t *testing.T) {
 
 			rootObj := &mockBusObject{
 				callResults: map[string]*dbus.Call{
+					introspectMethod: introspectCall(testRevision),
 					dbusObjectManager + ".GetManagedObjects": {
 						Body: []any{managedObjects},
 					},
@@ -285,7 +383,7 @@ func TestDBusManager_List(t *testing.T) {
 				t.Fatalf("NewDBusManager() error = %v", err)
 			}
 
-			got, err := m.List()
+			got, err := m.List(context.Background())
 			if (err != nil) != tt.wantErr {
 				t.Errorf("List() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -371,6 +469,7 @@ func TestDBusManager_GetByName(t *testing.T) {
 
 			rootObj := &mockBusObject{
 				callResults: map[string]*dbus.Call{
+					introspectMethod: introspectCall(testRevision),
 					dbusObjectManager + ".GetManagedObjects": {
 						Body: []any{managedObjects},
 					},
@@ -388,7 +487,7 @@ func TestDBusManager_GetByName(t *testing.T) {
 				t.Fatalf("NewDBusManager() error = %v", err)
 			}
 
-			got, err := m.GetByName(tt.fsName)
+			got, err := m.GetByName(context.Background(), tt.fsName)
 			if tt.wantErr != nil {
 				if err != tt.wantErr {
 					t.Errorf("GetByName() error = %v, wantErr %v", err, tt.wantErr)
@@ -409,7 +508,7 @@ func TestDBusManager_GetByName(t *testing.T) {
 }
 
 func TestParseFilesystemFromProps(t *testing.T) {
-	m := &DBusManager{pool: "test-pool"}
+	m := &DBusManager{pool: "test-pool", rev: testRevision}
 
 	tests := []struct {
 		name      string
@@ -534,6 +633,7 @@ func TestCheckReturnCode(t *testing.T) {
 		returnCode uint16
 		message    string
 		wantErr    bool
+		wantKind   error
 	}{
 		{
 			name:       "success",
@@ -547,14 +647,24 @@ func TestCheckReturnCode(t *testing.T) {
 			message:    "something went wrong",
 			wantErr:    true,
 		},
+		{
+			name:       "classified error",
+			returnCode: 1,
+			message:    "Pool has no space left for a new filesystem",
+			wantErr:    true,
+			wantKind:   ErrNoSpace,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := checkReturnCode(tt.returnCode, tt.message)
+			err := checkReturnCode("CreateFilesystems", tt.returnCode, tt.message)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("checkReturnCode() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if tt.wantKind != nil && !errors.Is(err, tt.wantKind) {
+				t.Errorf("checkReturnCode() error = %v, want %v", err, tt.wantKind)
+			}
 		})
 	}
 }
@@ -0,0 +1,95 @@
+package stratis
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors shared by every backend. Backends wrap them, so callers check them
+// with errors.Is.
+var (
+	// ErrNotFound is returned when a filesystem is not found
+	ErrNotFound = errors.New("filesystem not found")
+	// ErrAlreadyExists is returned when creating a filesystem whose name is taken
+	ErrAlreadyExists = errors.New("filesystem already exists")
+	// ErrPoolNotFound is returned when the configured pool does not exist
+	ErrPoolNotFound = errors.New("pool not found")
+	// ErrNoSpace is returned when the pool has no room for the operation
+	ErrNoSpace = errors.New("not enough space in pool")
+	// ErrBusy is returned when a filesystem or device is in use
+	ErrBusy = errors.New("filesystem is busy")
+	// ErrLocked is returned when the pool is encrypted and locked
+	ErrLocked = errors.New("pool is locked")
+	// ErrStratisdUnavailable is returned when stratisd cannot be reached
+	ErrStratisdUnavailable = errors.New("stratisd is unavailable")
+)
+
+// Error is a failure reported by stratisd, either as a DBus return code or
+// by stratis-cli. It wraps the shared error it corresponds to, if any.
+type Error struct {
+	// Op is the failed DBus method or stratis command
+	Op string
+	// Code is stratisd's return code; zero for stratis-cli failures
+	Code uint16
+	// Message is stratisd's message, or stratis-cli's output
+	Message string
+	// Kind is the shared error the failure corresponds to, or nil
+	Kind error
+}
+
+func (e *Error) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("%s: stratisd error (code %d): %s", e.Op, e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Op, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// newError builds the Error for a failure reported by stratisd
+func newError(op string, code uint16, message string) *Error {
+	return &Error{
+		Op:      op,
+		Code:    code,
+		Message: message,
+		Kind:    classify(message),
+	}
+}
+
+// classify maps a message of stratisd or stratis-cli onto a shared error.
+// stratisd uses one return code for almost every failure, so the message is
+// all there is to go by.
+func classify(message string) error {
+	msg := strings.ToLower(message)
+	missing := strings.Contains(msg, "does not exist") ||
+		strings.Contains(msg, "not found") ||
+		strings.Contains(msg, "no such")
+
+	switch {
+	case strings.Contains(msg, "serviceunknown"),
+		strings.Contains(msg, "namehasnoowner"),
+		strings.Contains(msg, "unable to connect to the stratisd"),
+		strings.Contains(msg, "stratisd") && strings.Contains(msg, "not running"):
+		return ErrStratisdUnavailable
+	case strings.Contains(msg, "already exists"):
+		return ErrAlreadyExists
+	case missing && strings.Contains(msg, "filesystem"):
+		return ErrNotFound
+	case missing && strings.Contains(msg, "pool"):
+		return ErrPoolNotFound
+	case strings.Contains(msg, "locked"):
+		return ErrLocked
+	case strings.Contains(msg, "no space"),
+		strings.Contains(msg, "not enough space"),
+		strings.Contains(msg, "insufficient space"),
+		strings.Contains(msg, "out of space"):
+		return ErrNoSpace
+	case strings.Contains(msg, "busy"):
+		return ErrBusy
+	default:
+		return nil
+	}
+}
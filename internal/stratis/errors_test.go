@@ -0,0 +1,41 @@
+package stratis
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		message string
+		want    error
+	}{
+		{`Execution failed: stratisd reported an error: filesystem which does not exist: vol1`, ErrNotFound},
+		{`Execution failure caused by: no pool found named "podman_vols": pool which does not exist`, ErrPoolNotFound},
+		{`A filesystem with name "vol1" already exists`, ErrAlreadyExists},
+		{`Not enough space in pool to allocate a new filesystem`, ErrNoSpace},
+		{`Device or resource busy (os error 16)`, ErrBusy},
+		{`Pool podman_vols is locked; unlock it before use`, ErrLocked},
+		{`org.freedesktop.DBus.Error.ServiceUnknown: The name org.storage.stratis3 was not provided by any .service files`, ErrStratisdUnavailable},
+		{`Execution failed: Most likely stratis is unable to connect to the stratisd D-Bus service.`, ErrStratisdUnavailable},
+		{`Invalid size specification`, nil},
+	}
+
+	for _, tt := range tests {
+		if got := classify(tt.message); got != tt.want {
+			t.Errorf("classify(%q) = %v, want %v", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestError_Unwrap(t *testing.T) {
+	err := error(newError("stratis fs create podman_vols vol1", 0, `A filesystem with name "vol1" already exists`))
+
+	if !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("errors.Is(%v, ErrAlreadyExists) = false", err)
+	}
+	var stratisErr *Error
+	if !errors.As(err, &stratisErr) || stratisErr.Op != "stratis fs create podman_vols vol1" {
+		t.Errorf("errors.As(%v) did not give the failed operation", err)
+	}
+}
@@ -0,0 +1,9 @@
+package stratis
+
+import "testing"
+
+// FakeLoopTools puts fake losetup and mkfs.xfs first in PATH, for the
+// loopfile backend in external tests
+func FakeLoopTools(t *testing.T) {
+	fakeLoopTools(t)
+}
@@ -0,0 +1,597 @@
+package stratis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+)
+
+const (
+	// DefaultLoopfileDir is where the loopfile backend keeps its pools by default
+	DefaultLoopfileDir = "/var/lib/podman-volume-stratis/loopfile"
+
+	// loopfileDefaultSize is the size of the image of a filesystem without a
+	// size limit. Images are sparse, so like a Stratis filesystem it takes
+	// only the space written to; 1 TiB is also what stratisd gives them.
+	loopfileDefaultSize = 1 << 40
+
+	// loopfileImageExt and loopfileMetaExt name the image of a filesystem
+	// and the file with what the image cannot record
+	loopfileImageExt = ".img"
+	loopfileMetaExt  = ".json"
+
+	// ioctlFICLONE is FICLONE from linux/fs.h, which makes dst share the
+	// blocks of src
+	ioctlFICLONE = 0x40049409
+)
+
+// LoopfileManager implements Manager without Stratis, for development on
+// machines without a pool. The pool is a directory; every filesystem in it
+// is a sparse image file formatted as XFS and attached to a loop device. A
+// size limit is the size of the image, and snapshots are reflink copies of
+// it, so the directory must be on a filesystem with reflinks for them, such
+// as XFS or Btrfs.
+type LoopfileManager struct {
+	pool string
+	dir  string
+	// mu serializes changes to the images and attaching them, so an image
+	// is never attached twice
+	mu sync.Mutex
+}
+
+// loopfileMeta is what is kept next to an image
+type loopfileMeta struct {
+	UUID      string  `json:"uuid"`
+	SizeLimit *uint64 `json:"size_limit,omitempty"`
+}
+
+// NewLoopfileManager creates a loopfile manager for the pool kept in a
+// directory of baseDir, creating the directory if needed
+func NewLoopfileManager(pool, baseDir string) (*LoopfileManager, error) {
+	if pool == "" || strings.ContainsRune(pool, '/') {
+		return nil, fmt.Errorf("invalid pool name %q", pool)
+	}
+
+	dir := filepath.Join(baseDir, pool)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create pool directory: %w", err)
+	}
+
+	return &LoopfileManager{
+		pool: pool,
+		dir:  dir,
+	}, nil
+}
+
+// imagePath returns the path of the image of a filesystem
+func (m *LoopfileManager) imagePath(name string) string {
+	return filepath.Join(m.dir, name+loopfileImageExt)
+}
+
+// metaPath returns the path of the metadata of a filesystem
+func (m *LoopfileManager) metaPath(name string) string {
+	return filepath.Join(m.dir, name+loopfileMetaExt)
+}
+
+// checkName rejects names that would put an image outside the pool directory
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, '/') {
+		return fmt.Errorf("invalid filesystem name %q", name)
+	}
+	return nil
+}
+
+// PoolExists checks if the pool directory exists
+func (m *LoopfileManager) PoolExists(ctx context.Context) (bool, error) {
+	info, err := os.Stat(m.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("check pool: %w", err)
+	}
+	return info.IsDir(), nil
+}
+
+// List returns all filesystems in the pool
+func (m *LoopfileManager) List(ctx context.Context) ([]Filesystem, error) {
+	log.Debug("listing filesystems", "pool", m.pool, "dir", m.dir)
+
+	entries, err := os.ReadDir(m.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("list filesystems: %w: %s", ErrPoolNotFound, m.pool)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list filesystems: %w", err)
+	}
+
+	var filesystems []Filesystem
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), loopfileImageExt)
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+
+		fs, err := m.filesystem(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			// Deleted meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list filesystems: %w", err)
+		}
+		filesystems = append(filesystems, *fs)
+	}
+
+	return filesystems, nil
+}
+
+// GetByName returns the filesystem with the given name
+func (m *LoopfileManager) GetByName(ctx context.Context, name string) (*Filesystem, error) {
+	log.Debug("getting filesystem by name", "name", name, "pool", m.pool)
+
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+
+	fs, err := m.filesystem(ctx, name)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("get filesystem: %w", err)
+	}
+	return fs, nil
+}
+
+// filesystem describes the filesystem of an image, attaching the image to a
+// loop device if it is not, e.g. after a reboot
+func (m *LoopfileManager) filesystem(ctx context.Context, name string) (*Filesystem, error) {
+	image := m.imagePath(name)
+
+	info, err := os.Stat(image)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("stat image: %w", err)
+	}
+
+	// The metadata is written last on creating and removed last on
+	// deleting; without it, the filesystem is not there yet, or any more
+	meta, err := m.readMeta(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	device, err := m.attach(ctx, image)
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Like a thin Stratis filesystem, the image takes only the blocks
+	// written to
+	total := uint64(info.Size())
+	var used uint64
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		used = uint64(st.Blocks) * 512
+	}
+
+	return &Filesystem{
+		Name:       name,
+		Pool:       m.pool,
+		DevicePath: device,
+		Total:      total,
+		Used:       used,
+		Free:       freeBytes(total, used),
+		SizeLimit:  meta.SizeLimit,
+		UUID:       meta.UUID,
+	}, nil
+}
+
+// readMeta reads the metadata of a filesystem
+func (m *LoopfileManager) readMeta(name string) (*loopfileMeta, error) {
+	data, err := os.ReadFile(m.metaPath(name))
+	if err != nil {
+		return nil, fmt.Errorf("read metadata: %w", err)
+	}
+
+	var meta loopfileMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("parse metadata of %s: %w", name, err)
+	}
+	return &meta, nil
+}
+
+// writeMeta writes the metadata of a filesystem
+func (m *LoopfileManager) writeMeta(name string, meta *loopfileMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("encode metadata: %w", err)
+	}
+	if err := os.WriteFile(m.metaPath(name), data, 0600); err != nil {
+		return fmt.Errorf("write metadata: %w", err)
+	}
+	return nil
+}
+
+// Create creates a new filesystem with the given name and optional size
+// limit, which becomes the size of its image
+func (m *LoopfileManager) Create(ctx context.Context, name string, sizeLimit *uint64) (*Filesystem, error) {
+	log.Debug("creating filesystem", "name", name, "pool", m.pool, "sizeLimit", sizeLimit)
+
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+
+	size := uint64(loopfileDefaultSize)
+	if sizeLimit != nil {
+		size = *sizeLimit
+	}
+
+	uuid, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	err = m.createImage(ctx, name, size, &loopfileMeta{UUID: uuid, SizeLimit: sizeLimit})
+	m.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("create filesystem: %w", err)
+	}
+
+	fs, err := m.GetByName(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("get created filesystem: %w", err)
+	}
+
+	log.Debug("filesystem created", "name", name, "device", fs.DevicePath)
+	return fs, nil
+}
+
+// createImage creates and formats the image of a new filesystem. Must be
+// called with mu held.
+func (m *LoopfileManager) createImage(ctx context.Context, name string, size uint64, meta *loopfileMeta) (err error) {
+	image := m.imagePath(name)
+
+	f, err := os.OpenFile(image, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, name)
+	}
+	if err != nil {
+		return fmt.Errorf("create image: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			m.removeImage(name)
+		}
+	}()
+
+	err = f.Truncate(int64(size))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("size image: %w", err)
+	}
+
+	if _, err := runCommand(ctx, "mkfs.xfs", "-q", "-m", "reflink=1,uuid="+meta.UUID, image); err != nil {
+		return err
+	}
+
+	return m.writeMeta(name, meta)
+}
+
+// Snapshot creates the filesystem name as a reflink copy of the image of
+// origin. The copy of a mounted origin is as consistent as after a crash.
+func (m *LoopfileManager) Snapshot(ctx context.Context, origin, name string) (*Filesystem, error) {
+	log.Debug("snapshotting filesystem", "origin", origin, "name", name, "pool", m.pool)
+
+	if err := checkName(origin); err != nil {
+		return nil, err
+	}
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+
+	uuid, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	err = m.snapshotImage(ctx, origin, name, uuid)
+	m.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("snapshot filesystem: %w", err)
+	}
+
+	fs, err := m.GetByName(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("get snapshot filesystem: %w", err)
+	}
+
+	log.Debug("filesystem snapshotted", "origin", origin, "name", name, "device", fs.DevicePath)
+	return fs, nil
+}
+
+// snapshotImage clones the image of origin and gives the copy its own XFS
+// UUID, so both can be mounted at once. Must be called with mu held.
+func (m *LoopfileManager) snapshotImage(ctx context.Context, origin, name, uuid string) (err error) {
+	meta, err := m.readMeta(origin)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrNotFound, origin)
+	}
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(m.imagePath(origin))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrNotFound, origin)
+	}
+	if err != nil {
+		return fmt.Errorf("open origin image: %w", err)
+	}
+	defer src.Close()
+
+	image := m.imagePath(name)
+	dst, err := os.OpenFile(image, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, name)
+	}
+	if err != nil {
+		return fmt.Errorf("create image: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			m.removeImage(name)
+		}
+	}()
+
+	err = cloneFile(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	device, err := m.attach(ctx, image)
+	if err != nil {
+		return err
+	}
+
+	// xfs_admin refuses a dirty log, as in a copy of a mounted filesystem.
+	// Mounting the copy replays it; nouuid lets it mount next to its origin.
+	if err := replayLog(device); err != nil {
+		return err
+	}
+	if _, err := runCommand(ctx, "xfs_admin", "-U", uuid, device); err != nil {
+		return err
+	}
+
+	return m.writeMeta(name, &loopfileMeta{UUID: uuid, SizeLimit: meta.SizeLimit})
+}
+
+// cloneFile makes dst share all blocks of src
+func cloneFile(dst, src *os.File) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ioctlFICLONE, src.Fd())
+	switch errno {
+	case 0:
+		return nil
+	case syscall.EOPNOTSUPP, syscall.EXDEV, syscall.EINVAL, syscall.ENOTTY:
+		return fmt.Errorf("clone image: the pool directory is on a filesystem without reflinks (%w)", errno)
+	default:
+		return fmt.Errorf("clone image: %w", errno)
+	}
+}
+
+// replayLog mounts and unmounts an XFS device, replaying its log
+func replayLog(device string) error {
+	dir, err := os.MkdirTemp("", "podman-volume-stratis-snapshot-")
+	if err != nil {
+		return fmt.Errorf("create temporary mount point: %w", err)
+	}
+	defer os.Remove(dir)
+
+	if err := syscall.Mount(device, dir, "xfs", 0, "nouuid"); err != nil {
+		return fmt.Errorf("mount %s to replay its log: %w", device, err)
+	}
+	if err := syscall.Unmount(dir, 0); err != nil {
+		return fmt.Errorf("unmount %s after replaying its log: %w", device, err)
+	}
+	return nil
+}
+
+// Delete detaches and removes the image of the filesystem with the given name
+func (m *LoopfileManager) Delete(ctx context.Context, name string) error {
+	log.Debug("deleting filesystem", "name", name, "pool", m.pool)
+
+	if err := checkName(name); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	image := m.imagePath(name)
+	if _, err := os.Stat(image); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("delete filesystem: %w", err)
+	}
+
+	if err := m.detach(ctx, image); err != nil {
+		return fmt.Errorf("delete filesystem: %w", err)
+	}
+	if err := m.removeImage(name); err != nil {
+		return fmt.Errorf("delete filesystem: %w", err)
+	}
+
+	log.Debug("filesystem deleted", "name", name)
+	return nil
+}
+
+// Rename renames the image of the filesystem name and its metadata. The
+// image stays attached to its loop device.
+func (m *LoopfileManager) Rename(ctx context.Context, name, newName string) error {
+	log.Debug("renaming filesystem", "name", name, "newName", newName, "pool", m.pool)
+
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if err := checkName(newName); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := os.Stat(m.metaPath(name)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("rename filesystem: %w", err)
+	}
+	if name == newName {
+		return nil
+	}
+
+	// The link claims the new name. Moving the metadata then makes the
+	// filesystem appear under it and vanish under the old one at once.
+	err := os.Link(m.imagePath(name), m.imagePath(newName))
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("rename filesystem: %w: %s", ErrAlreadyExists, newName)
+	}
+	if err != nil {
+		return fmt.Errorf("rename filesystem: %w", err)
+	}
+	if err := os.Rename(m.metaPath(name), m.metaPath(newName)); err != nil {
+		os.Remove(m.imagePath(newName))
+		return fmt.Errorf("rename filesystem: %w", err)
+	}
+	if err := os.Remove(m.imagePath(name)); err != nil {
+		log.Warn("failed to remove old image name", "path", m.imagePath(name), "error", err)
+	}
+
+	log.Debug("filesystem renamed", "name", name, "newName", newName)
+	return nil
+}
+
+// removeImage removes the image of a filesystem and its metadata
+func (m *LoopfileManager) removeImage(name string) error {
+	var errs []error
+	for _, path := range []string{m.imagePath(name), m.metaPath(name)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// loopDevices returns the loop devices an image is attached to
+func loopDevices(ctx context.Context, image string) ([]string, error) {
+	output, err := runCommand(ctx, "losetup", "--noheadings", "--output", "NAME", "--associated", image)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(output)), nil
+}
+
+// attach returns the loop device an image is attached to, attaching it
+// first if needed. Must be called with mu held.
+func (m *LoopfileManager) attach(ctx context.Context, image string) (string, error) {
+	devices, err := loopDevices(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	if len(devices) > 0 {
+		return devices[0], nil
+	}
+
+	output, err := runCommand(ctx, "losetup", "--find", "--show", image)
+	if err != nil {
+		return "", err
+	}
+	device := strings.TrimSpace(string(output))
+	log.Debug("attached image to loop device", "image", image, "device", device)
+	return device, nil
+}
+
+// detach detaches an image from its loop devices. It fails with ErrBusy if
+// one is in use, e.g. mounted, as stratisd fails to destroy a mounted
+// filesystem. Must be called with mu held.
+func (m *LoopfileManager) detach(ctx context.Context, image string) error {
+	devices, err := loopDevices(ctx, image)
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		// An exclusive open fails while the device is mounted
+		f, err := os.OpenFile(device, os.O_RDONLY|syscall.O_EXCL, 0)
+		if errors.Is(err, syscall.EBUSY) {
+			return fmt.Errorf("%w: %s is in use", ErrBusy, device)
+		}
+		if err == nil {
+			f.Close()
+		}
+
+		if _, err := runCommand(ctx, "losetup", "--detach", device); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCommand runs a command and returns its output. The process is killed
+// when ctx is done.
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = cliWaitDelay
+	output, err := cmd.Output()
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		return output, fmt.Errorf("%s: %w", name, ctxErr)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		message := strings.TrimSpace(string(exitErr.Stderr))
+		if message == "" {
+			message = err.Error()
+		}
+		return output, newError(name+" "+strings.Join(args, " "), 0, message)
+	}
+	if err != nil {
+		return output, fmt.Errorf("%s: %w", name, err)
+	}
+	return output, nil
+}
+
+// newUUID returns a random UUID
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("generate uuid: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,118 @@
+package stratis
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeLoopTools puts losetup and mkfs.xfs scripts in PATH. losetup attaches
+// images to made-up devices, remembering them in a file.
+func fakeLoopTools(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	attached := filepath.Join(dir, "attached")
+	scripts := map[string]string{
+		"losetup": `
+case "$1" in
+--find)
+	echo "$3 /dev/fakeloop-$(basename "$3" .img)" >> ` + attached + `
+	echo "/dev/fakeloop-$(basename "$3" .img)" ;;
+--noheadings)
+	grep -F "$5 " ` + attached + ` 2>/dev/null | cut -d' ' -f2 ;;
+--detach)
+	grep -vF " $2" ` + attached + ` > ` + attached + `.new
+	mv ` + attached + `.new ` + attached + ` ;;
+esac`,
+		"mkfs.xfs": `exit 0`,
+	}
+	for name, body := range scripts {
+		script := "#!/bin/sh\n" + body + "\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestLoopfileManager(t *testing.T) {
+	fakeLoopTools(t)
+	ctx := context.Background()
+
+	m, err := NewLoopfileManager("dev", t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLoopfileManager() error = %v", err)
+	}
+
+	if exists, err := m.PoolExists(ctx); err != nil || !exists {
+		t.Fatalf("PoolExists() = %v, %v, want true", exists, err)
+	}
+
+	limit := uint64(512 << 20)
+	fs, err := m.Create(ctx, "vol1", &limit)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if fs.Total != limit || fs.SizeLimit == nil || *fs.SizeLimit != limit {
+		t.Errorf("Create() = %+v, want an image of the size limit", fs)
+	}
+	if fs.DevicePath != "/dev/fakeloop-vol1" || fs.UUID == "" {
+		t.Errorf("Create() = %+v, want it attached, with a UUID", fs)
+	}
+
+	if _, err := m.Create(ctx, "vol1", nil); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("Create() of a taken name error = %v, want ErrAlreadyExists", err)
+	}
+
+	thin, err := m.Create(ctx, "vol2", nil)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if thin.Total != loopfileDefaultSize || thin.SizeLimit != nil || thin.Used >= thin.Total {
+		t.Errorf("Create() = %+v, want a sparse image without a size limit", thin)
+	}
+
+	list, err := m.List(ctx)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("List() = %+v, %v, want both filesystems", list, err)
+	}
+
+	// Reattached with the same device, not attached again
+	got, err := m.GetByName(ctx, "vol1")
+	if err != nil || got.DevicePath != fs.DevicePath || got.UUID != fs.UUID {
+		t.Errorf("GetByName() = %+v, %v, want %+v", got, err, fs)
+	}
+
+	if err := m.Delete(ctx, "vol1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := m.GetByName(ctx, "vol1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetByName() after Delete() error = %v, want ErrNotFound", err)
+	}
+	if err := m.Delete(ctx, "vol1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() of a missing filesystem error = %v, want ErrNotFound", err)
+	}
+
+	if _, err := m.Snapshot(ctx, "vol1", "snap1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Snapshot() of a missing origin error = %v, want ErrNotFound", err)
+	}
+	if _, err := m.Snapshot(ctx, "vol2", "vol2"); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("Snapshot() onto a taken name error = %v, want ErrAlreadyExists", err)
+	}
+}
+
+func TestLoopfileManager_RejectsPaths(t *testing.T) {
+	m, err := NewLoopfileManager("dev", t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLoopfileManager() error = %v", err)
+	}
+
+	for _, name := range []string{"", "..", "../vol1", "a/b"} {
+		if _, err := m.Create(context.Background(), name, nil); err == nil {
+			t.Errorf("Create(%q) succeeded, want an error", name)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package stratis
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+	"github.com/kriansa/podman-volume-stratis/internal/procmounts"
+)
+
+// memoryDefaultSize is the reported size of a filesystem without a size
+// limit, as stratisd gives them
+const memoryDefaultSize = 1 << 40
+
+// MemoryManager implements Manager with in-process records, for testing
+// without root or stratisd. Every filesystem is a plain directory, which the
+// bind mounter can mount; its "device" is the directory. Size limits are
+// only reported, not enforced, and snapshots are full copies.
+//
+// Nothing outlives the process: every manager starts with an empty pool in a
+// new directory.
+type MemoryManager struct {
+	pool string
+	dir  string
+
+	mu          sync.Mutex
+	filesystems map[string]*Filesystem
+}
+
+// NewMemoryManager creates an empty in-memory pool, keeping its directories
+// in a new directory of baseDir, or of the system's temporary directory if
+// baseDir is empty
+func NewMemoryManager(pool, baseDir string) (*MemoryManager, error) {
+	if baseDir != "" {
+		if err := os.MkdirAll(baseDir, 0755); err != nil {
+			return nil, fmt.Errorf("create memory backend directory: %w", err)
+		}
+	}
+
+	dir, err := os.MkdirTemp(baseDir, "podman-volume-stratis-"+pool+"-")
+	if err != nil {
+		return nil, fmt.Errorf("create pool directory: %w", err)
+	}
+	// Podman, possibly in another user namespace, must get to the volumes
+	if err := os.Chmod(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create pool directory: %w", err)
+	}
+
+	log.Info("memory backend keeps volumes in a temporary directory", "pool", pool, "dir", dir)
+	return &MemoryManager{
+		pool:        pool,
+		dir:         dir,
+		filesystems: make(map[string]*Filesystem),
+	}, nil
+}
+
+// PoolExists reports that the pool exists; it does as long as the manager
+func (m *MemoryManager) PoolExists(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// List returns all filesystems in the pool
+func (m *MemoryManager) List(ctx context.Context) ([]Filesystem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	filesystems := make([]Filesystem, 0, len(m.filesystems))
+	for _, fs := range m.filesystems {
+		filesystems = append(filesystems, m.describe(fs))
+	}
+	sort.Slice(filesystems, func(i, j int) bool { return filesystems[i].Name < filesystems[j].Name })
+	return filesystems, nil
+}
+
+// GetByName returns the filesystem with the given name
+func (m *MemoryManager) GetByName(ctx context.Context, name string) (*Filesystem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fs, ok := m.filesystems[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	f := m.describe(fs)
+	return &f, nil
+}
+
+// describe returns a copy of a record with its current usage. Must be
+// called with mu held.
+func (m *MemoryManager) describe(fs *Filesystem) Filesystem {
+	f := *fs
+	if fs.SizeLimit != nil {
+		limit := *fs.SizeLimit
+		f.SizeLimit = &limit
+	}
+	f.Used = diskUsage(fs.DevicePath)
+	f.Free = freeBytes(f.Total, f.Used)
+	return f
+}
+
+// Create creates a new filesystem with the given name and optional size
+// limit, which is reported as its size
+func (m *MemoryManager) Create(ctx context.Context, name string, sizeLimit *uint64) (*Filesystem, error) {
+	log.Debug("creating filesystem in memory", "name", name, "pool", m.pool, "sizeLimit", sizeLimit)
+
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.filesystems[name]; ok {
+		return nil, fmt.Errorf("create filesystem %s: %w", name, ErrAlreadyExists)
+	}
+
+	dir := filepath.Join(m.dir, name)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create filesystem: %w", err)
+	}
+
+	fs, err := m.add(name, dir, sizeLimit)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("create filesystem: %w", err)
+	}
+	return fs, nil
+}
+
+// add records a filesystem. Must be called with mu held.
+func (m *MemoryManager) add(name, dir string, sizeLimit *uint64) (*Filesystem, error) {
+	uuid, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	total := uint64(memoryDefaultSize)
+	if sizeLimit != nil {
+		limit := *sizeLimit
+		total, sizeLimit = limit, &limit
+	}
+
+	fs := &Filesystem{
+		Name:       name,
+		Pool:       m.pool,
+		DevicePath: dir,
+		Total:      total,
+		SizeLimit:  sizeLimit,
+		UUID:       uuid,
+	}
+	m.filesystems[name] = fs
+
+	f := m.describe(fs)
+	return &f, nil
+}
+
+// Snapshot creates the filesystem name as a copy of the directory of origin
+func (m *MemoryManager) Snapshot(ctx context.Context, origin, name string) (*Filesystem, error) {
+	log.Debug("snapshotting filesystem in memory", "origin", origin, "name", name, "pool", m.pool)
+
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	src, ok := m.filesystems[origin]
+	if !ok {
+		return nil, fmt.Errorf("snapshot filesystem %s: %w", origin, ErrNotFound)
+	}
+	if _, ok := m.filesystems[name]; ok {
+		return nil, fmt.Errorf("snapshot filesystem %s: %w", name, ErrAlreadyExists)
+	}
+
+	dir := filepath.Join(m.dir, name)
+	if err := copyTree(ctx, src.DevicePath, dir); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("snapshot filesystem: %w", err)
+	}
+
+	fs, err := m.add(name, dir, src.SizeLimit)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("snapshot filesystem: %w", err)
+	}
+	return fs, nil
+}
+
+// Delete removes the filesystem with the given name and its directory. It
+// fails with ErrBusy while the directory is mounted, as stratisd fails to
+// destroy a mounted filesystem.
+func (m *MemoryManager) Delete(ctx context.Context, name string) error {
+	log.Debug("deleting filesystem in memory", "name", name, "pool", m.pool)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fs, ok := m.filesystems[name]
+	if !ok {
+		return ErrNotFound
+	}
+
+	mountPoint, err := bindMountOf(fs.DevicePath)
+	if err != nil {
+		return fmt.Errorf("delete filesystem: %w", err)
+	}
+	if mountPoint != "" {
+		return fmt.Errorf("delete filesystem %s: %w: mounted at %s", name, ErrBusy, mountPoint)
+	}
+
+	if err := os.RemoveAll(fs.DevicePath); err != nil {
+		return fmt.Errorf("delete filesystem: %w", err)
+	}
+	delete(m.filesystems, name)
+	return nil
+}
+
+// Rename renames the filesystem name to newName, and its directory with it
+func (m *MemoryManager) Rename(ctx context.Context, name, newName string) error {
+	log.Debug("renaming filesystem in memory", "name", name, "newName", newName, "pool", m.pool)
+
+	if err := checkName(newName); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fs, ok := m.filesystems[name]
+	if !ok {
+		return ErrNotFound
+	}
+	if name == newName {
+		return nil
+	}
+	if _, ok := m.filesystems[newName]; ok {
+		return fmt.Errorf("rename filesystem %s: %w", newName, ErrAlreadyExists)
+	}
+
+	dir := filepath.Join(m.dir, newName)
+	if err := os.Rename(fs.DevicePath, dir); err != nil {
+		return fmt.Errorf("rename filesystem: %w", err)
+	}
+	fs.Name, fs.DevicePath = newName, dir
+	delete(m.filesystems, name)
+	m.filesystems[newName] = fs
+	return nil
+}
+
+// bindMountOf returns where dir is bind mounted, or "" if it is not
+func bindMountOf(dir string) (string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+
+	mounts, err := procmounts.Parse()
+	if err != nil {
+		return "", fmt.Errorf("unable to parse mounts: %w", err)
+	}
+
+	for _, mount := range mounts {
+		if target, err := os.Stat(mount.MountPoint); err == nil && os.SameFile(info, target) {
+			return mount.MountPoint, nil
+		}
+	}
+	return "", nil
+}
+
+// diskUsage returns the bytes allocated to the files under dir
+func diskUsage(dir string) uint64 {
+	var used uint64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			used += uint64(st.Blocks) * 512
+		}
+		return nil
+	})
+	return used
+}
+
+// copyTree copies the directories, regular files and symlinks under src to
+// dst, which must not exist
+func copyTree(ctx context.Context, src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			if err := os.Mkdir(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			return os.Chmod(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			// Devices, sockets and pipes are not data
+			log.Debug("not copying special file", "path", path)
+			return nil
+		}
+	})
+}
+
+// copyFile copies a regular file
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, perm)
+}
@@ -0,0 +1,80 @@
+package stratis
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryManager(t *testing.T) {
+	ctx := context.Background()
+
+	m, err := NewMemoryManager("dev", t.TempDir())
+	if err != nil {
+		t.Fatalf("NewMemoryManager() error = %v", err)
+	}
+
+	limit := uint64(1 << 30)
+	fs, err := m.Create(ctx, "vol1", &limit)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if fs.Total != limit || fs.SizeLimit == nil || *fs.SizeLimit != limit || fs.UUID == "" {
+		t.Errorf("Create() = %+v, want the size limit as its size", fs)
+	}
+	if info, err := os.Stat(fs.DevicePath); err != nil || !info.IsDir() {
+		t.Fatalf("Create() device %s is not a directory: %v", fs.DevicePath, err)
+	}
+
+	if _, err := m.Create(ctx, "vol1", nil); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("Create() of a taken name error = %v, want ErrAlreadyExists", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(fs.DevicePath, "data"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(fs.DevicePath, "data", "file"), []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("data/file", filepath.Join(fs.DevicePath, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := m.Snapshot(ctx, "vol1", "snap1")
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if snap.SizeLimit == nil || *snap.SizeLimit != limit || snap.UUID == fs.UUID {
+		t.Errorf("Snapshot() = %+v, want the limit of the origin and its own UUID", snap)
+	}
+	if data, err := os.ReadFile(filepath.Join(snap.DevicePath, "link")); err != nil || string(data) != "hello" {
+		t.Errorf("snapshot link reads %q, %v, want the copied file", data, err)
+	}
+	if snap.Used == 0 {
+		t.Errorf("Snapshot() used = 0, want the copied data")
+	}
+
+	if _, err := m.Snapshot(ctx, "missing", "snap2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Snapshot() of a missing origin error = %v, want ErrNotFound", err)
+	}
+
+	list, err := m.List(ctx)
+	if err != nil || len(list) != 2 || list[0].Name != "snap1" || list[1].Name != "vol1" {
+		t.Fatalf("List() = %+v, %v, want snap1 and vol1", list, err)
+	}
+
+	if err := m.Delete(ctx, "vol1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := os.Stat(fs.DevicePath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Delete() left %s behind", fs.DevicePath)
+	}
+	if _, err := m.GetByName(ctx, "vol1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetByName() after Delete() error = %v, want ErrNotFound", err)
+	}
+	if err := m.Delete(ctx, "vol1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() of a missing filesystem error = %v, want ErrNotFound", err)
+	}
+}
@@ -1,6 +1,9 @@
 package stratis
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
 // Filesystem represents a Stratis filesystem
 type Filesystem struct {
@@ -14,7 +17,7 @@ type Filesystem struct {
 	Total uint64
 	// Used is the used space in bytes
 	Used uint64
-	// Free is the free space in bytes
+	// Free is the free space in bytes, always Total minus Used
 	Free uint64
 	// SizeLimit is the optional size limit in bytes (nil = thin provisioned)
 	SizeLimit *uint64
@@ -22,38 +25,89 @@ type Filesystem struct {
 	UUID string
 }
 
-// Manager defines the interface for Stratis filesystem management operations
+// Manager defines the interface for Stratis filesystem management operations.
+// Every operation gives up when its context is done, returning an error that
+// wraps the context's error.
 type Manager interface {
 	// PoolExists checks if the configured pool exists
-	PoolExists() (bool, error)
+	PoolExists(ctx context.Context) (bool, error)
 
 	// List returns all filesystems in the pool
-	List() ([]Filesystem, error)
+	List(ctx context.Context) ([]Filesystem, error)
 
 	// Create creates a new filesystem with the given name and optional size limit
 	// If sizeLimit is nil, the filesystem is thin-provisioned without a limit
 	// Returns the created filesystem
-	Create(name string, sizeLimit *uint64) (*Filesystem, error)
+	Create(ctx context.Context, name string, sizeLimit *uint64) (*Filesystem, error)
 
 	// Delete removes the filesystem with the given name
-	Delete(name string) error
+	Delete(ctx context.Context, name string) error
+
+	// Rename renames the filesystem name to newName, keeping its data, UUID
+	// and size limit
+	Rename(ctx context.Context, name, newName string) error
 
 	// GetByName returns the filesystem with the given name
 	// Returns nil if not found
-	GetByName(name string) (*Filesystem, error)
+	GetByName(ctx context.Context, name string) (*Filesystem, error)
+
+	// Snapshot creates the filesystem name as a copy of the filesystem origin,
+	// sharing its blocks until either is written to. It has the size limit of
+	// the origin. Returns the created filesystem
+	Snapshot(ctx context.Context, origin, name string) (*Filesystem, error)
+}
+
+// freeBytes returns the free space of a filesystem. Both backends derive it
+// from the exact sizes rather than report stratisd's rounded figure.
+func freeBytes(total, used uint64) uint64 {
+	if total > used {
+		return total - used
+	}
+	return 0
+}
+
+// managerOptions holds the settings of backends other than the default
+type managerOptions struct {
+	loopfileDir string
+	memoryDir   string
 }
 
-// ErrNotFound is returned when a filesystem is not found
-var ErrNotFound = fmt.Errorf("filesystem not found")
+// ManagerOption configures NewManager
+type ManagerOption func(*managerOptions)
+
+// WithLoopfileDir sets the directory the loopfile backend keeps the image
+// files of its pools in. Defaults to DefaultLoopfileDir.
+func WithLoopfileDir(dir string) ManagerOption {
+	return func(o *managerOptions) {
+		o.loopfileDir = dir
+	}
+}
+
+// WithMemoryDir sets the directory the memory backend creates the
+// directories of its pools in. Defaults to the system's temporary directory.
+func WithMemoryDir(dir string) ManagerOption {
+	return func(o *managerOptions) {
+		o.memoryDir = dir
+	}
+}
 
 // NewManager creates a Manager based on the specified backend
-func NewManager(pool, backend string) (Manager, error) {
+func NewManager(pool, backend string, opts ...ManagerOption) (Manager, error) {
+	o := managerOptions{loopfileDir: DefaultLoopfileDir}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	switch backend {
 	case "cli":
 		return NewCLIManager(pool), nil
 	case "dbus":
 		return NewDBusManager(pool)
+	case "loopfile":
+		return NewLoopfileManager(pool, o.loopfileDir)
+	case "memory":
+		return NewMemoryManager(pool, o.memoryDir)
 	default:
-		return nil, fmt.Errorf("unknown backend: %s (use 'dbus' or 'cli')", backend)
+		return nil, fmt.Errorf("unknown backend: %s (use 'dbus', 'cli', 'loopfile' or 'memory')", backend)
 	}
 }
@@ -0,0 +1,316 @@
+package stratistest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// cliStateEnv names the state file of the fake stratis-cli. The test binary
+// runs as the fake when it is started as stratis with it set.
+const cliStateEnv = "STRATISTEST_CLI_STATE"
+
+// cliState is what the fake stratis-cli keeps between invocations
+type cliState struct {
+	Pools map[string]map[string]*cliFilesystem `json:"pools"`
+}
+
+type cliFilesystem struct {
+	UUID  string  `json:"uuid"`
+	Size  uint64  `json:"size"`
+	Used  uint64  `json:"used"`
+	Limit *uint64 `json:"limit,omitempty"`
+}
+
+// InstallCLI puts a fake stratis-cli with the given pools first in PATH
+// until the test ends. The fake is the test binary itself, so the test
+// package must call RunCLI from its TestMain. It answers the commands the
+// CLI backend runs, with reports of exact sizes, and fails as stratis-cli
+// does, with a message and exit status 1.
+//
+// InstallCLI sets environment variables, so it cannot be used in parallel
+// tests.
+func InstallCLI(t *testing.T, pools ...string) {
+	t.Helper()
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatalf("install fake stratis-cli: %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := os.Symlink(executable, filepath.Join(dir, "stratis")); err != nil {
+		t.Fatalf("install fake stratis-cli: %v", err)
+	}
+
+	state := cliState{Pools: make(map[string]map[string]*cliFilesystem)}
+	for _, name := range pools {
+		state.Pools[name] = make(map[string]*cliFilesystem)
+	}
+	statePath := filepath.Join(dir, "state.json")
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatalf("install fake stratis-cli: %v", err)
+	}
+	if err := os.WriteFile(statePath, data, 0644); err != nil {
+		t.Fatalf("install fake stratis-cli: %v", err)
+	}
+
+	t.Setenv(cliStateEnv, statePath)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// RunCLI runs the fake stratis-cli and exits if InstallCLI started the test
+// binary as it; otherwise it does nothing. Call it first in TestMain.
+func RunCLI() {
+	statePath := os.Getenv(cliStateEnv)
+	if statePath == "" || filepath.Base(os.Args[0]) != "stratis" {
+		return
+	}
+
+	output, err := runCLI(statePath, os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Execution failed:\n%v\n", err)
+		os.Exit(1)
+	}
+	os.Stdout.WriteString(output)
+	os.Exit(0)
+}
+
+// runCLI runs one command against the state, holding a lock on it
+func runCLI(statePath string, args []string) (string, error) {
+	f, err := os.OpenFile(statePath, os.O_RDWR, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return "", err
+	}
+
+	var state cliState
+	if err := json.NewDecoder(f).Decode(&state); err != nil {
+		return "", fmt.Errorf("read fake state: %w", err)
+	}
+
+	output, err := state.run(args)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	if err := f.Truncate(0); err != nil {
+		return "", err
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+func (s *cliState) run(args []string) (string, error) {
+	command := strings.Join(args, " ")
+	switch {
+	case command == "report engine_state_report":
+		return s.report()
+	case len(args) == 4 && args[0] == "pool" && args[1] == "list" && args[2] == "--name":
+		if _, ok := s.Pools[args[3]]; !ok {
+			return "", fmt.Errorf("Pool %s not found", args[3])
+		}
+		return args[3] + "\n", nil
+	case len(args) >= 4 && args[0] == "fs" && args[1] == "create":
+		return "", s.create(args[2:])
+	case len(args) == 4 && args[0] == "fs" && args[1] == "destroy":
+		return "", s.destroy(args[2], args[3])
+	case len(args) == 5 && args[0] == "fs" && args[1] == "snapshot":
+		return "", s.snapshot(args[2], args[3], args[4])
+	case len(args) == 5 && args[0] == "fs" && args[1] == "rename":
+		return "", s.rename(args[2], args[3], args[4])
+	default:
+		return "", fmt.Errorf("fake stratis-cli does not know %q", command)
+	}
+}
+
+func (s *cliState) pool(name string) (map[string]*cliFilesystem, error) {
+	p, ok := s.Pools[name]
+	if !ok {
+		return nil, fmt.Errorf("Pool %s not found", name)
+	}
+	return p, nil
+}
+
+func (s *cliState) report() (string, error) {
+	type reportFilesystem struct {
+		Name      string `json:"name"`
+		UUID      string `json:"uuid"`
+		Size      string `json:"size"`
+		Used      string `json:"used"`
+		SizeLimit string `json:"size_limit,omitempty"`
+	}
+	type reportPool struct {
+		Name        string             `json:"name"`
+		Filesystems []reportFilesystem `json:"filesystems"`
+	}
+
+	report := struct {
+		Pools        []reportPool `json:"pools"`
+		ErroredPools []string     `json:"errored_pools"`
+	}{Pools: []reportPool{}, ErroredPools: []string{}}
+
+	for _, poolName := range sortedKeys(s.Pools) {
+		p := reportPool{Name: poolName, Filesystems: []reportFilesystem{}}
+		for _, name := range sortedKeys(s.Pools[poolName]) {
+			fs := s.Pools[poolName][name]
+			entry := reportFilesystem{
+				Name: name,
+				UUID: fs.UUID,
+				Size: strconv.FormatUint(fs.Size, 10),
+				Used: strconv.FormatUint(fs.Used, 10),
+			}
+			if fs.Limit != nil {
+				entry.SizeLimit = strconv.FormatUint(*fs.Limit, 10)
+			}
+			p.Filesystems = append(p.Filesystems, entry)
+		}
+		report.Pools = append(report.Pools, p)
+	}
+
+	data, err := json.MarshalIndent(report, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// create runs fs create [--size SIZE] POOL NAME. stratis-cli sets both the
+// size and the size limit from --size.
+func (s *cliState) create(args []string) error {
+	var limit *uint64
+	if args[0] == "--size" {
+		if len(args) != 4 {
+			return fmt.Errorf("usage: stratis fs create [--size SIZE] POOL NAME")
+		}
+		size, err := parseCLISize(args[1])
+		if err != nil {
+			return err
+		}
+		limit = &size
+		args = args[2:]
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: stratis fs create [--size SIZE] POOL NAME")
+	}
+
+	p, err := s.pool(args[0])
+	if err != nil {
+		return err
+	}
+	name := args[1]
+	if _, ok := p[name]; ok {
+		return fmt.Errorf("Filesystem %s already exists in pool %s", name, args[0])
+	}
+
+	fs := &cliFilesystem{UUID: newUUID(), Size: defaultSize, Limit: limit}
+	if limit != nil {
+		fs.Size = *limit
+	}
+	if fs.Size < minSize {
+		return fmt.Errorf("Requested size %d is less than the minimum filesystem size %d", fs.Size, uint64(minSize))
+	}
+	fs.Used = initialUsed
+	p[name] = fs
+	return nil
+}
+
+func (s *cliState) destroy(poolName, name string) error {
+	p, err := s.pool(poolName)
+	if err != nil {
+		return err
+	}
+	if _, ok := p[name]; !ok {
+		return fmt.Errorf("Filesystem %s not found in pool %s", name, poolName)
+	}
+	delete(p, name)
+	return nil
+}
+
+func (s *cliState) rename(poolName, name, newName string) error {
+	p, err := s.pool(poolName)
+	if err != nil {
+		return err
+	}
+	fs, ok := p[name]
+	if !ok {
+		return fmt.Errorf("Filesystem %s not found in pool %s", name, poolName)
+	}
+	if name == newName {
+		return nil
+	}
+	if _, ok := p[newName]; ok {
+		return fmt.Errorf("Filesystem %s already exists in pool %s", newName, poolName)
+	}
+	delete(p, name)
+	p[newName] = fs
+	return nil
+}
+
+func (s *cliState) snapshot(poolName, origin, name string) error {
+	p, err := s.pool(poolName)
+	if err != nil {
+		return err
+	}
+	src, ok := p[origin]
+	if !ok {
+		return fmt.Errorf("Filesystem %s not found in pool %s", origin, poolName)
+	}
+	if _, ok := p[name]; ok {
+		return fmt.Errorf("Filesystem %s already exists in pool %s", name, poolName)
+	}
+
+	fs := *src
+	fs.UUID = newUUID()
+	if src.Limit != nil {
+		limit := *src.Limit
+		fs.Limit = &limit
+	}
+	p[name] = &fs
+	return nil
+}
+
+// parseCLISize parses a size as stratis-cli takes it, e.g. "1GiB" or "512B"
+func parseCLISize(s string) (uint64, error) {
+	units := []struct {
+		suffix string
+		shift  uint
+	}{
+		{"TiB", 40}, {"GiB", 30}, {"MiB", 20}, {"KiB", 10}, {"B", 0},
+	}
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(s, unit.suffix); ok {
+			n, err := strconv.ParseUint(number, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid size %q", s)
+			}
+			return n << unit.shift, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid size %q", s)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,731 @@
+// Package stratistest runs a fake stratisd on a private message bus, so the
+// DBus backend can be tested end to end, with real marshalling, without
+// Stratis or a VM.
+//
+// The fake exports org.storage.stratis3 the way stratisd does: every
+// revision of the API up to the newest one offered, pools with the
+// CreateFilesystems, DestroyFilesystems and SnapshotFilesystem methods,
+// filesystems with SetName, and the ObjectManager on the root object
+// announcing changes with signals.
+// Failures are reported as stratisd reports them, with a return code and a
+// message.
+package stratistest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	service       = "org.storage.stratis3"
+	rootPath      = "/org/storage/stratis3"
+	objectManager = "org.freedesktop.DBus.ObjectManager"
+	introspectIf  = "org.freedesktop.DBus.Introspectable"
+	propertiesIf  = "org.freedesktop.DBus.Properties"
+
+	// DefaultRevision is the newest API revision offered by default,
+	// that of stratisd 3.8
+	DefaultRevision = 8
+
+	// sizeLimitRevision is the first revision with filesystem size limits
+	sizeLimitRevision = 5
+
+	// defaultSize is the size stratisd gives filesystems created without one
+	defaultSize = 1 << 40
+	// minSize is the smallest filesystem stratisd creates
+	minSize = 512 << 20
+	// initialUsed is what a freshly made XFS filesystem takes
+	initialUsed = 74 << 20
+
+	// rcOK and rcError are stratisd's return codes
+	rcOK    = 0
+	rcError = 1
+
+	// startTimeout bounds starting the bus
+	startTimeout = 10 * time.Second
+)
+
+// Stratisd is a fake stratisd, serving on a private bus until the test ends
+type Stratisd struct {
+	t        testing.TB
+	address  string
+	revision int
+
+	mu      sync.Mutex
+	conn    *dbus.Conn
+	nextID  int
+	pools   map[string]*pool
+	failure map[string]string
+}
+
+type pool struct {
+	name        string
+	uuid        string
+	path        dbus.ObjectPath
+	filesystems map[string]*filesystem
+}
+
+type filesystem struct {
+	name  string
+	uuid  string
+	path  dbus.ObjectPath
+	size  uint64
+	used  uint64
+	limit *uint64
+}
+
+// Option configures Start
+type Option func(*Stratisd)
+
+// WithRevision sets the newest API revision offered; older ones are
+// offered too, as stratisd does
+func WithRevision(revision int) Option {
+	return func(s *Stratisd) {
+		s.revision = revision
+	}
+}
+
+// Start starts a private bus and a fake stratisd on it. The test is skipped
+// if dbus-daemon is not installed. Both are stopped when the test ends.
+func Start(t testing.TB, opts ...Option) *Stratisd {
+	t.Helper()
+
+	s := &Stratisd{
+		t:        t,
+		revision: DefaultRevision,
+		pools:    make(map[string]*pool),
+		failure:  make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.address = startBus(t)
+	if err := s.connect(); err != nil {
+		t.Fatalf("start fake stratisd: %v", err)
+	}
+	t.Cleanup(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.conn.Close()
+	})
+
+	return s
+}
+
+// startBus starts a dbus-daemon on a socket of a temporary directory and
+// returns its address
+func startBus(t testing.TB) string {
+	t.Helper()
+
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	err = os.WriteFile(config, []byte(`<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-Bus Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+  <type>custom</type>
+  <listen>unix:path=`+filepath.Join(dir, "bus.sock")+`</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow user="*"/>
+    <allow own="*"/>
+    <allow send_destination="*" eavesdrop="true"/>
+    <allow eavesdrop="true"/>
+  </policy>
+</busconfig>
+`), 0600)
+	if err != nil {
+		t.Fatalf("write bus config: %v", err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(daemon, "--config-file="+config, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("start dbus-daemon: %v", err)
+	}
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	address := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(stdout).ReadString('\n')
+		address <- strings.TrimSpace(line)
+	}()
+
+	select {
+	case addr := <-address:
+		if addr == "" {
+			cmd.Wait()
+			t.Fatalf("dbus-daemon exited without an address: %s", stderr.String())
+		}
+		return addr
+	case <-time.After(startTimeout):
+		t.Fatalf("dbus-daemon did not start within %s", startTimeout)
+		return ""
+	}
+}
+
+// Address returns the address of the private bus
+func (s *Stratisd) Address() string {
+	return s.address
+}
+
+// Connect opens a new client connection to the private bus
+func (s *Stratisd) Connect() (*dbus.Conn, error) {
+	return dbus.Connect(s.address)
+}
+
+// connect joins the bus, exports every object and takes stratisd's name
+func (s *Stratisd) connect() error {
+	conn, err := dbus.Connect(s.address)
+	if err != nil {
+		return fmt.Errorf("connect to bus: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn = conn
+
+	err = conn.ExportMethodTable(map[string]any{
+		"GetManagedObjects": s.getManagedObjects,
+	}, rootPath, objectManager)
+	if err != nil {
+		return fmt.Errorf("export object manager: %w", err)
+	}
+	err = conn.ExportMethodTable(map[string]any{
+		"Introspect": s.introspect,
+	}, rootPath, introspectIf)
+	if err != nil {
+		return fmt.Errorf("export introspection: %w", err)
+	}
+	for _, p := range s.pools {
+		if err := s.exportPool(p); err != nil {
+			return err
+		}
+	}
+	if err := s.exportFilesystems(); err != nil {
+		return err
+	}
+
+	reply, err := conn.RequestName(service, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return fmt.Errorf("request name: %w", err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("request name: %s is taken", service)
+	}
+	return nil
+}
+
+// Restart stops and starts the fake stratisd, as after a crash or an
+// upgrade. Pools and filesystems survive, under new object paths.
+func (s *Stratisd) Restart() {
+	s.t.Helper()
+
+	s.mu.Lock()
+	s.conn.Close()
+	for _, p := range s.pools {
+		p.path = s.newPath("pool")
+		for _, fs := range p.filesystems {
+			fs.path = s.newPath("filesystem")
+		}
+	}
+	s.mu.Unlock()
+
+	if err := s.connect(); err != nil {
+		s.t.Fatalf("restart fake stratisd: %v", err)
+	}
+}
+
+// AddPool creates a pool
+func (s *Stratisd) AddPool(name string) {
+	s.t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pools[name]; ok {
+		s.t.Fatalf("pool %s already exists", name)
+	}
+	p := &pool{
+		name:        name,
+		uuid:        newUUID(),
+		path:        s.newPath("pool"),
+		filesystems: make(map[string]*filesystem),
+	}
+	s.pools[name] = p
+
+	if err := s.exportPool(p); err != nil {
+		s.t.Fatalf("add pool: %v", err)
+	}
+	s.emitAdded(p.path, s.poolInterfaces(p))
+}
+
+// Fail makes the next call of method, e.g. "CreateFilesystems", fail with
+// message and stratisd's error return code
+func (s *Stratisd) Fail(method, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failure[method] = message
+}
+
+// SetUsed changes the used bytes of a filesystem, announcing it as stratisd does
+func (s *Stratisd) SetUsed(poolName, name string, used uint64) {
+	s.t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fs := s.filesystem(poolName, name)
+	fs.used = used
+	for r := 0; r <= s.revision; r++ {
+		s.emit(fs.path, propertiesIf+".PropertiesChanged", filesystemInterface(r), map[string]dbus.Variant{
+			"Used": dbus.MakeVariant(optional{true, strconv.FormatUint(used, 10)}),
+		}, []string{})
+	}
+}
+
+// Filesystems returns the names of the filesystems of a pool
+func (s *Stratisd) Filesystems(poolName string) []string {
+	s.t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pools[poolName]
+	if !ok {
+		s.t.Fatalf("pool %s does not exist", poolName)
+	}
+	names := make([]string, 0, len(p.filesystems))
+	for name := range p.filesystems {
+		names = append(names, name)
+	}
+	return names
+}
+
+// filesystem returns a filesystem, failing the test if there is none. Must
+// be called with mu held.
+func (s *Stratisd) filesystem(poolName, name string) *filesystem {
+	p, ok := s.pools[poolName]
+	if !ok {
+		s.t.Fatalf("pool %s does not exist", poolName)
+	}
+	fs, ok := p.filesystems[name]
+	if !ok {
+		s.t.Fatalf("filesystem %s does not exist in pool %s", name, poolName)
+	}
+	return fs
+}
+
+// newPath returns a new object path of the given kind. Must be called with
+// mu held.
+func (s *Stratisd) newPath(kind string) dbus.ObjectPath {
+	s.nextID++
+	return dbus.ObjectPath(fmt.Sprintf("%s/%s/%d", rootPath, kind, s.nextID))
+}
+
+// takeFailure returns and clears the failure set for method. Must be called
+// with mu held.
+func (s *Stratisd) takeFailure(method string) (string, bool) {
+	message, ok := s.failure[method]
+	delete(s.failure, method)
+	return message, ok
+}
+
+// emit sends a signal, reporting failures to the test. Must be called with
+// mu held.
+func (s *Stratisd) emit(path dbus.ObjectPath, name string, values ...any) {
+	if err := s.conn.Emit(path, name, values...); err != nil {
+		s.t.Errorf("emit %s: %v", name, err)
+	}
+}
+
+func (s *Stratisd) emitAdded(path dbus.ObjectPath, interfaces map[string]map[string]dbus.Variant) {
+	s.emit(rootPath, objectManager+".InterfacesAdded", path, interfaces)
+}
+
+func (s *Stratisd) emitRemoved(path dbus.ObjectPath, interfaces map[string]map[string]dbus.Variant) {
+	names := make([]string, 0, len(interfaces))
+	for name := range interfaces {
+		names = append(names, name)
+	}
+	s.emit(rootPath, objectManager+".InterfacesRemoved", path, names)
+}
+
+func poolInterface(revision int) string {
+	return fmt.Sprintf("%s.pool.r%d", service, revision)
+}
+
+func filesystemInterface(revision int) string {
+	return fmt.Sprintf("%s.filesystem.r%d", service, revision)
+}
+
+// optional is stratisd's optional value, (bs) on the bus
+type optional struct {
+	HasValue bool
+	Value    string
+}
+
+func optionalSize(size *uint64) optional {
+	if size == nil {
+		return optional{}
+	}
+	return optional{true, strconv.FormatUint(*size, 10)}
+}
+
+// poolInterfaces returns the interfaces of a pool object, with their
+// properties. Must be called with mu held.
+func (s *Stratisd) poolInterfaces(p *pool) map[string]map[string]dbus.Variant {
+	interfaces := make(map[string]map[string]dbus.Variant)
+	for r := 0; r <= s.revision; r++ {
+		interfaces[poolInterface(r)] = map[string]dbus.Variant{
+			"Name": dbus.MakeVariant(p.name),
+			"Uuid": dbus.MakeVariant(p.uuid),
+		}
+	}
+	return interfaces
+}
+
+// filesystemInterfaces returns the interfaces of a filesystem object, with
+// their properties. Must be called with mu held.
+func (s *Stratisd) filesystemInterfaces(p *pool, fs *filesystem) map[string]map[string]dbus.Variant {
+	interfaces := make(map[string]map[string]dbus.Variant)
+	for r := 0; r <= s.revision; r++ {
+		props := map[string]dbus.Variant{
+			"Name":    dbus.MakeVariant(fs.name),
+			"Uuid":    dbus.MakeVariant(fs.uuid),
+			"Pool":    dbus.MakeVariant(p.path),
+			"Devnode": dbus.MakeVariant("/dev/stratis/" + p.name + "/" + fs.name),
+			"Size":    dbus.MakeVariant(strconv.FormatUint(fs.size, 10)),
+			"Used":    dbus.MakeVariant(optional{true, strconv.FormatUint(fs.used, 10)}),
+		}
+		if r >= sizeLimitRevision {
+			props["SizeLimit"] = dbus.MakeVariant(optionalSize(fs.limit))
+		}
+		interfaces[filesystemInterface(r)] = props
+	}
+	return interfaces
+}
+
+// getManagedObjects implements ObjectManager.GetManagedObjects
+func (s *Stratisd) getManagedObjects() (map[dbus.ObjectPath]map[string]map[string]dbus.Variant, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objects := make(map[dbus.ObjectPath]map[string]map[string]dbus.Variant)
+	for _, p := range s.pools {
+		objects[p.path] = s.poolInterfaces(p)
+		for _, fs := range p.filesystems {
+			objects[fs.path] = s.filesystemInterfaces(p, fs)
+		}
+	}
+	return objects, nil
+}
+
+// introspect implements Introspectable.Introspect for the root object,
+// listing the Manager interface of every revision
+func (s *Stratisd) introspect() (string, *dbus.Error) {
+	var b strings.Builder
+	b.WriteString(`<node>`)
+	b.WriteString(`<interface name="` + introspectIf + `"/>`)
+	b.WriteString(`<interface name="` + objectManager + `"/>`)
+	for r := 0; r <= s.revision; r++ {
+		fmt.Fprintf(&b, `<interface name="%s.Manager.r%d"/>`, service, r)
+	}
+	b.WriteString(`</node>`)
+	return b.String(), nil
+}
+
+// Results of the pool methods: (changed, result), return code, message
+type (
+	createdFilesystem struct {
+		Path dbus.ObjectPath
+		Name string
+	}
+	createResult struct {
+		Changed     bool
+		Filesystems []createdFilesystem
+	}
+	destroyResult struct {
+		Changed bool
+		UUIDs   []string
+	}
+	snapshotResult struct {
+		Changed bool
+		Path    dbus.ObjectPath
+	}
+	setNameResult struct {
+		Changed bool
+		UUID    string
+	}
+)
+
+// exportPool exports the methods of a pool in every revision. Before size
+// limits, CreateFilesystems takes a(s(bs)); since, a(s(bs)(bs)). Must be
+// called with mu held.
+func (s *Stratisd) exportPool(p *pool) error {
+	type specNoLimit struct {
+		Name string
+		Size optional
+	}
+	type spec struct {
+		Name      string
+		Size      optional
+		SizeLimit optional
+	}
+
+	for r := 0; r <= s.revision; r++ {
+		methods := map[string]any{
+			"DestroyFilesystems": func(paths []dbus.ObjectPath) (destroyResult, uint16, string, *dbus.Error) {
+				return s.destroyFilesystems(p.name, paths)
+			},
+			"SnapshotFilesystem": func(origin dbus.ObjectPath, name string) (snapshotResult, uint16, string, *dbus.Error) {
+				return s.snapshotFilesystem(p.name, origin, name)
+			},
+		}
+		if r >= sizeLimitRevision {
+			methods["CreateFilesystems"] = func(specs []spec) (createResult, uint16, string, *dbus.Error) {
+				requests := make([]createRequest, 0, len(specs))
+				for _, sp := range specs {
+					requests = append(requests, createRequest{sp.Name, sp.Size, sp.SizeLimit})
+				}
+				return s.createFilesystems(p.name, requests)
+			}
+		} else {
+			methods["CreateFilesystems"] = func(specs []specNoLimit) (createResult, uint16, string, *dbus.Error) {
+				requests := make([]createRequest, 0, len(specs))
+				for _, sp := range specs {
+					requests = append(requests, createRequest{sp.Name, sp.Size, optional{}})
+				}
+				return s.createFilesystems(p.name, requests)
+			}
+		}
+
+		if err := s.conn.ExportMethodTable(methods, p.path, poolInterface(r)); err != nil {
+			return fmt.Errorf("export pool %s: %w", p.name, err)
+		}
+	}
+	return nil
+}
+
+// exportFilesystems exports the methods of every filesystem, present and
+// future, in every revision. Must be called with mu held.
+func (s *Stratisd) exportFilesystems() error {
+	for r := 0; r <= s.revision; r++ {
+		methods := map[string]any{
+			"SetName": func(msg dbus.Message, name string) (setNameResult, uint16, string, *dbus.Error) {
+				path, _ := msg.Headers[dbus.FieldPath].Value().(dbus.ObjectPath)
+				return s.setName(path, name)
+			},
+		}
+		if err := s.conn.ExportSubtreeMethodTable(methods, rootPath+"/filesystem", filesystemInterface(r)); err != nil {
+			return fmt.Errorf("export filesystems: %w", err)
+		}
+	}
+	return nil
+}
+
+// createRequest is a filesystem spec of CreateFilesystems
+type createRequest struct {
+	name  string
+	size  optional
+	limit optional
+}
+
+// parseSize parses an optional size
+func parseSize(o optional, what string) (*uint64, error) {
+	if !o.HasValue {
+		return nil, nil
+	}
+	size, err := strconv.ParseUint(o.Value, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", what, o.Value)
+	}
+	return &size, nil
+}
+
+func (s *Stratisd) createFilesystems(poolName string, requests []createRequest) (createResult, uint16, string, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if message, ok := s.takeFailure("CreateFilesystems"); ok {
+		return createResult{}, rcError, message, nil
+	}
+
+	p := s.pools[poolName]
+	var created []*filesystem
+	for _, req := range requests {
+		size, err := parseSize(req.size, "size")
+		if err != nil {
+			return createResult{}, rcError, err.Error(), nil
+		}
+		limit, err := parseSize(req.limit, "size limit")
+		if err != nil {
+			return createResult{}, rcError, err.Error(), nil
+		}
+
+		if existing, ok := p.filesystems[req.name]; ok {
+			// stratisd does nothing when asked for what already exists
+			if size == nil || *size == existing.size {
+				continue
+			}
+			return createResult{}, rcError, fmt.Sprintf("Filesystem %s already exists with a different size", req.name), nil
+		}
+
+		fs := &filesystem{name: req.name, uuid: newUUID(), size: defaultSize, limit: limit}
+		if size != nil {
+			fs.size = *size
+		}
+		if fs.size < minSize {
+			return createResult{}, rcError, fmt.Sprintf("Requested size %d is less than the minimum filesystem size %d", fs.size, uint64(minSize)), nil
+		}
+		if limit != nil && *limit < fs.size {
+			return createResult{}, rcError, fmt.Sprintf("Size limit %d is less than the filesystem size %d", *limit, fs.size), nil
+		}
+		fs.used = min(uint64(initialUsed), fs.size)
+		created = append(created, fs)
+	}
+
+	result := createResult{Changed: len(created) > 0, Filesystems: []createdFilesystem{}}
+	for _, fs := range created {
+		fs.path = s.newPath("filesystem")
+		p.filesystems[fs.name] = fs
+		result.Filesystems = append(result.Filesystems, createdFilesystem{fs.path, fs.name})
+		s.emitAdded(fs.path, s.filesystemInterfaces(p, fs))
+	}
+	return result, rcOK, "", nil
+}
+
+func (s *Stratisd) destroyFilesystems(poolName string, paths []dbus.ObjectPath) (destroyResult, uint16, string, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if message, ok := s.takeFailure("DestroyFilesystems"); ok {
+		return destroyResult{UUIDs: []string{}}, rcError, message, nil
+	}
+
+	p := s.pools[poolName]
+	result := destroyResult{UUIDs: []string{}}
+	for _, path := range paths {
+		// Filesystems that do not exist are already destroyed
+		for name, fs := range p.filesystems {
+			if fs.path != path {
+				continue
+			}
+			interfaces := s.filesystemInterfaces(p, fs)
+			delete(p.filesystems, name)
+			result.Changed = true
+			result.UUIDs = append(result.UUIDs, fs.uuid)
+			s.emitRemoved(fs.path, interfaces)
+		}
+	}
+	return result, rcOK, "", nil
+}
+
+func (s *Stratisd) snapshotFilesystem(poolName string, originPath dbus.ObjectPath, name string) (snapshotResult, uint16, string, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if message, ok := s.takeFailure("SnapshotFilesystem"); ok {
+		return snapshotResult{Path: "/"}, rcError, message, nil
+	}
+
+	p := s.pools[poolName]
+	var origin *filesystem
+	for _, fs := range p.filesystems {
+		if fs.path == originPath {
+			origin = fs
+		}
+	}
+	if origin == nil {
+		return snapshotResult{Path: "/"}, rcError, fmt.Sprintf("Filesystem with path %s does not exist", originPath), nil
+	}
+	if _, ok := p.filesystems[name]; ok {
+		return snapshotResult{Path: "/"}, rcError, fmt.Sprintf("Filesystem %s already exists", name), nil
+	}
+
+	fs := &filesystem{
+		name: name,
+		uuid: newUUID(),
+		path: s.newPath("filesystem"),
+		size: origin.size,
+		used: origin.used,
+	}
+	if origin.limit != nil {
+		limit := *origin.limit
+		fs.limit = &limit
+	}
+	p.filesystems[name] = fs
+	s.emitAdded(fs.path, s.filesystemInterfaces(p, fs))
+
+	return snapshotResult{Changed: true, Path: fs.path}, rcOK, "", nil
+}
+
+func (s *Stratisd) setName(path dbus.ObjectPath, name string) (setNameResult, uint16, string, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if message, ok := s.takeFailure("SetName"); ok {
+		return setNameResult{}, rcError, message, nil
+	}
+
+	for _, p := range s.pools {
+		for oldName, fs := range p.filesystems {
+			if fs.path != path {
+				continue
+			}
+			if oldName == name {
+				return setNameResult{UUID: fs.uuid}, rcOK, "", nil
+			}
+			if _, ok := p.filesystems[name]; ok {
+				return setNameResult{}, rcError, fmt.Sprintf("Filesystem %s already exists", name), nil
+			}
+
+			delete(p.filesystems, oldName)
+			fs.name = name
+			p.filesystems[name] = fs
+			for r := 0; r <= s.revision; r++ {
+				s.emit(fs.path, propertiesIf+".PropertiesChanged", filesystemInterface(r), map[string]dbus.Variant{
+					"Name":    dbus.MakeVariant(name),
+					"Devnode": dbus.MakeVariant("/dev/stratis/" + p.name + "/" + name),
+				}, []string{})
+			}
+			return setNameResult{Changed: true, UUID: fs.uuid}, rcOK, "", nil
+		}
+	}
+	return setNameResult{}, rcError, fmt.Sprintf("Filesystem with path %s does not exist", path), nil
+}
+
+// newUUID returns a random UUID in stratisd's format, without dashes
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x", b)
+}
@@ -0,0 +1,84 @@
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/coreos/go-systemd/activation"
+	"github.com/coreos/go-systemd/daemon"
+
+	"github.com/kriansa/podman-volume-stratis/internal/log"
+)
+
+// ActivatedListener returns the socket passed in by systemd socket activation
+// (LISTEN_FDS), or nil when the process was not socket activated
+func ActivatedListener() (net.Listener, error) {
+	listeners, err := activation.Listeners()
+	if err != nil {
+		return nil, fmt.Errorf("get activated sockets: %w", err)
+	}
+
+	switch len(listeners) {
+	case 0:
+		return nil, nil
+	case 1:
+		if listeners[0] == nil {
+			return nil, fmt.Errorf("activated socket is not a stream socket")
+		}
+		return listeners[0], nil
+	default:
+		return nil, fmt.Errorf("expected one activated socket, got %d", len(listeners))
+	}
+}
+
+// Notify sends a state string to systemd. It is a no-op when not running
+// under a Type=notify unit.
+func Notify(state string) {
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		log.Warn("failed to notify systemd", "state", state, "error", err)
+	}
+}
+
+// Ready tells systemd that the plugin has finished starting up. It also
+// claims the main PID, as after an upgrade the serving process is a child of
+// the one systemd started.
+func Ready(status string) {
+	Notify(fmt.Sprintf("%s\nMAINPID=%d\nSTATUS=%s", daemon.SdNotifyReady, os.Getpid(), status))
+}
+
+// Watchdog pings the systemd watchdog at half of WatchdogSec for as long as
+// check succeeds. When check fails the ping is skipped, so systemd restarts
+// the service if the failure outlasts the watchdog timeout.
+// Blocks until ctx is cancelled; returns immediately if the watchdog is disabled.
+func Watchdog(ctx context.Context, check func() error) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		log.Warn("failed to read watchdog settings", "error", err)
+		return
+	}
+	if interval == 0 {
+		return
+	}
+
+	log.Debug("systemd watchdog enabled", "interval", interval)
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := check(); err != nil {
+			log.Warn("health check failed, skipping watchdog ping", "error", err)
+			continue
+		}
+		Notify(daemon.SdNotifyWatchdog)
+	}
+}
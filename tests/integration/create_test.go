@@ -50,9 +50,32 @@ func TestCreate_Duplicate(t *testing.T) {
 	err := testClient.Create(name, nil)
 	require.NoError(t, err, "first create should succeed")
 
-	// Try to create duplicate - driver returns error for duplicates
+	// Podman and Compose create existing volumes again; the same options succeed
 	err = testClient.Create(name, nil)
-	assert.Error(t, err, "duplicate create should fail")
+	assert.NoError(t, err, "duplicate create with the same options should succeed")
+}
+
+func TestCreate_DuplicateEquivalentSize(t *testing.T) {
+	name := uniqueVolumeName(t)
+	cleanupVolume(t, name)
+
+	err := testClient.Create(name, map[string]string{"size": "1GiB"})
+	require.NoError(t, err, "first create should succeed")
+
+	err = testClient.Create(name, map[string]string{"size": "1073741824"})
+	assert.NoError(t, err, "duplicate create with an equivalent size should succeed")
+}
+
+func TestCreate_DuplicateConflictingOptions(t *testing.T) {
+	name := uniqueVolumeName(t)
+	cleanupVolume(t, name)
+
+	err := testClient.Create(name, map[string]string{"size": "1GiB"})
+	require.NoError(t, err, "first create should succeed")
+
+	err = testClient.Create(name, map[string]string{"size": "2GiB"})
+	require.Error(t, err, "duplicate create with another size should fail")
+	assert.Contains(t, err.Error(), "size: existing 1073741824, requested 2147483648")
 }
 
 func TestCreate_ValidName_MinLength(t *testing.T) {